@@ -1,8 +1,17 @@
 package v1alpha1
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	apps "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 const (
@@ -10,9 +19,83 @@ const (
 	// which is strategy fields of Advanced Deployment.
 	DeploymentStrategyAnnotation = "rollouts.kruise.io/deployment-strategy"
 
+	// DeploymentStrategyRefAnnotation is annotation for deployment, which refers to the strategy
+	// in a key of ConfigMap in the same namespace as "<configmap>/<key>", e.g. for a strategy too
+	// large for an annotation. DeploymentStrategyAnnotation wins if both of them are set. The
+	// changes of the ConfigMap take effect on the next sync of the deployment, which can be
+	// forced by DeploymentResyncAnnotation.
+	DeploymentStrategyRefAnnotation = "rollouts.kruise.io/deployment-strategy-ref"
+
 	// DeploymentExtraStatusAnnotation is annotation for deployment,
 	// which is extra status field of Advanced Deployment.
 	DeploymentExtraStatusAnnotation = "rollouts.kruise.io/deployment-extra-status"
+
+	// DeploymentStrategyStatusAnnotation is annotation for deployment, which is a readable
+	// summary of the effective strategy of Advanced Deployment in DeploymentStrategyStatus.
+	DeploymentStrategyStatusAnnotation = "rollouts.kruise.io/deployment-strategy-status"
+
+	// DeploymentPromoteAnnotation is annotation for deployment,
+	// Advanced Deployment will hold at Partition until it is "true",
+	// and then promote all the Pods to the latest version. It is ignored once any
+	// RolloutApproval refers to the latest version of deployment.
+	DeploymentPromoteAnnotation = "rollouts.kruise.io/deployment-promote"
+
+	// DeploymentRollbackAnnotation is annotation for deployment, whose value is the
+	// UpdateRevision of extra status. Advanced Deployment rolls back to the latest old
+	// revision at once while it matches the current revision, as RollbackPolicy does, and
+	// removes it together with the rollback.
+	DeploymentRollbackAnnotation = "rollouts.kruise.io/deployment-rollback"
+
+	// DeploymentApprovePartitionAnnotation is annotation for deployment, whose value is a
+	// Partition in ManualPartitions of strategy. Advanced Deployment holds at such a Partition
+	// until it is approved by the annotation, and removes the annotation once approved.
+	DeploymentApprovePartitionAnnotation = "rollouts.kruise.io/deployment-approve-partition"
+
+	// DeploymentResyncAnnotation is annotation for deployment, whose value is a nonce.
+	// Advanced Deployment will be reconciled immediately once it is changed, e.g. to kick
+	// a stuck rollout, but the value itself is never taken into account.
+	DeploymentResyncAnnotation = "rollouts.kruise.io/resync"
+
+	// DeploymentPausedAnnotation is annotation for deployment, Advanced Deployment
+	// will not touch the deployment or its ReplicaSets at all while it is "true", e.g.
+	// to debug a rollout, and resume from the current Partition once it is cleared.
+	DeploymentPausedAnnotation = "rollouts.kruise.io/deployment-paused"
+
+	// DeploymentDryRunAnnotation is annotation for deployment, Advanced Deployment only reports
+	// the scaling of ReplicaSets it would do in the extra status and events while it is "true",
+	// without creating, scaling or deleting any ReplicaSet. It requires the AdvancedDeploymentDryRun
+	// feature gate.
+	DeploymentDryRunAnnotation = "rollouts.kruise.io/deployment-dry-run"
+
+	// NamespaceFreezeAnnotation is annotation or label for namespace,
+	// all the Advanced Deployments in the namespace will hold their
+	// rolling while it is "true", and resume once it is cleared.
+	NamespaceFreezeAnnotation = "rollouts.kruise.io/freeze"
+
+	// DeploymentConfirmDrainAnnotation is annotation for deployment, whose value is the
+	// UpdateRevision of extra status. Advanced Deployment with ConfirmFinalDrain holds the
+	// last old Pod until it matches the current revision, and then scales it down.
+	DeploymentConfirmDrainAnnotation = "rollouts.kruise.io/confirm-final-drain"
+
+	// NamespaceMaxActiveRolloutsAnnotation is annotation for namespace, which caps how many
+	// Advanced Deployments in the namespace may roll at the same time. The rollouts beyond
+	// it are queued until the active ones complete.
+	NamespaceMaxActiveRolloutsAnnotation = "rollouts.kruise.io/max-active-rollouts"
+
+	// TestBatchReadinessGate is the readiness gate of the Pods created by Advanced Deployment
+	// in a test batch, whose condition is controlled by Advanced Deployment to keep these
+	// Pods out of Service endpoints until the test batch is promoted.
+	TestBatchReadinessGate = "rollouts.kruise.io/test-batch"
+
+	// RolloutGenerationLabel is label for the ReplicaSet and the auxiliary objects created by
+	// Advanced Deployment for a rollout, whose value is unique for each rollout attempt, so
+	// that all the objects of an attempt can be found or cleaned up by the label.
+	RolloutGenerationLabel = "rollouts.kruise.io/rollout-generation"
+
+	// SchedulingHintsAnnotation is annotation for the ReplicaSet created by Advanced Deployment,
+	// which records the SchedulingHints merged into its pod template in JSON, so that they can
+	// be told apart from the template of the Deployment.
+	SchedulingHintsAnnotation = "rollouts.kruise.io/scheduling-hints"
 )
 
 // DeploymentStrategy is strategy field for Advanced Deployment
@@ -21,13 +104,279 @@ type DeploymentStrategy struct {
 	RollingStyle RollingStyleType `json:"rollingStyle,omitempty"`
 	// original deployment strategy rolling update fields
 	RollingUpdate *apps.RollingUpdateDeployment `json:"rollingUpdate,omitempty"`
-	// Paused = true will block the upgrade of Pods
+	// MaxSurgeReplicas caps the surge of MaxSurge of RollingUpdate at an absolute number of
+	// Pods, e.g. to keep a large percentage on a big Deployment within the cluster capacity.
+	// +optional
+	MaxSurgeReplicas *int32 `json:"maxSurgeReplicas,omitempty"`
+	// Paused = true will block the upgrade of Pods, while the ReplicaSets are still scaled with
+	// the replicas of Deployment. The native spec.paused of Deployment is always true under rollout
+	// control, which keeps the native controller away, so it never pauses Advanced Deployment. Set
+	// DeploymentPausedAnnotation instead to hold the ReplicaSets as they are, which wins over Paused.
 	Paused bool `json:"paused,omitempty"`
 	// Partition describe how many Pods should be updated during rollout.
 	// We use this field to implement partition-style rolling update.
+	// An integer is the absolute number of updated Pods, and a percentage is the
+	// proportion of spec.replicas rounded up, both capped at spec.replicas. A
+	// percentage below 100% always leaves at least one old Pod if replicas > 1.
+	// The deployment will be held at Partition as the steady state, and will
+	// never be completed unless Partition is 100% or it is promoted via the
+	// DeploymentPromoteAnnotation.
 	Partition intstr.IntOrString `json:"partition,omitempty"`
+	// ManualPartitions are the partitions which wait for a human, e.g. the steps of a Rollout
+	// to be signed off. Once Partition is set to any of them, the Deployment is held where it
+	// is, with the WaitingForApproval condition set, until DeploymentApprovePartitionAnnotation
+	// is set to the same Partition, e.g. "30%" or "3". The approval is recorded in the extra
+	// status for the current revision, and the annotation is removed once taken.
+	// +optional
+	ManualPartitions []intstr.IntOrString `json:"manualPartitions,omitempty"`
+	// TestBatch = true means the new Pods of the current batch are only used for testing.
+	// They are surged beyond replicas and kept out of Service endpoints by the
+	// TestBatchReadinessGate, and no old Pods will be scaled down for them, until
+	// the batch is promoted to a real one by setting TestBatch to false.
+	// It only takes effect if the new ReplicaSet is created with TestBatch = true.
+	TestBatch bool `json:"testBatch,omitempty"`
+	// TrafficWeight overrides the traffic weight of the updated Pods exposed in the
+	// extra status, which is a percentage from 0 to 100. If it is not set, the ratio
+	// of updated ready replicas to replicas is exposed.
+	// +optional
+	TrafficWeight *int32 `json:"trafficWeight,omitempty"`
+	// ManageOldReplicaSets = false means Advanced Deployment only scales up the new ReplicaSet
+	// batch by batch, and never scales down or deletes the old ReplicaSets, which are left to
+	// another process or manual action. Defaults to true.
+	// +optional
+	ManageOldReplicaSets *bool `json:"manageOldReplicaSets,omitempty"`
+	// PodDeletionCost decides which Pods should be deleted first during rollout, e.g. scaled
+	// down by HPA, via the controller.kubernetes.io/pod-deletion-cost annotation of the Pods.
+	// Empty means the annotation is never set.
+	// +optional
+	PodDeletionCost PodDeletionCostPolicyType `json:"podDeletionCost,omitempty"`
+	// BatchSoak requires the new Pods of the current batch to have been continuously Ready
+	// for a while before the batch is considered healthy. The deployment will not advance
+	// to the next batch, even if Partition is increased or it is promoted, until then.
+	// +optional
+	BatchSoak *DeploymentBatchSoak `json:"batchSoak,omitempty"`
+	// BatchChecks requires a quorum of the custom checks registered in the controller to pass
+	// before the current batch is considered healthy. The deployment will not advance to the
+	// next batch, even if Partition is increased or it is promoted, until then.
+	// +optional
+	BatchChecks *DeploymentBatchChecks `json:"batchChecks,omitempty"`
+	// TinyDeployment decides how deployments with at most TinyDeploymentMaxReplicas replicas
+	// are rolled, where each Pod is a large share of the capacity. By default, a percentage
+	// Partition is rounded up, e.g. 30% of 1 replica updates the only Pod.
+	// +optional
+	TinyDeployment *TinyDeploymentPolicy `json:"tinyDeployment,omitempty"`
+	// MinCanaryReplicas is the min number of new Pods updated by a non-zero percentage
+	// Partition, so that a small percentage of a small deployment never yields no canary.
+	// It is still capped by replicas, and a Partition other than 100% never updates all
+	// of the Pods. The new Pods are surged or replaced within MaxSurge and MaxUnavailable
+	// as usual. 0 means the percentage is only rounded up. Defaults to 1.
+	// +optional
+	MinCanaryReplicas *int32 `json:"minCanaryReplicas,omitempty"`
+	// PostRolloutJob is a Job to verify each revision once it is completely rolled out, which
+	// is rolled back to the previous revision if the Job fails within the window.
+	// +optional
+	PostRolloutJob *DeploymentPostRolloutJob `json:"postRolloutJob,omitempty"`
+	// ProgressDeadlineFrom decides when the progressDeadlineSeconds of Deployment starts to count
+	// for each batch. Defaults to BatchStart.
+	// +optional
+	ProgressDeadlineFrom ProgressDeadlineFromType `json:"progressDeadlineFrom,omitempty"`
+	// ConfirmFinalDrain makes the new ReplicaSet scale up to full size while the old ReplicaSets
+	// keep a single Pod, which is only scaled down once the confirm-final-drain annotation of
+	// deployment matches the current revision. It requires a non-zero MaxSurge.
+	// +optional
+	ConfirmFinalDrain bool `json:"confirmFinalDrain,omitempty"`
+	// ReadinessRegression decides what to do once the new Pods of a completed batch regress to
+	// not Ready before the rollout completes, e.g. because of an outage of their dependency.
+	// The regression is ignored by default.
+	// +optional
+	ReadinessRegression ReadinessRegressionPolicyType `json:"readinessRegression,omitempty"`
+	// TemplateChangeWindowSeconds coalesces the successive template changes of deployment into
+	// a single rollout to the latest template. The new ReplicaSet is only created once the
+	// template has not changed for the window, and the current ReplicaSets are held as they are
+	// meanwhile. 0 means a rollout is started on each change.
+	// +optional
+	TemplateChangeWindowSeconds int32 `json:"templateChangeWindowSeconds,omitempty"`
+	// ReplicaSetNameTemplate is the name of the new ReplicaSets, in which ReplicaSetNameHash is
+	// replaced with the pod-template-hash, ReplicaSetNameDeployment with the deployment name and
+	// ReplicaSetNameRevision with the revision, e.g. "{deployment}-canary-{hash}". It must contain
+	// ReplicaSetNameHash to be unique for each template. The name of "<deployment>-<hash>" is used
+	// if it is empty, or the rendered name is not a DNS-1123 label.
+	// +optional
+	ReplicaSetNameTemplate string `json:"replicaSetNameTemplate,omitempty"`
+	// BatchUnschedulable detects the new Pods which stay Pending since they cannot be scheduled,
+	// e.g. because of insufficient cluster capacity, so that the rollout will not stall silently.
+	// +optional
+	BatchUnschedulable *DeploymentBatchUnschedulable `json:"batchUnschedulable,omitempty"`
+	// PendingGraceSeconds holds the rollout, neither scaling up the new ReplicaSet nor scaling
+	// down the old ones, while any new Pod has been Pending for longer than it, e.g. because of a
+	// capacity crunch, instead of leaving the deployment half rolled. Zero means never held.
+	// +optional
+	PendingGraceSeconds int32 `json:"pendingGraceSeconds,omitempty"`
+	// BatchProgressDeadlineSeconds is how long each batch may take to get its expected updated
+	// Pods available, counted from the start of the batch in the extra status. The rollout is
+	// frozen as it is once it is exceeded, with the Progressing condition of the Deployment set to
+	// ProgressDeadlineExceeded, until the new Pods become available or the batch is changed.
+	// Zero means no deadline.
+	// +optional
+	BatchProgressDeadlineSeconds int32 `json:"batchProgressDeadlineSeconds,omitempty"`
+	// RollbackPolicy rolls the deployment back to the previous revision once too many new Pods
+	// are failing, e.g. crash looping, and restores the old ReplicaSet at once instead of
+	// waiting for Partition to be lowered.
+	// +optional
+	RollbackPolicy *DeploymentRollbackPolicy `json:"rollbackPolicy,omitempty"`
+	// PauseSeconds holds each batch for a while after its new Pods are all available. The
+	// deployment will not advance to the next batch, even if Partition is increased or it is
+	// promoted, until then. Zero means no pause.
+	// +optional
+	PauseSeconds int32 `json:"pauseSeconds,omitempty"`
+	// StableService is the name of the Service in front of the Deployment. If set, a canary
+	// Service named after the Deployment with a "-canary" suffix is maintained while the old
+	// ReplicaSets still have replicas, which only selects the Pods of the new ReplicaSet by their
+	// pod-template-hash, with the ports and session affinity copied from StableService. It is
+	// deleted once the rollout is completed. Empty means no canary Service.
+	// +optional
+	StableService string `json:"stableService,omitempty"`
+	// ScaleDownStepSeconds drains the old ReplicaSets progressively at the final step, once the
+	// new ReplicaSet may be scaled to full size, by MaxUnavailable Pods, at least one, every
+	// ScaleDownStepSeconds, instead of scaling them down to zero at once. Zero means no steps.
+	// +optional
+	ScaleDownStepSeconds int32 `json:"scaleDownStepSeconds,omitempty"`
+	// VariantWeights splits the replicas among multiple ReplicaSets, e.g. the stable one and
+	// several canaries for A/B/C testing, by the weight of each pod-template-hash. The ReplicaSets
+	// not listed are scaled down to 0, and Partition is ignored while it is set. The listed hashes
+	// without a ReplicaSet yet are ignored until the template is updated to them.
+	// +optional
+	VariantWeights map[string]int32 `json:"variantWeights,omitempty"`
+	// SchedulingHints are merged into the pod template of each new ReplicaSet once it is created,
+	// e.g. to prefer the node pool of the stable Pods to keep the cache locality during rollout.
+	// They are appended to the affinity and topology spread constraints of the template instead
+	// of overwriting them, and the template is not regarded as changed by them.
+	// +optional
+	SchedulingHints *DeploymentSchedulingHints `json:"schedulingHints,omitempty"`
+}
+
+const (
+	// ReplicaSetNameDeployment is the placeholder of the deployment name in ReplicaSetNameTemplate.
+	ReplicaSetNameDeployment = "{deployment}"
+	// ReplicaSetNameHash is the placeholder of the pod-template-hash in ReplicaSetNameTemplate.
+	ReplicaSetNameHash = "{hash}"
+	// ReplicaSetNameRevision is the placeholder of the revision in ReplicaSetNameTemplate.
+	ReplicaSetNameRevision = "{revision}"
+)
+
+// ReadinessRegressionPolicyType is what Advanced Deployment does once the updated ready replicas
+// fall below the ones of a completed batch of the current rollout.
+type ReadinessRegressionPolicyType string
+
+const (
+	// HaltReadinessRegressionPolicy means the rollout is held at the completed batch, no matter
+	// how Partition is advanced, until the readiness recovers.
+	HaltReadinessRegressionPolicy ReadinessRegressionPolicyType = "Halt"
+	// RollbackReadinessRegressionPolicy means the deployment is rolled back to the previous
+	// revision, or held like Halt if there is no previous revision.
+	RollbackReadinessRegressionPolicy ReadinessRegressionPolicyType = "Rollback"
+)
+
+// ProgressDeadlineFromType is when the progress deadline of Advanced Deployment starts to count.
+type ProgressDeadlineFromType string
+
+const (
+	// BatchStartProgressDeadline means the progress deadline counts from the last progress of
+	// the batch, which is the same as the stock Deployment.
+	BatchStartProgressDeadline ProgressDeadlineFromType = "BatchStart"
+	// PodsInitializedProgressDeadline means the progress deadline does not count while any new
+	// Pod scheduled is still running its init containers, and then counts from the last time a
+	// new Pod is initialized, so that the long init containers will not time the rollout out.
+	PodsInitializedProgressDeadline ProgressDeadlineFromType = "PodsInitialized"
+)
+
+// DeploymentPostRolloutJob is the verification Job run after Advanced Deployment completes.
+type DeploymentPostRolloutJob struct {
+	// Template is the template of the Job, which is created in the namespace of the Deployment
+	// and deleted once it finishes or the window expires.
+	Template batchv1.JobTemplateSpec `json:"template"`
+	// WindowSeconds is how long to wait for the Job after the rollout completes. The Job is
+	// regarded as passed if it has not failed by then. 0 means waiting until it finishes.
+	// +optional
+	WindowSeconds int32 `json:"windowSeconds,omitempty"`
+}
+
+// TinyDeploymentMaxReplicas is the max replicas of deployments handled by TinyDeploymentPolicy.
+const TinyDeploymentMaxReplicas = 2
+
+// TinyDeploymentPolicy is the handling of tiny deployments by Advanced Deployment.
+type TinyDeploymentPolicy struct {
+	// AllOrNothing means a percentage Partition updates none of the Pods until it is 100%,
+	// instead of being rounded up. A Partition of an integer is still respected.
+	AllOrNothing bool `json:"allOrNothing,omitempty"`
+	// RequireSurge means a new Pod is always surged before an old Pod is scaled down, i.e.
+	// MaxUnavailable is 0 and MaxSurge is at least 1, so that the availability is maintained.
+	RequireSurge bool `json:"requireSurge,omitempty"`
+}
+
+// DeploymentBatchChecks is the custom checks of each batch of Advanced Deployment.
+type DeploymentBatchChecks struct {
+	// Names are the names of the checks to run, which are registered in the controller.
+	Names []string `json:"names"`
+	// Quorum is how many of the checks must pass. Defaults to all of them.
+	// +optional
+	Quorum *int32 `json:"quorum,omitempty"`
+	// PassingStreak is how many consecutive runs of the checks must reach the quorum before the
+	// batch is considered healthy, which are run at least the batch check interval apart. The
+	// streak is reset by any run not reaching the quorum. Defaults to 1.
+	// +optional
+	PassingStreak *int32 `json:"passingStreak,omitempty"`
+}
+
+// DeploymentBatchUnschedulable is the handling of the unschedulable new Pods of Advanced Deployment.
+type DeploymentBatchUnschedulable struct {
+	// Seconds is how long a new Pod may stay unschedulable before the batch is regarded as
+	// unschedulable, in case the cluster is scaled up by then.
+	// +optional
+	Seconds int32 `json:"seconds,omitempty"`
+	// Rollback means the deployment is rolled back to the previous revision once the batch is
+	// unschedulable, otherwise the rollout is only held with a warning event.
+	// +optional
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+// DeploymentRollbackPolicy is the automatic rollback of Advanced Deployment.
+type DeploymentRollbackPolicy struct {
+	// FailureThreshold is how many new Pods must be failing to trigger the rollback, which are
+	// unavailable because they are crash looping, failing to pull the image or Failed.
+	FailureThreshold int32 `json:"failureThreshold"`
 }
 
+// DeploymentSchedulingHints are the scheduling hints of the new Pods of Advanced Deployment.
+type DeploymentSchedulingHints struct {
+	// PreferredNodeAffinity is appended to the preferred node affinity of the pod template.
+	// +optional
+	PreferredNodeAffinity []corev1.PreferredSchedulingTerm `json:"preferredNodeAffinity,omitempty"`
+	// TopologySpreadConstraints are appended to the ones of the pod template, except those whose
+	// topologyKey and whenUnsatisfiable are constrained by the template already.
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
+// DeploymentBatchSoak is the soak requirement of each batch of Advanced Deployment.
+type DeploymentBatchSoak struct {
+	// Seconds is how long a new Pod must have been continuously Ready to be soaked.
+	Seconds int32 `json:"seconds"`
+	// Percent is the percentage of the new Pods expected by the current batch which must
+	// be soaked, from 1 to 100. Defaults to 100.
+	// +optional
+	Percent *int32 `json:"percent,omitempty"`
+}
+
+type PodDeletionCostPolicyType string
+
+const (
+	// ProtectCanaryPodDeletionCostPolicy means the old Pods are deleted before the new Pods.
+	ProtectCanaryPodDeletionCostPolicy PodDeletionCostPolicyType = "ProtectCanary"
+	// ProtectStablePodDeletionCostPolicy means the new Pods are deleted before the old Pods.
+	ProtectStablePodDeletionCostPolicy PodDeletionCostPolicyType = "ProtectStable"
+)
+
 type RollingStyleType string
 
 const (
@@ -37,8 +386,15 @@ const (
 	CanaryRollingStyleType RollingStyleType = "Canary"
 )
 
+// DeploymentExtraStatusSchemaVersion is the current schema version of DeploymentExtraStatus.
+// The extra status without schema version is written by the controllers of older versions.
+const DeploymentExtraStatusSchemaVersion = 1
+
 // DeploymentExtraStatus is extra status field for Advanced Deployment
 type DeploymentExtraStatus struct {
+	// SchemaVersion is the schema version of the extra status, which is used to migrate the
+	// extra status written by the controllers of older versions.
+	SchemaVersion int32 `json:"schemaVersion,omitempty"`
 	// ObservedGeneration record the generation of deployment this status observed.
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 	// UpdatedReadyReplicas the number of pods that has been updated and ready.
@@ -49,6 +405,140 @@ type DeploymentExtraStatus struct {
 	// This field is designed to avoid users to fall into the details of algorithm
 	// for Partition calculation.
 	ExpectedUpdatedReplicas int32 `json:"expectedUpdatedReplicas,omitempty"`
+	// BatchReadiness is the readiness progress of the current batch as a fraction, e.g. "3/5"
+	// means 3 of the 5 pods expected to be updated are ready. It is empty if no pod is expected
+	// to be updated.
+	BatchReadiness string `json:"batchReadiness,omitempty"`
+	// TrafficWeight is the percentage of traffic expected to be routed to the updated
+	// Pods, so that the routing layers can track the capacity of the new version.
+	TrafficWeight int32 `json:"trafficWeight"`
+	// UpdateRevision is the pod-template-hash of the new replica set.
+	UpdateRevision string `json:"updateRevision,omitempty"`
+	// CorrelationID identifies the rollout to UpdateRevision in the logs of Advanced Deployment.
+	// It is the UID of the BatchRelease controlling the deployment if any, otherwise generated
+	// once the deployment starts rolling to a new revision.
+	CorrelationID string `json:"correlationID,omitempty"`
+	// VerifiedRevision is the pod-template-hash of the last revision verified by PostRolloutJob,
+	// including the one rolled back to, which is never verified again.
+	VerifiedRevision string `json:"verifiedRevision,omitempty"`
+	// ApprovedPartition is the manual partition approved for ApprovedRevision via
+	// DeploymentApprovePartitionAnnotation, see ManualPartitions of strategy.
+	ApprovedPartition string `json:"approvedPartition,omitempty"`
+	// ApprovedRevision is the pod-template-hash computed from the template ApprovedPartition is
+	// approved for, which is known before the new replica set is created.
+	ApprovedRevision string `json:"approvedRevision,omitempty"`
+	// RolloutStartTime is the time when the deployment started rolling to UpdateRevision.
+	RolloutStartTime *metav1.Time `json:"rolloutStartTime,omitempty"`
+	// BatchStartTime is the time when the deployment started rolling to ExpectedUpdatedReplicas.
+	BatchStartTime *metav1.Time `json:"batchStartTime,omitempty"`
+	// BatchSLABreached is true if the current batch has taken longer than the batch SLA.
+	BatchSLABreached bool `json:"batchSLABreached,omitempty"`
+	// RolloutSLABreached is true if the rollout has taken longer than the rollout SLA.
+	RolloutSLABreached bool `json:"rolloutSLABreached,omitempty"`
+	// PausedUpdatedReplicas and PausedReplicas are the replicas of the new replica set and the
+	// deployment when it was paused, whose ratio is frozen to distribute the replicas changed
+	// while paused, e.g. by HPA. They are cleared once the deployment is resumed.
+	PausedUpdatedReplicas int32 `json:"pausedUpdatedReplicas,omitempty"`
+	PausedReplicas        int32 `json:"pausedReplicas,omitempty"`
+	// SoakedReplicas is the number of new Pods which have been continuously Ready for the
+	// soak duration, and BatchSoaked is true if they are enough for the current batch.
+	// They are only set if BatchSoak of strategy is set.
+	SoakedReplicas int32 `json:"soakedReplicas,omitempty"`
+	BatchSoaked    bool  `json:"batchSoaked,omitempty"`
+	// BatchAvailableTime is the time when the new Pods of the current batch became all available,
+	// and BatchPauseElapsed is true if PauseSeconds has elapsed since then. They are only set if
+	// PauseSeconds of strategy is set.
+	BatchAvailableTime *metav1.Time `json:"batchAvailableTime,omitempty"`
+	BatchPauseElapsed  bool         `json:"batchPauseElapsed,omitempty"`
+	// PassedBatchChecks is the number of custom checks passed for the current batch, and
+	// BatchChecksPassed is true if they reach the quorum. They are only set if BatchChecks
+	// of strategy is set.
+	PassedBatchChecks int32 `json:"passedBatchChecks,omitempty"`
+	BatchChecksPassed bool  `json:"batchChecksPassed,omitempty"`
+	// BatchChecksStreak is the number of consecutive runs of the custom checks reaching the quorum
+	// for the current batch, the last of which is run at BatchChecksRunTime. They are only set if
+	// PassingStreak of BatchChecks is more than 1.
+	BatchChecksStreak  int32        `json:"batchChecksStreak,omitempty"`
+	BatchChecksRunTime *metav1.Time `json:"batchChecksRunTime,omitempty"`
+	// Queued is true if the rollout is waiting for other rollouts in the namespace to complete,
+	// because of the max active rollouts of the namespace.
+	Queued bool `json:"queued,omitempty"`
+	// CompletedBatchReplicas is the expected updated replicas of the last completed batch of the
+	// current rollout, and ReadinessRegressed is true while the updated ready replicas are fewer
+	// than them. They are only set if ReadinessRegression of strategy is set.
+	CompletedBatchReplicas int32 `json:"completedBatchReplicas,omitempty"`
+	ReadinessRegressed     bool  `json:"readinessRegressed,omitempty"`
+	// PendingRevision is the pod-template-hash of the changed template waiting for the window
+	// since PendingSince, before its new ReplicaSet is created. They are only set if
+	// TemplateChangeWindowSeconds of strategy is set.
+	PendingRevision string       `json:"pendingRevision,omitempty"`
+	PendingSince    *metav1.Time `json:"pendingSince,omitempty"`
+	// ScaleDownStepTime is the time when the old ReplicaSets were last scaled down at the final
+	// step. It is only set if ScaleDownStepSeconds of strategy is set.
+	ScaleDownStepTime *metav1.Time `json:"scaleDownStepTime,omitempty"`
+	// DryRunScales are the scaling of ReplicaSets Advanced Deployment would do in the last sync for
+	// the current step, which is only set while the deployment is in dry-run mode.
+	DryRunScales []DeploymentReplicaSetScale `json:"dryRunScales,omitempty"`
+	// OldReplicaSets are the old ReplicaSets which still have replicas, from the latest revision
+	// to the oldest. Only the first MaxReportedOldReplicaSets of them are reported.
+	OldReplicaSets []DeploymentReplicaSetSize `json:"oldReplicaSets,omitempty"`
+	// History records the batches the deployment has rolled, from the oldest to the latest.
+	// The oldest records are dropped once the annotation exceeds the configured size.
+	History []DeploymentProgressRecord `json:"history,omitempty"`
+}
+
+// MaxReportedOldReplicaSets is the max number of old ReplicaSets reported in the extra status.
+const MaxReportedOldReplicaSets = 5
+
+// DeploymentStrategyStatus is the effective strategy of Advanced Deployment, decoded from the
+// strategy annotation with defaults, so that it can be read without parsing the strategy.
+type DeploymentStrategyStatus struct {
+	// RollingStyle is the rolling style of the strategy.
+	RollingStyle RollingStyleType `json:"rollingStyle"`
+	// Partition is the partition of the strategy, and PartitionReplicas is the number of Pods it
+	// allows to be updated, which is all the replicas once the deployment is promoted.
+	Partition         string `json:"partition"`
+	PartitionReplicas int32  `json:"partitionReplicas"`
+	// Promoted is true if the deployment is promoted to roll out all the replicas.
+	Promoted bool `json:"promoted,omitempty"`
+	// Paused is true if the rollout is paused by the strategy, annotation or namespace freeze.
+	Paused bool `json:"paused,omitempty"`
+	// MaxSurge and MaxUnavailable are the rolling update parameters of the strategy.
+	MaxSurge       string `json:"maxSurge"`
+	MaxUnavailable string `json:"maxUnavailable"`
+}
+
+// DeploymentReplicaSetSize is the size of a ReplicaSet of Advanced Deployment.
+type DeploymentReplicaSetSize struct {
+	// Name is the name of the ReplicaSet.
+	Name string `json:"name"`
+	// Replicas is the spec.replicas of the ReplicaSet.
+	Replicas int32 `json:"replicas"`
+}
+
+// DeploymentReplicaSetScale is a scaling of a ReplicaSet of Advanced Deployment.
+type DeploymentReplicaSetScale struct {
+	// Name is the name of the ReplicaSet.
+	Name string `json:"name"`
+	// Replicas is the current spec.replicas of the ReplicaSet, which is 0 if it is to be created.
+	Replicas int32 `json:"replicas"`
+	// TargetReplicas is the spec.replicas the ReplicaSet is scaled to.
+	TargetReplicas int32 `json:"targetReplicas"`
+	// Create is true if the ReplicaSet does not exist yet and is to be created.
+	Create bool `json:"create,omitempty"`
+}
+
+// DeploymentProgressRecord is the record of a batch of Advanced Deployment.
+type DeploymentProgressRecord struct {
+	// Revision is the pod-template-hash of the new replica set rolled in this batch.
+	Revision string `json:"revision"`
+	// ExpectedUpdatedReplicas is the number of pods expected to be updated in this batch.
+	ExpectedUpdatedReplicas int32 `json:"expectedUpdatedReplicas"`
+	// StartTime is the time when the batch started.
+	StartTime metav1.Time `json:"startTime"`
+	// Diff summarizes the diff between the stable and new pod templates, which is only
+	// recorded in the first batch of each revision.
+	Diff string `json:"diff,omitempty"`
 }
 
 func SetDefaultDeploymentStrategy(strategy *DeploymentStrategy) {
@@ -66,7 +556,7 @@ func SetDefaultDeploymentStrategy(strategy *DeploymentStrategy) {
 	if strategy.RollingUpdate.MaxSurge == nil {
 		// Set MaxSurge as 25% by default
 		maxSurge := intstr.FromString("25%")
-		strategy.RollingUpdate.MaxUnavailable = &maxSurge
+		strategy.RollingUpdate.MaxSurge = &maxSurge
 	}
 
 	// Cannot allow maxSurge==0 && MaxUnavailable==0, otherwise, no pod can be updated when rolling update.
@@ -79,3 +569,220 @@ func SetDefaultDeploymentStrategy(strategy *DeploymentStrategy) {
 		}
 	}
 }
+
+// ValidateDeploymentStrategy validates the strategy of Advanced Deployment before it is defaulted,
+// it is shared by the webhook and the controller, so that the combinations of maxSurge and
+// maxUnavailable with which the rolling can never make progress are rejected by both.
+func ValidateDeploymentStrategy(strategy *DeploymentStrategy, fldPath *field.Path) field.ErrorList {
+	var errList field.ErrorList
+	switch strategy.RollingStyle {
+	case "", PartitionRollingStyleType:
+	case CanaryRollingStyleType:
+		return nil
+	default:
+		return append(errList, field.NotSupported(fldPath.Child("rollingStyle"), strategy.RollingStyle,
+			[]string{string(PartitionRollingStyleType), string(CanaryRollingStyleType)}))
+	}
+
+	errList = append(errList, validateIntOrPercent(&strategy.Partition, fldPath.Child("partition"), true)...)
+	for i := range strategy.ManualPartitions {
+		errList = append(errList, validateIntOrPercent(&strategy.ManualPartitions[i], fldPath.Child("manualPartitions").Index(i), true)...)
+	}
+	if soak := strategy.BatchSoak; soak != nil {
+		if soak.Seconds < 0 {
+			errList = append(errList, field.Invalid(fldPath.Child("batchSoak", "seconds"), soak.Seconds, "must be non-negative"))
+		}
+		if soak.Percent != nil && (*soak.Percent < 1 || *soak.Percent > 100) {
+			errList = append(errList, field.Invalid(fldPath.Child("batchSoak", "percent"), *soak.Percent, "must be between 1 and 100"))
+		}
+	}
+	if unschedulable := strategy.BatchUnschedulable; unschedulable != nil && unschedulable.Seconds < 0 {
+		errList = append(errList, field.Invalid(fldPath.Child("batchUnschedulable", "seconds"), unschedulable.Seconds, "must be non-negative"))
+	}
+	if checks := strategy.BatchChecks; checks != nil {
+		if len(checks.Names) == 0 {
+			errList = append(errList, field.Required(fldPath.Child("batchChecks", "names"), "at least one check is required"))
+		}
+		if checks.Quorum != nil && (*checks.Quorum < 1 || int(*checks.Quorum) > len(checks.Names)) {
+			errList = append(errList, field.Invalid(fldPath.Child("batchChecks", "quorum"), *checks.Quorum, "must be between 1 and the number of checks"))
+		}
+		if checks.PassingStreak != nil && *checks.PassingStreak < 1 {
+			errList = append(errList, field.Invalid(fldPath.Child("batchChecks", "passingStreak"), *checks.PassingStreak, "must be positive"))
+		}
+	}
+	switch strategy.PodDeletionCost {
+	case "", ProtectCanaryPodDeletionCostPolicy, ProtectStablePodDeletionCostPolicy:
+	default:
+		errList = append(errList, field.NotSupported(fldPath.Child("podDeletionCost"), strategy.PodDeletionCost,
+			[]string{string(ProtectCanaryPodDeletionCostPolicy), string(ProtectStablePodDeletionCostPolicy)}))
+	}
+	if job := strategy.PostRolloutJob; job != nil {
+		if len(job.Template.Spec.Template.Spec.Containers) == 0 {
+			errList = append(errList, field.Required(fldPath.Child("postRolloutJob", "template", "spec", "template", "spec", "containers"), "at least one container is required"))
+		}
+		if job.WindowSeconds < 0 {
+			errList = append(errList, field.Invalid(fldPath.Child("postRolloutJob", "windowSeconds"), job.WindowSeconds, "must be non-negative"))
+		}
+	}
+	switch strategy.ProgressDeadlineFrom {
+	case "", BatchStartProgressDeadline, PodsInitializedProgressDeadline:
+	default:
+		errList = append(errList, field.NotSupported(fldPath.Child("progressDeadlineFrom"), strategy.ProgressDeadlineFrom,
+			[]string{string(BatchStartProgressDeadline), string(PodsInitializedProgressDeadline)}))
+	}
+	switch strategy.ReadinessRegression {
+	case "", HaltReadinessRegressionPolicy, RollbackReadinessRegressionPolicy:
+	default:
+		errList = append(errList, field.NotSupported(fldPath.Child("readinessRegression"), strategy.ReadinessRegression,
+			[]string{string(HaltReadinessRegressionPolicy), string(RollbackReadinessRegressionPolicy)}))
+	}
+	if strategy.ReplicaSetNameTemplate != "" && !strings.Contains(strategy.ReplicaSetNameTemplate, ReplicaSetNameHash) {
+		errList = append(errList, field.Invalid(fldPath.Child("replicaSetNameTemplate"), strategy.ReplicaSetNameTemplate, fmt.Sprintf("must contain %s", ReplicaSetNameHash)))
+	}
+	if policy := strategy.RollbackPolicy; policy != nil && policy.FailureThreshold < 1 {
+		errList = append(errList, field.Invalid(fldPath.Child("rollbackPolicy", "failureThreshold"), policy.FailureThreshold, "must be positive"))
+	}
+	if len(strategy.VariantWeights) > 0 {
+		errList = append(errList, validateVariantWeights(strategy, fldPath.Child("variantWeights"))...)
+	}
+	if strategy.MaxSurgeReplicas != nil && *strategy.MaxSurgeReplicas < 1 {
+		errList = append(errList, field.Invalid(fldPath.Child("maxSurgeReplicas"), *strategy.MaxSurgeReplicas, "must be positive"))
+	}
+	if strategy.SchedulingHints != nil {
+		errList = append(errList, validateSchedulingHints(strategy.SchedulingHints, fldPath.Child("schedulingHints"))...)
+	}
+	if strategy.PendingGraceSeconds < 0 {
+		errList = append(errList, field.Invalid(fldPath.Child("pendingGraceSeconds"), strategy.PendingGraceSeconds, "must be non-negative"))
+	}
+	if strategy.BatchProgressDeadlineSeconds < 0 {
+		errList = append(errList, field.Invalid(fldPath.Child("batchProgressDeadlineSeconds"), strategy.BatchProgressDeadlineSeconds, "must be non-negative"))
+	}
+	if strategy.StableService != "" {
+		for _, msg := range validation.IsDNS1035Label(strategy.StableService) {
+			errList = append(errList, field.Invalid(fldPath.Child("stableService"), strategy.StableService, msg))
+		}
+	}
+	if strategy.PauseSeconds < 0 {
+		errList = append(errList, field.Invalid(fldPath.Child("pauseSeconds"), strategy.PauseSeconds, "must be non-negative"))
+	}
+	if strategy.ScaleDownStepSeconds < 0 {
+		errList = append(errList, field.Invalid(fldPath.Child("scaleDownStepSeconds"), strategy.ScaleDownStepSeconds, "must be non-negative"))
+	}
+	if strategy.TemplateChangeWindowSeconds < 0 {
+		errList = append(errList, field.Invalid(fldPath.Child("templateChangeWindowSeconds"), strategy.TemplateChangeWindowSeconds, "must be non-negative"))
+	}
+	if strategy.MinCanaryReplicas != nil && *strategy.MinCanaryReplicas < 0 {
+		errList = append(errList, field.Invalid(fldPath.Child("minCanaryReplicas"), *strategy.MinCanaryReplicas, "must be non-negative"))
+	}
+	if strategy.TrafficWeight != nil && (*strategy.TrafficWeight < 0 || *strategy.TrafficWeight > 100) {
+		errList = append(errList, field.Invalid(fldPath.Child("trafficWeight"), *strategy.TrafficWeight, "must be between 0 and 100"))
+	}
+	if strategy.RollingUpdate == nil {
+		return errList
+	}
+	rollingUpdatePath := fldPath.Child("rollingUpdate")
+	maxSurge, maxUnavailable := strategy.RollingUpdate.MaxSurge, strategy.RollingUpdate.MaxUnavailable
+	if maxSurge != nil {
+		errList = append(errList, validateIntOrPercent(maxSurge, rollingUpdatePath.Child("maxSurge"), false)...)
+	}
+	if maxUnavailable != nil {
+		errList = append(errList, validateIntOrPercent(maxUnavailable, rollingUpdatePath.Child("maxUnavailable"), true)...)
+	}
+	if len(errList) > 0 || maxSurge == nil || maxUnavailable == nil {
+		return errList
+	}
+
+	// No pod can be created or deleted if both of them are 0, so the rolling will be stuck forever.
+	surge, _ := intstr.GetScaledValueFromIntOrPercent(maxSurge, 100, true)
+	unavailable, _ := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, 100, true)
+	if surge == 0 && unavailable == 0 {
+		errList = append(errList, field.Invalid(rollingUpdatePath, fmt.Sprintf("maxSurge=%s, maxUnavailable=%s", maxSurge.String(), maxUnavailable.String()),
+			"maxSurge and maxUnavailable cannot be both 0, set maxSurge to 1 to surge a new pod first, or set maxUnavailable to 1 to replace an old pod in place"))
+	}
+	// The last old pod is kept beside the full new replica set until the drain is confirmed.
+	if strategy.ConfirmFinalDrain && surge == 0 {
+		errList = append(errList, field.Invalid(rollingUpdatePath.Child("maxSurge"), maxSurge.String(), "must be greater than 0 if confirmFinalDrain is set"))
+	}
+	return errList
+}
+
+// validateSchedulingHints validates that the hints are valid to be merged into any pod template,
+// the constraints duplicated with the template are left out on merging instead.
+func validateSchedulingHints(hints *DeploymentSchedulingHints, fldPath *field.Path) field.ErrorList {
+	var errList field.ErrorList
+	for i, term := range hints.PreferredNodeAffinity {
+		if term.Weight < 1 || term.Weight > 100 {
+			errList = append(errList, field.Invalid(fldPath.Child("preferredNodeAffinity").Index(i).Child("weight"), term.Weight, "must be between 1 and 100"))
+		}
+	}
+	constrained := map[string]bool{}
+	for i, constraint := range hints.TopologySpreadConstraints {
+		constraintPath := fldPath.Child("topologySpreadConstraints").Index(i)
+		if constraint.MaxSkew < 1 {
+			errList = append(errList, field.Invalid(constraintPath.Child("maxSkew"), constraint.MaxSkew, "must be positive"))
+		}
+		if constraint.TopologyKey == "" {
+			errList = append(errList, field.Required(constraintPath.Child("topologyKey"), ""))
+		}
+		switch constraint.WhenUnsatisfiable {
+		case corev1.DoNotSchedule, corev1.ScheduleAnyway:
+		default:
+			errList = append(errList, field.NotSupported(constraintPath.Child("whenUnsatisfiable"), constraint.WhenUnsatisfiable,
+				[]string{string(corev1.DoNotSchedule), string(corev1.ScheduleAnyway)}))
+		}
+		key := fmt.Sprintf("%s/%s", constraint.TopologyKey, constraint.WhenUnsatisfiable)
+		if constrained[key] {
+			errList = append(errList, field.Duplicate(constraintPath, key))
+		}
+		constrained[key] = true
+	}
+	return errList
+}
+
+// validateVariantWeights validates that the weights are non-negative with a positive total, and
+// all the replica sets can be scaled against them.
+func validateVariantWeights(strategy *DeploymentStrategy, fldPath *field.Path) field.ErrorList {
+	var errList field.ErrorList
+	hashes := make([]string, 0, len(strategy.VariantWeights))
+	for hash := range strategy.VariantWeights {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	total := int64(0)
+	for _, hash := range hashes {
+		weight := strategy.VariantWeights[hash]
+		if hash == "" {
+			errList = append(errList, field.Invalid(fldPath, hash, "pod-template-hash must not be empty"))
+		}
+		if weight < 0 {
+			errList = append(errList, field.Invalid(fldPath.Key(hash), weight, "must be non-negative"))
+		}
+		total += int64(weight)
+	}
+	if total <= 0 {
+		errList = append(errList, field.Invalid(fldPath, strategy.VariantWeights, "must have a positive weight"))
+	}
+	if strategy.TestBatch {
+		errList = append(errList, field.Forbidden(fldPath, "must not be set with testBatch"))
+	}
+	if strategy.ManageOldReplicaSets != nil && !*strategy.ManageOldReplicaSets {
+		errList = append(errList, field.Forbidden(fldPath, "must not be set with manageOldReplicaSets false"))
+	}
+	return errList
+}
+
+// validateIntOrPercent validates that value is a non-negative integer or percentage,
+// and the percentage is no more than 100% if it is limited.
+func validateIntOrPercent(value *intstr.IntOrString, fldPath *field.Path, limited bool) field.ErrorList {
+	scaled, err := intstr.GetScaledValueFromIntOrPercent(value, 100, true)
+	if err != nil {
+		return field.ErrorList{field.Invalid(fldPath, value.String(), "must be an integer or a percentage, e.g. 1 or 25%")}
+	}
+	if scaled < 0 {
+		return field.ErrorList{field.Invalid(fldPath, value.String(), "must be non-negative")}
+	}
+	if limited && value.Type == intstr.String && scaled > 100 {
+		return field.ErrorList{field.Invalid(fldPath, value.String(), "must not be greater than 100%")}
+	}
+	return nil
+}
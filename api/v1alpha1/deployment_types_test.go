@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestSetDefaultDeploymentStrategy(t *testing.T) {
+	percent := func(value string) *intstr.IntOrString {
+		v := intstr.FromString(value)
+		return &v
+	}
+	number := func(value int) *intstr.IntOrString {
+		v := intstr.FromInt(value)
+		return &v
+	}
+	cases := []struct {
+		name     string
+		strategy DeploymentStrategy
+		expect   *apps.RollingUpdateDeployment
+	}{
+		{
+			name:     "default both",
+			strategy: DeploymentStrategy{},
+			expect:   &apps.RollingUpdateDeployment{MaxSurge: percent("25%"), MaxUnavailable: percent("25%")},
+		},
+		{
+			name: "default max surge only",
+			strategy: DeploymentStrategy{
+				RollingUpdate: &apps.RollingUpdateDeployment{MaxUnavailable: number(2)},
+			},
+			expect: &apps.RollingUpdateDeployment{MaxSurge: percent("25%"), MaxUnavailable: number(2)},
+		},
+		{
+			name: "default max unavailable only",
+			strategy: DeploymentStrategy{
+				RollingUpdate: &apps.RollingUpdateDeployment{MaxSurge: number(3)},
+			},
+			expect: &apps.RollingUpdateDeployment{MaxSurge: number(3), MaxUnavailable: percent("25%")},
+		},
+		{
+			name: "both zero",
+			strategy: DeploymentStrategy{
+				RollingUpdate: &apps.RollingUpdateDeployment{MaxSurge: number(0), MaxUnavailable: percent("0%")},
+			},
+			expect: &apps.RollingUpdateDeployment{MaxSurge: number(0), MaxUnavailable: number(1)},
+		},
+		{
+			name:     "canary",
+			strategy: DeploymentStrategy{RollingStyle: CanaryRollingStyleType},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			SetDefaultDeploymentStrategy(&cs.strategy)
+			if !reflect.DeepEqual(cs.strategy.RollingUpdate, cs.expect) {
+				t.Fatalf("expect rolling update %+v, got %+v", cs.expect, cs.strategy.RollingUpdate)
+			}
+		})
+	}
+}
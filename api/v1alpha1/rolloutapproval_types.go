@@ -0,0 +1,64 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RolloutApprovalSpec defines the desired state of RolloutApproval
+type RolloutApprovalSpec struct {
+	// TargetRef refers to the workload in the same namespace to approve,
+	// only Advanced Deployment is supported now.
+	TargetRef WorkloadRef `json:"targetRef"`
+	// Revision is the pod-template-hash of the workload revision to approve,
+	// the approval never matches any other revision of the workload.
+	Revision string `json:"revision"`
+	// Approved indicates whether the revision is allowed to advance past the gated batches.
+	// Approvals take precedence over the DeploymentPromoteAnnotation, so a matching
+	// approval that is not approved holds the workload even if it is annotated as promoted.
+	// +optional
+	Approved bool `json:"approved,omitempty"`
+}
+
+// +genclient
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="TARGET",type="string",JSONPath=".spec.targetRef.name",description="The name of the workload to approve"
+//+kubebuilder:printcolumn:name="REVISION",type="string",JSONPath=".spec.revision",description="The revision of the workload to approve"
+//+kubebuilder:printcolumn:name="APPROVED",type="boolean",JSONPath=".spec.approved",description="Whether the revision is approved"
+//+kubebuilder:printcolumn:name="AGE",type=date,JSONPath=".metadata.creationTimestamp"
+
+// RolloutApproval is the Schema for the rolloutapprovals API
+type RolloutApproval struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RolloutApprovalSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RolloutApprovalList contains a list of RolloutApproval
+type RolloutApprovalList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RolloutApproval `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RolloutApproval{}, &RolloutApprovalList{})
+}
@@ -23,6 +23,7 @@ package v1alpha1
 
 import (
 	"k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/gateway-api/apis/v1alpha2"
@@ -266,9 +267,115 @@ func (in *CanaryStrategy) DeepCopy() *CanaryStrategy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentBatchChecks) DeepCopyInto(out *DeploymentBatchChecks) {
+	*out = *in
+	if in.Names != nil {
+		in, out := &in.Names, &out.Names
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Quorum != nil {
+		in, out := &in.Quorum, &out.Quorum
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PassingStreak != nil {
+		in, out := &in.PassingStreak, &out.PassingStreak
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentBatchChecks.
+func (in *DeploymentBatchChecks) DeepCopy() *DeploymentBatchChecks {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentBatchChecks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentBatchSoak) DeepCopyInto(out *DeploymentBatchSoak) {
+	*out = *in
+	if in.Percent != nil {
+		in, out := &in.Percent, &out.Percent
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentBatchSoak.
+func (in *DeploymentBatchSoak) DeepCopy() *DeploymentBatchSoak {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentBatchSoak)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentBatchUnschedulable) DeepCopyInto(out *DeploymentBatchUnschedulable) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentBatchUnschedulable.
+func (in *DeploymentBatchUnschedulable) DeepCopy() *DeploymentBatchUnschedulable {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentBatchUnschedulable)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeploymentExtraStatus) DeepCopyInto(out *DeploymentExtraStatus) {
 	*out = *in
+	if in.RolloutStartTime != nil {
+		in, out := &in.RolloutStartTime, &out.RolloutStartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.BatchStartTime != nil {
+		in, out := &in.BatchStartTime, &out.BatchStartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.BatchAvailableTime != nil {
+		in, out := &in.BatchAvailableTime, &out.BatchAvailableTime
+		*out = (*in).DeepCopy()
+	}
+	if in.BatchChecksRunTime != nil {
+		in, out := &in.BatchChecksRunTime, &out.BatchChecksRunTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PendingSince != nil {
+		in, out := &in.PendingSince, &out.PendingSince
+		*out = (*in).DeepCopy()
+	}
+	if in.ScaleDownStepTime != nil {
+		in, out := &in.ScaleDownStepTime, &out.ScaleDownStepTime
+		*out = (*in).DeepCopy()
+	}
+	if in.DryRunScales != nil {
+		in, out := &in.DryRunScales, &out.DryRunScales
+		*out = make([]DeploymentReplicaSetScale, len(*in))
+		copy(*out, *in)
+	}
+	if in.OldReplicaSets != nil {
+		in, out := &in.OldReplicaSets, &out.OldReplicaSets
+		*out = make([]DeploymentReplicaSetSize, len(*in))
+		copy(*out, *in)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]DeploymentProgressRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentExtraStatus.
@@ -281,6 +388,112 @@ func (in *DeploymentExtraStatus) DeepCopy() *DeploymentExtraStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentPostRolloutJob) DeepCopyInto(out *DeploymentPostRolloutJob) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentPostRolloutJob.
+func (in *DeploymentPostRolloutJob) DeepCopy() *DeploymentPostRolloutJob {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentPostRolloutJob)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentProgressRecord) DeepCopyInto(out *DeploymentProgressRecord) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentProgressRecord.
+func (in *DeploymentProgressRecord) DeepCopy() *DeploymentProgressRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentProgressRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentReplicaSetScale) DeepCopyInto(out *DeploymentReplicaSetScale) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentReplicaSetScale.
+func (in *DeploymentReplicaSetScale) DeepCopy() *DeploymentReplicaSetScale {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentReplicaSetScale)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentReplicaSetSize) DeepCopyInto(out *DeploymentReplicaSetSize) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentReplicaSetSize.
+func (in *DeploymentReplicaSetSize) DeepCopy() *DeploymentReplicaSetSize {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentReplicaSetSize)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentRollbackPolicy) DeepCopyInto(out *DeploymentRollbackPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentRollbackPolicy.
+func (in *DeploymentRollbackPolicy) DeepCopy() *DeploymentRollbackPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentRollbackPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentSchedulingHints) DeepCopyInto(out *DeploymentSchedulingHints) {
+	*out = *in
+	if in.PreferredNodeAffinity != nil {
+		in, out := &in.PreferredNodeAffinity, &out.PreferredNodeAffinity
+		*out = make([]corev1.PreferredSchedulingTerm, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]corev1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentSchedulingHints.
+func (in *DeploymentSchedulingHints) DeepCopy() *DeploymentSchedulingHints {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentSchedulingHints)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DeploymentStrategy) DeepCopyInto(out *DeploymentStrategy) {
 	*out = *in
@@ -289,7 +502,74 @@ func (in *DeploymentStrategy) DeepCopyInto(out *DeploymentStrategy) {
 		*out = new(v1.RollingUpdateDeployment)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.MaxSurgeReplicas != nil {
+		in, out := &in.MaxSurgeReplicas, &out.MaxSurgeReplicas
+		*out = new(int32)
+		**out = **in
+	}
 	out.Partition = in.Partition
+	if in.ManualPartitions != nil {
+		in, out := &in.ManualPartitions, &out.ManualPartitions
+		*out = make([]intstr.IntOrString, len(*in))
+		copy(*out, *in)
+	}
+	if in.TrafficWeight != nil {
+		in, out := &in.TrafficWeight, &out.TrafficWeight
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ManageOldReplicaSets != nil {
+		in, out := &in.ManageOldReplicaSets, &out.ManageOldReplicaSets
+		*out = new(bool)
+		**out = **in
+	}
+	if in.BatchSoak != nil {
+		in, out := &in.BatchSoak, &out.BatchSoak
+		*out = new(DeploymentBatchSoak)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BatchChecks != nil {
+		in, out := &in.BatchChecks, &out.BatchChecks
+		*out = new(DeploymentBatchChecks)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TinyDeployment != nil {
+		in, out := &in.TinyDeployment, &out.TinyDeployment
+		*out = new(TinyDeploymentPolicy)
+		**out = **in
+	}
+	if in.MinCanaryReplicas != nil {
+		in, out := &in.MinCanaryReplicas, &out.MinCanaryReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PostRolloutJob != nil {
+		in, out := &in.PostRolloutJob, &out.PostRolloutJob
+		*out = new(DeploymentPostRolloutJob)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BatchUnschedulable != nil {
+		in, out := &in.BatchUnschedulable, &out.BatchUnschedulable
+		*out = new(DeploymentBatchUnschedulable)
+		**out = **in
+	}
+	if in.RollbackPolicy != nil {
+		in, out := &in.RollbackPolicy, &out.RollbackPolicy
+		*out = new(DeploymentRollbackPolicy)
+		**out = **in
+	}
+	if in.VariantWeights != nil {
+		in, out := &in.VariantWeights, &out.VariantWeights
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SchedulingHints != nil {
+		in, out := &in.SchedulingHints, &out.SchedulingHints
+		*out = new(DeploymentSchedulingHints)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentStrategy.
@@ -302,6 +582,21 @@ func (in *DeploymentStrategy) DeepCopy() *DeploymentStrategy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeploymentStrategyStatus) DeepCopyInto(out *DeploymentStrategyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeploymentStrategyStatus.
+func (in *DeploymentStrategyStatus) DeepCopy() *DeploymentStrategyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DeploymentStrategyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GatewayTrafficRouting) DeepCopyInto(out *GatewayTrafficRouting) {
 	*out = *in
@@ -515,6 +810,80 @@ func (in *Rollout) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutApproval) DeepCopyInto(out *RolloutApproval) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutApproval.
+func (in *RolloutApproval) DeepCopy() *RolloutApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RolloutApproval) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutApprovalList) DeepCopyInto(out *RolloutApprovalList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RolloutApproval, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutApprovalList.
+func (in *RolloutApprovalList) DeepCopy() *RolloutApprovalList {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutApprovalList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RolloutApprovalList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutApprovalSpec) DeepCopyInto(out *RolloutApprovalSpec) {
+	*out = *in
+	out.TargetRef = in.TargetRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutApprovalSpec.
+func (in *RolloutApprovalSpec) DeepCopy() *RolloutApprovalSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutApprovalSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RolloutCondition) DeepCopyInto(out *RolloutCondition) {
 	*out = *in
@@ -780,6 +1149,21 @@ func (in *ServiceInfo) DeepCopy() *ServiceInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TinyDeploymentPolicy) DeepCopyInto(out *TinyDeploymentPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TinyDeploymentPolicy.
+func (in *TinyDeploymentPolicy) DeepCopy() *TinyDeploymentPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TinyDeploymentPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TrafficRouting) DeepCopyInto(out *TrafficRouting) {
 	*out = *in
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// rolloutsAdvancePath and rolloutsRollbackPath are the paths of the admin endpoints to promote and
+// to roll back a rollout, which are served along with the metrics on every replica.
+const (
+	rolloutsAdvancePath  = "/advance"
+	rolloutsRollbackPath = "/rollback"
+)
+
+// adminResponse is the response of the admin endpoints.
+type adminResponse struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Revision is the UpdateRevision of extra status the request applies to.
+	Revision string `json:"revision,omitempty"`
+	// TargetPartition is the updated replicas the deployment is going to settle at, which may be
+	// less than the replicas while the batch gates hold it.
+	TargetPartition int32 `json:"targetPartition"`
+}
+
+// rolloutAdminHandler sets the annotations honored by syncDeployment for the deployment given by the
+// namespace and name query parameters, so that a rollout can be pushed forward or rolled back during
+// incidents without editing annotations by hand. Only POST requests with the bearer token of
+// --deployment-admin-token are accepted. A deployment is not promoted if a RolloutApproval of its
+// revision holds it, which takes precedence over the promote annotation.
+type rolloutAdminHandler struct {
+	factory *controllerFactory
+	token   string
+	// rollback is true for rolloutsRollbackPath, false for rolloutsAdvancePath.
+	rollback bool
+}
+
+func (h *rolloutAdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Only the exact bearer scheme is accepted, not a bare token.
+	authorization := req.Header.Get("Authorization")
+	if h.token == "" || !strings.HasPrefix(authorization, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(h.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	namespace, name := req.URL.Query().Get("namespace"), req.URL.Query().Get("name")
+	if namespace == "" || name == "" {
+		http.Error(w, "namespace and name are required", http.StatusBadRequest)
+		return
+	}
+	d, err := h.factory.dLister.Deployments(namespace).Get(name)
+	if errors.IsNotFound(err) || err == nil && !deploymentutil.IsUnderRolloutControl(d) {
+		http.Error(w, fmt.Sprintf("deployment %s/%s under rollout control is not found", namespace, name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := adminResponse{Namespace: namespace, Name: name}
+	if extraStatus := getExtraStatus(d); extraStatus != nil {
+		resp.Revision = extraStatus.UpdateRevision
+	}
+	var key, value string
+	var dc *DeploymentController
+	if h.rollback {
+		// The new replica set is scaled down to 0 once rolled back.
+		if resp.Revision == "" {
+			http.Error(w, fmt.Sprintf("deployment %s/%s has no rollout to roll back", namespace, name), http.StatusConflict)
+			return
+		}
+		key, value = rolloutsv1alpha1.DeploymentRollbackAnnotation, resp.Revision
+	} else {
+		if dc, err = h.factory.NewController(d); err != nil {
+			http.Error(w, fmt.Sprintf("deployment %s/%s has invalid strategy: %v", namespace, name, err), http.StatusConflict)
+			return
+		} else if dc == nil {
+			http.Error(w, fmt.Sprintf("deployment %s/%s is not rolled by advanced deployment controller", namespace, name), http.StatusConflict)
+			return
+		}
+		if approved, found := dc.getApproval(d); found && !approved {
+			http.Error(w, fmt.Sprintf("deployment %s/%s is held by RolloutApproval of its revision", namespace, name), http.StatusConflict)
+			return
+		}
+		key, value = rolloutsv1alpha1.DeploymentPromoteAnnotation, "true"
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: value},
+		},
+	})
+	patched, err := h.factory.client.AppsV1().Deployments(namespace).Patch(req.Context(), name, types.MergePatchType, body, metav1.PatchOptions{})
+	if err != nil {
+		klog.Errorf("Failed to set annotation %s=%s of deployment %s/%s: %v", key, value, namespace, name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dc != nil {
+		resp.TargetPartition = dc.newRSReplicasLimit(patched)
+	}
+	klog.Infof("Set annotation %s=%s of deployment %s/%s via admin endpoint", key, value, namespace, name)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
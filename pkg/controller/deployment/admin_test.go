@@ -0,0 +1,218 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestRolloutAdminHandler(t *testing.T) {
+	progressing := &rolloutsv1alpha1.DeploymentExtraStatus{UpdateRevision: "demo-v2", ExpectedUpdatedReplicas: 3}
+	cases := []struct {
+		name             string
+		path             string
+		method           string
+		token            string
+		authorization    string
+		query            string
+		extraStatus      *rolloutsv1alpha1.DeploymentExtraStatus
+		strategy         string
+		approved         *bool
+		released         bool
+		expectCode       int
+		expectAnnotation map[string]string
+		expectResponse   *adminResponse
+	}{
+		{
+			name:           "advance",
+			path:           rolloutsAdvancePath,
+			query:          "namespace=default&name=demo",
+			extraStatus:    progressing,
+			expectCode:     http.StatusOK,
+			expectResponse: &adminResponse{Namespace: "default", Name: "demo", Revision: "demo-v2", TargetPartition: 10},
+			expectAnnotation: map[string]string{
+				rolloutsv1alpha1.DeploymentPromoteAnnotation: "true",
+			},
+		},
+		{
+			name:           "advance held by readiness regression",
+			path:           rolloutsAdvancePath,
+			query:          "namespace=default&name=demo",
+			extraStatus:    &rolloutsv1alpha1.DeploymentExtraStatus{UpdateRevision: "demo-v2", ObservedGeneration: 1, ReadinessRegressed: true, CompletedBatchReplicas: 3},
+			strategy:       `{"rollingStyle":"Partition","partition":3,"readinessRegression":"Halt"}`,
+			expectCode:     http.StatusOK,
+			expectResponse: &adminResponse{Namespace: "default", Name: "demo", Revision: "demo-v2", TargetPartition: 3},
+			expectAnnotation: map[string]string{
+				rolloutsv1alpha1.DeploymentPromoteAnnotation: "true",
+			},
+		},
+		{
+			name:           "advance approved by RolloutApproval",
+			path:           rolloutsAdvancePath,
+			query:          "namespace=default&name=demo",
+			extraStatus:    progressing,
+			approved:       pointer.Bool(true),
+			expectCode:     http.StatusOK,
+			expectResponse: &adminResponse{Namespace: "default", Name: "demo", Revision: "demo-v2", TargetPartition: 10},
+			expectAnnotation: map[string]string{
+				rolloutsv1alpha1.DeploymentPromoteAnnotation: "true",
+			},
+		},
+		{
+			name:        "advance held by RolloutApproval",
+			path:        rolloutsAdvancePath,
+			query:       "namespace=default&name=demo",
+			extraStatus: progressing,
+			approved:    pointer.Bool(false),
+			expectCode:  http.StatusConflict,
+		},
+		{
+			name:           "rollback",
+			path:           rolloutsRollbackPath,
+			query:          "namespace=default&name=demo",
+			extraStatus:    progressing,
+			expectCode:     http.StatusOK,
+			expectResponse: &adminResponse{Namespace: "default", Name: "demo", Revision: "demo-v2", TargetPartition: 0},
+			expectAnnotation: map[string]string{
+				rolloutsv1alpha1.DeploymentRollbackAnnotation: "demo-v2",
+			},
+		},
+		{
+			name:        "rollback without rollout",
+			path:        rolloutsRollbackPath,
+			query:       "namespace=default&name=demo",
+			extraStatus: &rolloutsv1alpha1.DeploymentExtraStatus{},
+			expectCode:  http.StatusConflict,
+		},
+		{
+			name:        "wrong token",
+			path:        rolloutsAdvancePath,
+			token:       "guess",
+			query:       "namespace=default&name=demo",
+			extraStatus: progressing,
+			expectCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "token without bearer scheme",
+			path:          rolloutsAdvancePath,
+			authorization: "secret",
+			query:         "namespace=default&name=demo",
+			extraStatus:   progressing,
+			expectCode:    http.StatusUnauthorized,
+		},
+		{
+			name:        "read only method",
+			path:        rolloutsAdvancePath,
+			method:      http.MethodGet,
+			query:       "namespace=default&name=demo",
+			extraStatus: progressing,
+			expectCode:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:        "missing name",
+			path:        rolloutsAdvancePath,
+			query:       "namespace=default",
+			extraStatus: progressing,
+			expectCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "deployment not found",
+			path:        rolloutsAdvancePath,
+			query:       "namespace=default&name=missing",
+			extraStatus: progressing,
+			expectCode:  http.StatusNotFound,
+		},
+		{
+			name:        "deployment not under rollout control",
+			path:        rolloutsAdvancePath,
+			query:       "namespace=default&name=demo",
+			extraStatus: progressing,
+			released:    true,
+			expectCode:  http.StatusNotFound,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestControlledDeployment("demo", !cs.released, cs.extraStatus)
+			d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","partition":3}`
+			if cs.strategy != "" {
+				d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = cs.strategy
+			}
+			dc, client, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+			approvalIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+			if cs.approved != nil {
+				revision := deploymentutil.ComputeTemplateHash(&d.Spec.Template, nil)
+				_ = approvalIndexer.Add(newTestApproval("approval", d.Name, revision, *cs.approved))
+			}
+			dc.approvalIndexer = approvalIndexer
+			h := &rolloutAdminHandler{factory: (*controllerFactory)(dc), token: "secret", rollback: cs.path == rolloutsRollbackPath}
+
+			method, authorization := cs.method, cs.authorization
+			if method == "" {
+				method = http.MethodPost
+			}
+			if authorization == "" {
+				token := cs.token
+				if token == "" {
+					token = "secret"
+				}
+				authorization = "Bearer " + token
+			}
+			req := httptest.NewRequest(method, cs.path+"?"+cs.query, nil)
+			req.Header.Set("Authorization", authorization)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != cs.expectCode {
+				t.Fatalf("expect code %d, got %d: %s", cs.expectCode, w.Code, w.Body.String())
+			}
+			if cs.expectResponse != nil {
+				resp := &adminResponse{}
+				if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+					t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+				}
+				if !reflect.DeepEqual(resp, cs.expectResponse) {
+					t.Fatalf("expect response %+v, got %+v", cs.expectResponse, resp)
+				}
+			}
+
+			latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			for _, key := range []string{rolloutsv1alpha1.DeploymentPromoteAnnotation, rolloutsv1alpha1.DeploymentRollbackAnnotation} {
+				value, ok := latest.Annotations[key]
+				expect, expectOK := cs.expectAnnotation[key]
+				if ok != expectOK || value != expect {
+					t.Fatalf("expect annotation %s=%q set %v, got %q set %v", key, expect, expectOK, value, ok)
+				}
+			}
+		})
+	}
+}
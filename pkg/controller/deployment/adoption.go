@@ -0,0 +1,99 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// ReplicaSetAdoptedReason is added to a deployment which adopts an existing replica set with
+	// the same pod template, instead of creating a new one.
+	ReplicaSetAdoptedReason = "ReplicaSetAdopted"
+)
+
+// adoptReplicaSets adopts the replica set matching the selector and pod template of d, which is
+// controlled by nobody, e.g. left orphaned before the rollout control is enabled for d. Otherwise
+// a duplicated new replica set would be created, and the pods are doubled until the rollout
+// completes. If more than one replica set matches, the one of the highest revision is adopted.
+// The returned list contains the adopted replica set besides rsList.
+func (dc *DeploymentController) adoptReplicaSets(ctx context.Context, d *apps.Deployment, rsList []*apps.ReplicaSet) ([]*apps.ReplicaSet, error) {
+	if d.DeletionTimestamp != nil || deploymentutil.FindNewReplicaSet(d, rsList) != nil {
+		return rsList, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := dc.rsLister.ReplicaSets(d.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	var orphan *apps.ReplicaSet
+	var orphanRevision int64
+	for _, rs := range candidates {
+		if rs.DeletionTimestamp != nil || metav1.GetControllerOf(rs) != nil {
+			continue
+		}
+		if !deploymentutil.EqualIgnoreHash(&rs.Spec.Template, deploymentutil.ReplicaSetTemplateOf(&d.Spec.Template, rs)) {
+			continue
+		}
+		revision, err := deploymentutil.Revision(rs)
+		if err != nil {
+			dc.log().V(4).Info("Couldn't parse revision of replica set", "replicaSet", klog.KObj(rs), "error", err)
+		}
+		if orphan == nil || revision > orphanRevision {
+			orphan, orphanRevision = rs, revision
+		}
+	}
+	if orphan == nil {
+		return rsList, nil
+	}
+
+	adopted, err := dc.patchControllerRef(ctx, d, orphan)
+	if err != nil {
+		return nil, err
+	}
+	dc.log().Info("Adopted replica set", "replicaSet", klog.KObj(adopted), "revision", orphanRevision)
+	dc.eventRecorder.Eventf(d, v1.EventTypeNormal, ReplicaSetAdoptedReason, "Adopted replica set %s with the same pod template", adopted.Name)
+	return append(rsList, adopted), nil
+}
+
+// patchControllerRef sets d as the controller of rs by patching its owner references, the uid
+// of rs is sent as precondition so that a replica set recreated with the same name is not adopted.
+func (dc *DeploymentController) patchControllerRef(ctx context.Context, d *apps.Deployment, rs *apps.ReplicaSet) (*apps.ReplicaSet, error) {
+	ownerReferences := append(rs.DeepCopy().OwnerReferences, *metav1.NewControllerRef(d, controllerKind))
+	body, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":             rs.UID,
+			"ownerReferences": ownerReferences,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dc.client.AppsV1().ReplicaSets(rs.Namespace).Patch(ctx, rs.Name, types.MergePatchType, body, metav1.PatchOptions{})
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncDeploymentAdoptsOrphanedReplicaSet(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	// Two orphaned replica sets have the same template as the deployment, and the one of higher
+	// revision is expected to be adopted.
+	staleOrphan := newTestReplicaSet(d, "demo:v2", 2, 0)
+	staleOrphan.OwnerReferences = nil
+	orphan := newTestReplicaSet(d, "demo:v2", 3, 0)
+	orphan.Name, orphan.UID = "deployment-orphan", "deployment-orphan-uid"
+	orphan.OwnerReferences = nil
+	// Labels injected by us are ignored when comparing the templates.
+	delete(orphan.Spec.Template.Labels, deploymentutil.TemplateHashLabelKey)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromInt(3),
+	}
+	dc, client, recorder := newTestController(strategy, d, oldRS, staleOrphan, orphan)
+
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "replicasets" && action.GetVerb() == "create" {
+			t.Fatalf("expect the orphaned replica set adopted, got a replica set created")
+		}
+	}
+	if !hasEvent(collectEvents(recorder), ReplicaSetAdoptedReason) {
+		t.Fatalf("expect %s event", ReplicaSetAdoptedReason)
+	}
+
+	rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	replicas := map[string]int32{}
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		replicas[rs.Name] = *rs.Spec.Replicas
+		if controlled := metav1.IsControlledBy(rs, d); controlled != (rs.Name != staleOrphan.Name) {
+			t.Fatalf("expect replica set %s controlled %v, got owners %v", rs.Name, !controlled, rs.OwnerReferences)
+		}
+	}
+	expectReplicas := map[string]int32{oldRS.Name: 7, staleOrphan.Name: 0, orphan.Name: 3}
+	if !reflect.DeepEqual(replicas, expectReplicas) {
+		t.Fatalf("expect replicas %v, got %v", expectReplicas, replicas)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// isPromoted returns true if the deployment is allowed to advance past the partition. The
+// RolloutApprovals matching the current revision take precedence over the promote annotation,
+// the revision is held once any of them is not approved.
+func (dc *DeploymentController) isPromoted(d *apps.Deployment) bool {
+	if approved, found := dc.getApproval(d); found {
+		dc.log().V(4).Info("Revision is approved by RolloutApproval", "approved", approved)
+		return approved
+	}
+	return d.Annotations[rolloutsv1alpha1.DeploymentPromoteAnnotation] == "true"
+}
+
+// getApproval returns whether the current revision of deployment is approved by the
+// RolloutApprovals, and whether any RolloutApproval matches the revision at all.
+func (dc *DeploymentController) getApproval(d *apps.Deployment) (approved bool, found bool) {
+	if dc.approvalIndexer == nil {
+		return false, false
+	}
+	objects, err := dc.approvalIndexer.ByIndex(toolscache.NamespaceIndex, d.Namespace)
+	if err != nil {
+		dc.log().Error(err, "Failed to list rollout approvals")
+		return false, false
+	}
+
+	revision := deploymentutil.ComputeTemplateHash(&d.Spec.Template, d.Status.CollisionCount)
+	approved = true
+	for _, object := range objects {
+		approval, ok := object.(*rolloutsv1alpha1.RolloutApproval)
+		if !ok || !isApprovalTarget(approval, d.Name) || approval.Spec.Revision != revision {
+			continue
+		}
+		found = true
+		approved = approved && approval.Spec.Approved
+	}
+	return approved && found, found
+}
+
+// isApprovalTarget returns true if approval refers to the deployment with the given name.
+func isApprovalTarget(approval *rolloutsv1alpha1.RolloutApproval, name string) bool {
+	ref := approval.Spec.TargetRef
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false
+	}
+	return gv.Group == apps.GroupName && ref.Kind == controllerKind.Kind && ref.Name == name
+}
+
+// deploymentOfApproval returns a MapFunc which maps a RolloutApproval to the deployment it
+// refers to, so that the deployment can be advanced or held once it is approved or not.
+func deploymentOfApproval() handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		approval, ok := obj.(*rolloutsv1alpha1.RolloutApproval)
+		if !ok || !isApprovalTarget(approval, approval.Spec.TargetRef.Name) {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: approval.Namespace, Name: approval.Spec.TargetRef.Name}}}
+	}
+}
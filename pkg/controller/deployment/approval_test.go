@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func newTestApproval(name, target, revision string, approved bool) *rolloutsv1alpha1.RolloutApproval {
+	return &rolloutsv1alpha1.RolloutApproval{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: rolloutsv1alpha1.RolloutApprovalSpec{
+			TargetRef: rolloutsv1alpha1.WorkloadRef{APIVersion: "apps/v1", Kind: "Deployment", Name: target},
+			Revision:  revision,
+			Approved:  approved,
+		},
+	}
+}
+
+func TestSyncDeploymentWithApproval(t *testing.T) {
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	revision := deploymentutil.ComputeTemplateHash(&d.Spec.Template, nil)
+	cases := []struct {
+		name          string
+		promoted      bool
+		approvals     []*rolloutsv1alpha1.RolloutApproval
+		expectPromote bool
+	}{
+		{
+			name: "neither promoted nor approved",
+		},
+		{
+			name:          "promoted by annotation without approval",
+			promoted:      true,
+			expectPromote: true,
+		},
+		{
+			name:          "approved without annotation",
+			approvals:     []*rolloutsv1alpha1.RolloutApproval{newTestApproval("approval", d.Name, revision, true)},
+			expectPromote: true,
+		},
+		{
+			name:      "not approved takes precedence over annotation",
+			promoted:  true,
+			approvals: []*rolloutsv1alpha1.RolloutApproval{newTestApproval("approval", d.Name, revision, false)},
+		},
+		{
+			name:     "any not approved holds the revision",
+			promoted: true,
+			approvals: []*rolloutsv1alpha1.RolloutApproval{
+				newTestApproval("approval-a", d.Name, revision, true),
+				newTestApproval("approval-b", d.Name, revision, false),
+			},
+		},
+		{
+			name:          "approval of other revision is ignored",
+			promoted:      true,
+			approvals:     []*rolloutsv1alpha1.RolloutApproval{newTestApproval("approval", d.Name, "other-revision", false)},
+			expectPromote: true,
+		},
+		{
+			name:      "approval of other deployment is ignored",
+			approvals: []*rolloutsv1alpha1.RolloutApproval{newTestApproval("approval", "other", revision, true)},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := d.DeepCopy()
+			if cs.promoted {
+				d.Annotations[rolloutsv1alpha1.DeploymentPromoteAnnotation] = "true"
+			}
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 4)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+			approvalIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+			for _, approval := range cs.approvals {
+				_ = approvalIndexer.Add(approval)
+			}
+			dc.approvalIndexer = approvalIndexer
+
+			for i := 0; i < 20; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			expect := map[string]int32{"demo:v1": 2, "demo:v2": 2}
+			if cs.expectPromote {
+				expect = map[string]int32{"demo:v1": 0, "demo:v2": 4}
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+				t.Fatalf("expect replicas %v, got %v", expect, replicas)
+			}
+		})
+	}
+}
+
+func TestDeploymentOfApproval(t *testing.T) {
+	cases := []struct {
+		name     string
+		approval *rolloutsv1alpha1.RolloutApproval
+		expect   []reconcile.Request
+	}{
+		{
+			name:     "approval of deployment",
+			approval: newTestApproval("approval", "deployment", "revision", true),
+			expect:   []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "default", Name: "deployment"}}},
+		},
+		{
+			name: "approval of other kind",
+			approval: func() *rolloutsv1alpha1.RolloutApproval {
+				approval := newTestApproval("approval", "cloneset", "revision", true)
+				approval.Spec.TargetRef = rolloutsv1alpha1.WorkloadRef{APIVersion: "apps.kruise.io/v1alpha1", Kind: "CloneSet", Name: "cloneset"}
+				return approval
+			}(),
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if requests := deploymentOfApproval()(cs.approval); !reflect.DeepEqual(requests, cs.expect) {
+				t.Fatalf("expect requests %v, got %v", cs.expect, requests)
+			}
+		})
+	}
+}
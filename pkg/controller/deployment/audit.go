@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// auditLogMarker prefixes each line of the audit log, so that the records can be
+// told apart from other logs when the audit log is written to stdout.
+const auditLogMarker = "DEPLOYMENT_AUDIT"
+
+// Reasons of the scaling decisions recorded in the audit log.
+const (
+	auditReasonNewRSCreated        = "NewReplicaSetCreated"
+	auditReasonNewRSOversized      = "NewReplicaSetOversized"
+	auditReasonRollingScaleUp      = "RollingScaleUp"
+	auditReasonRollingScaleDown    = "RollingScaleDown"
+	auditReasonCleanupUnhealthy    = "CleanupUnhealthyReplicas"
+	auditReasonScaling             = "Scaling"
+	auditReasonProportionalScaling = "ProportionalScaling"
+	auditReasonRolloutCompleted    = "RolloutCompleted"
+	auditReasonScaleDownStep       = "ScaleDownStep"
+	auditReasonRolledBack          = "RolledBack"
+	auditReasonDriftRepaired       = "DriftRepaired"
+	auditReasonVariantWeights      = "VariantWeights"
+)
+
+// auditRecord is a scaling decision made by the controller.
+type auditRecord struct {
+	Time       time.Time `json:"time"`
+	Namespace  string    `json:"namespace"`
+	Deployment string    `json:"deployment"`
+	ReplicaSet string    `json:"replicaSet"`
+	From       int32     `json:"from"`
+	To         int32     `json:"to"`
+	Reason     string    `json:"reason"`
+}
+
+// auditLogger writes the scaling decisions to an append-only stream, one record
+// per line. A nil auditLogger discards all records.
+type auditLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	clock clock.PassiveClock
+}
+
+// newAuditLogger returns an auditLogger writing to the file at path, or to stdout
+// if path is "-". It returns nil if path is empty, which disables the audit log.
+func newAuditLogger(path string, clock clock.PassiveClock) (*auditLogger, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return &auditLogger{w: os.Stdout, clock: clock}, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %v", path, err)
+	}
+	return &auditLogger{w: f, clock: clock}, nil
+}
+
+// record writes a scaling decision of replica set rsName from replicas to replicas.
+func (l *auditLogger) record(d *apps.Deployment, rsName string, from, to int32, reason string) {
+	if l == nil || reason == "" {
+		return
+	}
+	data, err := json.Marshal(&auditRecord{
+		Time:       l.clock.Now().UTC(),
+		Namespace:  d.Namespace,
+		Deployment: d.Name,
+		ReplicaSet: rsName,
+		From:       from,
+		To:         to,
+		Reason:     reason,
+	})
+	if err != nil {
+		klog.Errorf("Failed to marshal audit record of deployment %v: %v", klog.KObj(d), err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err = fmt.Fprintf(l.w, "%s %s\n", auditLogMarker, data); err != nil {
+		klog.Errorf("Failed to write audit record of deployment %v: %v", klog.KObj(d), err)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestNewAuditLogger(t *testing.T) {
+	if l, err := newAuditLogger("", testingclock.NewFakeClock(time.Now())); err != nil || l != nil {
+		t.Fatalf("expect audit log disabled for empty path, got %v, %v", l, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("existing\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l, err := newAuditLogger(path, testingclock.NewFakeClock(time.Now()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.record(newTestDeployment(1, intstr.FromInt(1), intstr.FromInt(0)), "rs", 0, 1, auditReasonRollingScaleUp)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "existing" || !strings.HasPrefix(lines[1], auditLogMarker+" ") {
+		t.Fatalf("expect audit record appended to the file, got %q", string(data))
+	}
+
+	var nilLogger *auditLogger
+	nilLogger.record(newTestDeployment(1, intstr.FromInt(1), intstr.FromInt(0)), "rs", 0, 1, auditReasonRollingScaleUp)
+}
+
+func TestSyncDeploymentAuditLog(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 2)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("100%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+	buf := &bytes.Buffer{}
+	dc.clock = testingclock.NewFakeClock(now)
+	dc.auditor = &auditLogger{w: buf, clock: dc.clock}
+
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+
+	var records []auditRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.HasPrefix(line, auditLogMarker+" ") {
+			t.Fatalf("expect audit record prefixed by %s, got %q", auditLogMarker, line)
+		}
+		var record auditRecord
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, auditLogMarker+" ")), &record); err != nil {
+			t.Fatalf("failed to unmarshal audit record %q: %v", line, err)
+		}
+		if record.Namespace != d.Namespace || record.Deployment != d.Name || !record.Time.Equal(now) {
+			t.Errorf("unexpected audit record %+v", record)
+		}
+		records = append(records, record)
+	}
+
+	rsList, err := dc.getReplicaSetsForDeployment(context.TODO(), d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+	if newRS == nil {
+		t.Fatalf("expect new replica set to be created")
+	}
+
+	expected := []auditRecord{
+		{ReplicaSet: newRS.Name, From: 0, To: 1, Reason: auditReasonNewRSCreated},
+		{ReplicaSet: "deployment-demo-v1", From: 2, To: 1, Reason: auditReasonRollingScaleDown},
+		{ReplicaSet: newRS.Name, From: 1, To: 2, Reason: auditReasonRollingScaleUp},
+		{ReplicaSet: "deployment-demo-v1", From: 1, To: 0, Reason: auditReasonRollingScaleDown},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expect %d audit records, got %+v", len(expected), records)
+	}
+	for i := range expected {
+		got := records[i]
+		if got.ReplicaSet != expected[i].ReplicaSet || got.From != expected[i].From || got.To != expected[i].To || got.Reason != expected[i].Reason {
+			t.Errorf("expect audit record %d to be %+v, got %+v", i, expected[i], got)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// BatchCheck is a custom health signal of the current batch, e.g. from metrics, logs or
+// synthetic requests. It is registered by RegisterBatchCheck and referred to by name in the
+// batchChecks of strategy.
+type BatchCheck interface {
+	// Check returns true if the new pods of the current batch are healthy. An error is
+	// regarded as failed, and the check will be run again later.
+	Check(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet) (bool, error)
+}
+
+// BatchCheckFunc is an adapter to use ordinary functions as BatchCheck.
+type BatchCheckFunc func(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet) (bool, error)
+
+// Check calls f(ctx, d, newRS).
+func (f BatchCheckFunc) Check(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet) (bool, error) {
+	return f(ctx, d, newRS)
+}
+
+// NoopBatchCheck always passes, which is registered as "noop" by default.
+var NoopBatchCheck BatchCheck = BatchCheckFunc(func(context.Context, *apps.Deployment, *apps.ReplicaSet) (bool, error) {
+	return true, nil
+})
+
+const (
+	// BatchApprovedReason is added in a deployment event when the current batch passes the custom
+	// checks, and the deployment is allowed to advance to the next batch.
+	BatchApprovedReason = "BatchApproved"
+	// BatchRejectedReason is added in a deployment event when a StepVerifier rejects the current
+	// batch with a reason.
+	BatchRejectedReason = "BatchRejected"
+)
+
+// BatchStep is the current batch verified by a StepVerifier.
+type BatchStep struct {
+	// NewReplicaSet is the new replica set of the deployment.
+	NewReplicaSet *apps.ReplicaSet
+	// ExpectedUpdatedReplicas is the number of new pods expected by the current batch.
+	ExpectedUpdatedReplicas int32
+	// UpdatedReadyReplicas is the number of new pods ready.
+	UpdatedReadyReplicas int32
+}
+
+// StepVerifier is a BatchCheck which tells why the batch is approved or rejected, e.g. a canary
+// analysis of error rate and latency, and its decisions are recorded in the events of deployment.
+// It is registered by RegisterStepVerifier and referred to by name in the batchChecks of strategy.
+type StepVerifier interface {
+	// Verify returns true if the current batch is approved, and the reason of the decision. An
+	// error is regarded as rejected, and the batch will be verified again later.
+	Verify(ctx context.Context, d *apps.Deployment, step BatchStep) (approved bool, reason string, err error)
+}
+
+// StepVerifierFunc is an adapter to use ordinary functions as StepVerifier.
+type StepVerifierFunc func(ctx context.Context, d *apps.Deployment, step BatchStep) (bool, string, error)
+
+// Verify calls f(ctx, d, step).
+func (f StepVerifierFunc) Verify(ctx context.Context, d *apps.Deployment, step BatchStep) (bool, string, error) {
+	return f(ctx, d, step)
+}
+
+// NoopStepVerifier always approves without a reason.
+var NoopStepVerifier StepVerifier = StepVerifierFunc(func(context.Context, *apps.Deployment, BatchStep) (bool, string, error) {
+	return true, "", nil
+})
+
+// stepVerifierCheck registers a StepVerifier as a BatchCheck, which is verified with the whole
+// batch by syncBatchChecks instead of being checked.
+type stepVerifierCheck struct {
+	StepVerifier
+}
+
+// Check verifies the batch of newRS.
+func (c stepVerifierCheck) Check(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet) (bool, error) {
+	approved, _, err := c.Verify(ctx, d, BatchStep{NewReplicaSet: newRS})
+	return approved, err
+}
+
+// runBatchCheck runs check against step, with the reason of the decision if it is a StepVerifier.
+func runBatchCheck(ctx context.Context, check BatchCheck, d *apps.Deployment, step BatchStep) (bool, string, error) {
+	if verifier, ok := check.(StepVerifier); ok {
+		return verifier.Verify(ctx, d, step)
+	}
+	ok, err := check.Check(ctx, d, step.NewReplicaSet)
+	return ok, "", err
+}
+
+var (
+	batchChecksLock sync.RWMutex
+	batchChecks     = map[string]BatchCheck{"noop": NoopBatchCheck}
+)
+
+// RegisterBatchCheck registers check by name, which replaces the check registered with the same
+// name before. It must be called before the controller is added to the manager.
+func RegisterBatchCheck(name string, check BatchCheck) {
+	batchChecksLock.Lock()
+	defer batchChecksLock.Unlock()
+	batchChecks[name] = check
+}
+
+// RegisterStepVerifier registers verifier by name as a batch check, which replaces the check
+// registered with the same name before. It must be called before the controller is added to the
+// manager.
+func RegisterStepVerifier(name string, verifier StepVerifier) {
+	RegisterBatchCheck(name, stepVerifierCheck{verifier})
+}
+
+// registeredBatchChecks returns a copy of the checks registered so far.
+func registeredBatchChecks() map[string]BatchCheck {
+	batchChecksLock.RLock()
+	defer batchChecksLock.RUnlock()
+	checks := make(map[string]BatchCheck, len(batchChecks))
+	for name, check := range batchChecks {
+		checks[name] = check
+	}
+	return checks
+}
+
+// syncBatchChecks runs the custom checks of strategy against the current batch, and decides
+// whether a quorum of them passed. Unknown checks are regarded as failed, so that a typo in
+// the strategy never lets a batch advance. The deployment is requeued to run the checks again
+// if the quorum is not reached yet. If a passing streak is required, the runs reaching the quorum
+// are counted in the extra status, and the checks are not run again within the batch check
+// interval, so that the streak is not reached by a burst of syncs.
+func (dc *DeploymentController) syncBatchChecks(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) {
+	checks := dc.strategy.BatchChecks
+	if checks == nil || newRS == nil {
+		return
+	}
+	streak := int32(1)
+	if checks.PassingStreak != nil {
+		streak = *checks.PassingStreak
+	}
+	prev := getExtraStatus(d)
+	sameBatch := prev != nil && prev.UpdateRevision == extraStatus.UpdateRevision && prev.ExpectedUpdatedReplicas == extraStatus.ExpectedUpdatedReplicas
+	now := metav1.NewTime(dc.clock.Now())
+	if streak > 1 && sameBatch && prev.BatchChecksRunTime != nil {
+		if wait := batchCheckInterval - now.Sub(prev.BatchChecksRunTime.Time); wait > 0 {
+			extraStatus.PassedBatchChecks = prev.PassedBatchChecks
+			extraStatus.BatchChecksPassed = prev.BatchChecksPassed
+			extraStatus.BatchChecksStreak = prev.BatchChecksStreak
+			extraStatus.BatchChecksRunTime = prev.BatchChecksRunTime
+			if !extraStatus.BatchChecksPassed {
+				dc.enqueueAfter(d, wait)
+			}
+			return
+		}
+	}
+
+	passed := int32(0)
+	step := BatchStep{NewReplicaSet: newRS, ExpectedUpdatedReplicas: extraStatus.ExpectedUpdatedReplicas, UpdatedReadyReplicas: extraStatus.UpdatedReadyReplicas}
+	var approvals []string
+	for _, name := range checks.Names {
+		check, ok := dc.batchChecks[name]
+		if !ok {
+			dc.log().Info("Unknown batch check", "check", name)
+			continue
+		}
+		ok, reason, err := runBatchCheck(ctx, check, d, step)
+		if err != nil {
+			dc.log().Error(err, "Failed to run batch check", "check", name)
+			continue
+		}
+		if ok {
+			passed++
+			if reason != "" {
+				approvals = append(approvals, fmt.Sprintf("%s: %s", name, reason))
+			}
+		} else if reason != "" {
+			dc.eventRecorder.Eventf(d, v1.EventTypeWarning, BatchRejectedReason, "Batch of %d replicas of revision %s is rejected by %s: %s",
+				step.ExpectedUpdatedReplicas, extraStatus.UpdateRevision, name, reason)
+		}
+	}
+
+	quorum := int32(len(checks.Names))
+	if checks.Quorum != nil {
+		quorum = *checks.Quorum
+	}
+	// Any run not reaching the quorum resets the streak.
+	passes := int32(0)
+	if passed >= quorum {
+		passes = 1
+		if sameBatch {
+			passes += prev.BatchChecksStreak
+		}
+	}
+	extraStatus.PassedBatchChecks = passed
+	extraStatus.BatchChecksPassed = passes >= streak
+	if streak > 1 {
+		extraStatus.BatchChecksStreak = passes
+		extraStatus.BatchChecksRunTime = &now
+	}
+	if extraStatus.BatchChecksPassed && !(sameBatch && prev.BatchChecksPassed) {
+		msg := fmt.Sprintf("Batch of %d replicas of revision %s is approved by %d/%d batch checks", step.ExpectedUpdatedReplicas, extraStatus.UpdateRevision, passed, len(checks.Names))
+		if len(approvals) > 0 {
+			msg += ", " + strings.Join(approvals, "; ")
+		}
+		dc.eventRecorder.Event(d, v1.EventTypeNormal, BatchApprovedReason, msg)
+	}
+	if !extraStatus.BatchChecksPassed {
+		dc.log().V(4).Info("Batch checks evaluated", "passed", passed, "total", len(checks.Names), "quorum", quorum, "passes", passes, "streak", streak)
+		dc.enqueueAfter(d, batchCheckInterval)
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// newTestBatchCheck returns a check with the given result.
+func newTestBatchCheck(healthy bool, err error) BatchCheck {
+	return BatchCheckFunc(func(context.Context, *apps.Deployment, *apps.ReplicaSet) (bool, error) {
+		return healthy, err
+	})
+}
+
+func TestSyncBatchChecks(t *testing.T) {
+	checks := map[string]BatchCheck{
+		"noop":    NoopBatchCheck,
+		"metrics": newTestBatchCheck(true, nil),
+		"logs":    newTestBatchCheck(false, nil),
+		"probe":   newTestBatchCheck(true, fmt.Errorf("timeout")),
+	}
+	cases := []struct {
+		name         string
+		names        []string
+		quorum       *int32
+		expectPassed int32
+		expectQuorum bool
+	}{
+		{
+			name:         "all passed",
+			names:        []string{"noop", "metrics"},
+			expectPassed: 2,
+			expectQuorum: true,
+		},
+		{
+			name:         "all required by default",
+			names:        []string{"noop", "metrics", "logs"},
+			expectPassed: 2,
+		},
+		{
+			name:         "quorum passed",
+			names:        []string{"noop", "metrics", "logs"},
+			quorum:       pointer.Int32(2),
+			expectPassed: 2,
+			expectQuorum: true,
+		},
+		{
+			name:         "quorum failed with errors",
+			names:        []string{"metrics", "logs", "probe"},
+			quorum:       pointer.Int32(2),
+			expectPassed: 1,
+		},
+		{
+			name:         "unknown checks failed",
+			names:        []string{"metrics", "unknown"},
+			quorum:       pointer.Int32(2),
+			expectPassed: 1,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{BatchChecks: &rolloutsv1alpha1.DeploymentBatchChecks{Names: cs.names, Quorum: cs.quorum}}
+			dc, _, _ := newTestController(strategy, d, newRS)
+			dc.batchChecks = checks
+
+			extraStatus := &rolloutsv1alpha1.DeploymentExtraStatus{ExpectedUpdatedReplicas: 3}
+			dc.syncBatchChecks(context.TODO(), d, newRS, extraStatus)
+			if extraStatus.PassedBatchChecks != cs.expectPassed || extraStatus.BatchChecksPassed != cs.expectQuorum {
+				t.Fatalf("expect %d passed checks and quorum %v, got %+v", cs.expectPassed, cs.expectQuorum, extraStatus)
+			}
+			if requeue := dc.requeueAfter > 0; requeue == cs.expectQuorum {
+				t.Fatalf("expect requeue %v, got %v", !cs.expectQuorum, dc.requeueAfter)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentHeldUntilBatchChecked(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+		BatchChecks:   &rolloutsv1alpha1.DeploymentBatchChecks{Names: []string{"metrics", "logs", "synthetic"}, Quorum: pointer.Int32(2)},
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+	healthy := map[string]bool{"metrics": true}
+	dc.batchChecks = map[string]BatchCheck{}
+	for _, name := range strategy.BatchChecks.Names {
+		name := name
+		dc.batchChecks[name] = BatchCheckFunc(func(context.Context, *apps.Deployment, *apps.ReplicaSet) (bool, error) {
+			return healthy[name], nil
+		})
+	}
+
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.PassedBatchChecks != 1 || extraStatus.BatchChecksPassed {
+		t.Fatalf("expect batch checks not passed with 1 passed check, got %+v", extraStatus)
+	}
+
+	// The next batch is held even if partition is increased.
+	dc.strategy.Partition = intstr.FromString("60%")
+	for i := 0; i < 5; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expect := map[string]int32{"demo:v1": 7, "demo:v2": 3}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+		t.Fatalf("expect replicas %v held until checked, got %v", expect, replicas)
+	}
+
+	// The quorum is reached, so the deployment advances to the next batch.
+	healthy["synthetic"] = true
+	for i := 0; i < 5; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v2"] <= 3 {
+		t.Fatalf("expect the next batch to be rolled once checked, got %v", replicas)
+	}
+	if extraStatus := getExtraStatus(d); extraStatus.ExpectedUpdatedReplicas != 6 {
+		t.Fatalf("expect the next batch exposed, got %+v", extraStatus)
+	}
+}
+
+func TestSyncBatchChecksPassingStreak(t *testing.T) {
+	type step struct {
+		healthy         bool
+		wait            time.Duration
+		expectedUpdated int32
+		expectRun       bool
+		expectStreak    int32
+		expectPassed    bool
+	}
+	steps := []step{
+		{healthy: true, expectRun: true, expectStreak: 1},
+		{healthy: true, wait: batchCheckInterval / 2, expectStreak: 1},
+		{healthy: true, wait: batchCheckInterval, expectRun: true, expectStreak: 2},
+		{healthy: false, wait: batchCheckInterval, expectRun: true},
+		{healthy: true, wait: batchCheckInterval, expectRun: true, expectStreak: 1},
+		{healthy: true, wait: batchCheckInterval, expectRun: true, expectStreak: 2},
+		{healthy: true, wait: batchCheckInterval, expectRun: true, expectStreak: 3, expectPassed: true},
+		{healthy: true, wait: batchCheckInterval, expectedUpdated: 6, expectRun: true, expectStreak: 1},
+	}
+
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{BatchChecks: &rolloutsv1alpha1.DeploymentBatchChecks{Names: []string{"metrics"}, PassingStreak: pointer.Int32(3)}}
+	dc, _, _ := newTestController(strategy, d, newRS)
+	healthy, runs := false, 0
+	dc.batchChecks = map[string]BatchCheck{"metrics": BatchCheckFunc(func(context.Context, *apps.Deployment, *apps.ReplicaSet) (bool, error) {
+		runs++
+		return healthy, nil
+	})}
+	fakeClock := dc.clock.(*testingclock.FakeClock)
+
+	for i, s := range steps {
+		fakeClock.Step(s.wait)
+		healthy, runs = s.healthy, 0
+		dc.requeueAfter = 0
+		expectedUpdated := s.expectedUpdated
+		if expectedUpdated == 0 {
+			expectedUpdated = 3
+		}
+		extraStatus := &rolloutsv1alpha1.DeploymentExtraStatus{UpdateRevision: "demo-v2", ExpectedUpdatedReplicas: expectedUpdated}
+		dc.syncBatchChecks(context.TODO(), d, newRS, extraStatus)
+		if run := runs > 0; run != s.expectRun {
+			t.Fatalf("step %d: expect checks run %v, got %v", i, s.expectRun, run)
+		}
+		if extraStatus.BatchChecksStreak != s.expectStreak || extraStatus.BatchChecksPassed != s.expectPassed {
+			t.Fatalf("step %d: expect streak %d and passed %v, got %+v", i, s.expectStreak, s.expectPassed, extraStatus)
+		}
+		if requeue := dc.requeueAfter > 0; requeue == s.expectPassed {
+			t.Fatalf("step %d: expect requeue %v, got %v", i, !s.expectPassed, dc.requeueAfter)
+		}
+
+		// The extra status is carried over by the annotation to the next sync.
+		body, err := json.Marshal(extraStatus)
+		if err != nil {
+			t.Fatalf("failed to marshal extra status: %v", err)
+		}
+		d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] = string(body)
+	}
+}
+
+func TestSyncDeploymentWithStepVerifier(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+		BatchChecks:   &rolloutsv1alpha1.DeploymentBatchChecks{Names: []string{"analysis"}},
+	}
+	dc, client, recorder := newTestController(strategy, d, oldRS, newRS)
+	approved, reason := false, "error rate 5% is above 1%"
+	var steps []BatchStep
+	RegisterStepVerifier("analysis", StepVerifierFunc(func(_ context.Context, _ *apps.Deployment, step BatchStep) (bool, string, error) {
+		steps = append(steps, step)
+		return approved, reason, nil
+	}))
+	defer func() {
+		batchChecksLock.Lock()
+		delete(batchChecks, "analysis")
+		batchChecksLock.Unlock()
+	}()
+	dc.batchChecks = registeredBatchChecks()
+
+	// The next batch is held and requeued while the verifier rejects the current one.
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	dc.strategy.Partition = intstr.FromString("60%")
+	for i := 0; i < 3; i++ {
+		dc.requeueAfter = 0
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		if dc.requeueAfter == 0 {
+			t.Fatalf("sync %d: expect requeue while verification is pending", i)
+		}
+	}
+	expect := map[string]int32{"demo:v1": 7, "demo:v2": 3}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+		t.Fatalf("expect replicas %v held until approved, got %v", expect, replicas)
+	}
+	if events := collectEvents(recorder); !hasEvent(events, BatchRejectedReason) || hasEvent(events, BatchApprovedReason) {
+		t.Fatalf("expect %s event only, got %v", BatchRejectedReason, events)
+	}
+	if step := steps[len(steps)-1]; step.NewReplicaSet == nil || step.NewReplicaSet.Name != newRS.Name || step.ExpectedUpdatedReplicas != 3 || step.UpdatedReadyReplicas != 3 {
+		t.Fatalf("expect the batch of 3 replicas of %s verified, got %+v", newRS.Name, step)
+	}
+
+	// The deployment advances to the next batch once approved.
+	approved, reason = true, "error rate 0.1% is below 1%"
+	for i := 0; i < 5; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v2"] <= 3 {
+		t.Fatalf("expect the next batch to be rolled once approved, got %v", replicas)
+	}
+	if !hasEvent(collectEvents(recorder), BatchApprovedReason) {
+		t.Fatalf("expect %s event", BatchApprovedReason)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// BatchProgressDeadlineExceededReason is added in a deployment event when the current batch has not
+// got its expected updated pods available within batchProgressDeadlineSeconds, and the rollout is frozen.
+const BatchProgressDeadlineExceededReason = "BatchProgressDeadlineExceeded"
+
+// checkBatchProgressDeadline returns true if the new pods of the current batch are not all available
+// within batchProgressDeadlineSeconds of strategy since the batch started, which is recorded in the
+// extra status, see syncProgressTimes. Then the rollout is frozen as it is, neither surging nor scaling
+// down any further, and the Progressing condition is set to ProgressDeadlineExceeded by syncRolloutStatus,
+// until the new pods become available or the batch is changed, e.g. by a new partition or template.
+// Otherwise the deployment is requeued once the deadline would be exceeded.
+func (dc *DeploymentController) checkBatchProgressDeadline(d *apps.Deployment, newRS *apps.ReplicaSet) bool {
+	if dc.strategy.BatchProgressDeadlineSeconds <= 0 || newRS == nil {
+		return false
+	}
+	expected := dc.newRSReplicasLimit(d)
+	if newRS.Status.AvailableReplicas >= expected {
+		return false
+	}
+	prev := getExtraStatus(d)
+	if prev == nil || prev.BatchStartTime == nil || prev.ExpectedUpdatedReplicas != expected ||
+		prev.UpdateRevision != newRS.Labels[deploymentutil.TemplateHashLabelKey] {
+		return false
+	}
+
+	deadline := time.Duration(dc.strategy.BatchProgressDeadlineSeconds) * time.Second
+	if left := deadline - dc.clock.Now().Sub(prev.BatchStartTime.Time); left > 0 {
+		dc.enqueueAfter(d, left)
+		return false
+	}
+	dc.batchDeadlineExceeded = true
+	if cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing); cond == nil || cond.Reason != deploymentutil.TimedOutReason {
+		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, BatchProgressDeadlineExceededReason,
+			"Batch with %d expected updated replicas has only %d available after %v, freeze the rollout",
+			expected, newRS.Status.AvailableReplicas, deadline)
+	}
+	dc.log().Info("Freeze the rollout since the batch has exceeded its progress deadline", "replicaSet", klog.KObj(newRS),
+		"expected", expected, "available", newRS.Status.AvailableReplicas, "deadline", deadline)
+	return true
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncDeploymentFrozenByBatchProgressDeadline(t *testing.T) {
+	cases := []struct {
+		name            string
+		deadlineSeconds int32
+		expectFrozen    bool
+	}{
+		{
+			name: "no deadline",
+		},
+		{
+			name:            "deadline exceeded",
+			deadlineSeconds: 600,
+			expectFrozen:    true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(3))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:                 rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:                d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:                    intstr.FromString("50%"),
+				BatchProgressDeadlineSeconds: cs.deadlineSeconds,
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS)
+			clock := dc.clock.(*testingclock.FakeClock)
+
+			// The new pods never become ready, so the batch can never complete.
+			for i := 0; i < 2; i++ {
+				syncAndSettleNewReplicaSet(t, dc, client, d.Namespace, d.Name, 0)
+			}
+			clock.Step(11 * time.Minute)
+			d = syncAndSettleNewReplicaSet(t, dc, client, d.Namespace, d.Name, 0)
+			frozen := getReplicaSetReplicas(t, client, d.Namespace)
+
+			cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+			if timedOut := cond != nil && cond.Reason == deploymentutil.TimedOutReason; timedOut != cs.expectFrozen {
+				t.Fatalf("expect progress deadline exceeded %v, got condition %+v", cs.expectFrozen, cond)
+			}
+			if exceeded := hasEvent(collectEvents(recorder), BatchProgressDeadlineExceededReason); exceeded != cs.expectFrozen {
+				t.Fatalf("expect %s event %v, got %v", BatchProgressDeadlineExceededReason, cs.expectFrozen, exceeded)
+			}
+
+			// A few new pods becoming available would advance the rollout if it is not frozen.
+			for i := 0; i < 3; i++ {
+				d = syncAndSettleNewReplicaSet(t, dc, client, d.Namespace, d.Name, 2)
+			}
+			replicas := getReplicaSetReplicas(t, client, d.Namespace)
+			if held := reflect.DeepEqual(replicas, frozen); held != cs.expectFrozen {
+				t.Fatalf("expect rollout frozen %v at %v, got %v", cs.expectFrozen, frozen, replicas)
+			}
+			if events := collectEvents(recorder); hasEvent(events, BatchProgressDeadlineExceededReason) {
+				t.Fatalf("expect %s event only once, got %v", BatchProgressDeadlineExceededReason, events)
+			}
+		})
+	}
+}
+
+// syncAndSettleNewReplicaSet is like syncAndSettle, but at most available pods of the new replica
+// set become available.
+func syncAndSettleNewReplicaSet(t *testing.T, dc *DeploymentController, client *fake.Clientset, namespace, name string, available int32) *apps.Deployment {
+	d := syncAndSettle(t, dc, client, namespace, name)
+	updateReplicaSetStatus(t, dc, client, namespace, func(rs *apps.ReplicaSet) {
+		if deploymentutil.EqualIgnoreHash(&rs.Spec.Template, &d.Spec.Template) && rs.Status.AvailableReplicas > available {
+			rs.Status.ReadyReplicas, rs.Status.AvailableReplicas = available, available
+		}
+	})
+	return d
+}
+
+// updateReplicaSetStatus updates the status of replica sets in client by update, and refreshes the lister.
+func updateReplicaSetStatus(t *testing.T, dc *DeploymentController, client *fake.Clientset, namespace string, update func(rs *apps.ReplicaSet)) {
+	rsList, err := client.AppsV1().ReplicaSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	indexer := newTestReplicaSetIndexer()
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		update(rs)
+		if rs, err = client.AppsV1().ReplicaSets(namespace).UpdateStatus(context.TODO(), rs, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update replica set status: %v", err)
+		}
+		_ = indexer.Add(rs)
+	}
+	dc.rsLister = appslisters.NewReplicaSetLister(indexer)
+	dc.rsIndexer = indexer
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// syncBatchPause records when the new pods of the current batch became all available, and decides
+// whether the pause duration has elapsed since then. The time is kept in the extra status annotation,
+// so the pause is not restarted by the controller restarts, but it is restarted once the batch is
+// changed or its pods are not all available any more. The deployment is requeued once the pause is
+// going to elapse.
+func (dc *DeploymentController) syncBatchPause(d *apps.Deployment, newRS *apps.ReplicaSet, prev, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if dc.strategy.PauseSeconds <= 0 || newRS == nil {
+		return
+	}
+	expected := extraStatus.ExpectedUpdatedReplicas
+	if expected <= 0 || newRS.Status.AvailableReplicas < expected {
+		return
+	}
+
+	now := dc.clock.Now()
+	available := metav1.NewTime(now)
+	if prev != nil && prev.BatchAvailableTime != nil && prev.UpdateRevision == extraStatus.UpdateRevision &&
+		prev.ExpectedUpdatedReplicas == expected {
+		available = *prev.BatchAvailableTime
+	}
+	extraStatus.BatchAvailableTime = &available
+	left := time.Duration(dc.strategy.PauseSeconds)*time.Second - now.Sub(available.Time)
+	extraStatus.BatchPauseElapsed = left <= 0
+	if !extraStatus.BatchPauseElapsed {
+		dc.enqueueAfter(d, left)
+	}
+}
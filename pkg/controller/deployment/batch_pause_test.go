@@ -0,0 +1,85 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentHeldUntilBatchPauseElapsed(t *testing.T) {
+	now := time.Now()
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+		PauseSeconds:  600,
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+	fakeClock := testingclock.NewFakeClock(now)
+	dc.clock = fakeClock
+
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	extraStatus := getExtraStatus(d)
+	if extraStatus == nil || extraStatus.BatchAvailableTime == nil || !extraStatus.BatchAvailableTime.Time.Equal(now.Truncate(time.Second)) ||
+		extraStatus.BatchPauseElapsed {
+		t.Fatalf("expect batch paused since now, got %+v", extraStatus)
+	}
+	if dc.requeueAfter != 10*time.Minute {
+		t.Fatalf("expect requeue once the pause elapses, got %v", dc.requeueAfter)
+	}
+
+	// The next batch is held even if partition is increased, and the pause is not restarted.
+	dc.strategy.Partition = intstr.FromString("60%")
+	fakeClock.Step(4 * time.Minute)
+	for i := 0; i < 5; i++ {
+		dc.requeueAfter = 0
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expect := map[string]int32{"demo:v1": 7, "demo:v2": 3}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+		t.Fatalf("expect replicas %v held until the pause elapses, got %v", expect, replicas)
+	}
+	if extraStatus = getExtraStatus(d); extraStatus.ExpectedUpdatedReplicas != 3 || extraStatus.BatchPauseElapsed {
+		t.Fatalf("expect the held batch exposed, got %+v", extraStatus)
+	}
+	if left := 6 * time.Minute; dc.requeueAfter > left || dc.requeueAfter < left-time.Second {
+		t.Fatalf("expect requeue after the remaining %v, got %v", left, dc.requeueAfter)
+	}
+
+	// The pause elapses, so the deployment advances to the next batch and pauses again.
+	fakeClock.Step(6 * time.Minute)
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expect = map[string]int32{"demo:v1": 4, "demo:v2": 6}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+		t.Fatalf("expect replicas %v once the pause elapses, got %v", expect, replicas)
+	}
+	if extraStatus = getExtraStatus(d); extraStatus.ExpectedUpdatedReplicas != 6 || extraStatus.BatchPauseElapsed {
+		t.Fatalf("expect the next batch paused, got %+v", extraStatus)
+	}
+}
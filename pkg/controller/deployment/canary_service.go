@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// stableServicePollInterval is how often to look for the stable service if it is not found,
+// since the deployment is not notified once it is created.
+const stableServicePollInterval = 15 * time.Second
+
+// canaryServiceName returns the name of the canary service of deployment.
+func canaryServiceName(d *apps.Deployment) string {
+	return fmt.Sprintf("%s-canary", d.Name)
+}
+
+// syncCanaryService maintains the canary service of the deployment, which only selects the pods of
+// the new replica set, while the old replica sets still have replicas, and deletes it otherwise.
+// It is only done if the stable service of strategy is set, and not in dry-run mode. The service
+// of the same name which is not controlled by the deployment is left as it is.
+func (dc *DeploymentController) syncCanaryService(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) error {
+	if dc.strategy.StableService == "" || dc.dryRun {
+		return nil
+	}
+	name := canaryServiceName(d)
+	canary, err := dc.client.CoreV1().Services(d.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		canary = nil
+	} else if err != nil {
+		return err
+	}
+	if canary != nil && !metav1.IsControlledBy(canary, d) {
+		dc.log().Info("Service already exists and is not controlled by the deployment, skip the canary service", "service", name)
+		return nil
+	}
+
+	if newRS == nil || deploymentutil.GetReplicaCountForReplicaSets(oldRSs) == 0 {
+		if canary == nil {
+			return nil
+		}
+		err = dc.client.CoreV1().Services(d.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		dc.log().Info("Deleted canary service", "service", name)
+		return nil
+	}
+
+	stable, err := dc.client.CoreV1().Services(d.Namespace).Get(ctx, dc.strategy.StableService, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		dc.log().Info("Stable service not found, skip the canary service", "service", dc.strategy.StableService)
+		dc.enqueueAfter(d, stableServicePollInterval)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	spec := newCanaryServiceSpec(d, stable, newRS.Labels[deploymentutil.TemplateHashLabelKey])
+	if canary == nil {
+		canary = &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       d.Namespace,
+				Name:            name,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, controllerKind)},
+			},
+			Spec: spec,
+		}
+		if _, err = dc.client.CoreV1().Services(d.Namespace).Create(ctx, canary, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		dc.log().Info("Created canary service", "service", name, "selector", spec.Selector)
+		return nil
+	}
+
+	if equality.Semantic.DeepEqual(canary.Spec.Selector, spec.Selector) &&
+		equality.Semantic.DeepEqual(canary.Spec.Ports, spec.Ports) &&
+		canary.Spec.SessionAffinity == spec.SessionAffinity &&
+		equality.Semantic.DeepEqual(canary.Spec.SessionAffinityConfig, spec.SessionAffinityConfig) {
+		return nil
+	}
+	canary = canary.DeepCopy()
+	canary.Spec.Selector = spec.Selector
+	canary.Spec.Ports = spec.Ports
+	canary.Spec.SessionAffinity = spec.SessionAffinity
+	canary.Spec.SessionAffinityConfig = spec.SessionAffinityConfig
+	if _, err = dc.client.CoreV1().Services(d.Namespace).Update(ctx, canary, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	dc.log().Info("Updated canary service", "service", name, "selector", spec.Selector)
+	return nil
+}
+
+// newCanaryServiceSpec returns the spec of the canary service, which selects the pods of the
+// deployment with the pod-template-hash, with the ports and session affinity of the stable
+// service. The node ports are not copied, since they cannot be shared by the two services.
+func newCanaryServiceSpec(d *apps.Deployment, stable *v1.Service, podTemplateHash string) v1.ServiceSpec {
+	selector := map[string]string{}
+	for k, v := range d.Spec.Selector.MatchLabels {
+		selector[k] = v
+	}
+	selector[deploymentutil.TemplateHashLabelKey] = podTemplateHash
+
+	ports := make([]v1.ServicePort, 0, len(stable.Spec.Ports))
+	for _, port := range stable.Spec.Ports {
+		port.NodePort = 0
+		ports = append(ports, port)
+	}
+	return v1.ServiceSpec{
+		Selector:              selector,
+		Ports:                 ports,
+		Type:                  v1.ServiceTypeClusterIP,
+		SessionAffinity:       stable.Spec.SessionAffinity,
+		SessionAffinityConfig: stable.Spec.SessionAffinityConfig.DeepCopy(),
+	}
+}
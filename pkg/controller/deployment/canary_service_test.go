@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncCanaryService(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(5))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	stable := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: d.Namespace, Name: "demo"},
+		Spec: v1.ServiceSpec{
+			Type:            v1.ServiceTypeNodePort,
+			Selector:        map[string]string{"app": "demo"},
+			Ports:           []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080), NodePort: 30080}},
+			SessionAffinity: v1.ServiceAffinityClientIP,
+		},
+	}
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+		StableService: stable.Name,
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS, stable)
+
+	// The canary service is created with the ports and session affinity of the stable service.
+	syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	canary := getCanaryService(t, client, d.Namespace, "deployment-canary")
+	if canary == nil {
+		t.Fatalf("expect canary service created")
+	}
+	if !metav1.IsControlledBy(canary, d) {
+		t.Fatalf("expect canary service controlled by deployment, got %+v", canary.OwnerReferences)
+	}
+	expectPorts := []v1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)}}
+	if !reflect.DeepEqual(canary.Spec.Ports, expectPorts) {
+		t.Fatalf("expect ports %+v without node port, got %+v", expectPorts, canary.Spec.Ports)
+	}
+	if canary.Spec.Type != v1.ServiceTypeClusterIP || canary.Spec.SessionAffinity != v1.ServiceAffinityClientIP {
+		t.Fatalf("expect ClusterIP canary service with ClientIP session affinity, got %+v", canary.Spec)
+	}
+	if hash := canary.Spec.Selector[deploymentutil.TemplateHashLabelKey]; hash != getTemplateHash(t, client, d.Namespace, "demo:v2") {
+		t.Fatalf("expect canary service to select the new replica set, got %v", canary.Spec.Selector)
+	}
+
+	// The selector follows the new replica set once the template is changed.
+	d, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	d.Spec.Template.Spec.Containers[0].Image = "demo:v3"
+	if _, err = client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update deployment: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	canary = getCanaryService(t, client, d.Namespace, "deployment-canary")
+	if hash := canary.Spec.Selector[deploymentutil.TemplateHashLabelKey]; hash != getTemplateHash(t, client, d.Namespace, "demo:v3") {
+		t.Fatalf("expect canary service to select the new replica set after the template change, got %v", canary.Spec.Selector)
+	}
+
+	// The canary service is deleted once the old replica sets are scaled down.
+	dc.strategy.Partition = intstr.FromString("100%")
+	for i := 0; i < 5; i++ {
+		syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if canary = getCanaryService(t, client, d.Namespace, "deployment-canary"); canary != nil {
+		t.Fatalf("expect canary service deleted once the rollout is completed, got %+v", canary)
+	}
+	if _, err = client.CoreV1().Services(d.Namespace).Get(context.TODO(), stable.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("expect stable service kept, got %v", err)
+	}
+}
+
+// getCanaryService returns the service of name, or nil if it is not found.
+func getCanaryService(t *testing.T, client *fake.Clientset, namespace, name string) *v1.Service {
+	service, err := client.CoreV1().Services(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		t.Fatalf("failed to get service: %v", err)
+	}
+	return service
+}
+
+// getTemplateHash returns the pod-template-hash of the replica set running image.
+func getTemplateHash(t *testing.T, client *fake.Clientset, namespace, image string) string {
+	return getTestReplicaSet(t, client, namespace, image).Labels[deploymentutil.TemplateHashLabelKey]
+}
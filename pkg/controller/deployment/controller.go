@@ -21,18 +21,27 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"os"
+	"os/signal"
 	"reflect"
+	"strings"
+	"syscall"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/kubernetes/scheme"
-	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	toolscache "k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -48,37 +57,226 @@ import (
 	"github.com/openkruise/rollouts/pkg/feature"
 	clientutil "github.com/openkruise/rollouts/pkg/util/client"
 	utilfeature "github.com/openkruise/rollouts/pkg/util/feature"
+	"github.com/openkruise/rollouts/pkg/util/ratelimiter"
 )
 
 func init() {
 	flag.IntVar(&concurrentReconciles, "deployment-workers", concurrentReconciles, "Max concurrent workers for StatefulSet controller.")
+	flag.StringVar(&syncLimitPath, "deployment-workers-file", syncLimitPath, "File holding the max concurrent syncs of advanced deployments, at most deployment-workers, which is reloaded on SIGHUP to throttle the controller without a restart. Empty means disabled.")
+	flag.DurationVar(&drainStuckGrace, "deployment-drain-stuck-grace", drainStuckGrace, "How long an old pod may stay terminating before it is reported as stuck, 0 means terminating pods are not taken into account.")
+	flag.BoolVar(&drainStuckProceed, "deployment-drain-stuck-proceed", drainStuckProceed, "Whether to treat old pods stuck terminating as removed when calculating the capacity for new pods.")
+	flag.IntVar(&namespaceMaxActiveRollouts, "deployment-namespace-max-active-rollouts", namespaceMaxActiveRollouts, "Max rollouts of advanced deployments in each namespace at the same time, unless overridden by the rollouts.kruise.io/max-active-rollouts annotation of namespace, 0 means no limit.")
+	flag.DurationVar(&batchSLA, "deployment-batch-sla", batchSLA, "Expected max duration of each batch of advanced deployment, 0 means no limit.")
+	flag.DurationVar(&rolloutSLA, "deployment-rollout-sla", rolloutSLA, "Expected max duration of the whole rollout of advanced deployment, 0 means no limit.")
+	flag.DurationVar(&staleCacheRequeueDelay, "deployment-stale-cache-requeue-delay", staleCacheRequeueDelay, "How long to wait before syncing a deployment again if the informer caches seem stale, 0 means never check for stale caches.")
+	flag.BoolVar(&completeFullNewRS, "deployment-complete-full-new-rs", completeFullNewRS, "Whether to complete the rollout directly if the new replica set is already at full size and available, instead of rolling the remaining batches.")
+	flag.BoolVar(&finalizeSuperseded, "deployment-finalize-superseded-rollout", finalizeSuperseded, "Whether to scale down the old replica sets left by a completed rollout first if it is superseded by a new one, instead of rolling them over together with the completed replica set.")
+	flag.BoolVar(&scaleByFrozenRatio, "deployment-paused-scale-by-frozen-ratio", scaleByFrozenRatio, "Whether to distribute the replicas changed while paused, e.g. by HPA, between the new and old replica sets by the ratio frozen when paused, instead of the proportions of their current sizes.")
+	flag.Float64Var(&fairQueueQPS, "deployment-fair-queue-qps", fairQueueQPS, "Max requeues per second of each deployment, so that a hot deployment cannot monopolize the workers, 0 means no limit.")
+	flag.IntVar(&fairQueueBurst, "deployment-fair-queue-burst", fairQueueBurst, "Max burst of requeues of each deployment if deployment-fair-queue-qps is set.")
+	flag.DurationVar(&strategyRetryBaseDelay, "deployment-strategy-retry-base-delay", strategyRetryBaseDelay, "The base delay to retry a deployment whose strategy annotation is malformed, which is doubled on each failure, 0 means never retry.")
+	flag.DurationVar(&strategyRetryMaxDelay, "deployment-strategy-retry-max-delay", strategyRetryMaxDelay, "The max delay to retry a deployment whose strategy annotation is malformed.")
+	flag.DurationVar(&requeueBaseDelay, "deployment-requeue-base-delay", requeueBaseDelay, "The base delay to requeue a deployment whose sync failed, which is doubled on each failure, 0 means the rate-limiter-base-delay shared by all controllers.")
+	flag.DurationVar(&requeueMaxDelay, "deployment-requeue-max-delay", requeueMaxDelay, "The max delay to requeue a deployment whose sync failed, required if deployment-requeue-base-delay is set.")
+	flag.DurationVar(&readinessSampleInterval, "deployment-pod-readiness-sample-interval", readinessSampleInterval, "Min interval between inspecting the readiness of new pods of each deployment, the last sample is reused within the interval unless the new replica set is changed. 0 means inspecting them on every sync.")
+	flag.DurationVar(&milestoneTTL, "deployment-milestone-ttl", milestoneTTL, "How long to keep the rollout milestones of each deployment in a ConfigMap, which should be longer than the retention of events, e.g. 168h. 0 means disabled.")
+	flag.BoolVar(&checkPullSecrets, "deployment-check-image-pull-secrets", checkPullSecrets, "Whether to check the image pull secrets of template exist before starting a rollout, which requires caching all secrets.")
+	flag.BoolVar(&skipTerminatingNamespaces, "deployment-skip-terminating-namespaces", skipTerminatingNamespaces, "Whether to stop syncing the deployments in terminating namespaces, where all writes are going to be rejected.")
+	flag.BoolVar(&checkSelectorOverlaps, "deployment-check-selector-overlaps", checkSelectorOverlaps, "Whether to warn about the deployments whose selectors overlap with others in the same namespace, and stop syncing them if their replica sets cannot be told apart.")
+	flag.BoolVar(&untoleratedTaintsBlock, "deployment-block-on-untolerated-taints", untoleratedTaintsBlock, "Whether to hold the rollout while any new pod is scheduled onto a node with taints not tolerated by the template, otherwise only a warning event is emitted.")
+	flag.BoolVar(&repairDriftedReplicas, "deployment-repair-drifted-replicas", repairDriftedReplicas, "Whether to scale the replica sets created by advanced deployment back to partition on every sync if their replicas drift, e.g. after edited by kubectl. Changing the rollouts.kruise.io/resync annotation forces a repair at once.")
+	flag.BoolVar(&deploymentutil.NormalizeTemplateDefaults, "deployment-normalize-template-defaults", deploymentutil.NormalizeTemplateDefaults, "Whether to fill in the defaults of apiserver before comparing and hashing pod templates, so that the diffs only caused by defaulting will not trigger a rollout.")
+	flag.StringVar(&deploymentutil.TemplateHashLabelKey, "deployment-template-hash-label-key", deploymentutil.TemplateHashLabelKey, "Key of the label carrying the template hash, which is added to the selectors and templates of replica sets to tell their pods apart. Changing it makes the existing replica sets be taken as new revisions.")
+	flag.DurationVar(&batchCheckInterval, "deployment-batch-check-interval", batchCheckInterval, "How often to run the custom batch checks again while they have not reached the quorum.")
+	flag.BoolVar(&migrateExtraStatus, "deployment-migrate-extra-status", migrateExtraStatus, "Whether to upgrade the extra status annotation written by the controllers of older versions on the first reconcile, otherwise a rollout in progress may be regarded as a new one.")
+	flag.IntVar(&extraStatusMaxSize, "deployment-extra-status-max-size", extraStatusMaxSize, "Max size in bytes of the extra status annotation of advanced deployment, the oldest progress history is dropped to fit in, 0 means no limit.")
+	flag.StringVar(&lostControlPolicy, "deployment-lost-control-policy", lostControlPolicy, "What to do with a deployment released from rollout control in the middle of rollout, 'KeepPartition' settles the old replica sets around the current new replica set, 'Ignore' leaves them as they are.")
+	flag.StringVar(&auditLogPath, "deployment-audit-log", auditLogPath, "File to append the audit log of scaling decisions to, '-' means stdout, empty means disabled.")
+	flag.BoolVar(&scaleSubresource, "deployment-scale-subresource", scaleSubresource, "Whether to scale replica sets via the scale subresource if only their replicas are changed, falling back to updating the whole replica set on failure.")
+	flag.StringVar(&eventAggregationNamespace, "deployment-event-aggregation-namespace", eventAggregationNamespace, "Namespace to copy all events of advanced deployments to in addition to their own namespaces, empty means disabled.")
+	flag.DurationVar(&eventAggregationWindow, "deployment-event-aggregation-window", eventAggregationWindow, "How long the similar events of the same object and reason, e.g. ScalingReplicaSet, are combined into one event with a count. Non-positive means the default of client-go.")
+	flag.BoolVar(&minReadyFromPods, "deployment-min-ready-from-pods", minReadyFromPods, "Whether to count the available replicas of replica sets from the pods ready for at least minReadySeconds of deployment, instead of trusting the status of replica sets only.")
+	flag.StringVar(&watchNamespaceSelector, "watch-namespace-selector", watchNamespaceSelector, "Label selector of the namespaces whose advanced deployments are managed, e.g. rollouts.kruise.io/managed=true, the deployments in the other namespaces are ignored even if they carry the strategy annotation. Empty means all namespaces.")
+	flag.StringVar(&reservedLabelKeys, "deployment-reserved-label-keys", reservedLabelKeys, "Comma separated label keys owned by users, e.g. those left by another rollout controller being migrated from, which are not overwritten on the new replica sets and their templates if already set to other values.")
+	flag.StringVar(&adminToken, "deployment-admin-token", adminToken, "Bearer token of the admin endpoints served along with the metrics to promote or roll back an advanced deployment, i.e. POST /advance and /rollback with namespace and name query parameters. Empty means the endpoints are disabled.")
+	flag.BoolVar(&perDeploymentMetrics, "deployment-per-object-metrics", perDeploymentMetrics, "Whether to expose the rollout metrics labeled by namespace and name of each advanced deployment in addition to the aggregate ones, whose series grow with the deployments.")
 }
 
 var (
 	concurrentReconciles = 3
+
+	// syncLimitPath is the file of the max concurrent syncs reloaded on SIGHUP, see syncLimiter for details.
+	syncLimitPath string
+
+	// drainStuckGrace and drainStuckProceed decide how old pods stuck in terminating
+	// are handled during rolling, see countTerminatingPods for details.
+	drainStuckGrace   time.Duration
+	drainStuckProceed bool
+
+	// namespaceMaxActiveRollouts is the default max active rollouts of each namespace, see
+	// queueRollout for details.
+	namespaceMaxActiveRollouts int
+
+	// batchSLA and rolloutSLA are the expected max durations of each batch and the
+	// whole rollout, a warning event will be emitted once they are exceeded.
+	batchSLA   time.Duration
+	rolloutSLA time.Duration
+
+	// staleCacheRequeueDelay is how long to defer a deployment whose caches seem stale.
+	staleCacheRequeueDelay = 5 * time.Second
+
+	// completeFullNewRS decides whether to complete the rollout directly once the new
+	// replica set is found at full size, see isNewRSCompleted for details.
+	completeFullNewRS = true
+
+	// finalizeSuperseded decides whether to finish scaling down a completed rollout
+	// once it is superseded, see finalizeSupersededRollout for details.
+	finalizeSuperseded = true
+
+	// scaleByFrozenRatio decides how a paused deployment is scaled, see scaleWithFrozenRatio
+	// for details.
+	scaleByFrozenRatio = true
+
+	// fairQueueQPS and fairQueueBurst limit the requeues of each deployment, instead of
+	// all deployments, so that the workers are shared fairly across deployments.
+	fairQueueQPS   float64
+	fairQueueBurst = 5
+
+	// strategyRetryBaseDelay and strategyRetryMaxDelay decide the exponential backoff to retry
+	// the deployments whose strategy annotation is malformed, e.g. in the middle of editing.
+	strategyRetryBaseDelay = time.Second
+	strategyRetryMaxDelay  = 5 * time.Minute
+
+	// requeueBaseDelay and requeueMaxDelay decide the exponential backoff to requeue the
+	// deployments whose sync failed, e.g. throttled by apiserver, see validateRequeueBackoff.
+	requeueBaseDelay time.Duration
+	requeueMaxDelay  time.Duration
+
+	// readinessSampleInterval is the min interval between samples of the readiness of new pods,
+	// see readinessSampler for details.
+	readinessSampleInterval time.Duration
+
+	// milestoneTTL is how long the rollout milestones are kept in ConfigMaps, 0 means disabled.
+	milestoneTTL time.Duration
+
+	// checkPullSecrets decides whether to check the image pull secrets before rolling,
+	// see checkImagePullSecrets for details.
+	checkPullSecrets bool
+
+	// skipTerminatingNamespaces decides whether to stop syncing the deployments in terminating
+	// namespaces.
+	skipTerminatingNamespaces = true
+
+	// checkSelectorOverlaps decides whether to check the selector overlaps between deployments,
+	// see checkSelectorOverlap for details.
+	checkSelectorOverlaps = true
+
+	// untoleratedTaintsBlock decides whether to hold the rollout if new pods are found on
+	// nodes with untolerated taints, see checkUntoleratedTaints for details.
+	untoleratedTaintsBlock bool
+
+	// repairDriftedReplicas decides whether to restore the replicas of replica sets edited
+	// out of partition, see repairDriftedReplicaSets for details.
+	repairDriftedReplicas = true
+
+	// batchCheckInterval is how often to run the custom batch checks until they pass,
+	// see syncBatchChecks for details.
+	batchCheckInterval = 30 * time.Second
+
+	// migrateExtraStatus decides whether to upgrade the extra status of older schema versions,
+	// see migrateExtraStatus for details.
+	migrateExtraStatus = true
+
+	// extraStatusMaxSize bounds the extra status annotation, see marshalExtraStatus for details.
+	extraStatusMaxSize = 8 * 1024
+
+	// lostControlPolicy decides how a deployment released from rollout control in the middle
+	// of rollout is settled, see syncReleasedDeployment for details.
+	lostControlPolicy = KeepPartitionLostControlPolicy
+
+	// auditLogPath is where the audit log of scaling decisions is written to.
+	auditLogPath string
+
+	// scaleSubresource decides whether to scale replica sets via the scale subresource, see
+	// updateReplicaSetReplicas for details.
+	scaleSubresource bool
+
+	// eventAggregationNamespace is where the events are copied to, see eventSink for details.
+	eventAggregationNamespace string
+
+	// eventAggregationWindow is how long the similar events are combined for, see
+	// newEventBroadcaster for details.
+	eventAggregationWindow = 10 * time.Minute
+
+	// minReadyFromPods decides whether to verify the available replicas of replica sets against
+	// their pods, see capAvailableReplicas for details.
+	minReadyFromPods bool
+
+	// watchNamespaceSelector selects the namespaces whose deployments are managed, see
+	// isNamespaceSelected for details.
+	watchNamespaceSelector string
+
+	// reservedLabelKeys are the comma separated label keys never overwritten on new replica sets,
+	// see addLabel for details.
+	reservedLabelKeys string
+
+	// adminToken is the bearer token of the admin endpoints, empty means they are not served,
+	// see rolloutAdminHandler for details.
+	adminToken string
+
+	// perDeploymentMetrics decides whether to expose the metrics labeled by deployment, see
+	// recordRolloutMetrics for details.
+	perDeploymentMetrics bool
 )
 
 // Add creates a new StatefulSet Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
 	if !utilfeature.DefaultFeatureGate.Enabled(feature.AdvancedDeploymentGate) {
-		klog.Warningf("Advanced deployment controller is disabled")
-		return nil
+		klog.Warningf("Advanced deployment controller is disabled by feature gate %s", feature.AdvancedDeploymentGate)
+		return addFinalizerRemover(mgr)
 	}
 	r, err := newReconciler(mgr)
 	if err != nil {
 		return err
 	}
-	return add(mgr, r)
+	if err = add(mgr, r); err != nil {
+		return err
+	}
+	klog.Infof("Advanced deployment controller is added, waiting to be elected if leader election is enabled")
+	return nil
 }
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) (reconcile.Reconciler, error) {
-	cacher := mgr.GetCache()
-	podInformer, err := cacher.GetInformerForKind(context.TODO(), v1.SchemeGroupVersion.WithKind("Pod"))
+	if err := validateRequeueBackoff(requeueBaseDelay, requeueMaxDelay); err != nil {
+		return nil, err
+	}
+	if err := validateTemplateHashLabelKey(deploymentutil.TemplateHashLabelKey); err != nil {
+		return nil, err
+	}
+	namespaceSelector, err := parseNamespaceSelector(watchNamespaceSelector)
 	if err != nil {
 		return nil, err
 	}
+	reservedLabels, err := parseReservedLabelKeys(reservedLabelKeys)
+	if err != nil {
+		return nil, err
+	}
+	cacher := mgr.GetCache()
+	// Pods are not cached at all in the podless mode, which take most memory on large clusters.
+	var podLister corelisters.PodLister
+	var podListerSynced toolscache.InformerSynced
+	if !utilfeature.DefaultFeatureGate.Enabled(feature.AdvancedDeploymentPodlessModeGate) {
+		podInformer, err := cacher.GetInformerForKind(context.TODO(), v1.SchemeGroupVersion.WithKind("Pod"))
+		if err != nil {
+			return nil, err
+		}
+		podLister = corelisters.NewPodLister(podInformer.(toolscache.SharedIndexInformer).GetIndexer())
+		podListerSynced = podInformer.HasSynced
+	} else {
+		klog.Infof("Advanced deployment controller runs in podless mode, pods are not cached")
+	}
 	dInformer, err := cacher.GetInformerForKind(context.TODO(), appsv1.SchemeGroupVersion.WithKind("Deployment"))
 	if err != nil {
 		return nil, err
@@ -87,19 +285,41 @@ func newReconciler(mgr manager.Manager) (reconcile.Reconciler, error) {
 	if err != nil {
 		return nil, err
 	}
+	nsInformer, err := cacher.GetInformerForKind(context.TODO(), v1.SchemeGroupVersion.WithKind("Namespace"))
+	if err != nil {
+		return nil, err
+	}
+	nodeInformer, err := cacher.GetInformerForKind(context.TODO(), v1.SchemeGroupVersion.WithKind("Node"))
+	if err != nil {
+		return nil, err
+	}
+	approvalInformer, err := cacher.GetInformerForKind(context.TODO(), rolloutsv1alpha1.GroupVersion.WithKind("RolloutApproval"))
+	if err != nil {
+		return nil, err
+	}
 
 	// Lister
 	dLister := appslisters.NewDeploymentLister(dInformer.(toolscache.SharedIndexInformer).GetIndexer())
 	rsLister := appslisters.NewReplicaSetLister(rsInformer.(toolscache.SharedIndexInformer).GetIndexer())
-	podLister := corelisters.NewPodLister(podInformer.(toolscache.SharedIndexInformer).GetIndexer())
+	if err = rsInformer.(toolscache.SharedIndexInformer).AddIndexers(toolscache.Indexers{rsOwnerIndex: indexReplicaSetByOwner}); err != nil {
+		return nil, err
+	}
+	nsLister := corelisters.NewNamespaceLister(nsInformer.(toolscache.SharedIndexInformer).GetIndexer())
+	nodeLister := corelisters.NewNodeLister(nodeInformer.(toolscache.SharedIndexInformer).GetIndexer())
 
 	// Client & Recorder
 	genericClient := clientutil.GetGenericClientWithName("advanced-deployment-controller")
-	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster := newEventBroadcaster(eventAggregationWindow)
 	eventBroadcaster.StartLogging(klog.Infof)
-	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: genericClient.KubeClient.CoreV1().Events("")})
+	eventBroadcaster.StartRecordingToSink(newEventSink(genericClient.KubeClient, eventAggregationNamespace))
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "advanced-deployment-controller"})
 
+	realClock := clock.RealClock{}
+	auditor, err := newAuditLogger(auditLogPath, realClock)
+	if err != nil {
+		return nil, err
+	}
+
 	// Deployment controller factory
 	factory := &controllerFactory{
 		client:           genericClient.KubeClient,
@@ -107,9 +327,78 @@ func newReconciler(mgr manager.Manager) (reconcile.Reconciler, error) {
 		eventRecorder:    recorder,
 		dLister:          dLister,
 		rsLister:         rsLister,
+		rsIndexer:        rsInformer.(toolscache.SharedIndexInformer).GetIndexer(),
 		podLister:        podLister,
+		nsLister:         nsLister,
+		nodeLister:       nodeLister,
+		approvalIndexer:  approvalInformer.(toolscache.SharedIndexInformer).GetIndexer(),
+		batchChecks:      registeredBatchChecks(),
+		dListerSynced:    dInformer.HasSynced,
+		rsListerSynced:   rsInformer.HasSynced,
+		podListerSynced:  podListerSynced,
+		clock:            realClock,
+		auditor:          auditor,
+		milestones:       newMilestoneRecorder(genericClient.KubeClient, milestoneTTL, realClock),
+		readinessSamples: newReadinessSampler(readinessSampleInterval),
+		activeRollouts:   newActiveRolloutTracker(),
+		terminatingNS:    newTerminatingNamespaceTracker(),
+		strategyRefs:     newStrategyRefCache(),
+		canaryStyles:     newUIDTracker(),
+		ignoredRefs:      newUIDTracker(),
+		pausedByAnnos:    newUIDTracker(),
+		stuckDrains:      newUIDTracker(),
+		reservedLabels:   reservedLabels,
+	}
+	if checkPullSecrets {
+		secretInformer, err := cacher.GetInformerForKind(context.TODO(), v1.SchemeGroupVersion.WithKind("Secret"))
+		if err != nil {
+			return nil, err
+		}
+		factory.secretLister = corelisters.NewSecretLister(secretInformer.(toolscache.SharedIndexInformer).GetIndexer())
+	}
+	r := &ReconcileDeployment{Client: mgr.GetClient(), controllerFactory: factory, namespaceSelector: namespaceSelector}
+	if strategyRetryBaseDelay > 0 {
+		r.strategyBackoff = workqueue.NewItemExponentialFailureRateLimiter(strategyRetryBaseDelay, strategyRetryMaxDelay)
 	}
-	return &ReconcileDeployment{Client: mgr.GetClient(), controllerFactory: factory}, nil
+	if fairQueueQPS > 0 {
+		r.requeueLimiter = ratelimiter.NewItemBucketRateLimiter(fairQueueQPS, fairQueueBurst, realClock)
+	}
+	if requeueBaseDelay > 0 {
+		r.failureBackoff = workqueue.NewItemExponentialFailureRateLimiter(requeueBaseDelay, requeueMaxDelay)
+	}
+	if syncLimitPath != "" {
+		limit, err := readSyncLimit(syncLimitPath)
+		if err != nil {
+			return nil, err
+		}
+		if r.syncLimiter, err = newSyncLimiter(concurrentReconciles, limit); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// validateRequeueBackoff returns an error unless the backoff to requeue failed deployments is
+// unset, or both of its delays are positive and the base delay is no more than the max delay.
+func validateRequeueBackoff(baseDelay, maxDelay time.Duration) error {
+	if baseDelay == 0 && maxDelay == 0 {
+		return nil
+	}
+	if baseDelay <= 0 || maxDelay <= 0 {
+		return fmt.Errorf("deployment-requeue-base-delay %v and deployment-requeue-max-delay %v must be both positive", baseDelay, maxDelay)
+	}
+	if baseDelay > maxDelay {
+		return fmt.Errorf("deployment-requeue-base-delay %v must not be greater than deployment-requeue-max-delay %v", baseDelay, maxDelay)
+	}
+	return nil
+}
+
+// validateTemplateHashLabelKey returns an error unless key is a valid label key.
+func validateTemplateHashLabelKey(key string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("deployment-template-hash-label-key %q is invalid: %s", key, strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 var _ reconcile.Reconciler = &ReconcileDeployment{}
@@ -119,91 +408,306 @@ type ReconcileDeployment struct {
 	// client interface
 	client.Client
 	controllerFactory *controllerFactory
+	// requeueLimiter limits the requeues of each deployment, nil means no limit.
+	requeueLimiter *ratelimiter.ItemBucketRateLimiter
+	// strategyBackoff decides when to retry the deployments whose strategy cannot be parsed,
+	// nil means never retry until the next event.
+	strategyBackoff workqueue.RateLimiter
+	// failureBackoff decides when to requeue the deployments whose sync failed, nil means
+	// the default backoff shared by all controllers.
+	failureBackoff workqueue.RateLimiter
+	// syncLimiter limits the concurrent syncs below the workers, nil means no limit.
+	syncLimiter *syncLimiter
+	// namespaceSelector selects the namespaces whose deployments are reconciled, nil means all.
+	namespaceSelector labels.Selector
+}
+
+// rateLimiter returns the rate limiter of queue, or nil if the default one of controller is used.
+func (r *ReconcileDeployment) rateLimiter() workqueue.RateLimiter {
+	switch {
+	case r.failureBackoff != nil && r.requeueLimiter != nil:
+		return workqueue.NewMaxOfRateLimiter(r.failureBackoff, r.requeueLimiter)
+	case r.failureBackoff != nil:
+		return ratelimiter.ControllerRateLimiterWithBackoff(r.failureBackoff)
+	case r.requeueLimiter != nil:
+		return ratelimiter.FairControllerRateLimiter(r.requeueLimiter)
+	}
+	return nil
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
-	c, err := controller.New("advanced-deployment-controller", mgr, controller.Options{
-		Reconciler: r, MaxConcurrentReconciles: concurrentReconciles})
+	options := controller.Options{Reconciler: r, MaxConcurrentReconciles: concurrentReconciles}
+	if limiter := r.(*ReconcileDeployment).rateLimiter(); limiter != nil {
+		options.RateLimiter = limiter
+	}
+	// Serve whether any rollout is stuck along with the metrics, e.g. for dashboards.
+	factory := r.(*ReconcileDeployment).controllerFactory
+	if err := mgr.AddMetricsExtraHandler(rolloutsReadyPath, &stuckRolloutsHandler{dLister: factory.dLister, clock: factory.clock}); err != nil {
+		return err
+	}
+	// Serve the admin endpoints along with the metrics, which are served on every replica.
+	if adminToken != "" {
+		for path, rollback := range map[string]bool{rolloutsAdvancePath: false, rolloutsRollbackPath: true} {
+			handler := &rolloutAdminHandler{factory: factory, token: adminToken, rollback: rollback}
+			if err := mgr.AddMetricsExtraHandler(path, handler); err != nil {
+				return err
+			}
+		}
+	}
+	// Reload the max concurrent syncs on SIGHUP, which would terminate the process otherwise.
+	if limiter := r.(*ReconcileDeployment).syncLimiter; limiter != nil {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			defer signal.Stop(signals)
+			limiter.watchReload(ctx, syncLimitPath, signals)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	// Expose whether this replica is the leader, which is the only one reconciling.
+	if err := mgr.Add(manager.RunnableFunc(recordLeadership)); err != nil {
+		return err
+	}
+	c, err := controller.New("advanced-deployment-controller", mgr, options)
 	if err != nil {
 		return err
 	}
 
+	namespaceSelected := r.(*ReconcileDeployment).namespaceSelected()
 	if err = c.Watch(&source.Kind{Type: &appsv1.ReplicaSet{}}, &handler.EnqueueRequestForOwner{
-		IsController: true, OwnerType: &appsv1.ReplicaSet{}}, predicate.Funcs{}); err != nil {
+		IsController: true, OwnerType: &appsv1.ReplicaSet{}}, namespaceSelected); err != nil {
 		return err
 	}
 
-	// TODO: handle deployment only when the deployment is under our control
-	updateHandler := func(e event.UpdateEvent) bool {
-		oldObject := e.ObjectOld.(*appsv1.Deployment)
-		newObject := e.ObjectNew.(*appsv1.Deployment)
-		if !deploymentutil.IsUnderRolloutControl(newObject) {
-			return false
-		}
-		if oldObject.Generation != newObject.Generation || newObject.DeletionTimestamp != nil {
-			klog.V(3).Infof("Observed updated Spec for Deployment: %s/%s", newObject.Namespace, newObject.Name)
-			return true
-		}
-		if len(oldObject.Annotations) != len(newObject.Annotations) || !reflect.DeepEqual(oldObject.Annotations, newObject.Annotations) {
-			klog.V(3).Infof("Observed updated Annotation for Deployment: %s/%s", newObject.Namespace, newObject.Name)
+	// Watch for changes to Deployment
+	if err = c.Watch(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, predicate.Funcs{UpdateFunc: deploymentUpdated}, r.(*ReconcileDeployment).deploymentSelected()); err != nil {
+		return err
+	}
+
+	// Watch for changes to RolloutApproval
+	if err = c.Watch(&source.Kind{Type: &rolloutsv1alpha1.RolloutApproval{}}, handler.EnqueueRequestsFromMapFunc(deploymentOfApproval())); err != nil {
+		return err
+	}
+
+	// Watch for changes to the ConfigMaps referred to by the strategy ref annotation
+	strategyRefs := factory.strategyRefs
+	if err = c.Watch(&source.Kind{Type: &v1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(deploymentsOfStrategyRef(mgr.GetClient())), predicate.Funcs{
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			strategyRefs.evict(client.ObjectKeyFromObject(e.Object))
 			return true
-		}
-		return false
+		},
+	}, namespaceSelected); err != nil {
+		return err
 	}
 
-	// Watch for changes to Deployment
-	return c.Watch(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForObject{}, predicate.Funcs{UpdateFunc: updateHandler})
+	// Watch for freezing and unfreezing of Namespace, the changes of its max active rollouts,
+	// and whether it is selected
+	freezeHandler := func(e event.UpdateEvent) bool {
+		oldNamespace, newNamespace := e.ObjectOld.(*v1.Namespace), e.ObjectNew.(*v1.Namespace)
+		return isFrozen(oldNamespace) != isFrozen(newNamespace) || r.(*ReconcileDeployment).namespaceSelectionChanged(oldNamespace, newNamespace) ||
+			oldNamespace.Annotations[rolloutsv1alpha1.NamespaceMaxActiveRolloutsAnnotation] != newNamespace.Annotations[rolloutsv1alpha1.NamespaceMaxActiveRolloutsAnnotation]
+	}
+	return c.Watch(&source.Kind{Type: &v1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(deploymentsInNamespace(mgr.GetClient())), predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc:  freezeHandler,
+	})
+}
+
+// deploymentUpdated returns true if the update of deployment should be reconciled.
+// TODO: handle deployment only when the deployment is under our control
+func deploymentUpdated(e event.UpdateEvent) bool {
+	oldObject := e.ObjectOld.(*appsv1.Deployment)
+	newObject := e.ObjectNew.(*appsv1.Deployment)
+	if !deploymentutil.IsUnderRolloutControl(newObject) {
+		// The deployment released from rollout control should be settled once.
+		return deploymentutil.IsUnderRolloutControl(oldObject)
+	}
+	if oldObject.Generation != newObject.Generation || newObject.DeletionTimestamp != nil {
+		klog.V(3).Infof("Observed updated Spec for Deployment: %s/%s", newObject.Namespace, newObject.Name)
+		return true
+	}
+	if oldObject.Annotations[rolloutsv1alpha1.DeploymentResyncAnnotation] != newObject.Annotations[rolloutsv1alpha1.DeploymentResyncAnnotation] {
+		klog.V(3).Infof("Observed resync request for Deployment: %s/%s", newObject.Namespace, newObject.Name)
+		return true
+	}
+	if len(oldObject.Annotations) != len(newObject.Annotations) || !reflect.DeepEqual(oldObject.Annotations, newObject.Annotations) {
+		klog.V(3).Infof("Observed updated Annotation for Deployment: %s/%s", newObject.Namespace, newObject.Name)
+		return true
+	}
+	return false
 }
 
 // Reconcile reads that state of the cluster for a Deployment object and makes changes based on the state read
 // and what is in the Deployment.Spec and Deployment.Annotations
 // Automatically generate RBAC rules to allow the Controller to read and write ReplicaSets
-func (r *ReconcileDeployment) Reconcile(_ context.Context, request reconcile.Request) (reconcile.Result, error) {
+// +kubebuilder:rbac:groups=apps,resources=replicasets/scale,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=rollouts.kruise.io,resources=rolloutapprovals,verbs=get;list;watch
+func (r *ReconcileDeployment) Reconcile(ctx context.Context, request reconcile.Request) (result reconcile.Result, err error) {
+	outcome := reconcileSkip
+	defer func() {
+		if err != nil {
+			outcome = reconcileError
+		}
+		reconcileTotal.WithLabelValues(outcome).Inc()
+	}()
+
 	deployment := new(appsv1.Deployment)
-	err := r.Get(context.TODO(), request.NamespacedName, deployment)
+	err = r.Get(ctx, request.NamespacedName, deployment)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Object not found, return.  Created objects are automatically garbage collected.
 			// For additional cleanup logic use finalizers.
+			r.forgetStrategyFailures(request)
+			r.controllerFactory.readinessSamples.forget(request.NamespacedName)
+			r.controllerFactory.activeRollouts.release(request.Namespace, request.Name)
+			r.controllerFactory.canaryStyles.forget(request.NamespacedName)
+			r.controllerFactory.pausedByAnnos.forget(request.NamespacedName)
+			r.controllerFactory.ignoredRefs.forget(request.NamespacedName)
+			forgetDeploymentMetrics(request.Namespace, request.Name)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, err
 	}
 
+	// Remove the canary artifacts before the deployment is gone or once it is released from
+	// rollout control, which keeps the deployment until CleanupFinalizer is removed. It is done
+	// even if the namespace is not selected any more, otherwise the deployment is never deleted.
+	if needsFinalizing(deployment) {
+		dc := DeploymentController(*r.controllerFactory)
+		if err = dc.finalizeDeployment(ctx, deployment); err != nil {
+			return reconcile.Result{}, err
+		}
+		if deployment.DeletionTimestamp != nil {
+			outcome = reconcileSuccess
+			return reconcile.Result{}, nil
+		}
+	}
+
+	// The deployments in the namespaces not selected may still be enqueued, e.g. by approvals.
+	if !r.isNamespaceSelected(deployment.Namespace) {
+		klog.V(4).Infof("Skip deployment %v in namespace not selected by %s", klog.KObj(deployment), r.namespaceSelector)
+		return reconcile.Result{}, nil
+	}
+
+	if isReleased(deployment) {
+		forgetDeploymentMetrics(deployment.Namespace, deployment.Name)
+		dc := DeploymentController(*r.controllerFactory)
+		outcome = reconcileSuccess
+		return reconcile.Result{}, dc.syncReleasedDeployment(ctx, deployment)
+	}
+
 	// TODO: create new controller only when deployment is under our control
-	dc := r.controllerFactory.NewController(deployment)
+	dc, err := r.controllerFactory.NewController(deployment)
+	if err != nil {
+		// The strategy annotation may be in the middle of editing, so we retry it soon,
+		// and back off exponentially in case it is persistently malformed.
+		outcome = reconcileError
+		if r.strategyBackoff == nil {
+			return reconcile.Result{}, nil
+		}
+		after := r.strategyBackoff.When(request)
+		klog.Warningf("Retry deployment %v with malformed strategy after %v", klog.KObj(deployment), after)
+		return reconcile.Result{RequeueAfter: after}, nil
+	}
+	r.forgetStrategyFailures(request)
 	if dc == nil {
+		forgetDeploymentMetrics(deployment.Namespace, deployment.Name)
 		return reconcile.Result{}, nil
 	}
 
-	err = dc.syncDeployment(context.Background(), deployment)
-	return ctrl.Result{}, err
+	if err = r.syncLimiter.acquire(ctx); err != nil {
+		return reconcile.Result{}, err
+	}
+	defer r.syncLimiter.release()
+	if deployment, err = dc.ensureCleanupFinalizer(ctx, deployment); err != nil {
+		return reconcile.Result{}, err
+	}
+	start := dc.clock.Now()
+	err = dc.syncDeployment(ctx, deployment)
+	syncDuration.Observe(dc.clock.Since(start).Seconds())
+	outcome = reconcileSuccess
+	requeueAfter := dc.requeueAfter
+	// Requeues with errors are limited by the rate limiter of queue, but requeues after a
+	// duration are not, so we limit them here.
+	if requeueAfter > 0 && err == nil && r.requeueLimiter != nil {
+		if delay := r.requeueLimiter.When(request); delay > requeueAfter {
+			requeueAfter = delay
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, err
+}
+
+// forgetStrategyFailures resets the backoff of retrying the deployment with malformed strategy.
+func (r *ReconcileDeployment) forgetStrategyFailures(request reconcile.Request) {
+	if r.strategyBackoff != nil {
+		r.strategyBackoff.Forget(request)
+	}
 }
 
 type controllerFactory DeploymentController
 
-// NewController create a new DeploymentController
+// NewController create a new DeploymentController, it returns nil if the deployment should
+// not be processed by us, or an error if the strategy of deployment is malformed or invalid.
 // TODO: create new controller only when deployment is under our control
-func (f *controllerFactory) NewController(deployment *appsv1.Deployment) *DeploymentController {
+func (f *controllerFactory) NewController(deployment *appsv1.Deployment) (*DeploymentController, error) {
 	if !deploymentutil.IsUnderRolloutControl(deployment) {
 		klog.Warningf("Deployment %v is not under rollout control, ignore", klog.KObj(deployment))
-		return nil
+		return nil, nil
+	}
+	if err := checkSelector(deployment); err != nil {
+		klog.Errorf("Unsupported selector of deployment %v, ignore: %v", klog.KObj(deployment), err)
+		f.eventRecorder.Eventf(deployment, v1.EventTypeWarning, UnsupportedSelectorReason, "Refuse to process deployment: %v", err)
+		return nil, nil
 	}
 
 	strategy := rolloutsv1alpha1.DeploymentStrategy{}
-	strategyAnno := deployment.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation]
+	strategyAnno, err := f.strategyAnnotation(deployment)
+	if err != nil {
+		klog.Errorf("Failed to read strategy ref for deployment %v: %v", klog.KObj(deployment), err)
+		f.reportMalformedStrategy(deployment, err)
+		return nil, err
+	}
 	if err := json.Unmarshal([]byte(strategyAnno), &strategy); err != nil {
-		klog.Errorf("Failed to unmarshal strategy for deployment %v: %v", klog.KObj(deployment), strategyAnno)
-		return nil
+		klog.Errorf("Failed to unmarshal strategy for deployment %v: %v, %v", klog.KObj(deployment), strategyAnno, err)
+		f.reportMalformedStrategy(deployment, err)
+		return nil, err
+	}
+	fldPath := field.NewPath("strategy")
+	errList := rolloutsv1alpha1.ValidateDeploymentStrategy(&strategy, fldPath)
+	if f.podLister == nil {
+		errList = append(errList, validatePodlessStrategy(&strategy, fldPath)...)
+	}
+	if len(errList) > 0 {
+		err := errList.ToAggregate()
+		klog.Errorf("Invalid strategy for deployment %v: %v", klog.KObj(deployment), err)
+		f.reportInvalidStrategy(deployment, errList, fldPath)
+		return nil, err
 	}
 
 	// We do NOT process such deployment with canary rolling style
-	if strategy.RollingStyle == rolloutsv1alpha1.CanaryRollingStyleType {
-		return nil
+	canaryStyle := strategy.RollingStyle == rolloutsv1alpha1.CanaryRollingStyleType
+	if f.canaryStyles.observe(client.ObjectKeyFromObject(deployment), deployment.UID, canaryStyle) {
+		f.eventRecorder.Eventf(deployment, v1.EventTypeNormal, SkippedCanaryStyleReason,
+			"Rolling style %s is delegated to BatchRelease, advanced deployment controller leaves the deployment as it is", strategy.RollingStyle)
 	}
+	if canaryStyle {
+		klog.V(4).Infof("Skip deployment %v since its rolling style %s is delegated to BatchRelease", klog.KObj(deployment), strategy.RollingStyle)
+		return nil, nil
+	}
+	rolloutsv1alpha1.SetDefaultDeploymentStrategy(&strategy)
 
 	marshaled, _ := json.Marshal(&strategy)
 	klog.V(4).Infof("Processing deployment %v strategy %v", klog.KObj(deployment), string(marshaled))
@@ -214,7 +718,27 @@ func (f *controllerFactory) NewController(deployment *appsv1.Deployment) *Deploy
 		eventRecorder:    f.eventRecorder,
 		dLister:          f.dLister,
 		rsLister:         f.rsLister,
+		rsIndexer:        f.rsIndexer,
 		podLister:        f.podLister,
+		nsLister:         f.nsLister,
+		nodeLister:       f.nodeLister,
+		secretLister:     f.secretLister,
+		approvalIndexer:  f.approvalIndexer,
+		batchChecks:      f.batchChecks,
+		dListerSynced:    f.dListerSynced,
+		rsListerSynced:   f.rsListerSynced,
+		podListerSynced:  f.podListerSynced,
+		clock:            f.clock,
+		auditor:          f.auditor,
+		milestones:       f.milestones,
+		readinessSamples: f.readinessSamples,
+		activeRollouts:   f.activeRollouts,
+		terminatingNS:    f.terminatingNS,
+		reservedLabels:   f.reservedLabels,
+		pausedByAnnos:    f.pausedByAnnos,
+		stuckDrains:      f.stuckDrains,
 		strategy:         strategy,
-	}
+		pausedByAnno:     isPausedByAnnotation(deployment),
+		dryRun:           isDryRun(deployment),
+	}, nil
 }
@@ -0,0 +1,465 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestReconcileMalformedStrategy(t *testing.T) {
+	const (
+		malformed = `{"rollingStyle":`
+		valid     = `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":0},"partition":"100%"}`
+	)
+	type step struct {
+		annotation   string
+		expectResult reconcile.Result
+	}
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "persistent failures back off exponentially to the max",
+			steps: []step{
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: time.Second}},
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: 2 * time.Second}},
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: 4 * time.Second}},
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: 5 * time.Second}},
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: 5 * time.Second}},
+			},
+		},
+		{
+			name: "transient failures are forgotten once the strategy is fixed",
+			steps: []step{
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: time.Second}},
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: 2 * time.Second}},
+				{annotation: valid},
+				{annotation: malformed, expectResult: reconcile.Result{RequeueAfter: time.Second}},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+			d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+			d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			d.Spec.Paused = true
+			dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+			reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(d).Build()
+			r := &ReconcileDeployment{
+				Client:            reader,
+				controllerFactory: (*controllerFactory)(dc),
+				strategyBackoff:   workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Second),
+			}
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}}
+
+			for i, s := range cs.steps {
+				latest := &apps.Deployment{}
+				if err := reader.Get(context.TODO(), request.NamespacedName, latest); err != nil {
+					t.Fatalf("failed to get deployment: %v", err)
+				}
+				latest.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = s.annotation
+				if err := reader.Update(context.TODO(), latest); err != nil {
+					t.Fatalf("failed to update deployment: %v", err)
+				}
+				result, err := r.Reconcile(context.TODO(), request)
+				if err != nil {
+					t.Fatalf("step %d: unexpected error: %v", i, err)
+				}
+				if s.annotation == malformed && result != s.expectResult {
+					t.Fatalf("step %d: expect result %+v, got %+v", i, s.expectResult, result)
+				}
+			}
+		})
+	}
+}
+
+func TestNewControllerWithInvalidStrategy(t *testing.T) {
+	cases := []struct {
+		name         string
+		annotation   string
+		expectReason string
+	}{
+		{
+			name:       "valid strategy",
+			annotation: `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":0},"partition":"50%"}`,
+		},
+		{
+			name:       "maxUnavailable 0 with default maxSurge",
+			annotation: `{"rollingStyle":"Partition","rollingUpdate":{"maxUnavailable":0}}`,
+		},
+		{
+			name:         "maxSurge and maxUnavailable both 0",
+			annotation:   `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":0,"maxUnavailable":0}}`,
+			expectReason: InvalidRollingUpdateReason,
+		},
+		{
+			name:         "maxSurge and maxUnavailable both 0%",
+			annotation:   `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":"0%","maxUnavailable":"0%"}}`,
+			expectReason: InvalidRollingUpdateReason,
+		},
+		{
+			name:         "maxUnavailable over 100%",
+			annotation:   `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":"150%"}}`,
+			expectReason: InvalidRollingUpdateReason,
+		},
+		{
+			name:         "traffic weight over 100",
+			annotation:   `{"rollingStyle":"Partition","trafficWeight":150}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "partition over 100%",
+			annotation:   `{"rollingStyle":"Partition","partition":"150%"}`,
+			expectReason: InvalidPartitionReason,
+		},
+		{
+			name:         "rollback policy without failure threshold",
+			annotation:   `{"rollingStyle":"Partition","rollbackPolicy":{"failureThreshold":0}}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "variant weights without positive weight",
+			annotation:   `{"rollingStyle":"Partition","variantWeights":{"abc":0}}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "variant weights with test batch",
+			annotation:   `{"rollingStyle":"Partition","testBatch":true,"variantWeights":{"abc":1}}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "scheduling hints with duplicated topology",
+			annotation:   `{"rollingStyle":"Partition","schedulingHints":{"topologySpreadConstraints":[{"maxSkew":1,"topologyKey":"zone","whenUnsatisfiable":"DoNotSchedule"},{"maxSkew":2,"topologyKey":"zone","whenUnsatisfiable":"DoNotSchedule"}]}}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "non-positive max surge replicas",
+			annotation:   `{"rollingStyle":"Partition","maxSurgeReplicas":0}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "negative scale down step seconds",
+			annotation:   `{"rollingStyle":"Partition","scaleDownStepSeconds":-1}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "negative batch progress deadline seconds",
+			annotation:   `{"rollingStyle":"Partition","batchProgressDeadlineSeconds":-1}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "invalid stable service",
+			annotation:   `{"rollingStyle":"Partition","stableService":"Demo_Service"}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "invalid manual partition",
+			annotation:   `{"rollingStyle":"Partition","manualPartitions":["30%","-1"]}`,
+			expectReason: InvalidStrategyReason,
+		},
+		{
+			name:         "malformed json",
+			annotation:   `{"rollingStyle":`,
+			expectReason: InvalidStrategyAnnotationReason,
+		},
+		{
+			name:         "unknown rolling style",
+			annotation:   `{"rollingStyle":"BlueGreen"}`,
+			expectReason: InvalidStrategyReason,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+			d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+			d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = cs.annotation
+			d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			d.Spec.Paused = true
+			dc, client, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+
+			controller, err := (*controllerFactory)(dc).NewController(d)
+			if cs.expectReason != "" {
+				if err == nil || controller != nil {
+					t.Fatalf("expect error for invalid strategy, got controller %v", controller)
+				}
+				if events := collectEvents(recorder); !hasEvent(events, cs.expectReason) {
+					t.Fatalf("expect %s event, got %v", cs.expectReason, events)
+				}
+				latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get deployment: %v", err)
+				}
+				cond := deploymentutil.GetDeploymentCondition(latest.Status, deploymentutil.InvalidRolloutStrategy)
+				if cond == nil || cond.Status != v1.ConditionTrue || cond.Reason != cs.expectReason {
+					t.Fatalf("expect %s condition with reason %s, got %+v", deploymentutil.InvalidRolloutStrategy, cs.expectReason, cond)
+				}
+				return
+			}
+			if err != nil || controller == nil {
+				t.Fatalf("expect controller for valid strategy, got error %v", err)
+			}
+		})
+	}
+}
+
+func TestNewControllerWithUnsupportedSelector(t *testing.T) {
+	cases := []struct {
+		name        string
+		selector    *metav1.LabelSelector
+		expectError bool
+	}{
+		{
+			name:     "valid selector",
+			selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}},
+		},
+		{
+			name:        "nil selector",
+			expectError: true,
+		},
+		{
+			name:        "empty selector",
+			selector:    &metav1.LabelSelector{},
+			expectError: true,
+		},
+		{
+			name: "invalid selector",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn},
+			}},
+			expectError: true,
+		},
+		{
+			name:        "selector not matching template",
+			selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}},
+			expectError: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+			d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+			d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","partition":"50%"}`
+			d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			d.Spec.Paused = true
+			d.Spec.Selector = cs.selector
+			dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+
+			controller, err := (*controllerFactory)(dc).NewController(d)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if unsupported := controller == nil; unsupported != cs.expectError {
+				t.Fatalf("expect unsupported selector %v, got controller %v", cs.expectError, controller)
+			}
+			if events := collectEvents(recorder); hasEvent(events, UnsupportedSelectorReason) != cs.expectError {
+				t.Fatalf("expect %s event %v, got %v", UnsupportedSelectorReason, cs.expectError, events)
+			}
+		})
+	}
+}
+
+func TestNewControllerLeavesUnpausedDeployment(t *testing.T) {
+	for _, paused := range []bool{true, false} {
+		d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+		d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+		d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","partition":"50%"}`
+		d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+		d.Spec.Paused = paused
+		dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+
+		// The native spec.paused hands the deployment over to us, it is left to the native
+		// controller once unpaused.
+		controller, err := (*controllerFactory)(dc).NewController(d)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if processed := controller != nil; processed != paused {
+			t.Fatalf("expect deployment with spec.paused %v processed %v, got %v", paused, paused, processed)
+		}
+	}
+}
+
+func TestNewControllerSkipsCanaryStyle(t *testing.T) {
+	d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+	d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+	d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Canary"}`
+	d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+	d.Spec.Paused = true
+	dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+	dc.canaryStyles = newUIDTracker()
+	factory := (*controllerFactory)(dc)
+
+	for i, expectEvent := range []bool{true, false, false} {
+		controller, err := factory.NewController(d)
+		if err != nil || controller != nil {
+			t.Fatalf("round %d: expect nil controller for canary style, got %v, %v", i, controller, err)
+		}
+		if events := collectEvents(recorder); hasEvent(events, SkippedCanaryStyleReason) != expectEvent {
+			t.Fatalf("round %d: expect %s event %v, got %v", i, SkippedCanaryStyleReason, expectEvent, events)
+		}
+	}
+
+	// The event is emitted again once it is switched back to canary style.
+	for i, style := range []string{"Partition", "Canary"} {
+		d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = fmt.Sprintf(`{"rollingStyle":%q}`, style)
+		if _, err := factory.NewController(d); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if events := collectEvents(recorder); hasEvent(events, SkippedCanaryStyleReason) != (i == 1) {
+			t.Fatalf("%s: expect %s event %v, got %v", style, SkippedCanaryStyleReason, i == 1, events)
+		}
+	}
+}
+
+func TestDeploymentUpdatedOnResyncRequest(t *testing.T) {
+	cases := []struct {
+		name   string
+		oldVal string
+		newVal string
+		expect bool
+	}{
+		{
+			name:   "nonce unchanged",
+			oldVal: "1",
+			newVal: "1",
+			expect: false,
+		},
+		{
+			name:   "nonce set",
+			newVal: "1",
+			expect: true,
+		},
+		{
+			name:   "nonce bumped",
+			oldVal: "1",
+			newVal: "2",
+			expect: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			oldObject := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldObject.Annotations = map[string]string{util.BatchReleaseControlAnnotation: "control-info"}
+			oldObject.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			oldObject.Spec.Paused = true
+			newObject := oldObject.DeepCopy()
+			if cs.oldVal != "" {
+				oldObject.Annotations[rolloutsv1alpha1.DeploymentResyncAnnotation] = cs.oldVal
+			}
+			newObject.Annotations[rolloutsv1alpha1.DeploymentResyncAnnotation] = cs.newVal
+			if got := deploymentUpdated(event.UpdateEvent{ObjectOld: oldObject, ObjectNew: newObject}); got != cs.expect {
+				t.Fatalf("expect enqueue %v, got %v", cs.expect, got)
+			}
+		})
+	}
+}
+
+func TestValidateRequeueBackoff(t *testing.T) {
+	cases := []struct {
+		name      string
+		baseDelay time.Duration
+		maxDelay  time.Duration
+		expectErr bool
+	}{
+		{name: "unset"},
+		{name: "valid", baseDelay: time.Second, maxDelay: time.Minute},
+		{name: "equal delays", baseDelay: time.Second, maxDelay: time.Second},
+		{name: "max delay missing", baseDelay: time.Second, expectErr: true},
+		{name: "base delay missing", maxDelay: time.Minute, expectErr: true},
+		{name: "negative base delay", baseDelay: -time.Second, maxDelay: time.Minute, expectErr: true},
+		{name: "base delay beyond max delay", baseDelay: time.Minute, maxDelay: time.Second, expectErr: true},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if err := validateRequeueBackoff(cs.baseDelay, cs.maxDelay); (err != nil) != cs.expectErr {
+				t.Fatalf("expect error %v, got %v", cs.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateTemplateHashLabelKey(t *testing.T) {
+	cases := []struct {
+		name      string
+		key       string
+		expectErr bool
+	}{
+		{name: "default", key: "pod-template-hash"},
+		{name: "prefixed", key: "example.com/template-hash"},
+		{name: "empty", expectErr: true},
+		{name: "invalid characters", key: "template hash", expectErr: true},
+		{name: "invalid prefix", key: "Example_com/template-hash", expectErr: true},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if err := validateTemplateHashLabelKey(cs.key); (err != nil) != cs.expectErr {
+				t.Fatalf("expect error %v, got %v", cs.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestReconcileDeploymentRateLimiter(t *testing.T) {
+	item := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "deployment"}}
+
+	t.Run("default", func(t *testing.T) {
+		r := &ReconcileDeployment{}
+		if limiter := r.rateLimiter(); limiter != nil {
+			t.Fatalf("expect the default rate limiter of controller, got %v", limiter)
+		}
+	})
+
+	t.Run("failures back off exponentially to the max", func(t *testing.T) {
+		r := &ReconcileDeployment{failureBackoff: workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Second)}
+		limiter := r.rateLimiter()
+		for i, expect := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+			if delay := limiter.When(item); delay != expect {
+				t.Fatalf("failure %d: expect delay %v, got %v", i, expect, delay)
+			}
+		}
+		limiter.Forget(item)
+		if delay := limiter.When(item); delay != time.Second {
+			t.Fatalf("expect delay %v after forgotten, got %v", time.Second, delay)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+
+	"github.com/go-logr/logr"
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2/klogr"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+// log returns the logger of the current sync, see startSyncLogger, or a plain logger if no sync
+// is started, e.g. while settling a released deployment.
+func (dc *DeploymentController) log() logr.Logger {
+	if dc.logger == nil {
+		return klogr.New()
+	}
+	return dc.logger
+}
+
+// startSyncLogger sets the logger of this sync, which carries the namespace, name and partition
+// of d in all of its lines.
+func (dc *DeploymentController) startSyncLogger(d *apps.Deployment) {
+	dc.logger = klogr.New().WithValues("namespace", d.Namespace, "name", d.Name, "partition", dc.strategy.Partition.String())
+}
+
+// startRolloutLogger sets the correlation ID of the rollout of d in this sync, and adds it to the
+// logger of this sync, so that all the lines of a rollout can be found by it, see
+// rolloutCorrelationID for details.
+func (dc *DeploymentController) startRolloutLogger(d *apps.Deployment, rsList []*apps.ReplicaSet) {
+	dc.correlationID = rolloutCorrelationID(d, rsList)
+	dc.logger = dc.log().WithValues("correlationID", dc.correlationID)
+}
+
+// rolloutCorrelationID returns the UID of the BatchRelease controlling d, or the correlation ID
+// recorded in the extra status if d is still rolling to the same revision, otherwise a new one is
+// generated for the new rollout. The revision recorded right after the new replica set is created
+// may be empty, then the correlation ID is kept as well.
+func rolloutCorrelationID(d *apps.Deployment, rsList []*apps.ReplicaSet) string {
+	if controlInfo := d.Annotations[util.BatchReleaseControlAnnotation]; controlInfo != "" {
+		owner := &metav1.OwnerReference{}
+		if err := json.Unmarshal([]byte(controlInfo), owner); err == nil && owner.UID != "" {
+			return string(owner.UID)
+		}
+	}
+	prev := getExtraStatus(d)
+	if prev == nil || prev.CorrelationID == "" {
+		return string(uuid.NewUUID())
+	}
+	revision := ""
+	if newRS := deploymentutil.FindNewReplicaSet(d, rsList); newRS != nil {
+		revision = newRS.Labels[deploymentutil.TemplateHashLabelKey]
+	}
+	if prev.UpdateRevision == "" || prev.UpdateRevision == revision {
+		return prev.CorrelationID
+	}
+	return string(uuid.NewUUID())
+}
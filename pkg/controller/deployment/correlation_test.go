@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestRolloutCorrelationID(t *testing.T) {
+	cases := []struct {
+		name        string
+		owner       string
+		extraStatus *rolloutsv1alpha1.DeploymentExtraStatus
+		expect      string
+	}{
+		{
+			name:        "controlled by batch release",
+			owner:       "release-uid",
+			extraStatus: &rolloutsv1alpha1.DeploymentExtraStatus{UpdateRevision: "demo-v2", CorrelationID: "recorded"},
+			expect:      "release-uid",
+		},
+		{
+			name:        "same revision",
+			extraStatus: &rolloutsv1alpha1.DeploymentExtraStatus{UpdateRevision: "demo-v2", CorrelationID: "recorded"},
+			expect:      "recorded",
+		},
+		{
+			name:        "revision not recorded yet",
+			extraStatus: &rolloutsv1alpha1.DeploymentExtraStatus{CorrelationID: "recorded"},
+			expect:      "recorded",
+		},
+		{
+			name:        "new revision",
+			extraStatus: &rolloutsv1alpha1.DeploymentExtraStatus{UpdateRevision: "demo-v1", CorrelationID: "recorded"},
+		},
+		{
+			name: "not recorded",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			if cs.owner != "" {
+				owner, _ := json.Marshal(metav1.OwnerReference{Kind: "BatchRelease", Name: "release", UID: "release-uid"})
+				d.Annotations[util.BatchReleaseControlAnnotation] = string(owner)
+			}
+			if cs.extraStatus != nil {
+				extraStatus, _ := json.Marshal(cs.extraStatus)
+				d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] = string(extraStatus)
+			}
+			rsList := []*apps.ReplicaSet{newTestReplicaSet(d, "demo:v1", 1, 5), newTestReplicaSet(d, "demo:v2", 2, 5)}
+
+			got := rolloutCorrelationID(d, rsList)
+			if cs.expect != "" && got != cs.expect {
+				t.Fatalf("expect correlation ID %q, got %q", cs.expect, got)
+			}
+			if cs.expect == "" && (got == "" || got == "recorded") {
+				t.Fatalf("expect a new correlation ID, got %q", got)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentKeepsCorrelationID(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	correlationID := func(d *apps.Deployment) string {
+		extraStatus := getExtraStatus(d)
+		if extraStatus == nil || extraStatus.CorrelationID == "" {
+			t.Fatalf("expect correlation ID in extra status, got %q", d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation])
+		}
+		return extraStatus.CorrelationID
+	}
+
+	first := correlationID(syncAndSettle(t, dc, client, d.Namespace, d.Name))
+	for i := 0; i < 3; i++ {
+		if got := correlationID(syncAndSettle(t, dc, client, d.Namespace, d.Name)); got != first {
+			t.Fatalf("expect correlation ID %q kept in sync %d, got %q", first, i, got)
+		}
+	}
+
+	d, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	d.Spec.Template.Spec.Containers[0].Image = "demo:v3"
+	if _, err = client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update deployment: %v", err)
+	}
+	rotated := correlationID(syncAndSettle(t, dc, client, d.Namespace, d.Name))
+	if rotated == first {
+		t.Fatalf("expect a new correlation ID after the template change, got %q", rotated)
+	}
+	if got := correlationID(syncAndSettle(t, dc, client, d.Namespace, d.Name)); got != rotated {
+		t.Fatalf("expect correlation ID %q kept after the template change, got %q", rotated, got)
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// ControllerRefRepairedReason is added to a deployment whose new replica set was created
+	// without the controller reference to it, e.g. dropped by a mutating webhook.
+	ControllerRefRepairedReason = "ControllerRefRepaired"
+)
+
+// CleanupFinalizer is added to the deployments under rollout control with a stable service, so that
+// their canary artifacts are removed before they are gone, instead of being left to garbage collector
+// whose timing is nondeterministic. It is removed as well once a deployment is released from rollout
+// control, its stable service is unset, or the controller is disabled by feature gate, so that the
+// deployment can still be deleted without us.
+const CleanupFinalizer = "rollouts.kruise.io/advanced-deployment-cleanup"
+
+// ensureControllerRef makes sure the replica set created for d is controlled by d, otherwise it
+// would neither be listed as a replica set of d, nor be removed by garbage collector once d is
+// deleted. The missing controller reference is set back, and an error is returned if the replica
+// set is controlled by another object.
+func (dc *DeploymentController) ensureControllerRef(ctx context.Context, d *apps.Deployment, rs *apps.ReplicaSet) (*apps.ReplicaSet, error) {
+	if metav1.IsControlledBy(rs, d) {
+		return rs, nil
+	}
+	if controllerRef := metav1.GetControllerOf(rs); controllerRef != nil {
+		return nil, fmt.Errorf("replica set %s/%s created for deployment %s is controlled by %s %s", rs.Namespace, rs.Name, d.Name, controllerRef.Kind, controllerRef.Name)
+	}
+	rsCopy := rs.DeepCopy()
+	rsCopy.OwnerReferences = append(rsCopy.OwnerReferences, *metav1.NewControllerRef(d, controllerKind))
+	updated, err := dc.client.AppsV1().ReplicaSets(rsCopy.Namespace).Update(ctx, rsCopy, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, ControllerRefRepairedReason, "Set the missing controller reference of replica set %s", rs.Name)
+	return updated, nil
+}
+
+// logGarbageCollectedReplicaSets logs the replica sets of d, which is being deleted. They are
+// left to garbage collector via their controller references, instead of being scaled down or
+// deleted by us, or orphaned if d is deleted with the orphan propagation policy.
+func logGarbageCollectedReplicaSets(d *apps.Deployment, rsList []*apps.ReplicaSet) {
+	var names []string
+	for _, rs := range rsList {
+		if metav1.IsControlledBy(rs, d) {
+			names = append(names, rs.Name)
+		}
+	}
+	for _, finalizer := range d.Finalizers {
+		if finalizer == metav1.FinalizerOrphanDependents {
+			klog.Infof("Deployment %v is being deleted, replica sets %v will be orphaned", klog.KObj(d), names)
+			return
+		}
+	}
+	klog.Infof("Deployment %v is being deleted, replica sets %v will be garbage collected", klog.KObj(d), names)
+}
+
+// ensureCleanupFinalizer adds CleanupFinalizer to the deployment under rollout control if its stable
+// service is set, or removes it otherwise, and returns the updated deployment. Nothing is changed once
+// the deployment is being deleted, or while it is in dry-run mode or paused by annotation, where
+// nothing is expected to be written.
+func (dc *DeploymentController) ensureCleanupFinalizer(ctx context.Context, d *apps.Deployment) (*apps.Deployment, error) {
+	if d.DeletionTimestamp != nil || dc.dryRun || dc.pausedByAnno {
+		return d, nil
+	}
+	cleanup := dc.strategy.StableService != ""
+	if controllerutil.ContainsFinalizer(d, CleanupFinalizer) == cleanup {
+		return d, nil
+	}
+	dCopy := d.DeepCopy()
+	if cleanup {
+		controllerutil.AddFinalizer(dCopy, CleanupFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(dCopy, CleanupFinalizer)
+	}
+	return dc.client.AppsV1().Deployments(dCopy.Namespace).Update(ctx, dCopy, metav1.UpdateOptions{})
+}
+
+// needsFinalizing returns true if the canary artifacts of the deployment should be removed, i.e. it
+// carries CleanupFinalizer and is being deleted or is released from rollout control.
+func needsFinalizing(d *apps.Deployment) bool {
+	return controllerutil.ContainsFinalizer(d, CleanupFinalizer) &&
+		(d.DeletionTimestamp != nil || !deploymentutil.IsUnderRolloutControl(d))
+}
+
+// finalizeDeployment removes the canary service of the deployment and, if it is being deleted in
+// the middle of rollout, its new replica set, before removing CleanupFinalizer. The replica sets
+// are left as they are if the deployment is released from rollout control, or deleted with the
+// orphan propagation policy.
+func (dc *DeploymentController) finalizeDeployment(ctx context.Context, d *apps.Deployment) error {
+	name := canaryServiceName(d)
+	canary, err := dc.client.CoreV1().Services(d.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && metav1.IsControlledBy(canary, d) {
+		if err = dc.client.CoreV1().Services(d.Namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		klog.Infof("Deleted canary service %s of deployment %v", name, klog.KObj(d))
+	}
+
+	if d.DeletionTimestamp != nil && !controllerutil.ContainsFinalizer(d, metav1.FinalizerOrphanDependents) {
+		rsList, err := dc.getReplicaSetsForDeployment(ctx, d)
+		if err != nil {
+			return err
+		}
+		newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+		_, oldRSs := deploymentutil.FindOldReplicaSets(d, rsList)
+		if newRS != nil && metav1.IsControlledBy(newRS, d) && deploymentutil.GetReplicaCountForReplicaSets(oldRSs) > 0 {
+			if err = dc.client.AppsV1().ReplicaSets(newRS.Namespace).Delete(ctx, newRS.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			klog.Infof("Deleted canary replica set %s of deployment %v", newRS.Name, klog.KObj(d))
+		}
+	}
+
+	dCopy := d.DeepCopy()
+	controllerutil.RemoveFinalizer(dCopy, CleanupFinalizer)
+	_, err = dc.client.AppsV1().Deployments(dCopy.Namespace).Update(ctx, dCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// finalizerRemover removes CleanupFinalizer from the deployments while the advanced deployment
+// controller is disabled by feature gate, otherwise they could never be deleted. Their canary
+// artifacts are left to garbage collector.
+type finalizerRemover struct {
+	client.Client
+}
+
+var _ reconcile.Reconciler = &finalizerRemover{}
+
+func (r *finalizerRemover) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	d := new(apps.Deployment)
+	if err := r.Get(ctx, request.NamespacedName, d); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if !controllerutil.ContainsFinalizer(d, CleanupFinalizer) {
+		return reconcile.Result{}, nil
+	}
+	controllerutil.RemoveFinalizer(d, CleanupFinalizer)
+	if err := r.Update(ctx, d); err != nil {
+		return reconcile.Result{}, err
+	}
+	klog.Infof("Removed finalizer %s of deployment %v since advanced deployment controller is disabled", CleanupFinalizer, klog.KObj(d))
+	return reconcile.Result{}, nil
+}
+
+// addFinalizerRemover adds the controller removing CleanupFinalizer to mgr.
+func addFinalizerRemover(mgr manager.Manager) error {
+	c, err := controller.New("advanced-deployment-finalizer-remover", mgr, controller.Options{Reconciler: &finalizerRemover{Client: mgr.GetClient()}})
+	if err != nil {
+		return err
+	}
+	return c.Watch(&source.Kind{Type: &apps.Deployment{}}, &handler.EnqueueRequestForObject{}, predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return controllerutil.ContainsFinalizer(obj, CleanupFinalizer)
+	}))
+}
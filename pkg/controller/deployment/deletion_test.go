@@ -0,0 +1,281 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestSyncDeletingDeploymentInRolling(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromInt(3),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expectReplicas := map[string]int32{"demo:v1": 7, "demo:v2": 3}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+		t.Fatalf("expect replicas %v at partition 3, got %v", expectReplicas, replicas)
+	}
+
+	// The deployment is deleted at partition 3, and even raising the partition changes nothing.
+	deletionTime := metav1.Now()
+	d.DeletionTimestamp = &deletionTime
+	if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update deployment: %v", err)
+	}
+	dc.strategy.Partition = intstr.FromString("100%")
+	client.ClearActions()
+	for i := 0; i < 3; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource != "replicasets" {
+			continue
+		}
+		if verb := action.GetVerb(); verb == "create" || verb == "delete" || (verb == "update" && action.GetSubresource() == "") {
+			t.Fatalf("expect no replica set changed while deleting, got %s %s", verb, action.GetSubresource())
+		}
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+		t.Fatalf("expect replicas %v kept while deleting, got %v", expectReplicas, replicas)
+	}
+
+	// All replica sets are controlled by the deployment, so that none of them leaks once it is
+	// garbage collected.
+	rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	for i := range rsList.Items {
+		if rs := &rsList.Items[i]; !metav1.IsControlledBy(rs, d) {
+			t.Fatalf("expect replica set %s controlled by deployment, got owners %v", rs.Name, rs.OwnerReferences)
+		}
+	}
+}
+
+func TestSyncDeploymentRepairsControllerRef(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromInt(3),
+	}
+	dc, client, recorder := newTestController(strategy, d, oldRS)
+	// The owner references of new replica set are dropped on creation, e.g. by a webhook.
+	client.PrependReactor("create", "replicasets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		rs := action.(clienttesting.CreateAction).GetObject().(*apps.ReplicaSet)
+		rs.OwnerReferences = nil
+		return false, nil, nil
+	})
+
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	if len(rsList.Items) != 2 {
+		t.Fatalf("expect 2 replica sets, got %d", len(rsList.Items))
+	}
+	for i := range rsList.Items {
+		if rs := &rsList.Items[i]; !metav1.IsControlledBy(rs, d) {
+			t.Fatalf("expect replica set %s controlled by deployment, got owners %v", rs.Name, rs.OwnerReferences)
+		}
+	}
+	if d.Status.CollisionCount != nil {
+		t.Fatalf("expect no hash collision, got %d", *d.Status.CollisionCount)
+	}
+	expectReplicas := map[string]int32{"demo:v1": 7, "demo:v2": 3}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+		t.Fatalf("expect replicas %v, got %v", expectReplicas, replicas)
+	}
+	if !hasEvent(collectEvents(recorder), ControllerRefRepairedReason) {
+		t.Fatalf("expect %s event", ControllerRefRepairedReason)
+	}
+}
+
+func TestReconcileCleanupFinalizer(t *testing.T) {
+	cases := []struct {
+		name            string
+		noStable        bool
+		finalizer       bool
+		deleting        bool
+		released        bool
+		deselected      bool
+		expectFinalizer bool
+		expectService   bool
+		expectImages    []string
+	}{
+		{
+			name:            "added to managed deployment",
+			expectFinalizer: true,
+			expectService:   true,
+			expectImages:    []string{"demo:v1", "demo:v2"},
+		},
+		{
+			name:          "not added without stable service",
+			noStable:      true,
+			expectService: true,
+			expectImages:  []string{"demo:v1", "demo:v2"},
+		},
+		{
+			name:          "removed once stable service is unset",
+			noStable:      true,
+			finalizer:     true,
+			expectService: true,
+			expectImages:  []string{"demo:v1", "demo:v2"},
+		},
+		{
+			name:          "deleted with finalizer in namespace not selected",
+			finalizer:     true,
+			deleting:      true,
+			deselected:    true,
+			expectService: false,
+			expectImages:  []string{"demo:v1"},
+		},
+		{
+			name:          "deleted with finalizer",
+			finalizer:     true,
+			deleting:      true,
+			expectService: false,
+			expectImages:  []string{"demo:v1"},
+		},
+		{
+			name:          "deleted without finalizer",
+			deleting:      true,
+			expectService: true,
+			expectImages:  []string{"demo:v1", "demo:v2"},
+		},
+		{
+			name:          "released with finalizer",
+			finalizer:     true,
+			released:      true,
+			expectService: false,
+			expectImages:  []string{"demo:v1", "demo:v2"},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+			d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":0},"partition":3,"stableService":"demo"}`
+			if cs.noStable {
+				d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":0},"partition":3}`
+			}
+			d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			d.Spec.Paused = true
+			if cs.finalizer {
+				d.Finalizers = []string{CleanupFinalizer}
+			}
+			if cs.deleting {
+				deletionTime := metav1.Now()
+				d.DeletionTimestamp = &deletionTime
+			}
+			if cs.released {
+				delete(d.Annotations, util.BatchReleaseControlAnnotation)
+			}
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			canary := &v1.Service{ObjectMeta: metav1.ObjectMeta{
+				Namespace:       d.Namespace,
+				Name:            canaryServiceName(d),
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, controllerKind)},
+			}}
+			dc, client, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS, newRS, canary)
+			defer forgetDeploymentMetrics(d.Namespace, d.Name)
+			reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(d.DeepCopy()).Build()
+			r := &ReconcileDeployment{Client: reader, controllerFactory: (*controllerFactory)(dc)}
+			if cs.deselected {
+				r.namespaceSelector, _ = parseNamespaceSelector("rollouts.kruise.io/managed=true")
+			}
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}}
+			if _, err := r.Reconcile(context.TODO(), request); err != nil {
+				t.Fatalf("failed to reconcile: %v", err)
+			}
+
+			latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			if finalizer := controllerutil.ContainsFinalizer(latest, CleanupFinalizer); finalizer != cs.expectFinalizer {
+				t.Fatalf("expect finalizer %v, got finalizers %v", cs.expectFinalizer, latest.Finalizers)
+			}
+			_, err = client.CoreV1().Services(d.Namespace).Get(context.TODO(), canary.Name, metav1.GetOptions{})
+			if found := err == nil; found != cs.expectService {
+				t.Fatalf("expect canary service found %v, got %v", cs.expectService, err)
+			}
+			var images []string
+			for image := range getReplicaSetReplicas(t, client, d.Namespace) {
+				images = append(images, image)
+			}
+			sort.Strings(images)
+			if !reflect.DeepEqual(images, cs.expectImages) {
+				t.Fatalf("expect replica sets of %v, got %v", cs.expectImages, images)
+			}
+		})
+	}
+}
+
+func TestFinalizerRemover(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	d.Finalizers = []string{CleanupFinalizer, metav1.FinalizerOrphanDependents}
+	reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(d).Build()
+	r := &finalizerRemover{Client: reader}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}}
+	if _, err := r.Reconcile(context.TODO(), request); err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+
+	latest := &apps.Deployment{}
+	if err := reader.Get(context.TODO(), request.NamespacedName, latest); err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	if !reflect.DeepEqual(latest.Finalizers, []string{metav1.FinalizerOrphanDependents}) {
+		t.Fatalf("expect only %s removed, got finalizers %v", CleanupFinalizer, latest.Finalizers)
+	}
+	// The deployment already gone is ignored.
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: "missing"}}); err != nil {
+		t.Fatalf("expect missing deployment ignored, got %v", err)
+	}
+}
@@ -25,18 +25,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"strings"
 	"time"
 
+	"github.com/go-logr/logr"
 	apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	clientset "k8s.io/client-go/kubernetes"
 	appslisters "k8s.io/client-go/listers/apps/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/integer"
 
 	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
 	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
@@ -54,6 +58,21 @@ const (
 // controllerKind contains the schema.GroupVersionKind for this controller type.
 var controllerKind = apps.SchemeGroupVersion.WithKind("Deployment")
 
+// rsOwnerIndex is the name of the index of replica sets by the UID of their controller.
+const rsOwnerIndex = "ownerUID"
+
+// indexReplicaSetByOwner indexes replica sets by the UID of their controller.
+func indexReplicaSetByOwner(obj interface{}) ([]string, error) {
+	rs, ok := obj.(*apps.ReplicaSet)
+	if !ok {
+		return nil, nil
+	}
+	if ref := metav1.GetControllerOf(rs); ref != nil {
+		return []string{string(ref.UID)}, nil
+	}
+	return nil, nil
+}
+
 // DeploymentController is responsible for synchronizing Deployment objects stored
 // in the system with actual running replica sets and pods.
 type DeploymentController struct {
@@ -66,38 +85,221 @@ type DeploymentController struct {
 	dLister appslisters.DeploymentLister
 	// rsLister can list/get replica sets from the shared informer's store
 	rsLister appslisters.ReplicaSetLister
-	// podLister can list/get pods from the shared informer's store
+	// rsIndexer can list replica sets by the UID of their controller from the shared informer's
+	// store, nil means the replica sets are listed by the selector of deployment.
+	rsIndexer cache.Indexer
+	// podLister can list/get pods from the shared informer's store, nil means pods are not
+	// cached in the podless mode, see AdvancedDeploymentPodlessModeGate.
 	podLister corelisters.PodLister
+	// nsLister can list/get namespaces from the shared informer's store
+	nsLister corelisters.NamespaceLister
+	// nodeLister can list/get nodes from the shared informer's store
+	nodeLister corelisters.NodeLister
+	// secretLister can list/get secrets from the shared informer's store, nil means the image
+	// pull secrets are not checked.
+	secretLister corelisters.SecretLister
+	// approvalIndexer can list rollout approvals from the shared informer's store
+	approvalIndexer cache.Indexer
+	// batchChecks are the custom checks which can be referred to by name in the strategy
+	batchChecks map[string]BatchCheck
+
+	// dListerSynced returns true if the Deployment store has been synced at least once.
+	dListerSynced cache.InformerSynced
+	// rsListerSynced returns true if the ReplicaSet store has been synced at least once.
+	rsListerSynced cache.InformerSynced
+	// podListerSynced returns true if the pod store has been synced at least once.
+	podListerSynced cache.InformerSynced
+
+	// clock is used for all time-dependent logic, so that it can be faked in tests.
+	clock clock.Clock
+
+	// auditor writes the scaling decisions to the audit log, nil means disabled.
+	auditor *auditLogger
+
+	// milestones keeps the milestones of rollouts for longer than events, nil means disabled.
+	milestones *milestoneRecorder
+
+	// readinessSamples keeps the last readiness of new pods of each deployment, nil means the
+	// pods are inspected on every sync.
+	readinessSamples *readinessSampler
+
+	// activeRollouts tracks the rollouts admitted in each namespace, nil means they are only
+	// counted from the informer's store.
+	activeRollouts *activeRolloutTracker
+
+	// terminatingNS remembers the namespaces found terminating, nil means they are
+	// logged on every sync.
+	terminatingNS *terminatingNamespaceTracker
+
+	// strategyRefs caches the ConfigMaps referred to by the strategy ref annotation, nil means
+	// they are read on every sync.
+	strategyRefs *strategyRefCache
+	// canaryStyles tracks the deployments skipped because of their canary rolling style, nil
+	// means the event is emitted on every reconcile.
+	canaryStyles *uidTracker
+	// ignoredRefs tracks the deployments whose strategy ref is ignored because of the inline
+	// strategy, nil means the event is emitted on every reconcile.
+	ignoredRefs *uidTracker
+	// pausedByAnnos tracks the deployments paused by annotation, nil means the event is
+	// emitted on every requeue.
+	pausedByAnnos *uidTracker
+	// stuckDrains tracks the old replica sets with pods stuck terminating, nil means the event
+	// is emitted on every sync.
+	stuckDrains *uidTracker
+	// reservedLabels are the label keys never overwritten on new replica sets, nil means none.
+	reservedLabels sets.String
 
 	// we will use this strategy to replace spec.strategy of deployment
 	strategy rolloutsv1alpha1.DeploymentStrategy
+
+	// pausedByAnno is true if the deployment is paused by annotation, see isPausedByAnnotation.
+	pausedByAnno bool
+	// dryRun is true if the deployment is in dry-run mode, see isDryRun.
+	dryRun bool
+	// queued is true if the rollout is queued in this sync, see queueRollout.
+	queued bool
+	// waitingApproval is true if the rollout is held at a manual partition in this sync, see holdForApproval.
+	waitingApproval bool
+	// approvedPartition and approvedRevision are the manual partition approved and the revision it
+	// is approved for, see holdForApproval.
+	approvedPartition string
+	approvedRevision  string
+
+	// verifiedRevision is the revision verified in this sync, see syncPostRolloutJob.
+	verifiedRevision string
+
+	// pendingRevision and pendingSince are the template change held in this sync, see holdTemplateChange.
+	pendingRevision string
+	pendingSince    *metav1.Time
+
+	// dryRunScales are the scaling of replica sets skipped in dry-run mode in this sync, see recordDryRunScale.
+	dryRunScales []rolloutsv1alpha1.DeploymentReplicaSetScale
+
+	// batchDeadlineExceeded is true if the rollout is frozen in this sync, see checkBatchProgressDeadline.
+	batchDeadlineExceeded bool
+
+	// scaleDownStepCount is the replicas of old replica sets scaled down by the step in this sync, see scaleDownStepLimit.
+	scaleDownStepCount int32
+
+	// requeueAfter is the duration after which the deployment should be synced again,
+	// 0 means no requeue is required.
+	requeueAfter time.Duration
+
+	// logger is the logger of this sync, nil means no sync is started, see startSyncLogger.
+	logger logr.Logger
+	// correlationID identifies the rollout in this sync, see startRolloutLogger.
+	correlationID string
 }
 
-// getReplicaSetsForDeployment uses ControllerRefManager to reconcile
-// ControllerRef by adopting and orphaning.
-// It returns the list of ReplicaSets that this Deployment should manage.
+// enqueueAfter requires the deployment to be synced again after the given duration.
+// If it is called more than once during a sync, the shortest duration wins.
+func (dc *DeploymentController) enqueueAfter(d *apps.Deployment, after time.Duration) {
+	if after <= 0 {
+		after = time.Millisecond
+	}
+	if dc.requeueAfter == 0 || after < dc.requeueAfter {
+		dc.log().V(4).Info("Queueing up deployment", "after", after)
+		dc.requeueAfter = after
+	}
+}
+
+// getReplicaSetsForDeployment returns the list of ReplicaSets that this Deployment should manage.
+// The selector of deployment may match replica sets of other owners if they share the labels,
+// so only those whose ControllerRef points to this Deployment are returned.
 func (dc *DeploymentController) getReplicaSetsForDeployment(ctx context.Context, d *apps.Deployment) ([]*apps.ReplicaSet, error) {
-	deploymentSelector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
 	if err != nil {
 		return nil, fmt.Errorf("deployment %s/%s has invalid label selector: %v", d.Namespace, d.Name, err)
 	}
-	// List all ReplicaSets to find those we own but that no longer match our
-	// selector. They will be orphaned by ClaimReplicaSets().
-	return dc.rsLister.ReplicaSets(d.Namespace).List(deploymentSelector)
+	if dc.rsIndexer != nil {
+		return dc.listReplicaSetsByOwner(d, selector)
+	}
+	return deploymentutil.ListReplicaSets(d, dc.listReplicaSets)
+}
+
+// listReplicaSetsByOwner lists the replica sets controlled by the deployment and matching its
+// selector via the owner index, instead of scanning all replica sets matching the selector,
+// which may be costly in a namespace with lots of replica sets.
+func (dc *DeploymentController) listReplicaSetsByOwner(d *apps.Deployment, selector labels.Selector) ([]*apps.ReplicaSet, error) {
+	objects, err := dc.rsIndexer.ByIndex(rsOwnerIndex, string(d.UID))
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]*apps.ReplicaSet, 0, len(objects))
+	for _, object := range objects {
+		rs, ok := object.(*apps.ReplicaSet)
+		if ok && rs.Namespace == d.Namespace && metav1.IsControlledBy(rs, d) && selector.Matches(labels.Set(rs.Labels)) {
+			owned = append(owned, rs)
+		}
+	}
+	return owned, nil
+}
+
+// listReplicaSets lists replica sets from the shared informer's store.
+func (dc *DeploymentController) listReplicaSets(namespace string, options metav1.ListOptions) ([]*apps.ReplicaSet, error) {
+	selector, err := labels.Parse(options.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	return dc.rsLister.ReplicaSets(namespace).List(selector)
+}
+
+// cachedPods lists pods matching selector from the shared informer's store, it finds no pods
+// in the podless mode.
+func (dc *DeploymentController) cachedPods(namespace string, selector labels.Selector) ([]*v1.Pod, error) {
+	if dc.podLister == nil {
+		return nil, nil
+	}
+	return dc.podLister.Pods(namespace).List(selector)
+}
+
+// listPods lists pods from the shared informer's store.
+func (dc *DeploymentController) listPods(namespace string, options metav1.ListOptions) (*v1.PodList, error) {
+	selector, err := labels.Parse(options.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := dc.cachedPods(namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	podList := &v1.PodList{Items: make([]v1.Pod, 0, len(pods))}
+	for _, pod := range pods {
+		podList.Items = append(podList.Items, *pod)
+	}
+	return podList, nil
 }
 
 // syncDeployment will sync the deployment with the given key.
 // This function is not meant to be invoked concurrently with the same key.
 func (dc *DeploymentController) syncDeployment(ctx context.Context, deployment *apps.Deployment) (err error) {
-	startTime := time.Now()
-	klog.V(4).InfoS("Started syncing deployment", "deployment", klog.KObj(deployment), "startTime", startTime)
+	startTime := dc.clock.Now()
+	dc.startSyncLogger(deployment)
+	dc.log().V(4).Info("Started syncing deployment", "startTime", startTime)
 	defer func() {
-		klog.V(4).InfoS("Finished syncing deployment", "deployment", klog.KObj(deployment), "duration", time.Since(startTime))
+		dc.log().V(4).Info("Finished syncing deployment", "duration", dc.clock.Since(startTime))
 	}()
 
+	// Stop before any call if the sync is cancelled, e.g. the manager is shutting down.
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	// Nothing can be written in a terminating namespace, so stop syncing instead of failing.
+	if dc.isNamespaceTerminating(deployment.Namespace) {
+		return
+	}
+
+	// Nothing is touched while the deployment is paused by annotation, not even its status.
+	newlyPaused := dc.pausedByAnnos.observe(types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}, deployment.UID, dc.pausedByAnno)
+	if dc.pausedByAnno {
+		dc.holdPausedDeployment(deployment, newlyPaused)
+		return
+	}
+
 	// Deep-copy otherwise we are mutating our cache.
 	// TODO: Deep-copy only when needed.
-	d := deployment.DeepCopy()
+	d := dc.withStrategy(deployment)
 
 	everything := metav1.LabelSelector{}
 	if reflect.DeepEqual(d.Spec.Selector, &everything) {
@@ -115,23 +317,99 @@ func (dc *DeploymentController) syncDeployment(ctx context.Context, deployment *
 	if err != nil {
 		return
 	}
+	dc.startRolloutLogger(d, rsList)
+
+	// Do not make any decision based on stale caches, wait for them to catch up.
+	stale, err := dc.isCacheStale(ctx, d, rsList)
+	if err != nil || stale {
+		return
+	}
+
+	// Do not touch anything if some replica sets may be claimed by another deployment whose
+	// selector overlaps.
+	ambiguous, err := dc.checkSelectorOverlap(d)
+	if err != nil || ambiguous {
+		return
+	}
+
+	// Adopt the orphaned replica set of the same template instead of creating a duplicated one.
+	if rsList, err = dc.adoptReplicaSets(ctx, d, rsList); err != nil {
+		return
+	}
 
+	// Do not count the pods just ready as available until minReadySeconds of the deployment.
+	if rsList, err = dc.capAvailableReplicas(d, rsList); err != nil {
+		return
+	}
+
+	// Nothing is created, scaled or deleted once the deployment is being deleted, its replica
+	// sets are left to garbage collector, apart from those removed by finalizeDeployment.
 	if d.DeletionTimestamp != nil {
+		logGarbageCollectedReplicaSets(d, rsList)
 		return dc.syncStatusOnly(ctx, d, rsList)
 	}
 
+	// Upgrade the extra status written by the controllers of older versions first, so that the
+	// rollout in progress continues as if the extra status was written by us.
+	migrated, err := dc.migrateExtraStatus(ctx, deployment, rsList)
+	if err != nil {
+		return
+	}
+	if migrated != nil {
+		deployment = migrated
+		d.Annotations = migrated.Annotations
+	}
+
 	defer func() {
-		err = dc.updateExtraStatus(deployment, rsList)
+		err = dc.updateExtraStatus(ctx, deployment, rsList)
 	}()
 
+	// The native spec.paused of deployment under our control is always true, it is left to the
+	// native controller once turned to false, so we use the paused field of our strategy and the
+	// freeze of namespace here. The paused annotation has been handled before, which wins over them.
+	paused := dc.isPaused(d)
+
 	// Update deployment conditions with an Unknown condition when pausing/resuming
 	// a deployment. In this way, we can be sure that we won't timeout when a user
 	// resumes a Deployment with a set progressDeadlineSeconds.
-	if err = dc.checkPausedConditions(ctx, d); err != nil {
+	if err = dc.checkPausedConditions(ctx, d, paused); err != nil {
+		return
+	}
+
+	// Costs must be set before scaling, since the pods are deleted once replica sets are scaled down.
+	if err = dc.syncPodDeletionCost(ctx, d, rsList); err != nil {
 		return
 	}
 
-	if d.Spec.Paused {
+	if paused {
+		err = dc.sync(ctx, d, rsList)
+		return
+	}
+
+	// Fail fast before rolling to a template whose image pull secrets are missing, if configured.
+	if dc.checkImagePullSecrets(d, rsList) {
+		err = dc.sync(ctx, d, rsList)
+		return
+	}
+
+	// The template changed within the window is only scaled, like a paused one, until it settles.
+	if dc.holdTemplateChange(d, rsList) {
+		err = dc.sync(ctx, d, rsList)
+		return
+	}
+
+	// The rollout beyond the max active rollouts of namespace is only scaled, like a paused one.
+	if dc.queued = dc.queueRollout(d, rsList); dc.queued {
+		err = dc.sync(ctx, d, rsList)
+		return
+	}
+
+	// The rollout at a manual partition is only scaled, like a paused one, until it is approved.
+	held, err := dc.holdForApproval(ctx, d, rsList)
+	if err != nil {
+		return
+	}
+	if held {
 		err = dc.sync(ctx, d, rsList)
 		return
 	}
@@ -142,45 +420,146 @@ func (dc *DeploymentController) syncDeployment(ctx context.Context, deployment *
 	}
 
 	if scalingEvent {
-		err = dc.sync(ctx, d, rsList)
-		return
+		// If the template has been changed in the same update, there is no new replica set yet,
+		// we should roll to the new template against the new replicas directly, rather than
+		// scaling the old replica sets proportionally first and then rolling.
+		if deploymentutil.FindNewReplicaSet(d, rsList) != nil {
+			err = dc.sync(ctx, d, rsList)
+			return
+		}
+		if err = dc.syncReplicasAnnotations(ctx, d, rsList); err != nil {
+			return
+		}
 	}
 
+	if len(dc.strategy.VariantWeights) > 0 {
+		err = dc.rolloutVariants(ctx, d, rsList)
+		return
+	}
 	err = dc.rolloutRolling(ctx, d, rsList)
 	return
 }
 
-// updateExtraStatus will update extra status for advancedStatus
-func (dc *DeploymentController) updateExtraStatus(deployment *apps.Deployment, rsList []*apps.ReplicaSet) error {
-	newRS, _, err := dc.getAllReplicaSetsAndSyncRevision(context.TODO(), deployment, rsList, false)
-	if err != nil {
+// withStrategy returns a deep copy of deployment, whose strategy is replaced by ours. The native
+// strategy of deployment under our control is always Recreate, so we replace it with the rolling
+// update fields of our strategy.
+func (dc *DeploymentController) withStrategy(deployment *apps.Deployment) *apps.Deployment {
+	d := deployment.DeepCopy()
+	d.Spec.Strategy = apps.DeploymentStrategy{
+		Type:          apps.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: dc.strategy.RollingUpdate.DeepCopy(),
+	}
+	dc.requireSurge(d)
+	dc.capSurge(d)
+	return d
+}
+
+// updateExtraStatus will update extra status for advancedStatus, unless ctx is cancelled in the
+// middle of syncing, since the status computed from a partial sync would be misleading.
+func (dc *DeploymentController) updateExtraStatus(ctx context.Context, deployment *apps.Deployment, rsList []*apps.ReplicaSet) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	// rsList may be stale after syncing, so just look it up instead of syncing the revision,
+	// otherwise the new replica set may be updated with its stale replicas.
+	newRS := deploymentutil.FindNewReplicaSet(deployment, rsList)
+	expectedUpdatedReplicas := dc.newRSReplicasLimit(deployment)
+	if _, oldRSs := deploymentutil.FindOldReplicaSets(deployment, rsList); dc.isNewRSCompleted(dc.withStrategy(deployment), newRS, oldRSs) {
+		expectedUpdatedReplicas = *(deployment.Spec.Replicas)
+	}
+	if shares := variantReplicas(*(deployment.Spec.Replicas), dc.strategy.VariantWeights, rsList); newRS != nil && shares != nil {
+		expectedUpdatedReplicas = shares[newRS.Name]
+	}
+	if dc.queued {
+		expectedUpdatedReplicas = 0
+		if newRS != nil {
+			expectedUpdatedReplicas = *(newRS.Spec.Replicas)
+		}
+	}
 
 	updatedReadyReplicas := int32(0)
+	updateRevision, generation := "", ""
 	if newRS != nil {
 		updatedReadyReplicas = newRS.Status.ReadyReplicas
+		updateRevision = newRS.Labels[deploymentutil.TemplateHashLabelKey]
+		generation = newRS.Labels[rolloutsv1alpha1.RolloutGenerationLabel]
 	}
 
 	extraStatus := &rolloutsv1alpha1.DeploymentExtraStatus{
+		SchemaVersion:           rolloutsv1alpha1.DeploymentExtraStatusSchemaVersion,
 		ObservedGeneration:      deployment.Generation,
 		UpdatedReadyReplicas:    updatedReadyReplicas,
-		ExpectedUpdatedReplicas: deploymentutil.NewRSReplicasLimit(dc.strategy.Partition, deployment),
+		ExpectedUpdatedReplicas: expectedUpdatedReplicas,
+		BatchReadiness:          batchReadiness(updatedReadyReplicas, expectedUpdatedReplicas),
+		TrafficWeight:           dc.trafficWeight(deployment, updatedReadyReplicas),
+		UpdateRevision:          updateRevision,
+		CorrelationID:           dc.correlationID,
+		Queued:                  dc.queued,
+		OldReplicaSets:          oldReplicaSetSizes(newRS, rsList),
 	}
+	prevExtraStatus := getExtraStatus(deployment)
+	if extraStatus.CorrelationID == "" && prevExtraStatus != nil {
+		extraStatus.CorrelationID = prevExtraStatus.CorrelationID
+	}
+	templateDiff := ""
+	if newRS != nil && (prevExtraStatus == nil || prevExtraStatus.UpdateRevision != updateRevision) {
+		templateDiff = dc.recordTemplateDiff(deployment, newRS, rsList)
+	}
+	dc.syncProgressTimes(prevExtraStatus, extraStatus)
+	syncProgressHistory(prevExtraStatus, extraStatus, templateDiff)
+	dc.syncVerifiedRevision(prevExtraStatus, extraStatus)
+	dc.syncApprovedPartition(deployment, prevExtraStatus, extraStatus)
+	dc.syncBatchSoak(deployment, newRS, extraStatus)
+	dc.syncBatchPause(deployment, newRS, prevExtraStatus, extraStatus)
+	dc.syncBatchChecks(ctx, deployment, newRS, extraStatus)
+	dc.syncPausedReplicas(deployment, newRS, prevExtraStatus, extraStatus)
+	dc.syncReadinessRegression(deployment, prevExtraStatus, extraStatus)
+	dc.syncPendingRevision(extraStatus)
+	dc.syncScaleDownStep(prevExtraStatus, extraStatus)
+	dc.syncDryRunScales(deployment, extraStatus)
+	dc.recordMilestones(ctx, deployment, generation, prevExtraStatus, extraStatus)
+	dc.checkProgressSLA(deployment, extraStatus)
+	recordRolloutMetrics(deployment, dc.partitionReplicasLimit(dc.strategy.Partition, deployment), extraStatus, dc.clock.Now())
 
-	extraStatusByte, err := json.Marshal(extraStatus)
+	extraStatusByte, err := marshalExtraStatus(extraStatus, extraStatusMaxSize)
 	if err != nil {
-		klog.Errorf("Failed to marshal extra status for Deployment %v, err: %v", klog.KObj(deployment), err)
+		dc.log().Error(err, "Failed to marshal extra status")
 		return nil // no need to retry
 	}
 
-	extraStatusAnno := string(extraStatusByte)
-	if deployment.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] == extraStatusAnno {
+	annotations := map[string]string{}
+	if extraStatusAnno := string(extraStatusByte); deployment.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] != extraStatusAnno {
+		annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] = extraStatusAnno
+	}
+	if strategyStatusAnno := dc.strategyStatus(deployment); deployment.Annotations[rolloutsv1alpha1.DeploymentStrategyStatusAnnotation] != strategyStatusAnno {
+		annotations[rolloutsv1alpha1.DeploymentStrategyStatusAnnotation] = strategyStatusAnno
+	}
+	if len(annotations) == 0 {
 		return nil // no need to update
 	}
 
-	extraStatusAnno = strings.Replace(extraStatusAnno, `"`, `\"`, -1)
-	body := []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%s"}}}`, rolloutsv1alpha1.DeploymentExtraStatusAnnotation, extraStatusAnno))
-	_, err = dc.client.AppsV1().Deployments(deployment.Namespace).Patch(context.TODO(), deployment.Name, types.MergePatchType, body, metav1.PatchOptions{})
+	// The history may contain any characters, so let json escape the annotation.
+	body, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dc.client.AppsV1().Deployments(deployment.Namespace).Patch(ctx, deployment.Name, types.MergePatchType, body, metav1.PatchOptions{})
 	return err
 }
+
+// trafficWeight returns the percentage of traffic expected to be routed to the updated pods,
+// which is overridden by our strategy, or the ratio of updated ready replicas to replicas.
+func (dc *DeploymentController) trafficWeight(deployment *apps.Deployment, updatedReadyReplicas int32) int32 {
+	if dc.strategy.TrafficWeight != nil {
+		return *dc.strategy.TrafficWeight
+	}
+	replicas := *(deployment.Spec.Replicas)
+	if replicas <= 0 {
+		return 0
+	}
+	return integer.Int32Min(updatedReadyReplicas*100/replicas, 100)
+}
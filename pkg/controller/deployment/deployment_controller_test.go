@@ -0,0 +1,971 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func newTestDeployment(replicas int32, maxSurge, maxUnavailable intstr.IntOrString) *apps.Deployment {
+	return &apps.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "deployment",
+			Namespace:   "default",
+			UID:         types.UID("deployment-uid"),
+			Generation:  1,
+			Annotations: map[string]string{},
+		},
+		Spec: apps.DeploymentSpec{
+			Replicas: pointer.Int32(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}},
+			Strategy: apps.DeploymentStrategy{
+				Type: apps.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &apps.RollingUpdateDeployment{
+					MaxSurge:       &maxSurge,
+					MaxUnavailable: &maxUnavailable,
+				},
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "demo"}},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "main", Image: "demo:v2"}},
+				},
+			},
+		},
+	}
+}
+
+// newTestReplicaSet creates a replica set owned by d, with the given image, revision and
+// replicas; all of its replicas are available.
+func newTestReplicaSet(d *apps.Deployment, image string, revision int64, replicas int32) *apps.ReplicaSet {
+	template := d.Spec.Template.DeepCopy()
+	template.Spec.Containers[0].Image = image
+	hash := strings.Replace(image, ":", "-", -1)
+	template.Labels = map[string]string{"app": "demo", deploymentutil.TemplateHashLabelKey: hash}
+	return &apps.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              fmt.Sprintf("%s-%s", d.Name, hash),
+			Namespace:         d.Namespace,
+			UID:               types.UID(fmt.Sprintf("%s-%s-uid", d.Name, hash)),
+			Labels:            template.Labels,
+			CreationTimestamp: metav1.Unix(revision*100, 0),
+			Annotations: map[string]string{
+				deploymentutil.RevisionAnnotation:        fmt.Sprintf("%d", revision),
+				deploymentutil.DesiredReplicasAnnotation: fmt.Sprintf("%d", *d.Spec.Replicas),
+				deploymentutil.MaxReplicasAnnotation:     fmt.Sprintf("%d", *d.Spec.Replicas+deploymentutil.MaxSurge(*d)),
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, controllerKind)},
+		},
+		Spec: apps.ReplicaSetSpec{
+			Replicas: pointer.Int32(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: template.Labels},
+			Template: *template,
+		},
+		Status: apps.ReplicaSetStatus{
+			Replicas:          replicas,
+			ReadyReplicas:     replicas,
+			AvailableReplicas: replicas,
+		},
+	}
+}
+
+// newTestPod creates a running and ready pod controlled by rs.
+func newTestPod(rs *apps.ReplicaSet, name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       rs.Namespace,
+			UID:             types.UID(name + "-uid"),
+			Labels:          rs.Spec.Template.Labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(rs, apps.SchemeGroupVersion.WithKind("ReplicaSet"))},
+		},
+		Spec: rs.Spec.Template.Spec,
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			Conditions: []v1.PodCondition{{
+				Type:   v1.PodReady,
+				Status: v1.ConditionTrue,
+			}},
+		},
+	}
+}
+
+// newTestController returns a DeploymentController whose listers are populated with objects,
+// whose client is a fake clientset tracking the same objects, and whose clock is a fake clock
+// starting from now.
+func newTestController(strategy rolloutsv1alpha1.DeploymentStrategy, objects ...runtime.Object) (*DeploymentController, *fake.Clientset, *record.FakeRecorder) {
+	indexers := toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc}
+	dIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, indexers)
+	rsIndexer := newTestReplicaSetIndexer()
+	podIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, indexers)
+	nsIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{})
+	nodeIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{})
+	for _, object := range objects {
+		switch o := object.(type) {
+		case *apps.Deployment:
+			_ = dIndexer.Add(o)
+		case *apps.ReplicaSet:
+			_ = rsIndexer.Add(o)
+		case *v1.Pod:
+			_ = podIndexer.Add(o)
+		case *v1.Namespace:
+			_ = nsIndexer.Add(o)
+		case *v1.Node:
+			_ = nodeIndexer.Add(o)
+		}
+	}
+
+	client := fake.NewSimpleClientset(objects...)
+	recorder := record.NewFakeRecorder(100)
+	dc := &DeploymentController{
+		client:        client,
+		eventRecorder: recorder,
+		dLister:       appslisters.NewDeploymentLister(dIndexer),
+		rsLister:      appslisters.NewReplicaSetLister(rsIndexer),
+		rsIndexer:     rsIndexer,
+		podLister:     corelisters.NewPodLister(podIndexer),
+		nsLister:      corelisters.NewNamespaceLister(nsIndexer),
+		nodeLister:    corelisters.NewNodeLister(nodeIndexer),
+		strategy:      strategy,
+		clock:         testingclock.NewFakeClock(time.Now()),
+	}
+	return dc, client, recorder
+}
+
+// syncAndSettle runs syncDeployment against the latest deployment in client, then makes all
+// replica sets fully available and refreshes the replica set lister, just like what the
+// replica set controller and the informers would do.
+func syncAndSettle(t *testing.T, dc *DeploymentController, client *fake.Clientset, namespace, name string) *apps.Deployment {
+	d, err := client.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	if err = dc.syncDeployment(context.TODO(), d); err != nil {
+		t.Fatalf("failed to sync deployment: %v", err)
+	}
+	settleReplicaSets(t, dc, client, namespace)
+	d, err = client.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	return d
+}
+
+// settleReplicaSets makes all replica sets in client fully available and refreshes the lister.
+func settleReplicaSets(t *testing.T, dc *DeploymentController, client *fake.Clientset, namespace string) {
+	rsList, err := client.AppsV1().ReplicaSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	indexer := newTestReplicaSetIndexer()
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		rs.Status.Replicas = *rs.Spec.Replicas
+		rs.Status.ReadyReplicas = *rs.Spec.Replicas
+		rs.Status.AvailableReplicas = *rs.Spec.Replicas
+		rs.Status.ObservedGeneration = rs.Generation
+		if rs, err = client.AppsV1().ReplicaSets(namespace).UpdateStatus(context.TODO(), rs, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update replica set status: %v", err)
+		}
+		_ = indexer.Add(rs)
+	}
+	dc.rsLister = appslisters.NewReplicaSetLister(indexer)
+	dc.rsIndexer = indexer
+}
+
+// newTestReplicaSetIndexer returns an indexer of replica sets with the same indexes as the informer.
+func newTestReplicaSetIndexer() toolscache.Indexer {
+	return toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{
+		toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+		rsOwnerIndex:              indexReplicaSetByOwner,
+	})
+}
+
+// getTestReplicaSet returns the replica set in client running image.
+func getTestReplicaSet(t *testing.T, client *fake.Clientset, namespace, image string) *apps.ReplicaSet {
+	rsList, err := client.AppsV1().ReplicaSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	for i := range rsList.Items {
+		if rsList.Items[i].Spec.Template.Spec.Containers[0].Image == image {
+			return &rsList.Items[i]
+		}
+	}
+	t.Fatalf("replica set of image %s not found", image)
+	return nil
+}
+
+// getReplicaSetReplicas returns the spec.replicas of replica sets in client keyed by image.
+func getReplicaSetReplicas(t *testing.T, client *fake.Clientset, namespace string) map[string]int32 {
+	rsList, err := client.AppsV1().ReplicaSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	replicas := map[string]int32{}
+	for _, rs := range rsList.Items {
+		replicas[rs.Spec.Template.Spec.Containers[0].Image] = *rs.Spec.Replicas
+	}
+	return replicas
+}
+
+// collectEvents drains all events recorded so far by the fake recorder.
+func collectEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func hasEvent(events []string, reason string) bool {
+	for _, e := range events {
+		if strings.Contains(e, " "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncDeploymentHoldsSteadyPartition(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	for i := 0; i < 40; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 7 || replicas["demo:v2"] != 3 {
+		t.Fatalf("expect to hold steady partition 7/3, got %v", replicas)
+	}
+
+	d.Annotations[rolloutsv1alpha1.DeploymentPromoteAnnotation] = "true"
+	if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to promote deployment: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 0 || replicas["demo:v2"] != 10 {
+		t.Fatalf("expect to complete after promotion, got %v", replicas)
+	}
+}
+
+func TestSyncDeploymentWithCancelledContext(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+	client.ClearActions()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if err := dc.syncDeployment(ctx, d); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect sync cancelled, got %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Fatalf("expect no calls once cancelled, got %v", actions)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, map[string]int32{"demo:v1": 10}) {
+		t.Fatalf("expect replicas untouched, got %v", replicas)
+	}
+}
+
+func TestSyncDeploymentWithStaleCache(t *testing.T) {
+	cases := []struct {
+		name          string
+		synced        bool
+		liveRSExisted bool
+		expectRequeue bool
+	}{
+		{
+			name:          "informers have not been synced",
+			synced:        false,
+			liveRSExisted: true,
+			expectRequeue: true,
+		},
+		{
+			name:          "cache is transiently empty",
+			synced:        true,
+			liveRSExisted: true,
+			expectRequeue: true,
+		},
+		{
+			name:          "replica sets are really gone",
+			synced:        true,
+			liveRSExisted: false,
+			expectRequeue: false,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			d.Status.Replicas = 10
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, _ := newTestController(strategy, d)
+			dc.rsListerSynced = func() bool { return cs.synced }
+			if cs.liveRSExisted {
+				if _, err := client.AppsV1().ReplicaSets(d.Namespace).Create(context.TODO(), newTestReplicaSet(d, "demo:v1", 1, 10), metav1.CreateOptions{}); err != nil {
+					t.Fatalf("failed to create replica set: %v", err)
+				}
+			}
+			client.ClearActions()
+
+			if err := dc.syncDeployment(context.TODO(), d); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			created := false
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "create" && action.GetResource().Resource == "replicasets" {
+					created = true
+				}
+			}
+			if requeue := dc.requeueAfter > 0; requeue != cs.expectRequeue {
+				t.Errorf("expect requeue %v, got %v", cs.expectRequeue, requeue)
+			}
+			if created == cs.expectRequeue {
+				t.Errorf("expect replica set created %v, got %v", !cs.expectRequeue, created)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentWithTemplateAndReplicasChanged(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromString("25%"), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+	}
+	// template has been changed to demo:v2 and replicas to 20 in one update
+	d.Spec.Replicas = pointer.Int32(20)
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] > 10 {
+			t.Fatalf("expect old replica set not scaled up before rolling, got %v", replicas)
+		}
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 10 || replicas["demo:v2"] != 10 {
+		t.Fatalf("expect partition calculated against the new replicas 10/10, got %v", replicas)
+	}
+}
+
+func TestSyncDeploymentWithForeignObjects(t *testing.T) {
+	defer func(grace time.Duration) { drainStuckGrace = grace }(drainStuckGrace)
+	drainStuckGrace = 5 * time.Minute
+
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 3)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 1)
+
+	// other shares the same labels with d, so its replica set and pods match the selector of d.
+	other := newTestDeployment(5, intstr.FromInt(1), intstr.FromInt(0))
+	other.Name, other.UID = "other", types.UID("other-uid")
+	foreignRS := newTestReplicaSet(other, "demo:v1", 1, 5)
+	foreignPod := newTestPod(foreignRS, "foreign-terminating")
+	deletionTime := metav1.Now()
+	foreignPod.DeletionTimestamp = &deletionTime
+
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("100%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS, newRS, other, foreignRS, foreignPod)
+	syncAndSettle(t, dc, client, d.Namespace, d.Name)
+
+	expected := map[string]int32{oldRS.Name: 3, newRS.Name: 2, foreignRS.Name: 5}
+	for name, replicas := range expected {
+		rs, err := client.AppsV1().ReplicaSets(d.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get replica set %s: %v", name, err)
+		}
+		if *rs.Spec.Replicas != replicas {
+			t.Errorf("expect replica set %s scaled to %d, got %d", name, replicas, *rs.Spec.Replicas)
+		}
+	}
+}
+
+func TestSyncDeploymentWithCompletedNewReplicaSet(t *testing.T) {
+	cases := []struct {
+		name           string
+		complete       bool
+		expectOld      int32
+		expectExpected int32
+	}{
+		{
+			name:           "complete the rolling directly",
+			complete:       true,
+			expectOld:      0,
+			expectExpected: 10,
+		},
+		{
+			name:           "keep old replica sets held by partition",
+			complete:       false,
+			expectOld:      1,
+			expectExpected: 3,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(complete bool) { completeFullNewRS = complete }(completeFullNewRS)
+			completeFullNewRS = cs.complete
+
+			// The controller restarted after the new replica set was scaled up to full size,
+			// but before the last old replica was scaled down.
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 1)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("30%"),
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != cs.expectOld || replicas["demo:v2"] != 10 {
+				t.Fatalf("expect replicas %d/10, got %v", cs.expectOld, replicas)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.ExpectedUpdatedReplicas != cs.expectExpected {
+				t.Errorf("expect %d expected updated replicas, got %+v", cs.expectExpected, extraStatus)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentScaledDownInRolling(t *testing.T) {
+	cases := []struct {
+		name      string
+		partition intstr.IntOrString
+		replicas  int32
+		expect    map[string]int32
+	}{
+		{
+			name:      "absolute partition exceeds new replicas",
+			partition: intstr.FromInt(8),
+			replicas:  5,
+			expect:    map[string]int32{"demo:v1": 0, "demo:v2": 5},
+		},
+		{
+			name:      "absolute partition far exceeds new replicas",
+			partition: intstr.FromInt(8),
+			replicas:  1,
+			expect:    map[string]int32{"demo:v1": 0, "demo:v2": 1},
+		},
+		{
+			name:      "new replica set overshoots percentage partition",
+			partition: intstr.FromString("50%"),
+			replicas:  4,
+			expect:    map[string]int32{"demo:v1": 1, "demo:v2": 3},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(2), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     cs.partition,
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+			for i := 0; i < 20; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+
+			d.Spec.Replicas = pointer.Int32(cs.replicas)
+			d.Generation++
+			if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("failed to scale deployment: %v", err)
+			}
+			for i := 0; i < 20; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+				for image, replicas := range getReplicaSetReplicas(t, client, d.Namespace) {
+					if replicas < 0 {
+						t.Fatalf("expect non-negative replicas, got %d of %s", replicas, image)
+					}
+				}
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expect) {
+				t.Fatalf("expect replicas %v after scaled down, got %v", cs.expect, replicas)
+			}
+		})
+	}
+}
+
+func TestSyncReplicasAnnotationsRefreshesReplicaSets(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	inactiveRS := newTestReplicaSet(d, "demo:v0", 0, 0)
+	for _, rs := range []*apps.ReplicaSet{oldRS, newRS} {
+		rs.ResourceVersion = "1"
+		deploymentutil.SetReplicasAnnotations(rs, 5, 6)
+	}
+	dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS, newRS, inactiveRS)
+
+	rsList := []*apps.ReplicaSet{oldRS, newRS, inactiveRS}
+	if err := dc.syncReplicasAnnotations(context.TODO(), d, rsList); err != nil {
+		t.Fatalf("failed to sync replicas annotations: %v", err)
+	}
+	for _, rs := range rsList[:2] {
+		if desired, ok := deploymentutil.GetDesiredReplicasAnnotation(rs); !ok || desired != 10 {
+			t.Fatalf("expect desired replicas of %s refreshed in rsList, got %v", rs.Name, rs.Annotations)
+		}
+	}
+	if rsList[0] == oldRS || rsList[1] == newRS {
+		t.Fatalf("expect the updated replica sets returned in rsList")
+	}
+	if rsList[2] != inactiveRS {
+		t.Fatalf("expect the inactive replica set untouched")
+	}
+}
+
+func TestSyncDeploymentSupersedesFinalizingRollout(t *testing.T) {
+	cases := []struct {
+		name      string
+		finalize  bool
+		testBatch bool
+		expect    map[string]int32
+	}{
+		{
+			name:     "finalize the previous rollout and roll over the completed one",
+			finalize: true,
+			expect:   map[string]int32{"demo:v1": 0, "demo:v2": 5, "demo:v3": 5},
+		},
+		{
+			name:      "finalize the previous rollout while holding test batch",
+			finalize:  true,
+			testBatch: true,
+			expect:    map[string]int32{"demo:v1": 0, "demo:v2": 10, "demo:v3": 5},
+		},
+		{
+			name:      "leave the previous rollout while holding test batch",
+			finalize:  false,
+			testBatch: true,
+			expect:    map[string]int32{"demo:v1": 2, "demo:v2": 10, "demo:v3": 5},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(finalize bool) { finalizeSuperseded = finalize }(finalizeSuperseded)
+			finalizeSuperseded = cs.finalize
+
+			// The rollout to v2 was completed, and the template is changed to v3
+			// before the last old replicas of v1 are scaled down.
+			d := newTestDeployment(10, intstr.FromInt(2), intstr.FromInt(1))
+			v1RS := newTestReplicaSet(d, "demo:v1", 1, 2)
+			v2RS := newTestReplicaSet(d, "demo:v2", 2, 10)
+			d.Spec.Template.Spec.Containers[0].Image = "demo:v3"
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+				TestBatch:     cs.testBatch,
+			}
+			dc, client, _ := newTestController(strategy, d, v1RS, v2RS)
+
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expect) {
+				t.Errorf("expect replicas %v, got %v", cs.expect, replicas)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentWithUnmanagedOldReplicaSets(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	d.Spec.RevisionHistoryLimit = pointer.Int32(0)
+	v0RS := newTestReplicaSet(d, "demo:v0", 1, 0)
+	v1RS := newTestReplicaSet(d, "demo:v1", 2, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:         rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate:        d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:            intstr.FromString("50%"),
+		ManageOldReplicaSets: pointer.Bool(false),
+	}
+	dc, client, _ := newTestController(strategy, d, v0RS, v1RS)
+
+	steps := []struct {
+		name      string
+		partition intstr.IntOrString
+		replicas  int32
+		expect    map[string]int32
+	}{
+		{
+			name:      "scale up the new replica set to partition",
+			partition: intstr.FromString("50%"),
+			replicas:  10,
+			expect:    map[string]int32{"demo:v0": 0, "demo:v1": 10, "demo:v2": 5},
+		},
+		{
+			name:      "scale up the new replica set to full size",
+			partition: intstr.FromString("100%"),
+			replicas:  10,
+			expect:    map[string]int32{"demo:v0": 0, "demo:v1": 10, "demo:v2": 10},
+		},
+		{
+			name:      "scale down the new replica set only",
+			partition: intstr.FromString("100%"),
+			replicas:  6,
+			expect:    map[string]int32{"demo:v0": 0, "demo:v1": 10, "demo:v2": 6},
+		},
+	}
+	for _, step := range steps {
+		dc.strategy.Partition = step.partition
+		if *d.Spec.Replicas != step.replicas {
+			d.Spec.Replicas = pointer.Int32(step.replicas)
+			d.Generation++
+			if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("failed to scale deployment: %v", err)
+			}
+		}
+		client.ClearActions()
+		for i := 0; i < 20; i++ {
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		}
+		if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, step.expect) {
+			t.Fatalf("%s: expect replicas %v, got %v", step.name, step.expect, replicas)
+		}
+		// Only the status of old replica sets is updated by settleReplicaSets.
+		for _, action := range client.Actions() {
+			if action.GetResource().Resource != "replicasets" || action.GetSubresource() == "status" {
+				continue
+			}
+			var name string
+			switch a := action.(type) {
+			case clienttesting.UpdateAction:
+				name = a.GetObject().(*apps.ReplicaSet).Name
+			case clienttesting.DeleteAction:
+				name = a.GetName()
+			}
+			if name == v0RS.Name || name == v1RS.Name {
+				t.Fatalf("%s: expect old replica sets untouched, got %s %s", step.name, action.GetVerb(), name)
+			}
+		}
+	}
+}
+
+func TestGetReplicaSetsForDeploymentByOwnerIndex(t *testing.T) {
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 3)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 1)
+	// foreignRS matches the selector of d but is controlled by other.
+	other := newTestDeployment(5, intstr.FromInt(1), intstr.FromInt(0))
+	other.Name, other.UID = "other", types.UID("other-uid")
+	foreignRS := newTestReplicaSet(other, "demo:v1", 1, 5)
+	// unmatchedRS is controlled by d but does not match its selector any more.
+	unmatchedRS := newTestReplicaSet(d, "demo:v0", 0, 0)
+	unmatchedRS.Labels = map[string]string{"app": "legacy"}
+	// orphanRS matches the selector of d but has no controller.
+	orphanRS := newTestReplicaSet(d, "demo:v3", 3, 0)
+	orphanRS.OwnerReferences = nil
+
+	dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS, newRS, other, foreignRS, unmatchedRS, orphanRS)
+	byIndex, err := dc.getReplicaSetsForDeployment(context.TODO(), d)
+	if err != nil {
+		t.Fatalf("failed to get replica sets by index: %v", err)
+	}
+	dc.rsIndexer = nil
+	bySelector, err := dc.getReplicaSetsForDeployment(context.TODO(), d)
+	if err != nil {
+		t.Fatalf("failed to get replica sets by selector: %v", err)
+	}
+
+	names := func(rsList []*apps.ReplicaSet) []string {
+		var names []string
+		for _, rs := range rsList {
+			names = append(names, rs.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	expect := []string{oldRS.Name, newRS.Name}
+	sort.Strings(expect)
+	if !reflect.DeepEqual(names(byIndex), expect) || !reflect.DeepEqual(names(bySelector), expect) {
+		t.Fatalf("expect replica sets %v, got %v by index and %v by selector", expect, names(byIndex), names(bySelector))
+	}
+}
+
+// BenchmarkGetReplicaSetsForDeployment looks up the 10 replica sets of a deployment in a
+// namespace with 5000 replica sets of other deployments.
+func BenchmarkGetReplicaSetsForDeployment(b *testing.B) {
+	d := newTestDeployment(1, intstr.FromInt(1), intstr.FromInt(0))
+	var objects []runtime.Object
+	for i := 0; i < 10; i++ {
+		objects = append(objects, newTestReplicaSet(d, fmt.Sprintf("demo:v%d", i), int64(i), 0))
+	}
+	for i := 0; i < 500; i++ {
+		other := newTestDeployment(1, intstr.FromInt(1), intstr.FromInt(0))
+		other.Name, other.UID = fmt.Sprintf("other-%d", i), types.UID(fmt.Sprintf("other-uid-%d", i))
+		for j := 0; j < 10; j++ {
+			rs := newTestReplicaSet(other, fmt.Sprintf("demo:v%d", j), int64(j), 0)
+			rs.Labels["app"] = other.Name
+			objects = append(objects, rs)
+		}
+	}
+	dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, objects...)
+
+	for _, bc := range []struct {
+		name      string
+		rsIndexer toolscache.Indexer
+	}{
+		{name: "BySelector"},
+		{name: "ByOwnerIndex", rsIndexer: dc.rsIndexer},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			dc.rsIndexer = bc.rsIndexer
+			for i := 0; i < b.N; i++ {
+				if rsList, err := dc.getReplicaSetsForDeployment(context.TODO(), d); err != nil || len(rsList) != 10 {
+					b.Fatalf("expect 10 replica sets, got %d: %v", len(rsList), err)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateExtraStatusTrafficWeight(t *testing.T) {
+	cases := []struct {
+		name          string
+		replicas      int32
+		readyReplicas int32
+		override      *int32
+		expect        int32
+	}{
+		{
+			name:          "ratio of updated ready replicas",
+			replicas:      10,
+			readyReplicas: 3,
+			expect:        30,
+		},
+		{
+			name:          "ratio is rounded down",
+			replicas:      3,
+			readyReplicas: 1,
+			expect:        33,
+		},
+		{
+			name:          "ratio is no more than 100",
+			replicas:      2,
+			readyReplicas: 3,
+			expect:        100,
+		},
+		{
+			name:     "no replicas",
+			replicas: 0,
+			expect:   0,
+		},
+		{
+			name:          "overridden by strategy",
+			replicas:      10,
+			readyReplicas: 3,
+			override:      pointer.Int32(50),
+			expect:        50,
+		},
+		{
+			name:          "overridden to zero by strategy",
+			replicas:      10,
+			readyReplicas: 10,
+			override:      pointer.Int32(0),
+			expect:        0,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(cs.replicas, intstr.FromInt(1), intstr.FromInt(0))
+			newRS := newTestReplicaSet(d, "demo:v2", 1, cs.readyReplicas)
+			newRS.Status.ReadyReplicas = cs.readyReplicas
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("100%"),
+				TrafficWeight: cs.override,
+			}
+			dc, client, _ := newTestController(strategy, d, newRS)
+			if err := dc.updateExtraStatus(context.TODO(), d, []*apps.ReplicaSet{newRS}); err != nil {
+				t.Fatalf("failed to update extra status: %v", err)
+			}
+			d, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.TrafficWeight != cs.expect {
+				t.Fatalf("expect traffic weight %d, got %+v", cs.expect, extraStatus)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentWithDefaultedTemplate(t *testing.T) {
+	cases := []struct {
+		name             string
+		normalize        bool
+		expectReplicaSet int
+	}{
+		{
+			name:             "only different in defaults",
+			normalize:        true,
+			expectReplicaSet: 1,
+		},
+		{
+			name:             "spurious rollout without normalizing",
+			expectReplicaSet: 2,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(normalize bool) { deploymentutil.NormalizeTemplateDefaults = normalize }(deploymentutil.NormalizeTemplateDefaults)
+			deploymentutil.NormalizeTemplateDefaults = cs.normalize
+
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			// The template of replica set has been defaulted by apiserver, but the one of deployment has not.
+			rs := newTestReplicaSet(d, "demo:v2", 1, 10)
+			deploymentutil.NormalizePodTemplate(&rs.Spec.Template)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, _ := newTestController(strategy, d, rs)
+
+			syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list replica sets: %v", err)
+			}
+			if len(rsList.Items) != cs.expectReplicaSet {
+				t.Fatalf("expect %d replica sets, got %d", cs.expectReplicaSet, len(rsList.Items))
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentWithTemplateHashLabelKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		labelKey string
+	}{
+		{
+			name:     "default label key",
+			labelKey: apps.DefaultDeploymentUniqueLabelKey,
+		},
+		{
+			name:     "custom label key",
+			labelKey: "example.com/template-hash",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(labelKey string) { deploymentutil.TemplateHashLabelKey = labelKey }(deploymentutil.TemplateHashLabelKey)
+			deploymentutil.TemplateHashLabelKey = cs.labelKey
+
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			expectReplicas := map[string]int32{"demo:v1": 5, "demo:v2": 5}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+				t.Fatalf("expect replicas %v, got %v", expectReplicas, replicas)
+			}
+
+			rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list replica sets: %v", err)
+			}
+			for _, rs := range rsList.Items {
+				if rs.Name == oldRS.Name {
+					continue
+				}
+				hash := rs.Labels[cs.labelKey]
+				if hash == "" || rs.Spec.Selector.MatchLabels[cs.labelKey] != hash || rs.Spec.Template.Labels[cs.labelKey] != hash {
+					t.Fatalf("expect new replica set labeled and selected by %s, got labels %v, selector %v, template labels %v",
+						cs.labelKey, rs.Labels, rs.Spec.Selector.MatchLabels, rs.Spec.Template.Labels)
+				}
+				if _, ok := rs.Spec.Template.Labels[apps.DefaultDeploymentUniqueLabelKey]; ok && cs.labelKey != apps.DefaultDeploymentUniqueLabelKey {
+					t.Fatalf("expect no %s label, got template labels %v", apps.DefaultDeploymentUniqueLabelKey, rs.Spec.Template.Labels)
+				}
+				if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.UpdateRevision != hash || extraStatus.UpdatedReadyReplicas != 5 {
+					t.Fatalf("expect 5 updated ready replicas of revision %s, got %+v", hash, extraStatus)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/integer"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// DrainStuckReason is added in a deployment event when some pods of its old replica sets
+// have been terminating for longer than drainStuckGrace.
+const DrainStuckReason = "DrainStuck"
+
+// countTerminatingPods returns the number of terminating pods of old replica sets, which are
+// still occupying capacity of the deployment. Pods that have been terminating for longer than
+// drainStuckGrace are reported by a DrainStuck event once each old replica set gets stuck, and will
+// be treated as removed if drainStuckProceed is true, so that a stuck pod cannot block the rolling forever.
+func (dc *DeploymentController) countTerminatingPods(d *apps.Deployment, oldRSs []*apps.ReplicaSet) (int32, error) {
+	if drainStuckGrace <= 0 {
+		return 0, nil
+	}
+
+	// Only pods controlled by the old replica sets are taken into account, other pods
+	// matching the selector of deployment are not ours, even if they are terminating.
+	pods, err := deploymentutil.ListPods(d, oldRSs, dc.listPods)
+	if err != nil {
+		return 0, err
+	}
+	stuck := make(map[types.UID]int)
+	terminating := int32(0)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp == nil {
+			continue
+		}
+		// DeletionTimestamp is the time when the pod is expected to be gone.
+		if dc.clock.Now().Before(pod.DeletionTimestamp.Add(drainStuckGrace)) {
+			terminating++
+			continue
+		}
+		stuck[metav1.GetControllerOf(pod).UID]++
+		if !drainStuckProceed {
+			terminating++
+		}
+	}
+	for _, rs := range oldRSs {
+		count := stuck[rs.UID]
+		if !dc.stuckDrains.observe(types.NamespacedName{Namespace: rs.Namespace, Name: rs.Name}, rs.UID, count > 0) {
+			if count > 0 {
+				dc.log().V(4).Info("Pods of old replica set are still stuck terminating", "replicaSet", klog.KObj(rs), "count", count)
+			}
+			continue
+		}
+		dc.log().Info("Found pods of old replica set stuck terminating", "replicaSet", klog.KObj(rs), "count", count, "proceed", drainStuckProceed)
+		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, DrainStuckReason, "%d pods of old replica set %s have been terminating for more than %v", count, rs.Name, drainStuckGrace)
+	}
+	return terminating, nil
+}
+
+// limitScaleUpByTerminatingPods makes sure the terminating pods of old replica sets are taken
+// into account by maxSurge when scaling up the new replica set.
+func (dc *DeploymentController) limitScaleUpByTerminatingPods(d *apps.Deployment, allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, newReplicasCount int32) (int32, error) {
+	if newReplicasCount <= *(newRS.Spec.Replicas) || !deploymentutil.IsRollingUpdate(d) {
+		return newReplicasCount, nil
+	}
+
+	oldRSs := deploymentutil.FilterReplicaSets(allRSs, func(rs *apps.ReplicaSet) bool {
+		return rs != nil && rs.UID != newRS.UID
+	})
+	terminating, err := dc.countTerminatingPods(d, oldRSs)
+	if err != nil || terminating == 0 {
+		return newReplicasCount, err
+	}
+
+	maxTotalPods := *(d.Spec.Replicas) + deploymentutil.MaxSurge(*d)
+	currentPodCount := deploymentutil.GetReplicaCountForReplicaSets(allRSs) + terminating
+	if currentPodCount >= maxTotalPods {
+		dc.log().V(4).Info("Cannot scale up new replica set because of terminating pods", "replicaSet", klog.KObj(newRS), "terminating", terminating)
+		return *(newRS.Spec.Replicas), nil
+	}
+	return int32(integer.IntMin(int(newReplicasCount), int(*(newRS.Spec.Replicas)+maxTotalPods-currentPodCount))), nil
+}
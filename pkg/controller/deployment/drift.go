@@ -0,0 +1,77 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// repairDriftedReplicaSets restores the replicas of replica sets which drift from partition, e.g.
+// after edited by kubectl. Such edits do not change the generation of deployment, and rolling never
+// scales the old replica sets up nor the new one down, so they would stay as they are otherwise.
+// The old replica sets are scaled back up first if they keep fewer replicas than partition requires,
+// and the new replica set is scaled down to partition if it surges beyond maxSurge, only once the
+// old ones keep enough, so that no capacity is lost for the repair. A partition lowered by users is
+// not regarded as a drift, since the old replica sets have not been edited then.
+//
+// Only the replica sets created by us, i.e. labeled with the rollout generation, are repaired, so
+// that we never fight the upstream deployment controller over its replica sets. It returns true if
+// any replica set is scaled.
+func (dc *DeploymentController) repairDriftedReplicaSets(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) (bool, error) {
+	if !repairDriftedReplicas || newRS == nil || dc.isTestBatch(newRS) || !dc.managesOldReplicaSets() {
+		return false, nil
+	}
+	replicas := *(d.Spec.Replicas)
+	limit := integer.Int32Min(dc.newRSReplicasLimit(d), replicas)
+	newReplicas := *(newRS.Spec.Replicas)
+	oldReplicas := deploymentutil.GetReplicaCountForReplicaSets(oldRSs)
+
+	// The old replica sets keep the replicas not allowed to be updated, see maxOldScaleDown.
+	if keep := replicas - integer.Int32Max(limit, newReplicas); oldReplicas < keep {
+		stableRS := latestReplicaSet(oldRSs)
+		if stableRS == nil || !isCreatedByRollout(stableRS) {
+			return false, nil
+		}
+		target := *(stableRS.Spec.Replicas) + keep - oldReplicas
+		dc.log().Info("Old replica sets have fewer replicas than kept by partition, scaling up", "replicaSet", klog.KObj(stableRS), "replicas", oldReplicas, "keep", keep, "target", target)
+		scaled, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, stableRS, target, d, auditReasonDriftRepaired)
+		return scaled, err
+	}
+
+	// Rolling never surges beyond maxSurge, but the new replica set may overshoot partition within
+	// it if the deployment is scaled down in the middle of rolling, which is left to rolling.
+	surged := oldReplicas+newReplicas > replicas+deploymentutil.MaxSurge(*d)
+	if surged && newReplicas > limit && oldReplicas >= replicas-limit && isCreatedByRollout(newRS) {
+		dc.log().Info("New replica set has replicas beyond the partition, scaling it down", "replicaSet", klog.KObj(newRS), "replicas", newReplicas, "limit", limit)
+		scaled, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, newRS, limit, d, auditReasonDriftRepaired)
+		return scaled, err
+	}
+	return false, nil
+}
+
+// isCreatedByRollout returns true if rs is created by Advanced Deployment.
+func isCreatedByRollout(rs *apps.ReplicaSet) bool {
+	_, ok := rs.Labels[rolloutsv1alpha1.RolloutGenerationLabel]
+	return ok
+}
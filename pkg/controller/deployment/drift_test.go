@@ -0,0 +1,124 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// labelRolloutGeneration returns rs labeled as created by Advanced Deployment.
+func labelRolloutGeneration(rs *apps.ReplicaSet) *apps.ReplicaSet {
+	labels := map[string]string{rolloutsv1alpha1.RolloutGenerationLabel: "1"}
+	for k, v := range rs.Labels {
+		labels[k] = v
+	}
+	rs.Labels = labels
+	return rs
+}
+
+// skewReplicaSet scales rs in client to replicas directly.
+func skewReplicaSet(t *testing.T, client *fake.Clientset, rs *apps.ReplicaSet, replicas int32) {
+	latest, err := client.AppsV1().ReplicaSets(rs.Namespace).Get(context.TODO(), rs.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get replica set: %v", err)
+	}
+	latest.Spec.Replicas = pointer.Int32(replicas)
+	if _, err = client.AppsV1().ReplicaSets(rs.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update replica set: %v", err)
+	}
+}
+
+func TestSyncDeploymentRepairsDriftedReplicaSets(t *testing.T) {
+	cases := []struct {
+		name        string
+		oldReplicas int32
+		newReplicas int32
+		labeled     bool
+		disabled    bool
+		expect      map[string]int32
+	}{
+		{
+			name:        "old replica set scaled down",
+			oldReplicas: 4,
+			newReplicas: 3,
+			labeled:     true,
+			expect:      map[string]int32{"demo:v1": 7, "demo:v2": 3},
+		},
+		{
+			name:        "new replica set scaled up",
+			oldReplicas: 7,
+			newReplicas: 6,
+			labeled:     true,
+			expect:      map[string]int32{"demo:v1": 7, "demo:v2": 3},
+		},
+		{
+			name:        "replica sets not created by us",
+			oldReplicas: 4,
+			newReplicas: 3,
+			expect:      map[string]int32{"demo:v1": 4, "demo:v2": 3},
+		},
+		{
+			name:        "repair disabled",
+			oldReplicas: 4,
+			newReplicas: 3,
+			labeled:     true,
+			disabled:    true,
+			expect:      map[string]int32{"demo:v1": 4, "demo:v2": 3},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(repair bool) { repairDriftedReplicas = repair }(repairDriftedReplicas)
+			repairDriftedReplicas = !cs.disabled
+
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			if cs.labeled {
+				oldRS, newRS = labelRolloutGeneration(oldRS), labelRolloutGeneration(newRS)
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("30%"),
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+
+			// Skew the replica sets as kubectl does, without changing the deployment.
+			skewReplicaSet(t, client, oldRS, cs.oldReplicas)
+			skewReplicaSet(t, client, newRS, cs.newReplicas)
+			settleReplicaSets(t, dc, client, d.Namespace)
+
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expect) {
+				t.Fatalf("expect replicas %v, got %v", cs.expect, replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/feature"
+	utilfeature "github.com/openkruise/rollouts/pkg/util/feature"
+)
+
+// DryRunScaleReason is added in a deployment event in dry-run mode, listing the scaling of replica
+// sets which would be done in the sync.
+const DryRunScaleReason = "DryRunScale"
+
+// isDryRun returns true if the dry-run annotation of deployment is "true" and the feature gate
+// of dry run is enabled.
+func isDryRun(d *apps.Deployment) bool {
+	return d.Annotations[rolloutsv1alpha1.DeploymentDryRunAnnotation] == "true" &&
+		utilfeature.DefaultFeatureGate.Enabled(feature.AdvancedDeploymentDryRunGate)
+}
+
+// recordDryRunScale remembers the scaling of the replica set with the given name, which is reported
+// by syncDryRunScales instead of being done in dry-run mode.
+func (dc *DeploymentController) recordDryRunScale(name string, replicas, targetReplicas int32, create bool) {
+	dc.dryRunScales = append(dc.dryRunScales, rolloutsv1alpha1.DeploymentReplicaSetScale{
+		Name:           name,
+		Replicas:       replicas,
+		TargetReplicas: targetReplicas,
+		Create:         create,
+	})
+}
+
+// syncDryRunScales reports the scaling of replica sets recorded in this sync in the extra status, and
+// in an event if there is any. Since nothing is scaled in dry-run mode, the same scaling for the current
+// step is reported on every sync until the dry run is turned off.
+func (dc *DeploymentController) syncDryRunScales(d *apps.Deployment, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if !dc.dryRun || len(dc.dryRunScales) == 0 {
+		return
+	}
+	extraStatus.DryRunScales = dc.dryRunScales
+	scales := make([]string, 0, len(dc.dryRunScales))
+	for _, scale := range dc.dryRunScales {
+		if scale.Create {
+			scales = append(scales, fmt.Sprintf("create replica set %s with %d replicas", scale.Name, scale.TargetReplicas))
+		} else {
+			scales = append(scales, fmt.Sprintf("scale replica set %s from %d to %d", scale.Name, scale.Replicas, scale.TargetReplicas))
+		}
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeNormal, DryRunScaleReason, "Dry run would %s", strings.Join(scales, ", "))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/feature"
+	"github.com/openkruise/rollouts/pkg/util"
+	utilfeature "github.com/openkruise/rollouts/pkg/util/feature"
+)
+
+func TestSyncDeploymentInDryRun(t *testing.T) {
+	cases := []struct {
+		name         string
+		gateEnabled  bool
+		newReplicas  int32
+		expectScales func(d *apps.Deployment) []rolloutsv1alpha1.DeploymentReplicaSetScale
+	}{
+		{
+			name: "feature gate disabled",
+		},
+		{
+			name:        "create new replica set",
+			gateEnabled: true,
+			expectScales: func(d *apps.Deployment) []rolloutsv1alpha1.DeploymentReplicaSetScale {
+				name := d.Name + "-" + deploymentutil.ComputeTemplateHash(&d.Spec.Template, nil)
+				return []rolloutsv1alpha1.DeploymentReplicaSetScale{
+					{Name: name, TargetReplicas: 1, Create: true},
+					{Name: "deployment-demo-v1", Replicas: 10, TargetReplicas: 9},
+				}
+			},
+		},
+		{
+			name:        "scale existing replica sets",
+			gateEnabled: true,
+			newReplicas: 3,
+			expectScales: func(d *apps.Deployment) []rolloutsv1alpha1.DeploymentReplicaSetScale {
+				return []rolloutsv1alpha1.DeploymentReplicaSetScale{{Name: "deployment-demo-v2", Replicas: 3, TargetReplicas: 4}}
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if err := utilfeature.DefaultMutableFeatureGate.Set(fmt.Sprintf("%s=%v", feature.AdvancedDeploymentDryRunGate, cs.gateEnabled)); err != nil {
+				t.Fatalf("failed to set feature gate: %v", err)
+			}
+			defer func() {
+				_ = utilfeature.DefaultMutableFeatureGate.Set(fmt.Sprintf("%s=false", feature.AdvancedDeploymentDryRunGate))
+			}()
+
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(1))
+			objects := []runtime.Object{d, newTestReplicaSet(d, "demo:v1", 1, 10-cs.newReplicas)}
+			if cs.newReplicas > 0 {
+				objects = append(objects, newTestReplicaSet(d, "demo:v2", 2, cs.newReplicas))
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			factory, client, recorder := newTestController(strategy, objects...)
+
+			// The controller is created from the deployment in dry-run mode on each sync, like the reconciler does.
+			newController := func() *DeploymentController {
+				controlled := d.DeepCopy()
+				controlled.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+				controlled.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":1},"partition":"50%"}`
+				controlled.Annotations[rolloutsv1alpha1.DeploymentDryRunAnnotation] = "true"
+				controlled.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+				controlled.Spec.Paused = true
+				dc, err := (*controllerFactory)(factory).NewController(controlled)
+				if err != nil || dc == nil {
+					t.Fatalf("expect controller created, got %v, %v", dc, err)
+				}
+				dc.strategy = strategy
+				return dc
+			}
+
+			// The same scaling is reported on every sync, since nothing is done.
+			for i := 0; i < 3; i++ {
+				client.ClearActions()
+				d = syncAndSettle(t, newController(), client, d.Namespace, d.Name)
+				rsWritten := false
+				for _, action := range client.Actions() {
+					verb := action.GetVerb()
+					if action.GetResource().Resource == "replicasets" && action.GetSubresource() == "" && (verb == "create" || verb == "update" || verb == "delete") {
+						rsWritten = true
+					}
+				}
+				if cs.expectScales == nil {
+					if !rsWritten {
+						t.Fatalf("expect replica sets written without dry run")
+					}
+					return
+				}
+				if rsWritten {
+					t.Fatalf("sync %d: expect no replica set written in dry run, got %v", i, client.Actions())
+				}
+				extraStatus := getExtraStatus(d)
+				if expect := cs.expectScales(d); extraStatus == nil || !reflect.DeepEqual(extraStatus.DryRunScales, expect) {
+					t.Fatalf("sync %d: expect dry run scales %+v, got %+v", i, expect, extraStatus)
+				}
+				if !hasEvent(collectEvents(recorder), DryRunScaleReason) {
+					t.Fatalf("sync %d: expect %s event", i, DryRunScaleReason)
+				}
+			}
+		})
+	}
+}
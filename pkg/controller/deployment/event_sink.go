@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"math"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	clientset "k8s.io/client-go/kubernetes"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// eventSink records the events in the namespace of the objects they are about, and copies them to
+// the aggregation namespace as well if it is set, so that the events of all deployments can be
+// watched in one place without cluster-wide access to events.
+type eventSink struct {
+	client               clientset.Interface
+	aggregationNamespace string
+}
+
+var _ record.EventSink = &eventSink{}
+
+// newEventSink returns an eventSink writing events via client, and copying them to the aggregation
+// namespace unless it is empty.
+func newEventSink(client clientset.Interface, aggregationNamespace string) *eventSink {
+	return &eventSink{client: client, aggregationNamespace: aggregationNamespace}
+}
+
+func (s *eventSink) Create(event *v1.Event) (*v1.Event, error) {
+	created, err := s.events(event).CreateWithEventNamespace(event)
+	if err == nil {
+		s.aggregate(event, func(copied *v1.Event) (*v1.Event, error) {
+			return s.events(copied).CreateWithEventNamespace(copied)
+		})
+	}
+	return created, err
+}
+
+func (s *eventSink) Update(event *v1.Event) (*v1.Event, error) {
+	updated, err := s.events(event).UpdateWithEventNamespace(event)
+	if err == nil {
+		s.aggregate(event, func(copied *v1.Event) (*v1.Event, error) {
+			return s.events(copied).UpdateWithEventNamespace(copied)
+		})
+	}
+	return updated, err
+}
+
+func (s *eventSink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	patched, err := s.events(event).PatchWithEventNamespace(event, data)
+	if err == nil {
+		s.aggregate(event, func(copied *v1.Event) (*v1.Event, error) {
+			return s.events(copied).PatchWithEventNamespace(copied, data)
+		})
+	}
+	return patched, err
+}
+
+// events returns the client of events in the namespace of event, which is the namespace of the
+// object it is about unless set otherwise.
+func (s *eventSink) events(event *v1.Event) v1core.EventInterface {
+	if event.Namespace == "" {
+		event.Namespace = event.InvolvedObject.Namespace
+	}
+	return s.client.CoreV1().Events(event.Namespace)
+}
+
+// aggregate writes a copy of event to the aggregation namespace. The copy is best-effort, its
+// failure is logged but never fails or retries the event itself.
+func (s *eventSink) aggregate(event *v1.Event, write func(*v1.Event) (*v1.Event, error)) {
+	if s.aggregationNamespace == "" || event.Namespace == s.aggregationNamespace {
+		return
+	}
+	copied := event.DeepCopy()
+	copied.Namespace = s.aggregationNamespace
+	copied.ResourceVersion = ""
+	copied.UID = ""
+	if _, err := write(copied); err != nil && !errors.IsAlreadyExists(err) {
+		klog.Errorf("Failed to copy event %s/%s to aggregation namespace %s: %v", event.Namespace, event.Name, s.aggregationNamespace, err)
+	}
+}
+
+// newEventBroadcaster returns a broadcaster which combines the similar events, i.e. those of the
+// same object, type and reason but different messages, into one event with a count once there are
+// too many of them within window, e.g. the ScalingReplicaSet events of every batch in a large
+// rollout. The identical events are always counted in one event by the broadcaster. Non-positive
+// window means the default of client-go.
+func newEventBroadcaster(window time.Duration) record.EventBroadcaster {
+	if window <= 0 {
+		return record.NewBroadcaster()
+	}
+	return record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		MaxIntervalInSeconds: int(math.Ceil(window.Seconds())),
+	})
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventSinkNamespaces(t *testing.T) {
+	cases := []struct {
+		name                 string
+		aggregationNamespace string
+		expectNamespaces     []string
+	}{
+		{
+			name:             "namespace of deployment only",
+			expectNamespaces: []string{"default"},
+		},
+		{
+			name:                 "copied to aggregation namespace",
+			aggregationNamespace: "rollout-events",
+			expectNamespaces:     []string{"default", "rollout-events"},
+		},
+		{
+			name:                 "aggregation namespace of deployment",
+			aggregationNamespace: "default",
+			expectNamespaces:     []string{"default"},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(1, intstr.FromInt(1), intstr.FromInt(0))
+			client := fake.NewSimpleClientset()
+			broadcaster := record.NewBroadcaster()
+			defer broadcaster.Shutdown()
+			broadcaster.StartRecordingToSink(newEventSink(client, cs.aggregationNamespace))
+			recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "advanced-deployment-controller"})
+
+			recorder.Eventf(d, v1.EventTypeNormal, RolloutStartedReason, "Rollout started")
+			var events []v1.Event
+			err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+				list, err := client.CoreV1().Events(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+				if err != nil {
+					return false, err
+				}
+				events = list.Items
+				return len(events) >= len(cs.expectNamespaces), nil
+			})
+			if err != nil {
+				t.Fatalf("expect events in %v, got %d events: %v", cs.expectNamespaces, len(events), err)
+			}
+			// Wait a moment for any unexpected copy.
+			time.Sleep(50 * time.Millisecond)
+			list, err := client.CoreV1().Events(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list events: %v", err)
+			}
+
+			namespaces := map[string]bool{}
+			for _, event := range list.Items {
+				if event.InvolvedObject.Namespace != d.Namespace || event.InvolvedObject.Name != d.Name || event.Reason != RolloutStartedReason {
+					t.Fatalf("unexpected event %+v", event)
+				}
+				namespaces[event.Namespace] = true
+			}
+			if len(list.Items) != len(cs.expectNamespaces) {
+				t.Fatalf("expect events in %v, got %d events", cs.expectNamespaces, len(list.Items))
+			}
+			for _, namespace := range cs.expectNamespaces {
+				if !namespaces[namespace] {
+					t.Fatalf("expect event in namespace %s, got %v", namespace, namespaces)
+				}
+			}
+		})
+	}
+}
+
+func TestEventBroadcasterAggregatesSimilarEvents(t *testing.T) {
+	d := newTestDeployment(1, intstr.FromInt(1), intstr.FromInt(0))
+	client := fake.NewSimpleClientset()
+	broadcaster := newEventBroadcaster(time.Minute)
+	defer broadcaster.Shutdown()
+	broadcaster.StartRecordingToSink(newEventSink(client, ""))
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "advanced-deployment-controller"})
+
+	// Only the first 9 of the similar events are kept apart, the rest are combined into the 10th.
+	for i := 1; i <= 12; i++ {
+		recorder.Eventf(d, v1.EventTypeNormal, "ScalingReplicaSet", "Scaled up replica set deployment-demo-v2 to %d", i)
+	}
+	var events []v1.Event
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		list, err := client.CoreV1().Events(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		events = list.Items
+		for _, event := range events {
+			if strings.HasPrefix(event.Message, "(combined from similar events)") && event.Count == 3 {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("expect the similar events combined with count 3, got %+v: %v", events, err)
+	}
+	if len(events) != 10 {
+		t.Fatalf("expect 10 events, got %d", len(events))
+	}
+}
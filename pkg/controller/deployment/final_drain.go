@@ -0,0 +1,66 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// FinalDrainHeldReason is added in a deployment event when the new replica set is at full size,
+// but the last old pod is kept until the drain is confirmed.
+const FinalDrainHeldReason = "FinalDrainHeld"
+
+// isFinalDrainHeld returns true if the old replica sets must keep their last pod, i.e. the strategy
+// requires the final drain to be confirmed, and the confirm-final-drain annotation of deployment
+// does not match the revision of the new replica set. A confirmation of the previous revision is
+// stale and never drains the current rollout.
+func (dc *DeploymentController) isFinalDrainHeld(d *apps.Deployment, newRS *apps.ReplicaSet) bool {
+	if !dc.strategy.ConfirmFinalDrain || newRS == nil {
+		return false
+	}
+	revision := newRS.Labels[deploymentutil.TemplateHashLabelKey]
+	return d.Annotations[rolloutsv1alpha1.DeploymentConfirmDrainAnnotation] != revision
+}
+
+// isAwaitingDrainConfirmation returns true if the new replica set is at full size and available,
+// and only the last old pod held by isFinalDrainHeld is left, which is the steady state until
+// the drain is confirmed.
+func (dc *DeploymentController) isAwaitingDrainConfirmation(d *apps.Deployment, newRS *apps.ReplicaSet, newStatus *apps.DeploymentStatus) bool {
+	if !dc.isFinalDrainHeld(d, newRS) || newStatus.ObservedGeneration < d.Generation {
+		return false
+	}
+	replicas := *(d.Spec.Replicas)
+	return newStatus.UpdatedReplicas == replicas && newStatus.Replicas == replicas+1 && newRS.Status.AvailableReplicas >= replicas
+}
+
+// holdFinalDrain returns true if the old replica sets would be scaled down to zero by completeRolling,
+// but the final drain has not been confirmed yet. An event is emitted once only the last old pod
+// is left, the rest of them are still scaled down in batches by maxOldScaleDown.
+func (dc *DeploymentController) holdFinalDrain(d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) bool {
+	if !dc.isFinalDrainHeld(d, newRS) {
+		return false
+	}
+	if deploymentutil.GetReplicaCountForReplicaSets(oldRSs) == 1 {
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, FinalDrainHeldReason,
+			"Last old pod is kept until annotation %s is set to %s", rolloutsv1alpha1.DeploymentConfirmDrainAnnotation, newRS.Labels[deploymentutil.TemplateHashLabelKey])
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncConfirmFinalDrain(t *testing.T) {
+	cases := []struct {
+		name           string
+		confirm        bool
+		annotation     string
+		expectReplicas map[string]int32
+		expectHeld     bool
+	}{
+		{
+			name:           "drained without confirm final drain",
+			expectReplicas: map[string]int32{"demo:v1": 0, "demo:v2": 3},
+		},
+		{
+			name:           "held until confirmed",
+			confirm:        true,
+			expectReplicas: map[string]int32{"demo:v1": 1, "demo:v2": 3},
+			expectHeld:     true,
+		},
+		{
+			name:           "held with stale confirmation",
+			confirm:        true,
+			annotation:     "stale-revision",
+			expectReplicas: map[string]int32{"demo:v1": 1, "demo:v2": 3},
+			expectHeld:     true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(3, intstr.FromInt(1), intstr.FromInt(0))
+			d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+			if cs.annotation != "" {
+				d.Annotations = map[string]string{rolloutsv1alpha1.DeploymentConfirmDrainAnnotation: cs.annotation}
+			}
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 3)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:      rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:     d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:         intstr.FromString("100%"),
+				ConfirmFinalDrain: cs.confirm,
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS)
+
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expectReplicas) {
+				t.Fatalf("expect replicas %v, got %v", cs.expectReplicas, replicas)
+			}
+			if held := hasEvent(collectEvents(recorder), FinalDrainHeldReason); held != cs.expectHeld {
+				t.Fatalf("expect %s event %v, got %v", FinalDrainHeldReason, cs.expectHeld, held)
+			}
+			if !cs.expectHeld {
+				return
+			}
+			cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+			if cond == nil || cond.Reason != deploymentutil.PartitionHeldReason || cond.Status != v1.ConditionUnknown {
+				t.Fatalf("expect progressing condition Unknown/%s, got %+v", deploymentutil.PartitionHeldReason, cond)
+			}
+
+			// Confirm the drain of current revision.
+			extraStatus := getExtraStatus(d)
+			if extraStatus == nil || extraStatus.UpdateRevision == "" {
+				t.Fatalf("expect update revision in extra status, got %+v", extraStatus)
+			}
+			d.Annotations[rolloutsv1alpha1.DeploymentConfirmDrainAnnotation] = extraStatus.UpdateRevision
+			if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("failed to update deployment: %v", err)
+			}
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			expectReplicas := map[string]int32{"demo:v1": 0, "demo:v2": 3}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+				t.Fatalf("expect replicas %v after confirmed, got %v", expectReplicas, replicas)
+			}
+			cond = deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+			if cond == nil || cond.Reason != deploymentutil.NewRSAvailableReason {
+				t.Fatalf("expect progressing condition %s after confirmed, got %+v", deploymentutil.NewRSAvailableReason, cond)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// isPaused returns true if the deployment should not advance, either because it is paused
+// by our strategy, or because its namespace is frozen.
+func (dc *DeploymentController) isPaused(d *apps.Deployment) bool {
+	if dc.strategy.Paused {
+		return true
+	}
+	if dc.isNamespaceFrozen(d.Namespace) {
+		dc.log().V(3).Info("Namespace is frozen, hold the rolling")
+		return true
+	}
+	return false
+}
+
+// DeploymentPausedReason is added in a deployment event while it is paused by annotation.
+const DeploymentPausedReason = "DeploymentPaused"
+
+// pausedRecheckInterval is how often to check a deployment paused by annotation again, in case
+// the update clearing the annotation is missed.
+const pausedRecheckInterval = time.Minute
+
+// isPausedByAnnotation returns true if the paused annotation of deployment is "true".
+func isPausedByAnnotation(d *apps.Deployment) bool {
+	return d.Annotations[rolloutsv1alpha1.DeploymentPausedAnnotation] == "true"
+}
+
+// holdPausedDeployment requeues the deployment paused by annotation without syncing it at all, and
+// emits an event once it becomes paused. Unlike the paused strategy, even the replica sets are not
+// scaled, and the rolling resumes from where it was held once the annotation is cleared.
+func (dc *DeploymentController) holdPausedDeployment(d *apps.Deployment, newlyPaused bool) {
+	dc.log().V(3).Info("Deployment is paused by annotation, skip syncing")
+	if newlyPaused {
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, DeploymentPausedReason,
+			"Deployment is paused by annotation %s, nothing is synced until it is cleared", rolloutsv1alpha1.DeploymentPausedAnnotation)
+	}
+	dc.enqueueAfter(d, pausedRecheckInterval)
+}
+
+// isNamespaceFrozen returns true if the namespace is frozen. If the namespace cannot be found
+// in cache, it is regarded as not frozen, so that the deployments will not be held by mistake.
+func (dc *DeploymentController) isNamespaceFrozen(namespace string) bool {
+	if dc.nsLister == nil {
+		return false
+	}
+	ns, err := dc.nsLister.Get(namespace)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			dc.log().Error(err, "Failed to get namespace")
+		}
+		return false
+	}
+	return isFrozen(ns)
+}
+
+// isFrozen returns true if the freeze annotation or label of namespace is "true".
+func isFrozen(ns *v1.Namespace) bool {
+	return ns.Annotations[rolloutsv1alpha1.NamespaceFreezeAnnotation] == "true" ||
+		ns.Labels[rolloutsv1alpha1.NamespaceFreezeAnnotation] == "true"
+}
+
+// deploymentsInNamespace returns a MapFunc which maps a namespace to the deployments under our
+// control in it, so that they can be held or resumed once the namespace is frozen or unfrozen.
+func deploymentsInNamespace(reader client.Reader) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		deploymentList := &apps.DeploymentList{}
+		if err := reader.List(context.TODO(), deploymentList, client.InNamespace(obj.GetName())); err != nil {
+			klog.Errorf("Failed to list deployments in namespace %s: %v", obj.GetName(), err)
+			return nil
+		}
+		var requests []reconcile.Request
+		for i := range deploymentList.Items {
+			d := &deploymentList.Items[i]
+			if deploymentutil.IsUnderRolloutControl(d) {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}})
+			}
+		}
+		return requests
+	}
+}
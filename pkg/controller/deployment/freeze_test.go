@@ -0,0 +1,202 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestSyncDeploymentInFrozenNamespace(t *testing.T) {
+	cases := []struct {
+		name       string
+		namespace  *v1.Namespace
+		expectHeld bool
+	}{
+		{
+			name:      "namespace is not frozen",
+			namespace: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		},
+		{
+			name: "namespace is frozen by annotation",
+			namespace: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        "default",
+				Annotations: map[string]string{rolloutsv1alpha1.NamespaceFreezeAnnotation: "true"},
+			}},
+			expectHeld: true,
+		},
+		{
+			name: "namespace is frozen by label",
+			namespace: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:   "default",
+				Labels: map[string]string{rolloutsv1alpha1.NamespaceFreezeAnnotation: "true"},
+			}},
+			expectHeld: true,
+		},
+		{
+			name: "namespace is not found in cache",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+			d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 2)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("100%"),
+			}
+			objects := []runtime.Object{d, oldRS}
+			if cs.namespace != nil {
+				objects = append(objects, cs.namespace)
+			}
+			dc, client, _ := newTestController(strategy, objects...)
+
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			replicas := getReplicaSetReplicas(t, client, d.Namespace)
+			if held := replicas["demo:v1"] == 2 && replicas["demo:v2"] == 0; held != cs.expectHeld {
+				t.Fatalf("expect held %v, got %v", cs.expectHeld, replicas)
+			}
+			cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+			if paused := cond != nil && cond.Reason == deploymentutil.PausedDeployReason; paused != cs.expectHeld {
+				t.Fatalf("expect paused condition %v, got %+v", cs.expectHeld, cond)
+			}
+			if !cs.expectHeld {
+				return
+			}
+
+			// Resume once the freeze is cleared.
+			nsIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{})
+			_ = nsIndexer.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+			dc.nsLister = corelisters.NewNamespaceLister(nsIndexer)
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 0 || replicas["demo:v2"] != 2 {
+				t.Fatalf("expect resumed after unfreezing, got %v", replicas)
+			}
+		})
+	}
+}
+
+func TestDeploymentsInNamespace(t *testing.T) {
+	controlled := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+	controlled.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+	controlled.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+	controlled.Spec.Paused = true
+	native := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+	native.Name = "native"
+	other := controlled.DeepCopy()
+	other.Namespace = "other"
+
+	reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(controlled, native, other).Build()
+	requests := deploymentsInNamespace(reader)(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	if len(requests) != 1 || requests[0].Namespace != "default" || requests[0].Name != controlled.Name {
+		t.Fatalf("expect only controlled deployment in namespace enqueued, got %v", requests)
+	}
+}
+
+func TestSyncDeploymentPausedByAnnotation(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+	}
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	dc, client, recorder := newTestController(strategy, d, oldRS)
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 7 || replicas["demo:v2"] != 3 {
+		t.Fatalf("expect rolled to partition, got %v", replicas)
+	}
+	collectEvents(recorder)
+
+	// Nothing is written while paused, even if the partition is raised.
+	strategy.Partition = intstr.FromString("50%")
+	newController := func(paused bool) *DeploymentController {
+		controlled := d.DeepCopy()
+		controlled.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+		controlled.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":0},"partition":"50%"}`
+		controlled.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+		controlled.Spec.Paused = true
+		if paused {
+			controlled.Annotations[rolloutsv1alpha1.DeploymentPausedAnnotation] = "true"
+		}
+		controller, err := (*controllerFactory)(dc).NewController(controlled)
+		if err != nil || controller == nil {
+			t.Fatalf("expect controller created, got %v, %v", controller, err)
+		}
+		controller.strategy = strategy
+		return controller
+	}
+	// The event is only emitted once it becomes paused, not on every requeue.
+	dc.pausedByAnnos = newUIDTracker()
+	syncPaused := func(expectEvent bool) {
+		paused := newController(true)
+		client.ClearActions()
+		if err := paused.syncDeployment(context.TODO(), d); err != nil {
+			t.Fatalf("failed to sync deployment: %v", err)
+		}
+		for _, action := range client.Actions() {
+			if action.GetVerb() != "get" && action.GetVerb() != "list" && action.GetVerb() != "watch" {
+				t.Fatalf("expect nothing written while paused, got %v", action)
+			}
+		}
+		if paused.requeueAfter <= 0 {
+			t.Fatalf("expect requeued while paused")
+		}
+		if event := hasEvent(collectEvents(recorder), DeploymentPausedReason); event != expectEvent {
+			t.Fatalf("expect %s event %v, got %v", DeploymentPausedReason, expectEvent, event)
+		}
+	}
+	syncPaused(true)
+	syncPaused(false)
+	syncPaused(false)
+
+	// Resume from the current partition once the annotation is cleared.
+	dc = newController(false)
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 5 || replicas["demo:v2"] != 5 {
+		t.Fatalf("expect resumed to partition, got %v", replicas)
+	}
+
+	// The event is emitted again once it is paused again.
+	syncPaused(true)
+}
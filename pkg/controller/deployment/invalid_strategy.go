@@ -0,0 +1,99 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// InvalidStrategyAnnotationReason is added to a deployment whose strategy annotation is not
+	// a valid JSON of the strategy.
+	InvalidStrategyAnnotationReason = "InvalidStrategyAnnotation"
+	// InvalidRollingUpdateReason is added to a deployment whose strategy has invalid maxSurge or
+	// maxUnavailable, e.g. both of them are 0.
+	InvalidRollingUpdateReason = "InvalidRollingUpdate"
+	// InvalidPartitionReason is added to a deployment whose strategy has invalid partition.
+	InvalidPartitionReason = "InvalidPartition"
+	// InvalidStrategyReason is added to a deployment whose strategy has other invalid fields.
+	InvalidStrategyReason = "InvalidStrategy"
+)
+
+// invalidStrategyReason returns the event reason of the validation error of strategy.
+func invalidStrategyReason(err *field.Error, fldPath *field.Path) string {
+	switch {
+	case strings.HasPrefix(err.Field, fldPath.Child("rollingUpdate").String()):
+		return InvalidRollingUpdateReason
+	case err.Field == fldPath.Child("partition").String():
+		return InvalidPartitionReason
+	default:
+		return InvalidStrategyReason
+	}
+}
+
+// reportInvalidStrategy records an event of each reason of errList, and sets the InvalidRolloutStrategy
+// condition of the deployment with the first reason, so that the invalid strategy can be found via
+// `kubectl describe`. The condition is removed once the strategy is fixed, see calculateStatus.
+func (f *controllerFactory) reportInvalidStrategy(deployment *apps.Deployment, errList field.ErrorList, fldPath *field.Path) {
+	var reasons []string
+	messages := map[string][]string{}
+	for _, err := range errList {
+		reason := invalidStrategyReason(err, fldPath)
+		if _, ok := messages[reason]; !ok {
+			reasons = append(reasons, reason)
+		}
+		messages[reason] = append(messages[reason], err.Error())
+	}
+	for _, reason := range reasons {
+		f.eventRecorder.Eventf(deployment, v1.EventTypeWarning, reason, "Invalid strategy annotation: %s", strings.Join(messages[reason], "; "))
+	}
+	f.setInvalidStrategyCondition(deployment, reasons[0], errList.ToAggregate().Error())
+}
+
+// reportMalformedStrategy records an event with the JSON error of the strategy annotation, and sets
+// the InvalidRolloutStrategy condition of the deployment.
+func (f *controllerFactory) reportMalformedStrategy(deployment *apps.Deployment, err error) {
+	f.eventRecorder.Eventf(deployment, v1.EventTypeWarning, InvalidStrategyAnnotationReason, "Failed to parse strategy annotation: %v", err)
+	f.setInvalidStrategyCondition(deployment, InvalidStrategyAnnotationReason, err.Error())
+}
+
+// setInvalidStrategyCondition sets the InvalidRolloutStrategy condition of the deployment, the status
+// is not updated if the condition is not changed. Failures are only logged, since the deployment is
+// retried anyway until its strategy is fixed.
+func (f *controllerFactory) setInvalidStrategyCondition(deployment *apps.Deployment, reason, message string) {
+	newStatus := deployment.Status.DeepCopy()
+	condition := deploymentutil.NewDeploymentCondition(deploymentutil.InvalidRolloutStrategy, v1.ConditionTrue, reason, message, f.clock.Now())
+	deploymentutil.SetDeploymentConditionIfChanged(newStatus, *condition)
+	if reflect.DeepEqual(deployment.Status.Conditions, newStatus.Conditions) {
+		return
+	}
+	d := deployment.DeepCopy()
+	d.Status = *newStatus
+	if _, err := f.client.AppsV1().Deployments(d.Namespace).UpdateStatus(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("Failed to set %s condition of deployment %v: %v", deploymentutil.InvalidRolloutStrategy, klog.KObj(d), err)
+	}
+}
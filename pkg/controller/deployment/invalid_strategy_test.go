@@ -0,0 +1,47 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncDeploymentRemovesInvalidStrategyCondition(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	condition := deploymentutil.NewDeploymentCondition(deploymentutil.InvalidRolloutStrategy, v1.ConditionTrue,
+		InvalidStrategyAnnotationReason, "unexpected end of JSON input", time.Now())
+	d.Status.Conditions = append(d.Status.Conditions, *condition)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromInt(3),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	if cond := deploymentutil.GetDeploymentCondition(d.Status, deploymentutil.InvalidRolloutStrategy); cond != nil {
+		t.Fatalf("expect %s condition removed once the strategy is fixed, got %+v", deploymentutil.InvalidRolloutStrategy, cond)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// WaitingForApprovalReason is the reason of the WaitingForApproval condition, and of the event
+	// emitted once a deployment starts waiting at a manual partition.
+	WaitingForApprovalReason = "WaitingForApproval"
+	// PartitionApprovedReason is added in a deployment event once its manual partition is approved.
+	PartitionApprovedReason = "PartitionApproved"
+)
+
+// isManualPartition returns true if the partition of strategy is one of its manual partitions.
+func (dc *DeploymentController) isManualPartition() bool {
+	for _, partition := range dc.strategy.ManualPartitions {
+		if partition.String() == dc.strategy.Partition.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// holdForApproval returns true if the deployment should be held where it is, because its
+// partition is a manual one not yet approved for the current revision, and requeues it in case
+// the approval is missed. The approval annotation matching the partition is taken and removed,
+// unless in dry-run mode, and the new replica set already scaled to the partition is never held.
+// The revision is computed from the template, so that the approval taken before the new replica
+// set is created applies to it.
+func (dc *DeploymentController) holdForApproval(ctx context.Context, d *apps.Deployment, rsList []*apps.ReplicaSet) (bool, error) {
+	if !dc.isManualPartition() {
+		return false, nil
+	}
+	partition := dc.strategy.Partition.String()
+	newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+	revision := deploymentutil.ComputeTemplateHash(&d.Spec.Template, d.Status.CollisionCount)
+	if prev := getExtraStatus(d); prev != nil && prev.ApprovedPartition == partition && prev.ApprovedRevision == revision {
+		dc.approvedPartition, dc.approvedRevision = partition, revision
+		return false, nil
+	}
+
+	if d.Annotations[rolloutsv1alpha1.DeploymentApprovePartitionAnnotation] == partition {
+		if !dc.dryRun {
+			body, _ := json.Marshal(map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{rolloutsv1alpha1.DeploymentApprovePartitionAnnotation: nil},
+				},
+			})
+			if _, err := dc.client.AppsV1().Deployments(d.Namespace).Patch(ctx, d.Name, types.MergePatchType, body, metav1.PatchOptions{}); err != nil {
+				return false, err
+			}
+			// Keep the copy in sync as well, so that the later status updates do not bring it back.
+			delete(d.Annotations, rolloutsv1alpha1.DeploymentApprovePartitionAnnotation)
+		}
+		dc.approvedPartition, dc.approvedRevision = partition, revision
+		dc.log().Info("Manual partition is approved", "partition", partition, "revision", revision)
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, PartitionApprovedReason, "Partition %s of revision %s is approved", partition, revision)
+		return false, nil
+	}
+
+	if newRS != nil && *newRS.Spec.Replicas >= deploymentutil.NewRSReplicasLimit(dc.strategy.Partition, d) {
+		return false, nil
+	}
+	if cond := deploymentutil.GetDeploymentCondition(d.Status, deploymentutil.WaitingForApproval); cond == nil {
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, WaitingForApprovalReason,
+			"Waiting for partition %s to be approved via annotation %s", partition, rolloutsv1alpha1.DeploymentApprovePartitionAnnotation)
+	}
+	dc.log().V(3).Info("Manual partition is not approved yet, hold the rolling", "partition", partition, "revision", revision)
+	dc.waitingApproval = true
+	dc.enqueueAfter(d, pausedRecheckInterval)
+	return true, nil
+}
+
+// setWaitingForApprovalCondition sets the WaitingForApproval condition of status while the
+// deployment is held for approval in this sync, and removes it otherwise.
+func (dc *DeploymentController) setWaitingForApprovalCondition(status *apps.DeploymentStatus) {
+	if !dc.waitingApproval {
+		deploymentutil.RemoveDeploymentCondition(status, deploymentutil.WaitingForApproval)
+		return
+	}
+	if cond := deploymentutil.GetDeploymentCondition(*status, deploymentutil.WaitingForApproval); cond != nil {
+		return
+	}
+	message := fmt.Sprintf("Waiting for partition %s to be approved via annotation %s", dc.strategy.Partition.String(), rolloutsv1alpha1.DeploymentApprovePartitionAnnotation)
+	condition := deploymentutil.NewDeploymentCondition(deploymentutil.WaitingForApproval, v1.ConditionTrue, WaitingForApprovalReason, message, dc.clock.Now())
+	deploymentutil.SetDeploymentCondition(status, *condition)
+}
+
+// syncApprovedPartition records the manual partition approved in this sync along with its revision,
+// or carries the approval over from the previous extra status for the revision of the template.
+func (dc *DeploymentController) syncApprovedPartition(d *apps.Deployment, prev, cur *rolloutsv1alpha1.DeploymentExtraStatus) {
+	cur.ApprovedPartition, cur.ApprovedRevision = dc.approvedPartition, dc.approvedRevision
+	if cur.ApprovedPartition != "" || prev == nil || prev.ApprovedPartition == "" {
+		return
+	}
+	if prev.ApprovedRevision == deploymentutil.ComputeTemplateHash(&d.Spec.Template, d.Status.CollisionCount) {
+		cur.ApprovedPartition, cur.ApprovedRevision = prev.ApprovedPartition, prev.ApprovedRevision
+	}
+}
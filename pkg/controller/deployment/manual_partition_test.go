@@ -0,0 +1,201 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncDeploymentWaitsForApproval(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(0), intstr.FromInt(1))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:     rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate:    d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:        intstr.FromString("30%"),
+		ManualPartitions: []intstr.IntOrString{intstr.FromString("30%"), intstr.FromInt(8)},
+	}
+	dc, client, recorder := newTestController(strategy, d, oldRS)
+	syncTimes := func(times int) {
+		for i := 0; i < times; i++ {
+			dc.waitingApproval, dc.approvedPartition, dc.approvedRevision, dc.requeueAfter = false, "", "", 0
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		}
+	}
+	expectRolling := func(step string, expectReplicas map[string]int32, expectWaiting bool) {
+		if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+			t.Fatalf("%s: expect replicas %v, got %v", step, expectReplicas, replicas)
+		}
+		cond := deploymentutil.GetDeploymentCondition(d.Status, deploymentutil.WaitingForApproval)
+		if waiting := cond != nil; waiting != expectWaiting {
+			t.Fatalf("%s: expect %s condition %v, got %+v", step, deploymentutil.WaitingForApproval, expectWaiting, cond)
+		}
+		if expectWaiting && dc.requeueAfter == 0 {
+			t.Fatalf("%s: expect requeue while waiting for approval", step)
+		}
+	}
+	setAnnotation := func(value string) {
+		latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get deployment: %v", err)
+		}
+		latest.Annotations[rolloutsv1alpha1.DeploymentApprovePartitionAnnotation] = value
+		if _, err = client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update deployment: %v", err)
+		}
+	}
+
+	// The first step waits for a human, and the approval of another partition changes nothing.
+	syncTimes(3)
+	expectRolling("waiting", map[string]int32{"demo:v1": 10}, true)
+	if !hasEvent(collectEvents(recorder), WaitingForApprovalReason) {
+		t.Fatalf("expect %s event", WaitingForApprovalReason)
+	}
+	setAnnotation("50%")
+	syncTimes(2)
+	expectRolling("approved another partition", map[string]int32{"demo:v1": 10}, true)
+
+	// The approval of the current partition is taken and removed.
+	setAnnotation("30%")
+	syncTimes(6)
+	expectRolling("approved", map[string]int32{"demo:v1": 7, "demo:v2": 3}, false)
+	if !hasEvent(collectEvents(recorder), PartitionApprovedReason) {
+		t.Fatalf("expect %s event", PartitionApprovedReason)
+	}
+	if _, ok := d.Annotations[rolloutsv1alpha1.DeploymentApprovePartitionAnnotation]; ok {
+		t.Fatalf("expect approval annotation removed, got %v", d.Annotations)
+	}
+	revision := deploymentutil.ComputeTemplateHash(&d.Spec.Template, d.Status.CollisionCount)
+	if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.ApprovedPartition != "30%" || extraStatus.ApprovedRevision != revision {
+		t.Fatalf("expect approved partition 30%% of revision %s recorded, got %+v", revision, extraStatus)
+	}
+
+	// The partitions not manual advance as usual, until the next manual one.
+	dc.strategy.Partition = intstr.FromString("50%")
+	syncTimes(6)
+	expectRolling("not manual", map[string]int32{"demo:v1": 5, "demo:v2": 5}, false)
+	dc.strategy.Partition = intstr.FromInt(8)
+	syncTimes(3)
+	expectRolling("next manual partition", map[string]int32{"demo:v1": 5, "demo:v2": 5}, true)
+	setAnnotation("8")
+	syncTimes(6)
+	expectRolling("next manual partition approved", map[string]int32{"demo:v1": 2, "demo:v2": 8}, false)
+}
+
+func TestHoldForApprovalKeepsReachedPartition(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:     rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate:    d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:        intstr.FromString("30%"),
+		ManualPartitions: []intstr.IntOrString{intstr.FromString("30%")},
+	}
+	dc, _, _ := newTestController(strategy, d, oldRS, newRS)
+	held, err := dc.holdForApproval(context.TODO(), d, []*apps.ReplicaSet{oldRS, newRS})
+	if err != nil {
+		t.Fatalf("failed to check approval: %v", err)
+	}
+	if held || dc.waitingApproval {
+		t.Fatalf("expect the new replica set already at the manual partition not held")
+	}
+}
+
+func TestHoldForApprovalOfRevision(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	revision := deploymentutil.ComputeTemplateHash(&d.Spec.Template, d.Status.CollisionCount)
+	cases := []struct {
+		name             string
+		approvedRevision string
+		annotation       bool
+		dryRun           bool
+		expectHeld       bool
+		expectPatched    bool
+	}{
+		{
+			name:             "approved for the revision of template",
+			approvedRevision: revision,
+		},
+		{
+			name:             "approved for another revision",
+			approvedRevision: "other",
+			expectHeld:       true,
+		},
+		{
+			name:          "approved via annotation",
+			annotation:    true,
+			expectPatched: true,
+		},
+		{
+			name:       "approved via annotation in dry-run mode",
+			annotation: true,
+			dryRun:     true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := d.DeepCopy()
+			if cs.approvedRevision != "" {
+				extraStatus, _ := json.Marshal(&rolloutsv1alpha1.DeploymentExtraStatus{ApprovedPartition: "30%", ApprovedRevision: cs.approvedRevision})
+				d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] = string(extraStatus)
+			}
+			if cs.annotation {
+				d.Annotations[rolloutsv1alpha1.DeploymentApprovePartitionAnnotation] = "30%"
+			}
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:     rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:    d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:        intstr.FromString("30%"),
+				ManualPartitions: []intstr.IntOrString{intstr.FromString("30%")},
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+			dc.dryRun = cs.dryRun
+			client.ClearActions()
+
+			held, err := dc.holdForApproval(context.TODO(), d, []*apps.ReplicaSet{oldRS})
+			if err != nil {
+				t.Fatalf("failed to check approval: %v", err)
+			}
+			if held != cs.expectHeld {
+				t.Fatalf("expect held %v, got %v", cs.expectHeld, held)
+			}
+			if !held && (dc.approvedPartition != "30%" || dc.approvedRevision != revision) {
+				t.Fatalf("expect partition 30%% approved for revision %s, got %q of %q", revision, dc.approvedPartition, dc.approvedRevision)
+			}
+			patched := false
+			for _, action := range client.Actions() {
+				patched = patched || action.GetVerb() == "patch"
+			}
+			if patched != cs.expectPatched {
+				t.Fatalf("expect approval annotation patched %v, got actions %v", cs.expectPatched, client.Actions())
+			}
+		})
+	}
+}
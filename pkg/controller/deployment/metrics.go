@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/integer"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// slaBreachTypes are the values of the type label of the SLA breach metrics.
+var slaBreachTypes = []string{"batch", "rollout"}
+
+// The values of the result label of reconcileTotal.
+const (
+	reconcileSuccess = "success"
+	reconcileError   = "error"
+	reconcileSkip    = "skip"
+)
+
+var (
+	// reconcileTotal counts the reconciles by result, a reconcile is skipped if the deployment is
+	// gone or not processed by us.
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "advanced_deployment_reconcile_total",
+		Help: "Number of reconciles of advanced deployments by result.",
+	}, []string{"result"})
+	// syncDuration is the duration of syncing each advanced deployment.
+	syncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "advanced_deployment_sync_duration_seconds",
+		Help:    "Duration in seconds of syncing advanced deployments.",
+		Buckets: prometheus.DefBuckets,
+	})
+	// slaBreachTotal counts how many times batches or rollouts exceeded their SLA.
+	slaBreachTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "advanced_deployment_sla_breach_total",
+		Help: "Number of times that batches or rollouts of advanced deployment exceeded their SLA.",
+	}, []string{"type"})
+	// leader is 1 on the replica which reconciles advanced deployments, i.e. the leader if
+	// leader election is enabled, and 0 on the others.
+	leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "advanced_deployment_leader",
+		Help: "Whether this replica is the one reconciling advanced deployments, 1 if it is and 0 otherwise.",
+	})
+
+	// The metrics below are labeled by deployment, whose series grow with the deployments in
+	// the cluster, so they are only recorded if perDeploymentMetrics is enabled.
+
+	// deploymentSLABreachTotal counts how many times batches or rollouts of each deployment
+	// exceeded their SLA.
+	deploymentSLABreachTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "advanced_deployment_deployment_sla_breach_total",
+		Help: "Number of times that batches or rollouts of each advanced deployment exceeded their SLA.",
+	}, []string{"namespace", "name", "type"})
+	// deploymentExpectedUpdatedReplicas is the expected updated replicas of each deployment.
+	deploymentExpectedUpdatedReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "advanced_deployment_expected_updated_replicas",
+		Help: "Number of pods expected to be updated of each advanced deployment in the current batch.",
+	}, []string{"namespace", "name"})
+	// deploymentUpdatedReadyReplicas is the updated ready replicas of each deployment.
+	deploymentUpdatedReadyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "advanced_deployment_updated_ready_replicas",
+		Help: "Number of pods updated and ready of each advanced deployment.",
+	}, []string{"namespace", "name"})
+	// deploymentCurrentPartition is the partition of each deployment in replicas.
+	deploymentCurrentPartition = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "advanced_deployment_current_partition",
+		Help: "Number of pods allowed to be updated by the partition of each advanced deployment.",
+	}, []string{"namespace", "name"})
+	// deploymentPartitionSkew is how many pods each deployment is behind its current batch, i.e.
+	// the expected updated replicas which are not updated and ready yet.
+	deploymentPartitionSkew = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "advanced_deployment_partition_skew",
+		Help: "Number of pods expected to be updated in the current batch of each advanced deployment but not updated and ready yet.",
+	}, []string{"namespace", "name"})
+	// deploymentPartitionDuration is how long each deployment has been in its current batch.
+	deploymentPartitionDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "advanced_deployment_current_partition_seconds",
+		Help: "Duration in seconds since each advanced deployment started rolling to its current batch.",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileTotal, syncDuration, slaBreachTotal, leader,
+		deploymentSLABreachTotal, deploymentExpectedUpdatedReplicas, deploymentUpdatedReadyReplicas, deploymentCurrentPartition,
+		deploymentPartitionSkew, deploymentPartitionDuration)
+}
+
+// recordLeadership sets the leader gauge until ctx is done. It is run by the manager as a
+// runnable, which is only started once this replica is elected if leader election is enabled.
+func recordLeadership(ctx context.Context) error {
+	klog.Infof("Advanced deployment controller is leading, start reconciling")
+	leader.Set(1)
+	<-ctx.Done()
+	leader.Set(0)
+	klog.Infof("Advanced deployment controller stopped leading")
+	return nil
+}
+
+// recordSLABreach counts a breach of the SLA of the given type, for the deployment as well if
+// perDeploymentMetrics is enabled.
+func recordSLABreach(d *apps.Deployment, slaType string) {
+	slaBreachTotal.WithLabelValues(slaType).Inc()
+	if perDeploymentMetrics {
+		deploymentSLABreachTotal.WithLabelValues(d.Namespace, d.Name, slaType).Inc()
+	}
+}
+
+// recordRolloutMetrics exposes the partition in replicas and the progress in the extra status of
+// deployment, only if perDeploymentMetrics is enabled. The skew drops to zero once the current
+// batch is updated and ready, including the last one of a completed rollout, and the duration is
+// zero until the deployment starts rolling to a revision.
+func recordRolloutMetrics(d *apps.Deployment, partition int32, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus, now time.Time) {
+	if !perDeploymentMetrics {
+		return
+	}
+	deploymentCurrentPartition.WithLabelValues(d.Namespace, d.Name).Set(float64(partition))
+	deploymentExpectedUpdatedReplicas.WithLabelValues(d.Namespace, d.Name).Set(float64(extraStatus.ExpectedUpdatedReplicas))
+	deploymentUpdatedReadyReplicas.WithLabelValues(d.Namespace, d.Name).Set(float64(extraStatus.UpdatedReadyReplicas))
+	skew := integer.Int32Max(extraStatus.ExpectedUpdatedReplicas-extraStatus.UpdatedReadyReplicas, 0)
+	deploymentPartitionSkew.WithLabelValues(d.Namespace, d.Name).Set(float64(skew))
+	duration := time.Duration(0)
+	if extraStatus.BatchStartTime != nil {
+		duration = now.Sub(extraStatus.BatchStartTime.Time)
+	}
+	deploymentPartitionDuration.WithLabelValues(d.Namespace, d.Name).Set(duration.Seconds())
+}
+
+// forgetDeploymentMetrics deletes all series of the deployment, once it is deleted or no longer
+// under rollout control, so that the series of the gone deployments are not left behind. They are
+// deleted even if perDeploymentMetrics is disabled, in case it was enabled before restarting.
+func forgetDeploymentMetrics(namespace, name string) {
+	for _, slaType := range slaBreachTypes {
+		deploymentSLABreachTotal.DeleteLabelValues(namespace, name, slaType)
+	}
+	deploymentExpectedUpdatedReplicas.DeleteLabelValues(namespace, name)
+	deploymentUpdatedReadyReplicas.DeleteLabelValues(namespace, name)
+	deploymentCurrentPartition.DeleteLabelValues(namespace, name)
+	deploymentPartitionSkew.DeleteLabelValues(namespace, name)
+	deploymentPartitionDuration.DeleteLabelValues(namespace, name)
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	testingclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestDeploymentMetrics(t *testing.T) {
+	cases := []struct {
+		name         string
+		perMetrics   bool
+		expectSeries int
+	}{
+		{
+			name: "aggregate only by default",
+		},
+		{
+			name:         "labeled by deployment",
+			perMetrics:   true,
+			expectSeries: 1,
+		},
+	}
+
+	countSeries := func() map[string]int {
+		return map[string]int{
+			"sla breach":        testutil.CollectAndCount(deploymentSLABreachTotal),
+			"expected updated":  testutil.CollectAndCount(deploymentExpectedUpdatedReplicas),
+			"updated and ready": testutil.CollectAndCount(deploymentUpdatedReadyReplicas),
+			"partition":         testutil.CollectAndCount(deploymentCurrentPartition),
+			"partition skew":    testutil.CollectAndCount(deploymentPartitionSkew),
+			"partition seconds": testutil.CollectAndCount(deploymentPartitionDuration),
+		}
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(enabled bool) { perDeploymentMetrics = enabled }(perDeploymentMetrics)
+			perDeploymentMetrics = cs.perMetrics
+
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+			defer forgetDeploymentMetrics(d.Namespace, d.Name)
+
+			breaches := testutil.ToFloat64(slaBreachTotal.WithLabelValues("batch"))
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			recordSLABreach(d, "batch")
+			if increased := testutil.ToFloat64(slaBreachTotal.WithLabelValues("batch")) - breaches; increased != 1 {
+				t.Fatalf("expect aggregate breaches increased by 1, got %v", increased)
+			}
+			for metric, count := range countSeries() {
+				if count != cs.expectSeries {
+					t.Fatalf("expect %d series of %s metric, got %d", cs.expectSeries, metric, count)
+				}
+			}
+			if !cs.perMetrics {
+				return
+			}
+			for metric, expect := range map[*prometheus.GaugeVec]float64{deploymentExpectedUpdatedReplicas: 5, deploymentUpdatedReadyReplicas: 5, deploymentCurrentPartition: 5} {
+				if value := testutil.ToFloat64(metric.WithLabelValues(d.Namespace, d.Name)); value != expect {
+					t.Fatalf("expect %v replicas, got %v", expect, value)
+				}
+			}
+
+			// The series are cleaned up once the deployment is deleted.
+			r := &ReconcileDeployment{
+				Client:            fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build(),
+				controllerFactory: (*controllerFactory)(dc),
+			}
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}}
+			if _, err := r.Reconcile(context.TODO(), request); err != nil {
+				t.Fatalf("failed to reconcile deleted deployment: %v", err)
+			}
+			for metric, count := range countSeries() {
+				if count != 0 {
+					t.Fatalf("expect series of %s metric cleaned up, got %d", metric, count)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionSkewMetrics(t *testing.T) {
+	defer func(enabled bool) { perDeploymentMetrics = enabled }(perDeploymentMetrics)
+	perDeploymentMetrics = true
+
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(5))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+	defer forgetDeploymentMetrics(d.Namespace, d.Name)
+	clock := dc.clock.(*testingclock.FakeClock)
+	skew := func() float64 {
+		return testutil.ToFloat64(deploymentPartitionSkew.WithLabelValues(d.Namespace, d.Name))
+	}
+	seconds := func() float64 {
+		return testutil.ToFloat64(deploymentPartitionDuration.WithLabelValues(d.Namespace, d.Name))
+	}
+
+	// Only 2 of the new pods become ready, so the batch stays behind.
+	for i := 0; i < 3; i++ {
+		syncAndSettleNewReplicaSet(t, dc, client, d.Namespace, d.Name, 2)
+	}
+	clock.Step(5 * time.Minute)
+	syncAndSettleNewReplicaSet(t, dc, client, d.Namespace, d.Name, 2)
+	if value := skew(); value != 3 {
+		t.Fatalf("expect partition skew 3, got %v", value)
+	}
+	if value := seconds(); value < 300 {
+		t.Fatalf("expect at least 300 seconds in the current partition, got %v", value)
+	}
+
+	// The skew drops to zero once the batch is complete.
+	for i := 0; i < 3; i++ {
+		syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if value := skew(); value != 0 {
+		t.Fatalf("expect partition skew 0 once the batch is complete, got %v", value)
+	}
+
+	// The series are removed once the deployment leaves rollout control.
+	forgetDeploymentMetrics(d.Namespace, d.Name)
+	if count := testutil.CollectAndCount(deploymentPartitionSkew) + testutil.CollectAndCount(deploymentPartitionDuration); count != 0 {
+		t.Fatalf("expect series of partition skew cleaned up, got %d", count)
+	}
+}
+
+// syncDurationSamples returns how many syncs are observed by syncDuration.
+func syncDurationSamples(t *testing.T) uint64 {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "advanced_deployment_sync_duration_seconds" {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestRecordLeadership(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = recordLeadership(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(leader) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect leader gauge 1 once started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if value := testutil.ToFloat64(leader); value != 0 {
+		t.Fatalf("expect leader gauge 0 once stopped, got %v", value)
+	}
+}
+
+func TestReconcileMetrics(t *testing.T) {
+	defer func(enabled bool) { perDeploymentMetrics = enabled }(perDeploymentMetrics)
+	perDeploymentMetrics = true
+
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+	d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":0},"partition":"30%"}`
+	d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+	d.Spec.Paused = true
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS)
+	defer forgetDeploymentMetrics(d.Namespace, d.Name)
+	reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(d.DeepCopy()).Build()
+	r := &ReconcileDeployment{Client: reader, controllerFactory: (*controllerFactory)(dc)}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}}
+	updateDeployment := func(update func(d *apps.Deployment)) {
+		latest := &apps.Deployment{}
+		if err := reader.Get(context.TODO(), request.NamespacedName, latest); err != nil {
+			t.Fatalf("failed to get deployment: %v", err)
+		}
+		update(latest)
+		if err := reader.Update(context.TODO(), latest); err != nil {
+			t.Fatalf("failed to update deployment: %v", err)
+		}
+	}
+
+	steps := []struct {
+		name            string
+		update          func()
+		expectResult    string
+		expectSynced    bool
+		expectPartition bool
+	}{
+		{
+			name:            "synced",
+			expectResult:    reconcileSuccess,
+			expectSynced:    true,
+			expectPartition: true,
+		},
+		{
+			name: "malformed strategy",
+			update: func() {
+				updateDeployment(func(d *apps.Deployment) {
+					d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":`
+				})
+			},
+			expectResult:    reconcileError,
+			expectPartition: true,
+		},
+		{
+			name: "not under rollout control",
+			update: func() {
+				updateDeployment(func(d *apps.Deployment) {
+					delete(d.Annotations, util.BatchReleaseControlAnnotation)
+				})
+			},
+			expectResult: reconcileSkip,
+		},
+		{
+			name: "deleted",
+			update: func() {
+				if err := reader.Delete(context.TODO(), d.DeepCopy()); err != nil {
+					t.Fatalf("failed to delete deployment: %v", err)
+				}
+			},
+			expectResult: reconcileSkip,
+		},
+	}
+	for _, step := range steps {
+		if step.update != nil {
+			step.update()
+		}
+		results := map[string]float64{}
+		for _, result := range []string{reconcileSuccess, reconcileError, reconcileSkip} {
+			results[result] = testutil.ToFloat64(reconcileTotal.WithLabelValues(result))
+		}
+		samples := syncDurationSamples(t)
+
+		if _, err := r.Reconcile(context.TODO(), request); err != nil {
+			t.Fatalf("%s: failed to reconcile: %v", step.name, err)
+		}
+		for result, before := range results {
+			expect := before
+			if result == step.expectResult {
+				expect++
+			}
+			if value := testutil.ToFloat64(reconcileTotal.WithLabelValues(result)); value != expect {
+				t.Fatalf("%s: expect %v reconciles of result %s, got %v", step.name, expect, result, value)
+			}
+		}
+		if synced := syncDurationSamples(t) == samples+1; synced != step.expectSynced {
+			t.Fatalf("%s: expect sync duration observed %v, got %v", step.name, step.expectSynced, synced)
+		}
+		if count := testutil.CollectAndCount(deploymentCurrentPartition); (count == 1) != step.expectPartition {
+			t.Fatalf("%s: expect partition series %v, got %d series", step.name, step.expectPartition, count)
+		}
+		if step.expectPartition {
+			if value := testutil.ToFloat64(deploymentCurrentPartition.WithLabelValues(d.Namespace, d.Name)); value != 3 {
+				t.Fatalf("%s: expect partition of 3 replicas, got %v", step.name, value)
+			}
+		}
+	}
+}
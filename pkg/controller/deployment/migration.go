@@ -0,0 +1,87 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// migrateExtraStatus upgrades the extra status of older schema versions to the current one and
+// writes it back, and returns a copy of deployment with the upgraded annotation, or nil if no
+// migration is needed. The extra status without schema version lacks the fields to track the
+// rollout in progress, so they are filled from the new replica set:
+//   - updateRevision is the revision of the new replica set, or the rollout will be restarted.
+//   - rolloutStartTime is when the new replica set was created, batchStartTime is unknown and
+//     starts from now.
+//   - the batch in progress is regarded as soaked and paused, which was never held by older controllers.
+func (dc *DeploymentController) migrateExtraStatus(ctx context.Context, deployment *apps.Deployment, rsList []*apps.ReplicaSet) (*apps.Deployment, error) {
+	if !migrateExtraStatus {
+		return nil, nil
+	}
+	extraStatus := getExtraStatus(deployment)
+	if extraStatus == nil || extraStatus.SchemaVersion >= rolloutsv1alpha1.DeploymentExtraStatusSchemaVersion {
+		return nil, nil
+	}
+
+	from := extraStatus.SchemaVersion
+	if newRS := deploymentutil.FindNewReplicaSet(deployment, rsList); newRS != nil {
+		if extraStatus.UpdateRevision == "" {
+			extraStatus.UpdateRevision = newRS.Labels[deploymentutil.TemplateHashLabelKey]
+		}
+		if extraStatus.RolloutStartTime == nil {
+			rolloutStart := newRS.CreationTimestamp
+			extraStatus.RolloutStartTime = &rolloutStart
+		}
+		if extraStatus.BatchStartTime == nil {
+			batchStart := metav1.NewTime(dc.clock.Now())
+			extraStatus.BatchStartTime = &batchStart
+		}
+	}
+	extraStatus.BatchSoaked = true
+	extraStatus.BatchPauseElapsed = true
+	extraStatus.BatchChecksPassed = true
+	extraStatus.SchemaVersion = rolloutsv1alpha1.DeploymentExtraStatusSchemaVersion
+
+	extraStatusByte, err := marshalExtraStatus(extraStatus, extraStatusMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{rolloutsv1alpha1.DeploymentExtraStatusAnnotation: string(extraStatusByte)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dc.client.AppsV1().Deployments(deployment.Namespace).Patch(ctx, deployment.Name, types.MergePatchType, body, metav1.PatchOptions{}); err != nil {
+		return nil, err
+	}
+	dc.log().Info("Migrated extra status", "from", from, "to", extraStatus.SchemaVersion)
+
+	migrated := deployment.DeepCopy()
+	migrated.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] = string(extraStatusByte)
+	return migrated, nil
+}
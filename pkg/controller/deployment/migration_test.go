@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentMigratesExtraStatus(t *testing.T) {
+	// The extra status written by the controllers without schema version.
+	const oldExtraStatus = `{"observedGeneration":1,"updatedReadyReplicas":3,"expectedUpdatedReplicas":3,"trafficWeight":30}`
+
+	cases := []struct {
+		name         string
+		migrate      bool
+		expectNew    int32
+		expectEvents bool
+	}{
+		{
+			name:      "migrated on first reconcile",
+			migrate:   true,
+			expectNew: 4,
+		},
+		{
+			name:         "regarded as a new rollout without migration",
+			expectNew:    3,
+			expectEvents: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(migrate bool) { migrateExtraStatus = migrate }(migrateExtraStatus)
+			migrateExtraStatus = cs.migrate
+
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			d.Generation = 1
+			d.Annotations = map[string]string{rolloutsv1alpha1.DeploymentExtraStatusAnnotation: oldExtraStatus}
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			// The rollout was at 30%, and the partition is increased after the upgrade.
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("60%"),
+				BatchSoak:     &rolloutsv1alpha1.DeploymentBatchSoak{Seconds: 600},
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS, newRS)
+
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v2"] != cs.expectNew {
+				t.Fatalf("expect new replica set scaled to %d, got %v", cs.expectNew, replicas)
+			}
+			events := collectEvents(recorder)
+			if restarted := hasEvent(events, RolloutStartedReason) || hasEvent(events, TemplateDiffReason); restarted != cs.expectEvents {
+				t.Fatalf("expect rollout restarted %v, got events %v", cs.expectEvents, events)
+			}
+
+			extraStatus := getExtraStatus(d)
+			if extraStatus.SchemaVersion != rolloutsv1alpha1.DeploymentExtraStatusSchemaVersion || extraStatus.UpdateRevision != "demo-v2" {
+				t.Fatalf("expect extra status of current schema version, got %+v", extraStatus)
+			}
+			if cs.migrate && !extraStatus.RolloutStartTime.Equal(&newRS.CreationTimestamp) {
+				t.Fatalf("expect rollout started when the new replica set was created, got %v", extraStatus.RolloutStartTime)
+			}
+		})
+	}
+
+	t.Run("current schema version is not migrated", func(t *testing.T) {
+		d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+		d.Annotations = map[string]string{rolloutsv1alpha1.DeploymentExtraStatusAnnotation: `{"schemaVersion":1,"expectedUpdatedReplicas":3,"trafficWeight":30,"updateRevision":"demo-v2"}`}
+		newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+		dc, client, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, newRS)
+
+		migrated, err := dc.migrateExtraStatus(context.TODO(), d, nil)
+		if err != nil || migrated != nil {
+			t.Fatalf("expect no migration, got %v, %v", migrated, err)
+		}
+		if actions := client.Actions(); len(actions) != 0 {
+			t.Fatalf("expect no request, got %v", actions)
+		}
+	})
+}
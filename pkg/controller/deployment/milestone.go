@@ -0,0 +1,178 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+const (
+	// RolloutStartedReason is added in a deployment event and milestone when the deployment
+	// starts rolling to a new revision.
+	RolloutStartedReason = "RolloutStarted"
+	// BatchCompletedReason is added in a deployment event and milestone when the updated ready
+	// replicas reach the expected updated replicas of the current batch.
+	BatchCompletedReason = "BatchCompleted"
+	// RolloutCompletedReason is added in a deployment event and milestone when all the replicas
+	// of deployment are updated and ready.
+	RolloutCompletedReason = "RolloutCompleted"
+)
+
+// milestoneConfigMapSuffix is appended to the name of deployment to name the ConfigMap
+// which keeps the milestones of deployment.
+const milestoneConfigMapSuffix = "-rollout-milestones"
+
+// milestoneRecord is a milestone of the rollout of deployment.
+type milestoneRecord struct {
+	Time       time.Time `json:"time"`
+	Generation string    `json:"generation,omitempty"`
+	Revision   string    `json:"revision"`
+	Reason     string    `json:"reason"`
+	Message    string    `json:"message"`
+}
+
+// milestoneRecorder keeps the milestones of rollouts in a ConfigMap owned by each deployment,
+// so that they are retained for ttl, much longer than events. The records that have expired
+// are pruned once a new milestone is recorded. A nil milestoneRecorder discards all records.
+type milestoneRecorder struct {
+	client clientset.Interface
+	ttl    time.Duration
+	clock  clock.PassiveClock
+}
+
+// newMilestoneRecorder returns a milestoneRecorder retaining records for ttl, or nil if ttl
+// is not positive, which disables the milestone records.
+func newMilestoneRecorder(client clientset.Interface, ttl time.Duration, clock clock.PassiveClock) *milestoneRecorder {
+	if ttl <= 0 {
+		return nil
+	}
+	return &milestoneRecorder{client: client, ttl: ttl, clock: clock}
+}
+
+// record writes a milestone of deployment to its ConfigMap, and prunes the expired ones. The
+// ConfigMap is labeled with the rollout generation of the latest milestone, if it is known.
+// Failures are only logged, because the milestones must never block the rollout.
+func (r *milestoneRecorder) record(ctx context.Context, d *apps.Deployment, generation, revision, reason, message string) {
+	if r == nil {
+		return
+	}
+	now := r.clock.Now().UTC()
+	data, err := json.Marshal(&milestoneRecord{Time: now, Generation: generation, Revision: revision, Reason: reason, Message: message})
+	if err != nil {
+		klog.Errorf("Failed to marshal milestone of deployment %v: %v", klog.KObj(d), err)
+		return
+	}
+	// The keys are ordered by time, and the reason tells apart the milestones recorded at once.
+	key := fmt.Sprintf("%s-%s", now.Format("20060102-150405.000000000"), reason)
+
+	configMaps := r.client.CoreV1().ConfigMaps(d.Namespace)
+	cm, err := configMaps.Get(ctx, d.Name+milestoneConfigMapSuffix, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            d.Name + milestoneConfigMapSuffix,
+				Namespace:       d.Namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, controllerKind)},
+			},
+			Data: map[string]string{key: string(data)},
+		}
+		setRolloutGeneration(&cm.ObjectMeta, generation)
+		if _, err = configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			klog.Errorf("Failed to create milestones of deployment %v: %v", klog.KObj(d), err)
+		}
+		return
+	} else if err != nil {
+		klog.Errorf("Failed to get milestones of deployment %v: %v", klog.KObj(d), err)
+		return
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	r.prune(cm.Data, now)
+	cm.Data[key] = string(data)
+	setRolloutGeneration(&cm.ObjectMeta, generation)
+	if _, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("Failed to update milestones of deployment %v: %v", klog.KObj(d), err)
+	}
+}
+
+// setRolloutGeneration labels the object with the rollout generation, unless it is unknown.
+func setRolloutGeneration(meta *metav1.ObjectMeta, generation string) {
+	if generation == "" {
+		return
+	}
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels[rolloutsv1alpha1.RolloutGenerationLabel] = generation
+}
+
+// prune deletes the records which have been kept for longer than ttl or cannot be parsed.
+func (r *milestoneRecorder) prune(data map[string]string, now time.Time) {
+	for key, value := range data {
+		record := &milestoneRecord{}
+		if err := json.Unmarshal([]byte(value), record); err != nil || now.Sub(record.Time) > r.ttl {
+			delete(data, key)
+		}
+	}
+}
+
+// recordMilestones emits events and records milestones once the deployment starts rolling to
+// a new revision, or the updated ready replicas reach the expected ones of the current batch.
+func (dc *DeploymentController) recordMilestones(ctx context.Context, d *apps.Deployment, generation string, prev, cur *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if cur.UpdateRevision == "" {
+		return
+	}
+	sameRevision := prev != nil && prev.UpdateRevision == cur.UpdateRevision
+	if !sameRevision {
+		dc.recordMilestone(ctx, d, generation, cur.UpdateRevision, RolloutStartedReason,
+			fmt.Sprintf("Rollout to revision %s started", cur.UpdateRevision))
+	}
+
+	if cur.ExpectedUpdatedReplicas == 0 || cur.UpdatedReadyReplicas < cur.ExpectedUpdatedReplicas {
+		return
+	}
+	if sameRevision && prev.ExpectedUpdatedReplicas == cur.ExpectedUpdatedReplicas && prev.UpdatedReadyReplicas >= prev.ExpectedUpdatedReplicas {
+		return // already recorded
+	}
+	if cur.ExpectedUpdatedReplicas >= *(d.Spec.Replicas) {
+		dc.recordMilestone(ctx, d, generation, cur.UpdateRevision, RolloutCompletedReason,
+			fmt.Sprintf("Rollout to revision %s completed with %d updated ready replicas", cur.UpdateRevision, cur.UpdatedReadyReplicas))
+		return
+	}
+	dc.recordMilestone(ctx, d, generation, cur.UpdateRevision, BatchCompletedReason,
+		fmt.Sprintf("Batch with %d expected updated replicas of revision %s completed", cur.ExpectedUpdatedReplicas, cur.UpdateRevision))
+}
+
+func (dc *DeploymentController) recordMilestone(ctx context.Context, d *apps.Deployment, generation, revision, reason, message string) {
+	dc.eventRecorder.Event(d, v1.EventTypeNormal, reason, message)
+	dc.milestones.record(ctx, d, generation, revision, reason, message)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// getMilestoneReasons returns the reasons of milestones recorded for deployment, ordered by time.
+func getMilestoneReasons(t *testing.T, client *fake.Clientset, namespace, name string) []string {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name+milestoneConfigMapSuffix, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get milestones: %v", err)
+	}
+	var keys []string
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var reasons []string
+	for _, key := range keys {
+		record := &milestoneRecord{}
+		if err := json.Unmarshal([]byte(cm.Data[key]), record); err != nil {
+			t.Fatalf("failed to unmarshal milestone %s: %v", key, err)
+		}
+		reasons = append(reasons, record.Reason)
+	}
+	return reasons
+}
+
+func TestSyncDeploymentRecordsMilestones(t *testing.T) {
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 4)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+	}
+	dc, client, recorder := newTestController(strategy, d, oldRS)
+	fakeClock := dc.clock.(*testingclock.FakeClock)
+	dc.milestones = newMilestoneRecorder(client, time.Hour, fakeClock)
+
+	for i := 0; i < 20; i++ {
+		fakeClock.Step(time.Second)
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expect := []string{RolloutStartedReason, BatchCompletedReason}
+	if reasons := getMilestoneReasons(t, client, d.Namespace, d.Name); !reflect.DeepEqual(reasons, expect) {
+		t.Fatalf("expect milestones %v, got %v", expect, reasons)
+	}
+
+	dc.strategy.Partition = intstr.FromString("100%")
+	for i := 0; i < 20; i++ {
+		fakeClock.Step(time.Second)
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expect = []string{RolloutStartedReason, BatchCompletedReason, RolloutCompletedReason}
+	if reasons := getMilestoneReasons(t, client, d.Namespace, d.Name); !reflect.DeepEqual(reasons, expect) {
+		t.Fatalf("expect milestones %v, got %v", expect, reasons)
+	}
+	events := collectEvents(recorder)
+	for _, reason := range expect {
+		if !hasEvent(events, reason) {
+			t.Fatalf("expect event %s, got %v", reason, events)
+		}
+	}
+}
+
+func TestSyncDeploymentStampsRolloutGeneration(t *testing.T) {
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 4)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("100%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+	fakeClock := dc.clock.(*testingclock.FakeClock)
+	dc.milestones = newMilestoneRecorder(client, time.Hour, fakeClock)
+
+	var generations []string
+	for _, image := range []string{"demo:v2", "demo:v3"} {
+		d.Spec.Template.Spec.Containers[0].Image = image
+		d.Generation++
+		if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update deployment: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			fakeClock.Step(time.Second)
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		}
+
+		// Only the new replica set of this rollout is selected by the rollout generation.
+		rsList, err := dc.rsLister.ReplicaSets(d.Namespace).List(labels.Everything())
+		if err != nil {
+			t.Fatalf("failed to list replica sets: %v", err)
+		}
+		newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+		if newRS == nil {
+			t.Fatalf("expect new replica set of %s", image)
+		}
+		generation := newRS.Labels[rolloutsv1alpha1.RolloutGenerationLabel]
+		if !strings.HasPrefix(generation, newRS.Labels[apps.DefaultDeploymentUniqueLabelKey]+"-") {
+			t.Fatalf("expect rollout generation prefixed with pod template hash, got %q", generation)
+		}
+		selector := metav1.ListOptions{LabelSelector: rolloutsv1alpha1.RolloutGenerationLabel + "=" + generation}
+		labeled, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), selector)
+		if err != nil {
+			t.Fatalf("failed to list replica sets: %v", err)
+		}
+		if len(labeled.Items) != 1 || labeled.Items[0].Name != newRS.Name {
+			t.Fatalf("expect only %s labeled with rollout generation %s, got %v", newRS.Name, generation, labeled.Items)
+		}
+		if len(newRS.Spec.Template.Labels[rolloutsv1alpha1.RolloutGenerationLabel]) != 0 {
+			t.Fatalf("expect rollout generation not stamped on pods")
+		}
+
+		cm, err := client.CoreV1().ConfigMaps(d.Namespace).Get(context.TODO(), d.Name+milestoneConfigMapSuffix, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get milestones: %v", err)
+		}
+		if cm.Labels[rolloutsv1alpha1.RolloutGenerationLabel] != generation {
+			t.Fatalf("expect milestones labeled with rollout generation %s, got %v", generation, cm.Labels)
+		}
+		generations = append(generations, generation)
+	}
+	if generations[0] == generations[1] {
+		t.Fatalf("expect unique rollout generation for each rollout, got %v", generations)
+	}
+}
+
+func TestMilestoneRecorderPrune(t *testing.T) {
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	client := fake.NewSimpleClientset(d)
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	r := newMilestoneRecorder(client, time.Hour, fakeClock)
+
+	r.record(context.TODO(), d, "", "v1", RolloutStartedReason, "started")
+	fakeClock.Step(30 * time.Minute)
+	r.record(context.TODO(), d, "", "v1", BatchCompletedReason, "batch completed")
+	fakeClock.Step(45 * time.Minute)
+	r.record(context.TODO(), d, "", "v1", RolloutCompletedReason, "completed")
+
+	expect := []string{BatchCompletedReason, RolloutCompletedReason}
+	if reasons := getMilestoneReasons(t, client, d.Namespace, d.Name); !reflect.DeepEqual(reasons, expect) {
+		t.Fatalf("expect expired milestones pruned to %v, got %v", expect, reasons)
+	}
+	if newMilestoneRecorder(client, 0, fakeClock) != nil {
+		t.Fatalf("expect milestone recorder disabled with zero ttl")
+	}
+}
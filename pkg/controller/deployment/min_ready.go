@@ -0,0 +1,85 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+// capAvailableReplicas returns rsList with the available replicas of each replica set capped by
+// its pods which have been ready for at least minReadySeconds of d, the same as the availability
+// of stock deployment. The status of replica sets may count the pods just ready as available,
+// e.g. their minReadySeconds are not updated yet, which would advance the partition too early.
+// The replica sets capped are copied, and d is requeued once the next pod becomes available.
+// The status is trusted as it is in the podless mode, since no pods are cached then.
+func (dc *DeploymentController) capAvailableReplicas(d *apps.Deployment, rsList []*apps.ReplicaSet) ([]*apps.ReplicaSet, error) {
+	if !minReadyFromPods || d.Spec.MinReadySeconds <= 0 || dc.podLister == nil {
+		return rsList, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := dc.cachedPods(d.Namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	now := dc.clock.Now()
+	minReady := time.Duration(d.Spec.MinReadySeconds) * time.Second
+	available := map[types.UID]int32{}
+	wait := time.Duration(0)
+	for _, pod := range pods {
+		controllerRef := metav1.GetControllerOf(pod)
+		if controllerRef == nil || pod.DeletionTimestamp != nil {
+			continue
+		}
+		cond := util.GetPodReadyCondition(pod.Status)
+		if cond == nil || cond.Status != v1.ConditionTrue {
+			continue
+		}
+		if ready := now.Sub(cond.LastTransitionTime.Time); ready < minReady {
+			if left := minReady - ready; wait == 0 || left < wait {
+				wait = left
+			}
+			continue
+		}
+		available[controllerRef.UID]++
+	}
+
+	capped := make([]*apps.ReplicaSet, 0, len(rsList))
+	for _, rs := range rsList {
+		if rs.Status.AvailableReplicas > available[rs.UID] {
+			dc.log().V(4).Info("Replica set has fewer ready pods than available replicas in status", "replicaSet", klog.KObj(rs), "available", rs.Status.AvailableReplicas, "ready", available[rs.UID], "minReady", minReady)
+			rs = rs.DeepCopy()
+			rs.Status.AvailableReplicas = available[rs.UID]
+		}
+		capped = append(capped, rs)
+	}
+	if wait > 0 {
+		dc.enqueueAfter(d, wait)
+	}
+	return capped, nil
+}
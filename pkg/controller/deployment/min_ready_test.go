@@ -0,0 +1,120 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentWithMinReadySeconds(t *testing.T) {
+	cases := []struct {
+		name           string
+		enabled        bool
+		podless        bool
+		expectOldFirst int32
+	}{
+		{
+			name:           "status of replica sets trusted",
+			expectOldFirst: 7,
+		},
+		{
+			name:           "pods not ready for min ready seconds",
+			enabled:        true,
+			expectOldFirst: 10,
+		},
+		{
+			name:           "status of replica sets trusted in podless mode",
+			enabled:        true,
+			podless:        true,
+			expectOldFirst: 7,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(enabled bool) { minReadyFromPods = enabled }(minReadyFromPods)
+			minReadyFromPods = cs.enabled
+
+			d := newTestDeployment(10, intstr.FromInt(3), intstr.FromInt(0))
+			d.Spec.MinReadySeconds = 30
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			// The new replica set reports its pods available as soon as they are ready.
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			objects := []runtime.Object{d, oldRS, newRS}
+			now := metav1.Now()
+			for i := 0; i < 10; i++ {
+				pod := newTestPod(oldRS, fmt.Sprintf("old-%d", i))
+				pod.Status.Conditions[0].LastTransitionTime = metav1.NewTime(now.Add(-time.Hour))
+				objects = append(objects, pod)
+			}
+			for i := 0; i < 3; i++ {
+				pod := newTestPod(newRS, fmt.Sprintf("new-%d", i))
+				pod.Status.Conditions[0].LastTransitionTime = now
+				objects = append(objects, pod)
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("30%"),
+			}
+			dc, client, _ := newTestController(strategy, objects...)
+			dc.clock = testingclock.NewFakeClock(now.Time)
+			if cs.podless {
+				dc.podLister = nil
+			}
+
+			if err := dc.syncDeployment(context.TODO(), d); err != nil {
+				t.Fatalf("failed to sync deployment: %v", err)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace)["demo:v1"]; replicas != cs.expectOldFirst {
+				t.Fatalf("expect %d old replicas, got %d", cs.expectOldFirst, replicas)
+			}
+			if !cs.enabled || cs.podless {
+				return
+			}
+			if dc.requeueAfter <= 0 || dc.requeueAfter > 30*time.Second {
+				t.Fatalf("expect requeued once the new pods are available, got %v", dc.requeueAfter)
+			}
+			latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			if latest.Status.AvailableReplicas != 10 {
+				t.Fatalf("expect 10 available replicas, got %d", latest.Status.AvailableReplicas)
+			}
+
+			// The partition advances once the new pods have been ready for min ready seconds.
+			dc.clock.(*testingclock.FakeClock).Step(30 * time.Second)
+			if err := dc.syncDeployment(context.TODO(), latest); err != nil {
+				t.Fatalf("failed to sync deployment: %v", err)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace)["demo:v1"]; replicas != 7 {
+				t.Fatalf("expect 7 old replicas after min ready seconds, got %d", replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// parseNamespaceSelector returns the selector of the namespaces whose deployments are managed,
+// or nil if selector is empty, i.e. all namespaces are managed.
+func parseNamespaceSelector(selector string) (labels.Selector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("watch-namespace-selector %q is invalid: %v", selector, err)
+	}
+	return parsed, nil
+}
+
+// isNamespaceSelected returns true if the deployments in namespace are managed by us, i.e. no
+// namespace selector is set, or the labels of namespace match it. A namespace not in cache is
+// regarded as not selected, so that nothing is touched until it is known to be selected.
+func (r *ReconcileDeployment) isNamespaceSelected(namespace string) bool {
+	if r.namespaceSelector == nil {
+		return true
+	}
+	if r.controllerFactory.nsLister == nil {
+		return false
+	}
+	ns, err := r.controllerFactory.nsLister.Get(namespace)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("Failed to get namespace %s: %v", namespace, err)
+		}
+		return false
+	}
+	return r.namespaceSelector.Matches(labels.Set(ns.Labels))
+}
+
+// namespaceSelected returns a predicate which only passes the objects in the namespaces selected,
+// so that the deployments and replica sets in the other namespaces are never reconciled.
+func (r *ReconcileDeployment) namespaceSelected() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return r.isNamespaceSelected(obj.GetNamespace())
+	})
+}
+
+// deploymentSelected returns a predicate which passes the deployments in the namespaces selected,
+// and the deployments to be finalized or deleted in any namespace, so that CleanupFinalizer is
+// still removed and the deleted deployments are forgotten once their namespaces are not selected.
+func (r *ReconcileDeployment) deploymentSelected() predicate.Predicate {
+	selected := func(obj client.Object) bool {
+		if d, ok := obj.(*apps.Deployment); ok && needsFinalizing(d) {
+			return true
+		}
+		return r.isNamespaceSelected(obj.GetNamespace())
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return selected(e.Object) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return selected(e.ObjectNew) },
+		DeleteFunc:  func(event.DeleteEvent) bool { return true },
+		GenericFunc: func(e event.GenericEvent) bool { return selected(e.Object) },
+	}
+}
+
+// namespaceSelectionChanged returns true if the namespace is selected or deselected by the update
+// of its labels, then the deployments in it should be reconciled at once.
+func (r *ReconcileDeployment) namespaceSelectionChanged(oldNamespace, newNamespace *v1.Namespace) bool {
+	if r.namespaceSelector == nil {
+		return false
+	}
+	return r.namespaceSelector.Matches(labels.Set(oldNamespace.Labels)) != r.namespaceSelector.Matches(labels.Set(newNamespace.Labels))
+}
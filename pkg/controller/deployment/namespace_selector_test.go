@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestNamespaceSelectedPredicate(t *testing.T) {
+	managed := map[string]string{"rollouts.kruise.io/managed": "true"}
+	cases := []struct {
+		name      string
+		selector  string
+		labels    map[string]string
+		namespace string
+		expect    bool
+	}{
+		{
+			name:      "all namespaces by default",
+			namespace: "default",
+			expect:    true,
+		},
+		{
+			name:      "labeled namespace",
+			selector:  "rollouts.kruise.io/managed=true",
+			labels:    managed,
+			namespace: "default",
+			expect:    true,
+		},
+		{
+			name:      "unlabeled namespace",
+			selector:  "rollouts.kruise.io/managed=true",
+			namespace: "default",
+		},
+		{
+			name:      "namespace not in cache",
+			selector:  "rollouts.kruise.io/managed=true",
+			labels:    managed,
+			namespace: "unknown",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			selector, err := parseNamespaceSelector(cs.selector)
+			if err != nil {
+				t.Fatalf("failed to parse selector: %v", err)
+			}
+			indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{})
+			if err := indexer.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: cs.labels}}); err != nil {
+				t.Fatalf("failed to add namespace: %v", err)
+			}
+			r := &ReconcileDeployment{
+				controllerFactory: &controllerFactory{nsLister: corelisters.NewNamespaceLister(indexer)},
+				namespaceSelector: selector,
+			}
+
+			d := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: cs.namespace, Name: "deployment"}}
+			predicate := r.namespaceSelected()
+			if passed := predicate.Create(event.CreateEvent{Object: d}); passed != cs.expect {
+				t.Fatalf("expect create passed %v, got %v", cs.expect, passed)
+			}
+			if passed := predicate.Update(event.UpdateEvent{ObjectOld: d, ObjectNew: d}); passed != cs.expect {
+				t.Fatalf("expect update passed %v, got %v", cs.expect, passed)
+			}
+		})
+	}
+}
+
+func TestDeploymentSelectedPredicate(t *testing.T) {
+	selector, err := parseNamespaceSelector("rollouts.kruise.io/managed=true")
+	if err != nil {
+		t.Fatalf("failed to parse selector: %v", err)
+	}
+	r := &ReconcileDeployment{controllerFactory: &controllerFactory{}, namespaceSelector: selector}
+	predicate := r.deploymentSelected()
+
+	d := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deployment"}}
+	if predicate.Update(event.UpdateEvent{ObjectOld: d, ObjectNew: d}) {
+		t.Fatalf("expect deployment in namespace not selected filtered")
+	}
+	deleting := d.DeepCopy()
+	deletionTime := metav1.Now()
+	deleting.DeletionTimestamp = &deletionTime
+	deleting.Finalizers = []string{CleanupFinalizer}
+	if !predicate.Update(event.UpdateEvent{ObjectOld: d, ObjectNew: deleting}) {
+		t.Fatalf("expect deployment to be finalized passed")
+	}
+	if !predicate.Delete(event.DeleteEvent{Object: d}) {
+		t.Fatalf("expect deletion passed")
+	}
+}
+
+func TestNamespaceSelectionChanged(t *testing.T) {
+	selector, err := parseNamespaceSelector("rollouts.kruise.io/managed=true")
+	if err != nil {
+		t.Fatalf("failed to parse selector: %v", err)
+	}
+	r := &ReconcileDeployment{namespaceSelector: selector}
+	unlabeled := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	labeled := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"rollouts.kruise.io/managed": "true"}}}
+	if !r.namespaceSelectionChanged(unlabeled, labeled) || !r.namespaceSelectionChanged(labeled, unlabeled) {
+		t.Fatalf("expect selection changed once the namespace is labeled or unlabeled")
+	}
+	if r.namespaceSelectionChanged(labeled, labeled) {
+		t.Fatalf("expect selection unchanged")
+	}
+	if _, err := parseNamespaceSelector("rollouts.kruise.io/managed in (true"); err == nil {
+		t.Fatalf("expect malformed selector rejected")
+	}
+}
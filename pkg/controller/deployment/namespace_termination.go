@@ -0,0 +1,77 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// terminatingNamespaceTracker remembers the namespaces found terminating, so that each of them
+// is only logged once no matter how many deployments it contains. A nil
+// terminatingNamespaceTracker logs on every sync.
+type terminatingNamespaceTracker struct {
+	lock  sync.Mutex
+	names sets.String
+}
+
+func newTerminatingNamespaceTracker() *terminatingNamespaceTracker {
+	return &terminatingNamespaceTracker{names: sets.NewString()}
+}
+
+// observe records whether the namespace is terminating, and returns true if it is found
+// terminating for the first time.
+func (t *terminatingNamespaceTracker) observe(namespace string, terminating bool) bool {
+	if t == nil {
+		return terminating
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !terminating {
+		t.names.Delete(namespace)
+		return false
+	}
+	if t.names.Has(namespace) {
+		return false
+	}
+	t.names.Insert(namespace)
+	return true
+}
+
+// isNamespaceTerminating returns true if the namespace is terminating and such namespaces are
+// configured to be skipped. All writes in a terminating namespace are going to be rejected, and
+// its deployments are going to be deleted anyway, so there is nothing left to roll. If the
+// namespace cannot be found in cache, it is regarded as not terminating.
+func (dc *DeploymentController) isNamespaceTerminating(namespace string) bool {
+	if !skipTerminatingNamespaces || dc.nsLister == nil {
+		return false
+	}
+	terminating := false
+	ns, err := dc.nsLister.Get(namespace)
+	if err == nil {
+		terminating = ns.Status.Phase == v1.NamespaceTerminating || ns.DeletionTimestamp != nil
+	} else if !errors.IsNotFound(err) {
+		dc.log().Error(err, "Failed to get namespace")
+	}
+	if dc.terminatingNS.observe(namespace, terminating) {
+		dc.log().Info("Namespace is terminating, stop syncing its deployments")
+	}
+	return terminating
+}
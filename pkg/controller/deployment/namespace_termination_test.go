@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentInTerminatingNamespace(t *testing.T) {
+	cases := []struct {
+		name         string
+		phase        v1.NamespacePhase
+		disabled     bool
+		expectWrites bool
+	}{
+		{
+			name:  "namespace terminating",
+			phase: v1.NamespaceTerminating,
+		},
+		{
+			name:         "namespace active",
+			phase:        v1.NamespaceActive,
+			expectWrites: true,
+		},
+		{
+			name:         "skip disabled",
+			phase:        v1.NamespaceTerminating,
+			disabled:     true,
+			expectWrites: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(skip bool) { skipTerminatingNamespaces = skip }(skipTerminatingNamespaces)
+			skipTerminatingNamespaces = !cs.disabled
+
+			ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Status: v1.NamespaceStatus{Phase: cs.phase}}
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 8)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 2)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, _ := newTestController(strategy, ns, d, oldRS, newRS)
+			dc.terminatingNS = newTerminatingNamespaceTracker()
+			client.ClearActions()
+
+			if err := dc.syncDeployment(context.TODO(), d); err != nil {
+				t.Fatalf("failed to sync deployment: %v", err)
+			}
+			if writes := len(client.Actions()) > 0; writes != cs.expectWrites {
+				t.Fatalf("expect writes %v, got actions %v", cs.expectWrites, client.Actions())
+			}
+		})
+	}
+}
+
+func TestTerminatingNamespaceTracker(t *testing.T) {
+	tracker := newTerminatingNamespaceTracker()
+	steps := []struct {
+		terminating bool
+		expectFirst bool
+	}{
+		{terminating: false, expectFirst: false},
+		{terminating: true, expectFirst: true},
+		{terminating: true, expectFirst: false},
+		{terminating: false, expectFirst: false},
+		{terminating: true, expectFirst: true},
+	}
+	for i, step := range steps {
+		if first := tracker.observe("default", step.terminating); first != step.expectFirst {
+			t.Fatalf("step %d: expect first %v, got %v", i, step.expectFirst, first)
+		}
+	}
+	if first := (*terminatingNamespaceTracker)(nil).observe("default", true); !first {
+		t.Fatalf("expect nil tracker to report every terminating observation")
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"sort"
+
+	apps "k8s.io/api/apps/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// scaleWithFrozenRatio scales the replica sets of a paused deployment to exactly spec.replicas,
+// e.g. once it is scaled by HPA. The new replica set keeps the ratio of updated replicas frozen
+// when the deployment was paused, and the old replica sets share the rest proportionally to their
+// sizes. Unlike the proportional scaling of the stock controller, the ratio never drifts with the
+// roundings of successive scalings, so the paused batch is restored once the replicas recover, and
+// no surge replicas are added while paused.
+func (dc *DeploymentController) scaleWithFrozenRatio(ctx context.Context, deployment *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) error {
+	activeOldRSs := deploymentutil.FilterActiveReplicaSets(oldRSs)
+	updated, total := dc.frozenRatio(deployment, newRS)
+	if newRS == nil || len(activeOldRSs) == 0 || total <= 0 || !dc.managesOldReplicaSets() {
+		return dc.scale(ctx, deployment, newRS, oldRSs)
+	}
+
+	replicas := *(deployment.Spec.Replicas)
+	// Only the replicas changed while paused are distributed, the sizes are kept as is otherwise.
+	scaled := false
+	for _, rs := range append([]*apps.ReplicaSet{newRS}, activeOldRSs...) {
+		if desired, ok := deploymentutil.GetDesiredReplicasAnnotation(rs); ok && desired != replicas {
+			scaled = true
+		}
+	}
+	if !scaled {
+		return nil
+	}
+
+	// Round the frozen ratio to the nearest integer.
+	newReplicas := int32((2*int64(updated)*int64(replicas) + int64(total)) / (2 * int64(total)))
+	if newReplicas > replicas {
+		newReplicas = replicas
+	}
+	oldReplicas := replicas - newReplicas
+	dc.log().V(4).Info("Scaling paused deployment by frozen ratio", "replicas", newReplicas, "total", replicas, "updated", updated, "updatedTotal", total)
+
+	// The old replica sets share the rest proportionally to their sizes, and the leftover of
+	// the roundings goes to the largest and newest one.
+	sort.Sort(deploymentutil.ReplicaSetsBySizeNewer(activeOldRSs))
+	oldSizes := make([]int32, len(activeOldRSs))
+	currentOldReplicas := deploymentutil.GetReplicaCountForReplicaSets(activeOldRSs)
+	assigned := int32(0)
+	for i, rs := range activeOldRSs {
+		oldSizes[i] = int32(int64(*(rs.Spec.Replicas)) * int64(oldReplicas) / int64(currentOldReplicas))
+		assigned += oldSizes[i]
+	}
+	oldSizes[0] += oldReplicas - assigned
+
+	targets := append([]*apps.ReplicaSet{newRS}, activeOldRSs...)
+	sizes := append([]int32{newReplicas}, oldSizes...)
+	for i, rs := range targets {
+		scalingOperation := "down"
+		if sizes[i] > *(rs.Spec.Replicas) {
+			scalingOperation = "up"
+		}
+		if _, _, err := dc.scaleReplicaSet(ctx, rs, sizes[i], deployment, scalingOperation, auditReasonProportionalScaling); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frozenRatio returns the ratio of updated replicas frozen when the deployment was paused, or
+// the current ratio of the new replica set to the replicas it was scaled for, if not frozen yet.
+func (dc *DeploymentController) frozenRatio(deployment *apps.Deployment, newRS *apps.ReplicaSet) (int32, int32) {
+	if newRS == nil {
+		return 0, 0
+	}
+	if extraStatus := getExtraStatus(deployment); extraStatus != nil && extraStatus.PausedReplicas > 0 &&
+		extraStatus.UpdateRevision == newRS.Labels[deploymentutil.TemplateHashLabelKey] {
+		return extraStatus.PausedUpdatedReplicas, extraStatus.PausedReplicas
+	}
+	desired, ok := deploymentutil.GetDesiredReplicasAnnotation(newRS)
+	if !ok || desired <= 0 {
+		desired = *(deployment.Spec.Replicas)
+	}
+	return *(newRS.Spec.Replicas), desired
+}
+
+// syncPausedReplicas freezes the ratio of updated replicas once the deployment is paused, which is
+// carried over while the deployment keeps paused at the same revision, and cleared once resumed.
+// The newRS must be looked up before syncing, so that the ratio is frozen before any scaling.
+func (dc *DeploymentController) syncPausedReplicas(deployment *apps.Deployment, newRS *apps.ReplicaSet, prev, cur *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if !scaleByFrozenRatio || newRS == nil || !dc.isPaused(deployment) {
+		return
+	}
+	if prev != nil && prev.PausedReplicas > 0 && prev.UpdateRevision == cur.UpdateRevision {
+		cur.PausedUpdatedReplicas, cur.PausedReplicas = prev.PausedUpdatedReplicas, prev.PausedReplicas
+		return
+	}
+	cur.PausedUpdatedReplicas, cur.PausedReplicas = dc.frozenRatio(deployment, newRS)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncPausedDeploymentScaledByHPA(t *testing.T) {
+	type step struct {
+		replicas int32
+		expect   map[string]int32
+	}
+	cases := []struct {
+		name       string
+		frozen     bool
+		partition  intstr.IntOrString
+		steps      []step
+		expectDone map[string]int32
+	}{
+		{
+			name:      "scale by frozen ratio",
+			frozen:    true,
+			partition: intstr.FromString("30%"),
+			steps: []step{
+				{replicas: 20, expect: map[string]int32{"demo:v1": 14, "demo:v2": 6}},
+				{replicas: 5, expect: map[string]int32{"demo:v1": 3, "demo:v2": 2}},
+				{replicas: 13, expect: map[string]int32{"demo:v1": 9, "demo:v2": 4}},
+				{replicas: 10, expect: map[string]int32{"demo:v1": 7, "demo:v2": 3}},
+			},
+			expectDone: map[string]int32{"demo:v1": 7, "demo:v2": 3},
+		},
+		{
+			name:      "scale by frozen ratio with absolute partition",
+			frozen:    true,
+			partition: intstr.FromInt(3),
+			steps: []step{
+				{replicas: 20, expect: map[string]int32{"demo:v1": 14, "demo:v2": 6}},
+				{replicas: 10, expect: map[string]int32{"demo:v1": 7, "demo:v2": 3}},
+			},
+			expectDone: map[string]int32{"demo:v1": 7, "demo:v2": 3},
+		},
+		{
+			name:      "scale by proportions of current sizes",
+			frozen:    false,
+			partition: intstr.FromString("30%"),
+			steps: []step{
+				{replicas: 20, expect: map[string]int32{"demo:v1": 15, "demo:v2": 6}},
+				{replicas: 5, expect: map[string]int32{"demo:v1": 4, "demo:v2": 2}},
+				{replicas: 10, expect: map[string]int32{"demo:v1": 7, "demo:v2": 4}},
+			},
+			expectDone: map[string]int32{"demo:v1": 6, "demo:v2": 4},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(frozen bool) { scaleByFrozenRatio = frozen }(scaleByFrozenRatio)
+			scaleByFrozenRatio = cs.frozen
+
+			// The deployment is paused at the batch of 30%.
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     cs.partition,
+				Paused:        true,
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+
+			for _, s := range cs.steps {
+				// HPA scales the deployment while paused.
+				d.Spec.Replicas = pointer.Int32(s.replicas)
+				d.Generation++
+				if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+					t.Fatalf("failed to scale deployment: %v", err)
+				}
+				for i := 0; i < 5; i++ {
+					d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+				}
+				if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, s.expect) {
+					t.Fatalf("expect replicas %v scaled to %d, got %v", s.expect, s.replicas, replicas)
+				}
+			}
+
+			dc.strategy.Paused = false
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expectDone) {
+				t.Fatalf("expect replicas %v after resumed, got %v", cs.expectDone, replicas)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.PausedReplicas != 0 {
+				t.Fatalf("expect frozen ratio cleared after resumed, got %+v", extraStatus)
+			}
+		})
+	}
+}
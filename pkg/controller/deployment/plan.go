@@ -0,0 +1,193 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// RolloutPlan is the machine-readable plan of how Advanced Deployment rolls to a new revision,
+// e.g. to be rendered by UIs. It is calculated from the deployment and its strategy only, and
+// assumes the new pods become available in time, so it is independent of cluster state.
+type RolloutPlan struct {
+	// Replicas is the number of pods of the deployment.
+	Replicas int32 `json:"replicas"`
+	// MaxSurge and MaxUnavailable are the absolute numbers calculated from the strategy.
+	MaxSurge       int32 `json:"maxSurge"`
+	MaxUnavailable int32 `json:"maxUnavailable"`
+	// MinReadySeconds is how long each new pod must be ready before the next wave.
+	MinReadySeconds int32 `json:"minReadySeconds,omitempty"`
+	// ProgressDeadlineSeconds is how long each wave may take before the rollout is reported
+	// as not progressing.
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// Steps are the batches to roll in order.
+	Steps []RolloutPlanStep `json:"steps"`
+}
+
+// RolloutPlanStep is a batch of the rollout.
+type RolloutPlanStep struct {
+	// Gates must be passed in order before the step is started.
+	Gates []RolloutPlanGate `json:"gates,omitempty"`
+	// UpdatedReplicas and OldReplicas are the numbers of new and old pods once the step is done.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	OldReplicas     int32 `json:"oldReplicas"`
+	// TestBatch is true if the new pods of the step are only used for testing, which are surged
+	// beyond replicas and kept out of Service endpoints.
+	TestBatch bool `json:"testBatch,omitempty"`
+	// Waves are the numbers of new and old pods after each wave of scaling within the step,
+	// which is bounded by MaxSurge and MaxUnavailable.
+	Waves []RolloutPlanWave `json:"waves"`
+}
+
+// RolloutPlanWave is the numbers of new and old pods after a wave of scaling.
+type RolloutPlanWave struct {
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	OldReplicas     int32 `json:"oldReplicas"`
+}
+
+// RolloutPlanGate is what a step waits for before it is started.
+type RolloutPlanGate struct {
+	Type RolloutPlanGateType `json:"type"`
+	// Soak is the soak requirement of the previous step, only set for the Soak gate.
+	Soak *rolloutsv1alpha1.DeploymentBatchSoak `json:"soak,omitempty"`
+	// Checks is the custom checks of the previous step, only set for the Checks gate.
+	Checks *rolloutsv1alpha1.DeploymentBatchChecks `json:"checks,omitempty"`
+	// PauseSeconds is the pause after the previous step is available, only set for the Pause gate.
+	PauseSeconds int32 `json:"pauseSeconds,omitempty"`
+}
+
+type RolloutPlanGateType string
+
+const (
+	// ResumePlanGate waits for the paused field of strategy to be false.
+	ResumePlanGate RolloutPlanGateType = "Resume"
+	// SoakPlanGate waits for the new pods of the previous step to be soaked.
+	SoakPlanGate RolloutPlanGateType = "Soak"
+	// ChecksPlanGate waits for a quorum of the custom checks of the previous step to pass.
+	ChecksPlanGate RolloutPlanGateType = "Checks"
+	// PausePlanGate waits for the pause after the new pods of the previous step are all available.
+	PausePlanGate RolloutPlanGateType = "Pause"
+	// PromoteTestBatchPlanGate waits for the testBatch field of strategy to be false.
+	PromoteTestBatchPlanGate RolloutPlanGateType = "PromoteTestBatch"
+	// PromotePlanGate waits for the promote annotation, or the RolloutApprovals of the revision.
+	PromotePlanGate RolloutPlanGateType = "Promote"
+)
+
+// PlanRollout returns the plan of rolling deployment to a new revision under strategy, or an
+// error if the strategy is invalid or not rolled by Advanced Deployment.
+func PlanRollout(deployment *apps.Deployment, strategy rolloutsv1alpha1.DeploymentStrategy) (*RolloutPlan, error) {
+	if errList := rolloutsv1alpha1.ValidateDeploymentStrategy(&strategy, field.NewPath("strategy")); len(errList) > 0 {
+		return nil, errList.ToAggregate()
+	}
+	if strategy.RollingStyle == rolloutsv1alpha1.CanaryRollingStyleType {
+		return nil, fmt.Errorf("rolling style %s is not rolled by advanced deployment", strategy.RollingStyle)
+	}
+	rolloutsv1alpha1.SetDefaultDeploymentStrategy(&strategy)
+
+	dc := &DeploymentController{strategy: strategy}
+	d := dc.withStrategy(deployment)
+	if d.Spec.Replicas == nil {
+		one := int32(1)
+		d.Spec.Replicas = &one
+	}
+	replicas := *(d.Spec.Replicas)
+	plan := &RolloutPlan{
+		Replicas:                replicas,
+		MaxSurge:                deploymentutil.MaxSurge(*d),
+		MaxUnavailable:          deploymentutil.MaxUnavailable(*d),
+		MinReadySeconds:         d.Spec.MinReadySeconds,
+		ProgressDeadlineSeconds: d.Spec.ProgressDeadlineSeconds,
+	}
+
+	partition := strategy.Partition
+	if dc.isPromoted(d) {
+		partition = intstr.FromString("100%")
+	}
+	limit := dc.partitionReplicasLimit(partition, d)
+	var gates []RolloutPlanGate
+	if strategy.Paused {
+		gates = append(gates, RolloutPlanGate{Type: ResumePlanGate})
+	}
+	updated, old := int32(0), replicas
+	addStep := func(target int32, surgeOnly, testBatch bool) {
+		waves := planWaves(updated, old, target, replicas, plan.MaxSurge, plan.MaxUnavailable, surgeOnly)
+		if len(waves) > 0 {
+			updated, old = waves[len(waves)-1].UpdatedReplicas, waves[len(waves)-1].OldReplicas
+		}
+		plan.Steps = append(plan.Steps, RolloutPlanStep{
+			Gates:           gates,
+			UpdatedReplicas: updated,
+			OldReplicas:     old,
+			TestBatch:       testBatch,
+			Waves:           waves,
+		})
+		gates = nil
+		if strategy.BatchSoak != nil {
+			gates = append(gates, RolloutPlanGate{Type: SoakPlanGate, Soak: strategy.BatchSoak.DeepCopy()})
+		}
+		if strategy.BatchChecks != nil {
+			gates = append(gates, RolloutPlanGate{Type: ChecksPlanGate, Checks: strategy.BatchChecks.DeepCopy()})
+		}
+		if strategy.PauseSeconds > 0 {
+			gates = append(gates, RolloutPlanGate{Type: PausePlanGate, PauseSeconds: strategy.PauseSeconds})
+		}
+	}
+
+	// The old pods are never scaled down by us if they are left to others.
+	surgeOnly := !dc.managesOldReplicaSets()
+	if limit > 0 && strategy.TestBatch {
+		addStep(limit, true, true)
+		gates = append(gates, RolloutPlanGate{Type: PromoteTestBatchPlanGate})
+	}
+	if limit > 0 {
+		addStep(limit, surgeOnly, false)
+	}
+	if limit < replicas {
+		gates = append(gates, RolloutPlanGate{Type: PromotePlanGate})
+		addStep(replicas, surgeOnly, false)
+	}
+	return plan, nil
+}
+
+// planWaves simulates the waves of scaling from the given numbers of new and old pods, until the
+// new pods reach target and the old pods are scaled down to the rest of replicas. Only the new
+// pods are scaled up if surgeOnly is true, e.g. in a test batch.
+func planWaves(updated, old, target, replicas, maxSurge, maxUnavailable int32, surgeOnly bool) []RolloutPlanWave {
+	var waves []RolloutPlanWave
+	for {
+		nextUpdated, nextOld := integer.Int32Max(updated, target), old
+		if !surgeOnly {
+			nextUpdated = integer.Int32Max(updated, integer.Int32Min(target, replicas+maxSurge-old))
+			// The old replica sets keep the replicas that are not allowed to be updated.
+			maxScaledDown := integer.Int32Max(nextUpdated+old-(replicas-maxUnavailable), 0)
+			nextOld = integer.Int32Min(old, integer.Int32Max(old-maxScaledDown, replicas-target))
+		}
+		if nextUpdated == updated && nextOld == old {
+			return waves
+		}
+		updated, old = nextUpdated, nextOld
+		waves = append(waves, RolloutPlanWave{UpdatedReplicas: updated, OldReplicas: old})
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// summarizePlanSteps formats each step as "<gates>:<updated>/<old>[test](<waves>)".
+func summarizePlanSteps(plan *RolloutPlan) []string {
+	var steps []string
+	for _, step := range plan.Steps {
+		var gates []string
+		for _, gate := range step.Gates {
+			gates = append(gates, string(gate.Type))
+		}
+		test := ""
+		if step.TestBatch {
+			test = "test"
+		}
+		steps = append(steps, fmt.Sprintf("%s:%d/%d%s(%d)", strings.Join(gates, "+"), step.UpdatedReplicas, step.OldReplicas, test, len(step.Waves)))
+	}
+	return steps
+}
+
+func TestPlanRollout(t *testing.T) {
+	cases := []struct {
+		name           string
+		maxSurge       intstr.IntOrString
+		maxUnavailable intstr.IntOrString
+		promoted       bool
+		strategy       rolloutsv1alpha1.DeploymentStrategy
+		expectSteps    []string
+		expectError    bool
+	}{
+		{
+			name:        "held at partition until promoted",
+			maxSurge:    intstr.FromInt(1),
+			strategy:    rolloutsv1alpha1.DeploymentStrategy{Partition: intstr.FromString("30%")},
+			expectSteps: []string{":3/7(3)", "Promote:10/0(7)"},
+		},
+		{
+			name:           "rolling all in one step",
+			maxSurge:       intstr.FromString("25%"),
+			maxUnavailable: intstr.FromString("25%"),
+			strategy:       rolloutsv1alpha1.DeploymentStrategy{Partition: intstr.FromString("100%")},
+			expectSteps:    []string{":10/0(3)"},
+		},
+		{
+			name:        "promoted by annotation",
+			maxSurge:    intstr.FromInt(1),
+			promoted:    true,
+			strategy:    rolloutsv1alpha1.DeploymentStrategy{Partition: intstr.FromString("30%")},
+			expectSteps: []string{":10/0(10)"},
+		},
+		{
+			name:     "paused test batch with soak",
+			maxSurge: intstr.FromInt(1),
+			strategy: rolloutsv1alpha1.DeploymentStrategy{
+				Paused:    true,
+				Partition: intstr.FromString("20%"),
+				TestBatch: true,
+				BatchSoak: &rolloutsv1alpha1.DeploymentBatchSoak{Seconds: 600, Percent: pointer.Int32(95)},
+			},
+			expectSteps: []string{"Resume:2/10test(1)", "Soak+PromoteTestBatch:2/8(1)", "Soak+Promote:10/0(8)"},
+		},
+		{
+			name:     "custom batch checks",
+			maxSurge: intstr.FromInt(1),
+			strategy: rolloutsv1alpha1.DeploymentStrategy{
+				Partition:   intstr.FromString("50%"),
+				BatchChecks: &rolloutsv1alpha1.DeploymentBatchChecks{Names: []string{"noop"}},
+			},
+			expectSteps: []string{":5/5(5)", "Checks+Promote:10/0(5)"},
+		},
+		{
+			name:     "old replica sets left to others",
+			maxSurge: intstr.FromInt(1),
+			strategy: rolloutsv1alpha1.DeploymentStrategy{
+				Partition:            intstr.FromString("50%"),
+				ManageOldReplicaSets: pointer.Bool(false),
+			},
+			expectSteps: []string{":5/10(1)", "Promote:10/10(1)"},
+		},
+		{
+			name:        "invalid strategy",
+			strategy:    rolloutsv1alpha1.DeploymentStrategy{Partition: intstr.FromString("150%")},
+			expectError: true,
+		},
+		{
+			name:        "canary rolling style",
+			strategy:    rolloutsv1alpha1.DeploymentStrategy{RollingStyle: rolloutsv1alpha1.CanaryRollingStyleType},
+			expectError: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, cs.maxSurge, cs.maxUnavailable)
+			if cs.promoted {
+				d.Annotations = map[string]string{rolloutsv1alpha1.DeploymentPromoteAnnotation: "true"}
+			}
+			if cs.strategy.RollingUpdate == nil && cs.strategy.RollingStyle != rolloutsv1alpha1.CanaryRollingStyleType {
+				cs.strategy.RollingUpdate = d.Spec.Strategy.RollingUpdate.DeepCopy()
+			}
+			plan, err := PlanRollout(d, cs.strategy)
+			if (err != nil) != cs.expectError {
+				t.Fatalf("expect error %v, got %v", cs.expectError, err)
+			}
+			if cs.expectError {
+				return
+			}
+			if steps := summarizePlanSteps(plan); !reflect.DeepEqual(steps, cs.expectSteps) {
+				t.Fatalf("expect steps %v, got %v", cs.expectSteps, steps)
+			}
+		})
+	}
+}
+
+func TestPlanRolloutStructure(t *testing.T) {
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+		BatchSoak:     &rolloutsv1alpha1.DeploymentBatchSoak{Seconds: 60},
+	}
+	plan, err := PlanRollout(d, strategy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := json.Marshal(plan)
+	expect := `{"replicas":4,"maxSurge":1,"maxUnavailable":0,"progressDeadlineSeconds":600,"steps":[` +
+		`{"updatedReplicas":2,"oldReplicas":2,"waves":[{"updatedReplicas":1,"oldReplicas":3},{"updatedReplicas":2,"oldReplicas":2}]},` +
+		`{"gates":[{"type":"Soak","soak":{"seconds":60}},{"type":"Promote"}],"updatedReplicas":4,"oldReplicas":0,` +
+		`"waves":[{"updatedReplicas":3,"oldReplicas":1},{"updatedReplicas":4,"oldReplicas":0}]}]}`
+	if string(data) != expect {
+		t.Fatalf("expect plan %s, got %s", expect, data)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// protectedPodDeletionCost is the pod deletion cost of the pods to be deleted last.
+	protectedPodDeletionCost = "100"
+	// expendablePodDeletionCost is the pod deletion cost of the pods to be deleted first.
+	expendablePodDeletionCost = "-100"
+)
+
+// syncPodDeletionCost sets the pod deletion cost of the pods according to the policy of our
+// strategy while rolling, i.e. both the new and old replica sets have replicas, so that the
+// protected pods are deleted last once scaled down, e.g. by HPA. The costs are cleared once
+// the rollout is finished or the policy is unset. The costs set by others are never touched.
+func (dc *DeploymentController) syncPodDeletionCost(ctx context.Context, d *apps.Deployment, rsList []*apps.ReplicaSet) error {
+	newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+	_, oldRSs := deploymentutil.FindOldReplicaSets(d, rsList)
+	rolling := newRS != nil && *(newRS.Spec.Replicas) > 0 && len(deploymentutil.FilterActiveReplicaSets(oldRSs)) > 0
+
+	pods, err := deploymentutil.ListPods(d, rsList, dc.listPods)
+	if err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		cost := ""
+		if rolling && dc.strategy.PodDeletionCost != "" {
+			cost = podDeletionCost(dc.strategy.PodDeletionCost, metav1.IsControlledBy(pod, newRS))
+		}
+		current, ok := pod.Annotations[v1.PodDeletionCost]
+		if ok && current != protectedPodDeletionCost && current != expendablePodDeletionCost {
+			continue // set by others
+		}
+		if current == cost {
+			continue
+		}
+		if err = dc.patchPodDeletionCost(ctx, pod, cost); err != nil {
+			return err
+		}
+		dc.log().V(3).Info("Set pod deletion cost", "pod", klog.KObj(pod), "cost", cost)
+	}
+	return nil
+}
+
+// podDeletionCost returns the pod deletion cost of a new or old pod under the policy.
+func podDeletionCost(policy rolloutsv1alpha1.PodDeletionCostPolicyType, isNew bool) string {
+	if isNew == (policy == rolloutsv1alpha1.ProtectCanaryPodDeletionCostPolicy) {
+		return protectedPodDeletionCost
+	}
+	return expendablePodDeletionCost
+}
+
+// patchPodDeletionCost sets the pod deletion cost of pod, or removes it if cost is empty.
+func (dc *DeploymentController) patchPodDeletionCost(ctx context.Context, pod *v1.Pod, cost string) error {
+	var value interface{}
+	if cost != "" {
+		value = cost
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{v1.PodDeletionCost: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = dc.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, body, metav1.PatchOptions{})
+	return err
+}
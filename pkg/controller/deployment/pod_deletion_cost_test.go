@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncPodDeletionCost(t *testing.T) {
+	cases := []struct {
+		name          string
+		policy        rolloutsv1alpha1.PodDeletionCostPolicyType
+		oldReplicas   int32
+		oldCost       string
+		newCost       string
+		expectOldCost string
+		expectNewCost string
+	}{
+		{
+			name:          "protect canary while rolling",
+			policy:        rolloutsv1alpha1.ProtectCanaryPodDeletionCostPolicy,
+			oldReplicas:   2,
+			expectOldCost: expendablePodDeletionCost,
+			expectNewCost: protectedPodDeletionCost,
+		},
+		{
+			name:          "protect stable while rolling",
+			policy:        rolloutsv1alpha1.ProtectStablePodDeletionCostPolicy,
+			oldReplicas:   2,
+			expectOldCost: protectedPodDeletionCost,
+			expectNewCost: expendablePodDeletionCost,
+		},
+		{
+			name:          "policy is switched while rolling",
+			policy:        rolloutsv1alpha1.ProtectStablePodDeletionCostPolicy,
+			oldReplicas:   2,
+			oldCost:       expendablePodDeletionCost,
+			newCost:       protectedPodDeletionCost,
+			expectOldCost: protectedPodDeletionCost,
+			expectNewCost: expendablePodDeletionCost,
+		},
+		{
+			name:        "cleared once policy is unset",
+			oldReplicas: 2,
+			oldCost:     expendablePodDeletionCost,
+			newCost:     protectedPodDeletionCost,
+		},
+		{
+			name:    "cleared once rollout is finished",
+			policy:  rolloutsv1alpha1.ProtectCanaryPodDeletionCostPolicy,
+			oldCost: expendablePodDeletionCost,
+			newCost: protectedPodDeletionCost,
+		},
+		{
+			name:          "cost set by others is kept",
+			policy:        rolloutsv1alpha1.ProtectCanaryPodDeletionCostPolicy,
+			oldReplicas:   2,
+			oldCost:       "7",
+			expectOldCost: "7",
+			expectNewCost: protectedPodDeletionCost,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, cs.oldReplicas)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 2)
+			oldPod, newPod := newTestPod(oldRS, "old-pod"), newTestPod(newRS, "new-pod")
+			if cs.oldCost != "" {
+				oldPod.Annotations = map[string]string{v1.PodDeletionCost: cs.oldCost}
+			}
+			if cs.newCost != "" {
+				newPod.Annotations = map[string]string{v1.PodDeletionCost: cs.newCost}
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{Partition: intstr.FromString("50%"), PodDeletionCost: cs.policy}
+			dc, client, _ := newTestController(strategy, []runtime.Object{d, oldRS, newRS, oldPod, newPod}...)
+
+			if err := dc.syncPodDeletionCost(context.TODO(), d, []*apps.ReplicaSet{oldRS, newRS}); err != nil {
+				t.Fatalf("failed to sync pod deletion cost: %v", err)
+			}
+			for name, expect := range map[string]string{oldPod.Name: cs.expectOldCost, newPod.Name: cs.expectNewCost} {
+				pod, err := client.CoreV1().Pods(d.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get pod: %v", err)
+				}
+				if cost, ok := pod.Annotations[v1.PodDeletionCost]; cost != expect || (expect == "" && ok) {
+					t.Errorf("expect pod deletion cost of %s %q, got %q", name, expect, cost)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// podlessUnsupported is the message of the strategies which cannot work without pods cached.
+const podlessUnsupported = "not supported in podless mode, where pods are not cached"
+
+// validatePodlessStrategy returns the errors of strategy which requires inspecting pods, in the
+// podless mode, see AdvancedDeploymentPodlessModeGate. The rollout would hold forever since no pods
+// are ever soaked or promoted out of test batch, or the failures would never be detected. The other
+// features inspecting pods degrade silently, e.g. untolerated taints and stuck terminating pods are
+// never found, and progressDeadlineFrom PodsInitialized is the same as the default.
+func validatePodlessStrategy(strategy *rolloutsv1alpha1.DeploymentStrategy, fldPath *field.Path) field.ErrorList {
+	var errList field.ErrorList
+	if strategy.BatchSoak != nil {
+		errList = append(errList, field.Forbidden(fldPath.Child("batchSoak"), podlessUnsupported))
+	}
+	if strategy.TestBatch {
+		errList = append(errList, field.Forbidden(fldPath.Child("testBatch"), podlessUnsupported))
+	}
+	if strategy.BatchUnschedulable != nil {
+		errList = append(errList, field.Forbidden(fldPath.Child("batchUnschedulable"), podlessUnsupported))
+	}
+	if strategy.PendingGraceSeconds > 0 {
+		errList = append(errList, field.Forbidden(fldPath.Child("pendingGraceSeconds"), podlessUnsupported))
+	}
+	if strategy.RollbackPolicy != nil {
+		errList = append(errList, field.Forbidden(fldPath.Child("rollbackPolicy"), podlessUnsupported))
+	}
+	return errList
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestNewControllerInPodlessMode(t *testing.T) {
+	cases := []struct {
+		name       string
+		annotation string
+		expectErr  bool
+	}{
+		{
+			name:       "partition only",
+			annotation: `{"rollingStyle":"Partition","partition":"50%"}`,
+		},
+		{
+			name:       "batch soak",
+			annotation: `{"rollingStyle":"Partition","batchSoak":{"seconds":60}}`,
+			expectErr:  true,
+		},
+		{
+			name:       "test batch",
+			annotation: `{"rollingStyle":"Partition","testBatch":true}`,
+			expectErr:  true,
+		},
+		{
+			name:       "batch unschedulable",
+			annotation: `{"rollingStyle":"Partition","batchUnschedulable":{}}`,
+			expectErr:  true,
+		},
+		{
+			name:       "rollback policy",
+			annotation: `{"rollingStyle":"Partition","rollbackPolicy":{"failureThreshold":1}}`,
+			expectErr:  true,
+		},
+		{
+			name:       "pending grace",
+			annotation: `{"rollingStyle":"Partition","pendingGraceSeconds":300}`,
+			expectErr:  true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+			d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+			d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = cs.annotation
+			d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			d.Spec.Paused = true
+			dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+			dc.podLister = nil
+
+			controller, err := (*controllerFactory)(dc).NewController(d)
+			if !cs.expectErr {
+				if err != nil || controller == nil {
+					t.Fatalf("expect controller in podless mode, got error %v", err)
+				}
+				return
+			}
+			if err == nil || controller != nil {
+				t.Fatalf("expect error in podless mode, got controller %v", controller)
+			}
+			if events := collectEvents(recorder); !hasEvent(events, InvalidStrategyReason) {
+				t.Fatalf("expect %s event, got %v", InvalidStrategyReason, events)
+			}
+		})
+	}
+}
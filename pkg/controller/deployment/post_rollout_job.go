@@ -0,0 +1,240 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// PostRolloutJobSucceededReason is added in a deployment event when the post rollout job
+	// of its revision succeeds, or does not fail within the window.
+	PostRolloutJobSucceededReason = "PostRolloutJobSucceeded"
+	// PostRolloutJobFailedReason is added in a deployment event when the post rollout job of
+	// its revision fails, and it is rolled back to the stable revision.
+	PostRolloutJobFailedReason = "PostRolloutJobFailed"
+)
+
+const (
+	// postRolloutJobDeploymentLabel and postRolloutJobRevisionLabel are the labels of post rollout
+	// jobs, which are the name of deployment and the pod-template-hash the job verifies.
+	postRolloutJobDeploymentLabel = "rollouts.kruise.io/post-rollout-deployment"
+	postRolloutJobRevisionLabel   = "rollouts.kruise.io/post-rollout-revision"
+	// postRolloutJobStableAnnotation is the annotation of post rollout jobs, which is the
+	// pod-template-hash of the stable revision to roll back to if the job fails.
+	postRolloutJobStableAnnotation = "rollouts.kruise.io/stable-revision"
+)
+
+// postRolloutJobPollInterval is how often to check the post rollout job, since the deployment is
+// not notified once the job finishes.
+const postRolloutJobPollInterval = 15 * time.Second
+
+// syncPostRolloutJob runs the post rollout job of strategy for the completed new replica set, and
+// rolls the deployment back to the stable revision recorded in the job if it fails within the
+// window. Each revision is only verified once, and the job is deleted once it is finished or the
+// window expires. It returns true if the deployment is rolled back.
+func (dc *DeploymentController) syncPostRolloutJob(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) (bool, error) {
+	policy := dc.strategy.PostRolloutJob
+	if policy == nil || newRS == nil {
+		return false, nil
+	}
+	revision := newRS.Labels[deploymentutil.TemplateHashLabelKey]
+	verified := ""
+	if extraStatus := getExtraStatus(d); extraStatus != nil {
+		verified = extraStatus.VerifiedRevision
+	}
+
+	jobs, err := dc.client.BatchV1().Jobs(d.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{postRolloutJobDeploymentLabel: d.Name}).String(),
+	})
+	if err != nil {
+		return false, err
+	}
+	var job *batchv1.Job
+	for i := range jobs.Items {
+		if !metav1.IsControlledBy(&jobs.Items[i], d) {
+			continue
+		}
+		if revision != verified && jobs.Items[i].Labels[postRolloutJobRevisionLabel] == revision {
+			job = &jobs.Items[i]
+			continue
+		}
+		// The job of a superseded or already verified revision is of no use.
+		if err := dc.deletePostRolloutJob(ctx, &jobs.Items[i]); err != nil {
+			return false, err
+		}
+	}
+	if revision == verified {
+		return false, nil
+	}
+
+	if job == nil {
+		stable := latestReplicaSet(oldRSs)
+		if stable == nil {
+			// Nothing to roll back to, e.g. the first revision of deployment.
+			dc.verifiedRevision = revision
+			return false, nil
+		}
+		job = newPostRolloutJob(d, policy, revision, stable.Labels[deploymentutil.TemplateHashLabelKey])
+		if _, err = dc.client.BatchV1().Jobs(d.Namespace).Create(ctx, job, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+			return false, err
+		}
+		dc.log().Info("Created post rollout job", "job", job.Name, "revision", revision)
+		dc.enqueueAfter(d, postRolloutJobPollInterval)
+		return false, nil
+	}
+
+	switch {
+	case isJobFinished(job, batchv1.JobFailed):
+		stableRevision := job.Annotations[postRolloutJobStableAnnotation]
+		if err = dc.rollbackToRevision(ctx, d, oldRSs, stableRevision); err != nil {
+			return false, err
+		}
+		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, PostRolloutJobFailedReason,
+			"Post rollout job %s failed, rolled back to revision %s", job.Name, stableRevision)
+		// Ignore the error, since the job of a verified revision is deleted in the next sync.
+		_ = dc.deletePostRolloutJob(ctx, job)
+		return true, nil
+	case isJobFinished(job, batchv1.JobComplete):
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, PostRolloutJobSucceededReason, "Post rollout job %s succeeded", job.Name)
+	default:
+		window := time.Duration(policy.WindowSeconds) * time.Second
+		left := window - dc.clock.Since(job.CreationTimestamp.Time)
+		if window == 0 || left > 0 {
+			if window > 0 && left < postRolloutJobPollInterval {
+				dc.enqueueAfter(d, left)
+			} else {
+				dc.enqueueAfter(d, postRolloutJobPollInterval)
+			}
+			return false, nil
+		}
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, PostRolloutJobSucceededReason,
+			"Post rollout job %s did not fail within %v", job.Name, window)
+	}
+	dc.verifiedRevision = revision
+	return false, dc.deletePostRolloutJob(ctx, job)
+}
+
+// syncVerifiedRevision carries the verified revision over from the previous extra status, unless
+// another revision is verified in this sync.
+func (dc *DeploymentController) syncVerifiedRevision(prev, cur *rolloutsv1alpha1.DeploymentExtraStatus) {
+	cur.VerifiedRevision = dc.verifiedRevision
+	if cur.VerifiedRevision == "" && prev != nil {
+		cur.VerifiedRevision = prev.VerifiedRevision
+	}
+}
+
+// rollbackToRevision updates the pod template of deployment to the one of the old replica set
+// with the pod-template-hash revision, like what the stock rollback does. The revision rolled
+// back to is regarded as verified, so that it is never rolled back again, and the pending
+// DeploymentRollbackAnnotation is fulfilled by the rollback, so it is removed as well.
+func (dc *DeploymentController) rollbackToRevision(ctx context.Context, d *apps.Deployment, oldRSs []*apps.ReplicaSet, revision string) error {
+	var stable *apps.ReplicaSet
+	for _, rs := range oldRSs {
+		if rs.Labels[deploymentutil.TemplateHashLabelKey] == revision {
+			stable = rs
+			break
+		}
+	}
+	if stable == nil {
+		return fmt.Errorf("stable replica set of revision %s is not found for deployment %s/%s", revision, d.Namespace, d.Name)
+	}
+
+	// Get the latest deployment instead of d, whose strategy has been replaced by ours.
+	latest, err := dc.client.AppsV1().Deployments(d.Namespace).Get(ctx, d.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	latest = latest.DeepCopy()
+	latest.Spec.Template = *stable.Spec.Template.DeepCopy()
+	delete(latest.Spec.Template.Labels, deploymentutil.TemplateHashLabelKey)
+	delete(latest.Annotations, rolloutsv1alpha1.DeploymentRollbackAnnotation)
+	if _, err = dc.client.AppsV1().Deployments(d.Namespace).Update(ctx, latest, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	dc.verifiedRevision = revision
+	return nil
+}
+
+// deletePostRolloutJob deletes the job together with its pods.
+func (dc *DeploymentController) deletePostRolloutJob(ctx context.Context, job *batchv1.Job) error {
+	propagation := metav1.DeletePropagationBackground
+	err := dc.client.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// newPostRolloutJob returns the post rollout job of deployment verifying revision.
+func newPostRolloutJob(d *apps.Deployment, policy *rolloutsv1alpha1.DeploymentPostRolloutJob, revision, stableRevision string) *batchv1.Job {
+	suffix := "-verify-" + revision
+	name := d.Name
+	if maxLen := 63 - len(suffix); len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	template := policy.Template.DeepCopy()
+	job := &batchv1.Job{
+		ObjectMeta: template.ObjectMeta,
+		Spec:       template.Spec,
+	}
+	job.Name, job.Namespace, job.GenerateName = name+suffix, d.Namespace, ""
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	job.Labels[postRolloutJobDeploymentLabel] = d.Name
+	job.Labels[postRolloutJobRevisionLabel] = revision
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[postRolloutJobStableAnnotation] = stableRevision
+	job.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(d, controllerKind)}
+	return job
+}
+
+// latestReplicaSet returns the replica set of the max revision, or nil if there is none.
+func latestReplicaSet(rsList []*apps.ReplicaSet) *apps.ReplicaSet {
+	if len(rsList) == 0 {
+		return nil
+	}
+	sorted := append([]*apps.ReplicaSet{}, rsList...)
+	sort.Sort(deploymentutil.ReplicaSetsByRevision(sorted))
+	return sorted[len(sorted)-1]
+}
+
+// isJobFinished returns true if the job has the finished condition of condType.
+func isJobFinished(job *batchv1.Job, condType batchv1.JobConditionType) bool {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == condType && cond.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func listPostRolloutJobs(t *testing.T, client *fake.Clientset, namespace string) []batchv1.Job {
+	jobs, err := client.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list jobs: %v", err)
+	}
+	return jobs.Items
+}
+
+func TestSyncPostRolloutJob(t *testing.T) {
+	cases := []struct {
+		name             string
+		condition        batchv1.JobConditionType
+		age              time.Duration
+		expectReason     string
+		expectRolledBack bool
+	}{
+		{
+			name:         "job succeeded",
+			condition:    batchv1.JobComplete,
+			expectReason: PostRolloutJobSucceededReason,
+		},
+		{
+			name:             "job failed",
+			condition:        batchv1.JobFailed,
+			expectReason:     PostRolloutJobFailedReason,
+			expectRolledBack: true,
+		},
+		{
+			name:         "window expired",
+			age:          2 * time.Minute,
+			expectReason: PostRolloutJobSucceededReason,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 0)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("100%"),
+				PostRolloutJob: &rolloutsv1alpha1.DeploymentPostRolloutJob{
+					Template: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: v1.PodTemplateSpec{
+						Spec: v1.PodSpec{Containers: []v1.Container{{Name: "e2e", Image: "e2e:latest"}}},
+					}}},
+					WindowSeconds: 60,
+				},
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS, newRS)
+
+			// The job is created once the rollout completes.
+			var jobs []batchv1.Job
+			for i := 0; i < 3 && len(jobs) == 0; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+				jobs = listPostRolloutJobs(t, client, d.Namespace)
+			}
+			if len(jobs) != 1 {
+				t.Fatalf("expect 1 post rollout job, got %d", len(jobs))
+			}
+			job := &jobs[0]
+			if job.Labels[postRolloutJobRevisionLabel] != "demo-v2" || job.Annotations[postRolloutJobStableAnnotation] != "demo-v1" || !metav1.IsControlledBy(job, d) {
+				t.Fatalf("unexpected post rollout job %+v", job.ObjectMeta)
+			}
+			if dc.requeueAfter != postRolloutJobPollInterval {
+				t.Fatalf("expect post rollout job checked again after %v, got %v", postRolloutJobPollInterval, dc.requeueAfter)
+			}
+
+			job.CreationTimestamp = metav1.NewTime(dc.clock.Now().Add(-cs.age))
+			if cs.condition != "" {
+				job.Status.Conditions = []batchv1.JobCondition{{Type: cs.condition, Status: v1.ConditionTrue}}
+			}
+			if _, err := client.BatchV1().Jobs(d.Namespace).Update(context.TODO(), job, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("failed to update job: %v", err)
+			}
+			for i := 0; i < 20; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+
+			if jobs := listPostRolloutJobs(t, client, d.Namespace); len(jobs) != 0 {
+				t.Fatalf("expect post rollout job cleaned up, got %d", len(jobs))
+			}
+			if !hasEvent(collectEvents(recorder), cs.expectReason) {
+				t.Fatalf("expect %s event", cs.expectReason)
+			}
+			expectImage, expectRevision := "demo:v2", "demo-v2"
+			if cs.expectRolledBack {
+				expectImage, expectRevision = "demo:v1", "demo-v1"
+			}
+			if image := d.Spec.Template.Spec.Containers[0].Image; image != expectImage {
+				t.Fatalf("expect template image %s, got %s", expectImage, image)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas[expectImage] != 10 {
+				t.Fatalf("expect %s fully rolled out, got replicas %v", expectImage, replicas)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.VerifiedRevision != expectRevision {
+				t.Fatalf("expect verified revision %s, got %+v", expectRevision, extraStatus)
+			}
+		})
+	}
+}
+
+func TestSyncPostRolloutJobWithoutStableRevision(t *testing.T) {
+	d := newTestDeployment(3, intstr.FromInt(1), intstr.FromInt(0))
+	newRS := newTestReplicaSet(d, "demo:v2", 1, 3)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:   rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate:  d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:      intstr.FromString("100%"),
+		PostRolloutJob: &rolloutsv1alpha1.DeploymentPostRolloutJob{},
+	}
+	dc, client, _ := newTestController(strategy, d, newRS)
+	for i := 0; i < 3; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if jobs := listPostRolloutJobs(t, client, d.Namespace); len(jobs) != 0 {
+		t.Fatalf("expect no post rollout job for the first revision, got %d", len(jobs))
+	}
+	if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.VerifiedRevision != "demo-v2" {
+		t.Fatalf("expect first revision verified, got %+v", extraStatus)
+	}
+}
@@ -25,7 +25,6 @@ import (
 	apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/klog/v2"
 
 	"github.com/openkruise/rollouts/pkg/controller/deployment/util"
 )
@@ -35,7 +34,7 @@ import (
 // for example a resync of the deployment after it was scaled up. In those cases,
 // we shouldn't try to estimate any progress.
 func (dc *DeploymentController) syncRolloutStatus(ctx context.Context, allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, d *apps.Deployment) error {
-	newStatus := calculateStatus(allRSs, newRS, d)
+	newStatus := dc.calculateStatus(allRSs, newRS, d)
 
 	// If there is no progressDeadlineSeconds set, remove any Progressing condition.
 	if !util.HasProgressDeadline(d) {
@@ -58,17 +57,25 @@ func (dc *DeploymentController) syncRolloutStatus(ctx context.Context, allRSs []
 			if newRS != nil {
 				msg = fmt.Sprintf("ReplicaSet %q has successfully progressed.", newRS.Name)
 			}
-			condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, util.NewRSAvailableReason, msg)
+			condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, util.NewRSAvailableReason, msg, dc.clock.Now())
 			util.SetDeploymentCondition(&newStatus, *condition)
 
-		case util.DeploymentProgressing(d, &newStatus):
+		case dc.isHeldAtPartition(d, newRS, &newStatus):
+			// Update the deployment conditions with an Unknown condition while it is held at partition,
+			// just like a paused deployment. If the condition already exists, we ignore this update.
+			msg := fmt.Sprintf("Deployment %q is held at partition with %d updated replicas.", d.Name, newStatus.UpdatedReplicas)
+			condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionUnknown, util.PartitionHeldReason, msg, dc.clock.Now())
+			util.SetDeploymentCondition(&newStatus, *condition)
+
+		case util.DeploymentProgressing(d, &newStatus) || (currentCond != nil && currentCond.Reason == util.PartitionHeldReason):
 			// If there is any progress made, continue by not checking if the deployment failed. This
-			// behavior emulates the rolling updater progressDeadline check.
+			// behavior emulates the rolling updater progressDeadline check. A deployment released from
+			// partition is regarded as progressing as well, so that the deadline is counted from now on.
 			msg := fmt.Sprintf("Deployment %q is progressing.", d.Name)
 			if newRS != nil {
 				msg = fmt.Sprintf("ReplicaSet %q is progressing.", newRS.Name)
 			}
-			condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, util.ReplicaSetUpdatedReason, msg)
+			condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, util.ReplicaSetUpdatedReason, msg, dc.clock.Now())
 			// Update the current Progressing condition or add a new one if it doesn't exist.
 			// If a Progressing condition with status=true already exists, we should update
 			// everything but lastTransitionTime. SetDeploymentCondition already does that but
@@ -84,18 +91,27 @@ func (dc *DeploymentController) syncRolloutStatus(ctx context.Context, allRSs []
 			}
 			util.SetDeploymentCondition(&newStatus, *condition)
 
-		case util.DeploymentTimedOut(d, &newStatus):
+		case dc.isProgressTimedOut(d, newRS, &newStatus):
 			// Update the deployment with a timeout condition. If the condition already exists,
 			// we ignore this update.
 			msg := fmt.Sprintf("Deployment %q has timed out progressing.", d.Name)
 			if newRS != nil {
 				msg = fmt.Sprintf("ReplicaSet %q has timed out progressing.", newRS.Name)
 			}
-			condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionFalse, util.TimedOutReason, msg)
+			condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionFalse, util.TimedOutReason, msg, dc.clock.Now())
 			util.SetDeploymentCondition(&newStatus, *condition)
 		}
 	}
 
+	// The batch missing its own progress deadline times out regardless of progressDeadlineSeconds.
+	if dc.batchDeadlineExceeded {
+		msg := fmt.Sprintf("Batch with %d expected updated replicas has timed out progressing.", dc.newRSReplicasLimit(d))
+		condition := util.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionFalse, util.TimedOutReason, msg, dc.clock.Now())
+		util.SetDeploymentCondition(&newStatus, *condition)
+	}
+
+	dc.setAdvancedRolloutCondition(d, newRS, &newStatus)
+
 	// Move failure conditions of all replica sets in deployment conditions. For now,
 	// only one failure condition is returned from getReplicaFailures.
 	if replicaFailureCond := dc.getReplicaFailures(allRSs, newRS); len(replicaFailureCond) > 0 {
@@ -118,6 +134,51 @@ func (dc *DeploymentController) syncRolloutStatus(ctx context.Context, allRSs []
 	return err
 }
 
+// isHeldAtPartition returns true if the new replica set has been scaled up to partition and the old
+// replica sets have been scaled down accordingly, i.e. the rolling is held at partition as the steady
+// state until the partition is raised or the deployment is promoted. Waiting for the final drain to be
+// confirmed is held the same way.
+func (dc *DeploymentController) isHeldAtPartition(d *apps.Deployment, newRS *apps.ReplicaSet, newStatus *apps.DeploymentStatus) bool {
+	if dc.isAwaitingDrainConfirmation(d, newRS, newStatus) {
+		return true
+	}
+	replicas := *(d.Spec.Replicas)
+	limit := dc.newRSReplicasLimit(d)
+	if newRS == nil || limit >= replicas || newStatus.ObservedGeneration < d.Generation {
+		return false
+	}
+	// The old replica sets left to others may be scaled down in any pace.
+	if !dc.managesOldReplicaSets() {
+		return newStatus.UpdatedReplicas == limit && newRS.Status.AvailableReplicas >= limit
+	}
+	// The pods of test batch are surged beyond replicas, and not available until promoted.
+	expectedReplicas := replicas
+	if dc.isTestBatch(newRS) {
+		expectedReplicas += limit
+	}
+	return newStatus.UpdatedReplicas == limit && newStatus.Replicas == expectedReplicas && newStatus.AvailableReplicas >= replicas
+}
+
+// setAdvancedRolloutCondition reports the expected updated replicas of the current batch and the updated
+// and available replicas in the AdvancedRolloutProgressing condition, whose reason is PartitionComplete
+// once the new replica set is held at partition or the rollout is complete. The batch is reported instead
+// of partition, so that a new partition resulting in the same batch does not update the status.
+func (dc *DeploymentController) setAdvancedRolloutCondition(d *apps.Deployment, newRS *apps.ReplicaSet, newStatus *apps.DeploymentStatus) {
+	limit := dc.newRSReplicasLimit(d)
+	updatedAvailable := int32(0)
+	if newRS != nil {
+		updatedAvailable = newRS.Status.AvailableReplicas
+	}
+	reason, state := util.BatchProgressingReason, "is progressing"
+	if util.DeploymentComplete(d, newStatus) || dc.isHeldAtPartition(d, newRS, newStatus) {
+		reason, state = util.PartitionCompleteReason, "is complete"
+	}
+	msg := fmt.Sprintf("Batch with %d expected updated replicas %s, %d of %d replicas are updated and %d of them are available.",
+		limit, state, newStatus.UpdatedReplicas, *(d.Spec.Replicas), updatedAvailable)
+	condition := util.NewDeploymentCondition(util.AdvancedRolloutProgressing, v1.ConditionTrue, reason, msg, dc.clock.Now())
+	util.SetDeploymentConditionIfChanged(newStatus, *condition)
+}
+
 // getReplicaFailures will convert replica failure conditions from replica sets
 // to deployment conditions.
 func (dc *DeploymentController) getReplicaFailures(allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet) []apps.DeploymentCondition {
@@ -152,9 +213,6 @@ func (dc *DeploymentController) getReplicaFailures(allRSs []*apps.ReplicaSet, ne
 	return conditions
 }
 
-// used for unit testing
-var nowFn = func() time.Time { return time.Now() }
-
 // requeueStuckDeployment checks whether the provided deployment needs to be synced for a progress
 // check. It returns the time after the deployment will be requeued for the progress check, 0 if it
 // will be requeued now, or -1 if it does not need to be requeued.
@@ -164,8 +222,8 @@ func (dc *DeploymentController) requeueStuckDeployment(d *apps.Deployment, newSt
 	if !util.HasProgressDeadline(d) || currentCond == nil {
 		return time.Duration(-1)
 	}
-	// No need to estimate progress if the rollout is complete or already timed out.
-	if util.DeploymentComplete(d, &newStatus) || currentCond.Reason == util.TimedOutReason {
+	// No need to estimate progress if the rollout is complete, already timed out or held at partition.
+	if util.DeploymentComplete(d, &newStatus) || currentCond.Reason == util.TimedOutReason || currentCond.Reason == util.PartitionHeldReason {
 		return time.Duration(-1)
 	}
 	// If there is no sign of progress at this point then there is a high chance that the
@@ -183,16 +241,16 @@ func (dc *DeploymentController) requeueStuckDeployment(d *apps.Deployment, newSt
 	// progressDeadlineSeconds: 600 (10 minutes)
 	//
 	// lastUpdated + progressDeadlineSeconds - now => 00:00:00 + 00:10:00 - 00:03:00 => 07:00
-	after := currentCond.LastUpdateTime.Time.Add(time.Duration(*d.Spec.ProgressDeadlineSeconds) * time.Second).Sub(nowFn())
+	after := currentCond.LastUpdateTime.Time.Add(time.Duration(*d.Spec.ProgressDeadlineSeconds) * time.Second).Sub(dc.clock.Now())
 	// If the remaining time is less than a second, then requeue the deployment immediately.
 	// Make it ratelimited so we stay on the safe side, eventually the Deployment should
 	// transition either to a Complete or to a TimedOut condition.
 	if after < time.Second {
-		klog.V(4).Infof("Queueing up deployment %q for a progress check now", d.Name)
+		dc.log().V(4).Info("Queueing up deployment for a progress check now")
 		// dc.enqueueRateLimited(d)  requeue
 		return time.Duration(0)
 	}
-	klog.V(4).Infof("Queueing up deployment %q for a progress check after %ds", d.Name, int(after.Seconds()))
+	dc.log().V(4).Info("Queueing up deployment for a progress check", "after", after)
 	// Add a second to avoid milliseconds skew in AddAfter.
 	// See https://github.com/kubernetes/kubernetes/issues/39785#issuecomment-279959133 for more info.
 	// dc.enqueueAfter(d, after+time.Second) requeue
@@ -0,0 +1,90 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+// isProgressTimedOut returns true if the deployment has exceeded its progress deadline. If the
+// deadline counts from pods initialized, it is not exceeded while any new pod is initializing,
+// and counts from the last time a new pod was initialized if it is later than the last progress.
+func (dc *DeploymentController) isProgressTimedOut(d *apps.Deployment, newRS *apps.ReplicaSet, newStatus *apps.DeploymentStatus) bool {
+	if dc.strategy.ProgressDeadlineFrom != rolloutsv1alpha1.PodsInitializedProgressDeadline || newRS == nil {
+		return deploymentutil.DeploymentTimedOut(d, newStatus, dc.clock.Now())
+	}
+	cond := deploymentutil.GetDeploymentCondition(*newStatus, apps.DeploymentProgressing)
+	if cond == nil || cond.Reason == deploymentutil.TimedOutReason {
+		return deploymentutil.DeploymentTimedOut(d, newStatus, dc.clock.Now())
+	}
+
+	initializing, lastInitialized, err := dc.inspectPodsInitialization(newRS)
+	if err != nil {
+		dc.log().Error(err, "Failed to inspect initialization of new pods")
+		return deploymentutil.DeploymentTimedOut(d, newStatus, dc.clock.Now())
+	}
+	if initializing {
+		dc.log().V(4).Info("New pods are initializing, progress deadline is not counted")
+		return false
+	}
+	if !lastInitialized.After(cond.LastUpdateTime.Time) {
+		return deploymentutil.DeploymentTimedOut(d, newStatus, dc.clock.Now())
+	}
+	status := newStatus.DeepCopy()
+	cond.LastUpdateTime = lastInitialized
+	deploymentutil.RemoveDeploymentCondition(status, apps.DeploymentProgressing)
+	deploymentutil.SetDeploymentCondition(status, *cond)
+	return deploymentutil.DeploymentTimedOut(d, status, dc.clock.Now())
+}
+
+// inspectPodsInitialization returns whether any scheduled pod of newRS is still running its init
+// containers, and the last time a pod of newRS was initialized. The pods not scheduled yet are
+// not regarded as initializing, so that the rollout stuck in scheduling still times out.
+func (dc *DeploymentController) inspectPodsInitialization(newRS *apps.ReplicaSet) (bool, metav1.Time, error) {
+	selector, err := metav1.LabelSelectorAsSelector(newRS.Spec.Selector)
+	if err != nil {
+		return false, metav1.Time{}, err
+	}
+	pods, err := dc.cachedPods(newRS.Namespace, selector)
+	if err != nil {
+		return false, metav1.Time{}, err
+	}
+
+	initializing, lastInitialized := false, metav1.Time{}
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(pod, newRS) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		_, initialized := util.GetPodCondition(&pod.Status, v1.PodInitialized)
+		if initialized != nil && initialized.Status == v1.ConditionTrue {
+			if initialized.LastTransitionTime.After(lastInitialized.Time) {
+				lastInitialized = initialized.LastTransitionTime
+			}
+			continue
+		}
+		if _, scheduled := util.GetPodCondition(&pod.Status, v1.PodScheduled); scheduled != nil && scheduled.Status == v1.ConditionTrue {
+			initializing = true
+		}
+	}
+	return initializing, lastInitialized, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncRolloutStatusWithLongInitPods(t *testing.T) {
+	cases := []struct {
+		name          string
+		deadlineFrom  rolloutsv1alpha1.ProgressDeadlineFromType
+		scheduled     bool
+		initializedAt time.Duration
+		expectReason  string
+	}{
+		{
+			name:         "deadline from batch start with initializing pod",
+			scheduled:    true,
+			expectReason: deploymentutil.TimedOutReason,
+		},
+		{
+			name:         "deadline from pods initialized with initializing pod",
+			deadlineFrom: rolloutsv1alpha1.PodsInitializedProgressDeadline,
+			scheduled:    true,
+			expectReason: deploymentutil.ReplicaSetUpdatedReason,
+		},
+		{
+			name:          "deadline from pods initialized recently",
+			deadlineFrom:  rolloutsv1alpha1.PodsInitializedProgressDeadline,
+			scheduled:     true,
+			initializedAt: 5 * time.Minute,
+			expectReason:  deploymentutil.ReplicaSetUpdatedReason,
+		},
+		{
+			name:          "deadline from pods initialized long ago",
+			deadlineFrom:  rolloutsv1alpha1.PodsInitializedProgressDeadline,
+			scheduled:     true,
+			initializedAt: 20 * time.Minute,
+			expectReason:  deploymentutil.TimedOutReason,
+		},
+		{
+			name:         "deadline from pods initialized with unscheduled pod",
+			deadlineFrom: rolloutsv1alpha1.PodsInitializedProgressDeadline,
+			expectReason: deploymentutil.TimedOutReason,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 8)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			newRS.Status.AvailableReplicas, newRS.Status.ReadyReplicas = 2, 2
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:         rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:        d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:            intstr.FromString("50%"),
+				ProgressDeadlineFrom: cs.deadlineFrom,
+			}
+
+			// The last new pod runs its init containers for a long time, or is initialized at last.
+			now := time.Now()
+			pod := newTestPod(newRS, "long-init")
+			pod.Status.Phase = v1.PodPending
+			pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodInitialized, Status: v1.ConditionFalse}}
+			if cs.scheduled {
+				pod.Status.Conditions = append(pod.Status.Conditions, v1.PodCondition{Type: v1.PodScheduled, Status: v1.ConditionTrue})
+			}
+			if cs.initializedAt > 0 {
+				pod.Status.Conditions[0].Status = v1.ConditionTrue
+				pod.Status.Conditions[0].LastTransitionTime = metav1.NewTime(now.Add(-cs.initializedAt))
+			}
+			ready := newTestPod(newRS, "ready")
+			ready.Status.Conditions = append(ready.Status.Conditions, v1.PodCondition{
+				Type: v1.PodInitialized, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(now.Add(-time.Hour)),
+			})
+			dc, client, _ := newTestController(strategy, d, oldRS, newRS, pod, ready)
+			allRSs := []*apps.ReplicaSet{oldRS, newRS}
+
+			// The last progress was reported long before the progress deadline.
+			d.Status = dc.calculateStatus(allRSs, newRS, d)
+			condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, deploymentutil.ReplicaSetUpdatedReason, "", now.Add(-time.Hour))
+			deploymentutil.SetDeploymentCondition(&d.Status, *condition)
+			if _, err := client.AppsV1().Deployments(d.Namespace).UpdateStatus(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+				t.Fatalf("failed to update deployment status: %v", err)
+			}
+
+			if err := dc.syncRolloutStatus(context.TODO(), allRSs, newRS, d); err != nil {
+				t.Fatalf("failed to sync rollout status: %v", err)
+			}
+			d, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+			if cond == nil || cond.Reason != cs.expectReason {
+				t.Fatalf("expect progressing condition %s, got %+v", cs.expectReason, cond)
+			}
+		})
+	}
+}
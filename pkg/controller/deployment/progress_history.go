@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	apps "k8s.io/api/apps/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// syncProgressHistory carries the progress history over from the previous extra status, and
+// appends a record once a new batch is started, i.e. the batch start time is reset by
+// syncProgressTimes. diff is the summary of template diff if the revision is changed.
+func syncProgressHistory(prev, cur *rolloutsv1alpha1.DeploymentExtraStatus, diff string) {
+	if prev != nil {
+		cur.History = append([]rolloutsv1alpha1.DeploymentProgressRecord{}, prev.History...)
+	}
+	if cur.BatchStartTime == nil || (prev != nil && cur.BatchStartTime == prev.BatchStartTime) {
+		return
+	}
+	cur.History = append(cur.History, rolloutsv1alpha1.DeploymentProgressRecord{
+		Revision:                cur.UpdateRevision,
+		ExpectedUpdatedReplicas: cur.ExpectedUpdatedReplicas,
+		StartTime:               *cur.BatchStartTime,
+		Diff:                    diff,
+	})
+}
+
+// batchReadiness returns the fraction of ready pods to the expected updated replicas of the
+// current batch, e.g. "3/5". The ready pods beyond the batch are not counted, so that it never
+// exceeds 1. It only changes with the integers, so the extra status is not updated more often.
+func batchReadiness(ready, expected int32) string {
+	if expected <= 0 {
+		return ""
+	}
+	if ready > expected {
+		ready = expected
+	}
+	return fmt.Sprintf("%d/%d", ready, expected)
+}
+
+// oldReplicaSetSizes returns the sizes of old replica sets which still have replicas, from the
+// latest revision to the oldest, so that how the old replicas are drained can be tracked. At
+// most MaxReportedOldReplicaSets of them are returned to bound the size of extra status.
+func oldReplicaSetSizes(newRS *apps.ReplicaSet, rsList []*apps.ReplicaSet) []rolloutsv1alpha1.DeploymentReplicaSetSize {
+	oldRSs := deploymentutil.FilterReplicaSets(rsList, func(rs *apps.ReplicaSet) bool {
+		return rs != nil && (newRS == nil || rs.UID != newRS.UID) && *(rs.Spec.Replicas) > 0
+	})
+	if len(oldRSs) == 0 {
+		return nil
+	}
+	sort.Sort(sort.Reverse(deploymentutil.ReplicaSetsByRevision(oldRSs)))
+	if len(oldRSs) > rolloutsv1alpha1.MaxReportedOldReplicaSets {
+		oldRSs = oldRSs[:rolloutsv1alpha1.MaxReportedOldReplicaSets]
+	}
+	sizes := make([]rolloutsv1alpha1.DeploymentReplicaSetSize, 0, len(oldRSs))
+	for _, rs := range oldRSs {
+		sizes = append(sizes, rolloutsv1alpha1.DeploymentReplicaSetSize{Name: rs.Name, Replicas: *(rs.Spec.Replicas)})
+	}
+	return sizes
+}
+
+// marshalExtraStatus marshals the extra status into no more than maxSize bytes if maxSize is
+// positive. The oldest details are dropped first: the oldest records of the progress history
+// are dropped until only the latest one is left, then its diff, and finally the record itself.
+// The other fields are always kept, which are small and bounded.
+func marshalExtraStatus(status *rolloutsv1alpha1.DeploymentExtraStatus, maxSize int) ([]byte, error) {
+	data, err := json.Marshal(status)
+	if err != nil || maxSize <= 0 || len(data) <= maxSize {
+		return data, err
+	}
+
+	status = status.DeepCopy()
+	for len(data) > maxSize && len(status.History) > 0 {
+		switch latest := &status.History[len(status.History)-1]; {
+		case len(status.History) > 1:
+			status.History = status.History[1:]
+		case latest.Diff != "":
+			latest.Diff = ""
+		default:
+			status.History = nil
+		}
+		if data, err = json.Marshal(status); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
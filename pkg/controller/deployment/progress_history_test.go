@@ -0,0 +1,313 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func newTestProgressHistory(n int, diff string) []rolloutsv1alpha1.DeploymentProgressRecord {
+	start := time.Now().Add(-time.Duration(n) * time.Minute)
+	history := make([]rolloutsv1alpha1.DeploymentProgressRecord, n)
+	for i := range history {
+		history[i] = rolloutsv1alpha1.DeploymentProgressRecord{
+			Revision:                fmt.Sprintf("rev-%d", i),
+			ExpectedUpdatedReplicas: int32(i),
+			StartTime:               metav1.NewTime(start.Add(time.Duration(i) * time.Minute)),
+			Diff:                    diff,
+		}
+	}
+	return history
+}
+
+func TestMarshalExtraStatus(t *testing.T) {
+	cases := []struct {
+		name          string
+		history       []rolloutsv1alpha1.DeploymentProgressRecord
+		maxSize       int
+		expectRecords int
+		expectDiff    bool
+	}{
+		{
+			name:          "no limit",
+			history:       newTestProgressHistory(100, strings.Repeat("x", 100)),
+			expectRecords: 100,
+			expectDiff:    true,
+		},
+		{
+			name:          "within limit",
+			history:       newTestProgressHistory(3, "image demo:v1 -> demo:v2"),
+			maxSize:       4096,
+			expectRecords: 3,
+			expectDiff:    true,
+		},
+		{
+			name:          "oldest records are dropped",
+			history:       newTestProgressHistory(100, strings.Repeat("x", 100)),
+			maxSize:       2048,
+			expectRecords: -1,
+			expectDiff:    true,
+		},
+		{
+			name:          "diff of the latest record is dropped",
+			history:       newTestProgressHistory(2, strings.Repeat("x", 512)),
+			maxSize:       400,
+			expectRecords: 1,
+		},
+		{
+			name:    "the latest record is dropped",
+			history: newTestProgressHistory(2, strings.Repeat("x", 512)),
+			maxSize: 250,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			now := metav1.Now()
+			status := &rolloutsv1alpha1.DeploymentExtraStatus{
+				ObservedGeneration:      2,
+				ExpectedUpdatedReplicas: 5,
+				UpdateRevision:          "rev-latest",
+				RolloutStartTime:        &now,
+				BatchStartTime:          &now,
+				History:                 cs.history,
+			}
+			data, err := marshalExtraStatus(status, cs.maxSize)
+			if err != nil {
+				t.Fatalf("failed to marshal extra status: %v", err)
+			}
+			if cs.maxSize > 0 && len(data) > cs.maxSize {
+				t.Fatalf("expect at most %d bytes, got %d", cs.maxSize, len(data))
+			}
+			if len(status.History) != len(cs.history) {
+				t.Fatalf("expect the extra status not to be changed")
+			}
+
+			got := &rolloutsv1alpha1.DeploymentExtraStatus{}
+			if err := json.Unmarshal(data, got); err != nil {
+				t.Fatalf("failed to unmarshal extra status: %v", err)
+			}
+			if got.UpdateRevision != status.UpdateRevision || got.ExpectedUpdatedReplicas != status.ExpectedUpdatedReplicas {
+				t.Fatalf("expect the other fields to be kept, got %+v", got)
+			}
+			if cs.expectRecords >= 0 && len(got.History) != cs.expectRecords {
+				t.Fatalf("expect %d records, got %d", cs.expectRecords, len(got.History))
+			}
+			if cs.expectRecords < 0 && (len(got.History) == 0 || len(got.History) == len(cs.history)) {
+				t.Fatalf("expect the history to be truncated, got %d records", len(got.History))
+			}
+			if len(got.History) == 0 {
+				return
+			}
+			// What is left must be the latest records in order.
+			offset := len(cs.history) - len(got.History)
+			for i, record := range got.History {
+				if record.Revision != cs.history[offset+i].Revision {
+					t.Fatalf("expect record %s at %d, got %s", cs.history[offset+i].Revision, i, record.Revision)
+				}
+			}
+			if latest := got.History[len(got.History)-1]; (latest.Diff != "") != cs.expectDiff {
+				t.Fatalf("expect diff kept %v, got %q", cs.expectDiff, latest.Diff)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentBoundsProgressHistory(t *testing.T) {
+	defer func(size int) { extraStatusMaxSize = size }(extraStatusMaxSize)
+	extraStatusMaxSize = 2048
+
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	prev := &rolloutsv1alpha1.DeploymentExtraStatus{UpdateRevision: "rev-old", History: newTestProgressHistory(200, strings.Repeat("x", 100))}
+	data, _ := json.Marshal(prev)
+	d.Annotations = map[string]string{rolloutsv1alpha1.DeploymentExtraStatusAnnotation: string(data)}
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	// The new replica set is created in the first sync, and recorded in the next one.
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	if size := len(d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation]); size > extraStatusMaxSize {
+		t.Fatalf("expect extra status at most %d bytes, got %d", extraStatusMaxSize, size)
+	}
+	extraStatus := getExtraStatus(d)
+	if extraStatus == nil || len(extraStatus.History) == 0 {
+		t.Fatalf("expect progress history, got %+v", extraStatus)
+	}
+	if len(extraStatus.History) >= 200 {
+		t.Fatalf("expect the history to be truncated, got %d records", len(extraStatus.History))
+	}
+	latest := extraStatus.History[len(extraStatus.History)-1]
+	if latest.Revision != extraStatus.UpdateRevision || latest.ExpectedUpdatedReplicas != 5 || latest.Diff == "" {
+		t.Fatalf("expect the latest record of the current batch, got %+v", latest)
+	}
+}
+
+func TestBatchReadiness(t *testing.T) {
+	cases := []struct {
+		name     string
+		ready    int32
+		expected int32
+		expect   string
+	}{
+		{
+			name:     "batch scaling up",
+			ready:    3,
+			expected: 5,
+			expect:   "3/5",
+		},
+		{
+			name:     "batch ready",
+			ready:    5,
+			expected: 5,
+			expect:   "5/5",
+		},
+		{
+			name:     "ready beyond batch",
+			ready:    7,
+			expected: 5,
+			expect:   "5/5",
+		},
+		{
+			name:     "no pod expected",
+			ready:    2,
+			expected: 0,
+			expect:   "",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if got := batchReadiness(cs.ready, cs.expected); got != cs.expect {
+				t.Fatalf("expect batch readiness %q, got %q", cs.expect, got)
+			}
+		})
+	}
+	t.Run("extra status", func(t *testing.T) {
+		d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+		oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+		newRS := newTestReplicaSet(d, "demo:v2", 2, 2)
+		newRS.Status.ReadyReplicas = 1
+		strategy := rolloutsv1alpha1.DeploymentStrategy{
+			RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+			RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+			Partition:     intstr.FromString("30%"),
+		}
+		dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+		if err := dc.updateExtraStatus(context.TODO(), d, []*apps.ReplicaSet{oldRS, newRS}); err != nil {
+			t.Fatalf("failed to update extra status: %v", err)
+		}
+		d, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get deployment: %v", err)
+		}
+		if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.BatchReadiness != "1/3" {
+			t.Fatalf("expect batch readiness 1/3, got %+v", extraStatus)
+		}
+	})
+}
+
+func TestOldReplicaSetSizes(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	newRS := newTestReplicaSet(d, "demo:v9", 9, 2)
+	var manyOldRSs []*apps.ReplicaSet
+	for i := 1; i <= 8; i++ {
+		manyOldRSs = append(manyOldRSs, newTestReplicaSet(d, fmt.Sprintf("demo:v%d", i), int64(i), 1))
+	}
+
+	cases := []struct {
+		name   string
+		rsList []*apps.ReplicaSet
+		expect []rolloutsv1alpha1.DeploymentReplicaSetSize
+	}{
+		{
+			name:   "no old replica set",
+			rsList: []*apps.ReplicaSet{newRS},
+		},
+		{
+			name: "scaled down old replica sets are omitted",
+			rsList: []*apps.ReplicaSet{
+				newTestReplicaSet(d, "demo:v1", 1, 0),
+				newTestReplicaSet(d, "demo:v2", 2, 3),
+				newTestReplicaSet(d, "demo:v3", 3, 5),
+				newRS,
+			},
+			expect: []rolloutsv1alpha1.DeploymentReplicaSetSize{
+				{Name: "deployment-demo-v3", Replicas: 5},
+				{Name: "deployment-demo-v2", Replicas: 3},
+			},
+		},
+		{
+			name:   "latest old replica sets are reported at most",
+			rsList: append([]*apps.ReplicaSet{newRS}, manyOldRSs...),
+			expect: []rolloutsv1alpha1.DeploymentReplicaSetSize{
+				{Name: "deployment-demo-v8", Replicas: 1},
+				{Name: "deployment-demo-v7", Replicas: 1},
+				{Name: "deployment-demo-v6", Replicas: 1},
+				{Name: "deployment-demo-v5", Replicas: 1},
+				{Name: "deployment-demo-v4", Replicas: 1},
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if got := oldReplicaSetSizes(newRS, cs.rsList); !reflect.DeepEqual(got, cs.expect) {
+				t.Fatalf("expect old replica sets %v, got %v", cs.expect, got)
+			}
+		})
+	}
+
+	t.Run("extra status", func(t *testing.T) {
+		oldRS := newTestReplicaSet(d, "demo:v1", 1, 8)
+		newRS := newTestReplicaSet(d, "demo:v2", 2, 2)
+		strategy := rolloutsv1alpha1.DeploymentStrategy{
+			RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+			RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+			Partition:     intstr.FromString("30%"),
+		}
+		dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+		if err := dc.updateExtraStatus(context.TODO(), d, []*apps.ReplicaSet{oldRS, newRS}); err != nil {
+			t.Fatalf("failed to update extra status: %v", err)
+		}
+		latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get deployment: %v", err)
+		}
+		expect := []rolloutsv1alpha1.DeploymentReplicaSetSize{{Name: oldRS.Name, Replicas: 8}}
+		if extraStatus := getExtraStatus(latest); extraStatus == nil || !reflect.DeepEqual(extraStatus.OldReplicaSets, expect) {
+			t.Fatalf("expect old replica sets %v, got %+v", expect, extraStatus)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncRolloutStatusConditionReasons(t *testing.T) {
+	cases := []struct {
+		name          string
+		partition     intstr.IntOrString
+		testBatch     bool
+		newReplicas   int32
+		oldReplicas   int32
+		currentReason string
+		progressed    bool
+		expectReason  string
+		expectStatus  v1.ConditionStatus
+	}{
+		{
+			name:          "new replica set available",
+			partition:     intstr.FromString("30%"),
+			newReplicas:   10,
+			currentReason: deploymentutil.ReplicaSetUpdatedReason,
+			expectReason:  deploymentutil.NewRSAvailableReason,
+			expectStatus:  v1.ConditionTrue,
+		},
+		{
+			name:          "progressing",
+			partition:     intstr.FromString("30%"),
+			newReplicas:   2,
+			oldReplicas:   8,
+			currentReason: deploymentutil.ReplicaSetUpdatedReason,
+			progressed:    true,
+			expectReason:  deploymentutil.ReplicaSetUpdatedReason,
+			expectStatus:  v1.ConditionTrue,
+		},
+		{
+			name:          "progress deadline exceeded",
+			partition:     intstr.FromString("30%"),
+			newReplicas:   2,
+			oldReplicas:   8,
+			currentReason: deploymentutil.ReplicaSetUpdatedReason,
+			expectReason:  deploymentutil.TimedOutReason,
+			expectStatus:  v1.ConditionFalse,
+		},
+		{
+			name:          "held at partition beyond progress deadline",
+			partition:     intstr.FromString("30%"),
+			newReplicas:   3,
+			oldReplicas:   7,
+			currentReason: deploymentutil.ReplicaSetUpdatedReason,
+			expectReason:  deploymentutil.PartitionHeldReason,
+			expectStatus:  v1.ConditionUnknown,
+		},
+		{
+			name:          "held at partition with test batch",
+			partition:     intstr.FromString("30%"),
+			testBatch:     true,
+			newReplicas:   3,
+			oldReplicas:   10,
+			currentReason: deploymentutil.ReplicaSetUpdatedReason,
+			expectReason:  deploymentutil.PartitionHeldReason,
+			expectStatus:  v1.ConditionUnknown,
+		},
+		{
+			name:          "released from partition",
+			partition:     intstr.FromString("100%"),
+			newReplicas:   3,
+			oldReplicas:   7,
+			currentReason: deploymentutil.PartitionHeldReason,
+			expectReason:  deploymentutil.ReplicaSetUpdatedReason,
+			expectStatus:  v1.ConditionTrue,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, cs.oldReplicas)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, cs.newReplicas)
+			if cs.testBatch {
+				// The pods of test batch are not available until promoted.
+				newRS.Spec.Template.Spec.ReadinessGates = []v1.PodReadinessGate{{ConditionType: rolloutsv1alpha1.TestBatchReadinessGate}}
+				newRS.Status.AvailableReplicas = 0
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     cs.partition,
+				TestBatch:     cs.testBatch,
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+			allRSs := []*apps.ReplicaSet{oldRS, newRS}
+
+			// The current condition was updated long before the progress deadline.
+			d.Status = dc.calculateStatus(allRSs, newRS, d)
+			if cs.progressed {
+				d.Status.UpdatedReplicas--
+			}
+			lastUpdate := dc.clock.Now().Add(-time.Hour)
+			condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, cs.currentReason, "", lastUpdate)
+			if cs.currentReason == deploymentutil.PartitionHeldReason {
+				condition.Status = v1.ConditionUnknown
+			}
+			deploymentutil.SetDeploymentCondition(&d.Status, *condition)
+
+			if err := dc.syncRolloutStatus(context.TODO(), allRSs, newRS, d); err != nil {
+				t.Fatalf("failed to sync rollout status: %v", err)
+			}
+			d, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+			if cond == nil || cond.Reason != cs.expectReason || cond.Status != cs.expectStatus {
+				t.Fatalf("expect progressing condition %s/%s, got %+v", cs.expectStatus, cs.expectReason, cond)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentConditionReasons(t *testing.T) {
+	t.Run("paused", func(t *testing.T) {
+		d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+		d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+		oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+		strategy := rolloutsv1alpha1.DeploymentStrategy{
+			RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+			RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+			Partition:     intstr.FromString("100%"),
+			Paused:        true,
+		}
+		dc, client, _ := newTestController(strategy, d, oldRS)
+
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+		if cond == nil || cond.Reason != deploymentutil.PausedDeployReason || cond.Status != v1.ConditionUnknown {
+			t.Fatalf("expect progressing condition Unknown/%s, got %+v", deploymentutil.PausedDeployReason, cond)
+		}
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+		d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+		oldRS := newTestReplicaSet(d, "demo:v1", 1, 0)
+		newRS := newTestReplicaSet(d, "demo:v2", 2, 10)
+		// The rollout to v2 was completed, and then the template is rolled back to v1.
+		d.Status = apps.DeploymentStatus{ObservedGeneration: 1, Replicas: 10, UpdatedReplicas: 10, ReadyReplicas: 10, AvailableReplicas: 10}
+		condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, deploymentutil.NewRSAvailableReason, "", time.Now().Add(-time.Hour))
+		deploymentutil.SetDeploymentCondition(&d.Status, *condition)
+		d.Spec.Template.Spec.Containers[0].Image = "demo:v1"
+		d.Generation = 2
+		strategy := rolloutsv1alpha1.DeploymentStrategy{
+			RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+			RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+			Partition:     intstr.FromString("100%"),
+		}
+		dc, client, recorder := newTestController(strategy, d, oldRS, newRS)
+
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+		if cond == nil || cond.Reason != deploymentutil.RolledBackReason || cond.Status != v1.ConditionTrue {
+			t.Fatalf("expect progressing condition True/%s, got %+v", deploymentutil.RolledBackReason, cond)
+		}
+		if !hasEvent(collectEvents(recorder), deploymentutil.RolledBackReason) {
+			t.Errorf("expect %s event", deploymentutil.RolledBackReason)
+		}
+
+		for i := 0; i < 20; i++ {
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		}
+		cond = deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
+		if cond == nil || cond.Reason != deploymentutil.NewRSAvailableReason {
+			t.Fatalf("expect progressing condition %s after rolled back, got %+v", deploymentutil.NewRSAvailableReason, cond)
+		}
+	})
+}
+
+func TestSyncAdvancedRolloutProgressingCondition(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+	fakeClock := dc.clock.(*testingclock.FakeClock)
+
+	expectCondition := func(reason, msg string) *apps.DeploymentCondition {
+		cond := deploymentutil.GetDeploymentCondition(d.Status, deploymentutil.AdvancedRolloutProgressing)
+		if cond == nil || cond.Status != v1.ConditionTrue || cond.Reason != reason || cond.Message != msg {
+			t.Fatalf("expect %s condition with reason %s and message %q, got %+v", deploymentutil.AdvancedRolloutProgressing, reason, msg, cond)
+		}
+		return cond
+	}
+
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	expectCondition(deploymentutil.BatchProgressingReason,
+		"Batch with 5 expected updated replicas is progressing, 0 of 10 replicas are updated and 0 of them are available.")
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	complete := expectCondition(deploymentutil.PartitionCompleteReason,
+		"Batch with 5 expected updated replicas is complete, 5 of 10 replicas are updated and 5 of them are available.")
+
+	// The condition is not updated again while nothing is changed.
+	fakeClock.Step(time.Minute)
+	client.ClearActions()
+	for i := 0; i < 3; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetResource().Resource == "deployments" && action.GetSubresource() == "status" {
+			t.Fatalf("expect no status update while held at partition, got %v", action)
+		}
+	}
+	if cond := expectCondition(complete.Reason, complete.Message); !cond.LastUpdateTime.Equal(&complete.LastUpdateTime) {
+		t.Fatalf("expect last update time kept at %v, got %v", complete.LastUpdateTime, cond.LastUpdateTime)
+	}
+
+	// The next batch is reported once the partition is raised.
+	dc.strategy.Partition = intstr.FromString("100%")
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	if cond := expectCondition(deploymentutil.BatchProgressingReason,
+		"Batch with 10 expected updated replicas is progressing, 5 of 10 replicas are updated and 5 of them are available."); cond.LastUpdateTime.Equal(&complete.LastUpdateTime) {
+		t.Fatalf("expect last update time changed, got %v", cond.LastUpdateTime)
+	}
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expectCondition(deploymentutil.PartitionCompleteReason,
+		"Batch with 10 expected updated replicas is complete, 10 of 10 replicas are updated and 10 of them are available.")
+}
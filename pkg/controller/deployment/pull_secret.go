@@ -0,0 +1,69 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"strings"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// MissingImagePullSecretReason is added in a deployment event when the rollout is not started
+// because some image pull secrets referred to by the template do not exist.
+const MissingImagePullSecretReason = "MissingImagePullSecret"
+
+// missingPullSecretRecheckInterval is how often to check the missing image pull secrets again,
+// since the deployment is not notified once they are created.
+const missingPullSecretRecheckInterval = 30 * time.Second
+
+// checkImagePullSecrets returns true and emits a warning event if the deployment is going to
+// start rolling, but some image pull secrets of its template are missing, so that the rollout
+// fails fast instead of creating pods which can never pull their images. It is only checked
+// before the new replica set is scaled up, and only if the secret lister is configured.
+func (dc *DeploymentController) checkImagePullSecrets(d *apps.Deployment, rsList []*apps.ReplicaSet) bool {
+	if dc.secretLister == nil || len(d.Spec.Template.Spec.ImagePullSecrets) == 0 {
+		return false
+	}
+	if newRS := deploymentutil.FindNewReplicaSet(d, rsList); newRS != nil && *newRS.Spec.Replicas > 0 {
+		return false
+	}
+
+	var missing []string
+	for _, ref := range d.Spec.Template.Spec.ImagePullSecrets {
+		if ref.Name == "" {
+			continue
+		}
+		_, err := dc.secretLister.Secrets(d.Namespace).Get(ref.Name)
+		if errors.IsNotFound(err) {
+			missing = append(missing, ref.Name)
+		} else if err != nil {
+			dc.log().Error(err, "Failed to get image pull secret", "secret", ref.Name)
+		}
+	}
+	if len(missing) == 0 {
+		return false
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, MissingImagePullSecretReason,
+		"Rollout is not started since image pull secrets %s are not found", strings.Join(missing, ", "))
+	dc.enqueueAfter(d, missingPullSecretRecheckInterval)
+	return true
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentImagePullSecrets(t *testing.T) {
+	cases := []struct {
+		name          string
+		secrets       []string
+		disabled      bool
+		expectStarted bool
+	}{
+		{
+			name:          "pull secrets present",
+			secrets:       []string{"registry-a", "registry-b"},
+			expectStarted: true,
+		},
+		{
+			name:    "pull secret missing",
+			secrets: []string{"registry-a"},
+		},
+		{
+			name:          "check disabled",
+			disabled:      true,
+			expectStarted: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			d.Spec.Template.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "registry-a"}, {Name: "registry-b"}}
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("30%"),
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS)
+			if !cs.disabled {
+				secretIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{})
+				for _, name := range cs.secrets {
+					_ = secretIndexer.Add(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: d.Namespace, Name: name}})
+				}
+				dc.secretLister = corelisters.NewSecretLister(secretIndexer)
+			}
+
+			for i := 0; i < 3; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			replicas := getReplicaSetReplicas(t, client, d.Namespace)
+			if started := replicas["demo:v2"] > 0; started != cs.expectStarted {
+				t.Fatalf("expect rollout started %v, got replicas %v", cs.expectStarted, replicas)
+			}
+			if replicas["demo:v1"] != 10 && !cs.expectStarted {
+				t.Fatalf("expect old replicas kept, got replicas %v", replicas)
+			}
+			if hasEvent(collectEvents(recorder), MissingImagePullSecretReason) == cs.expectStarted {
+				t.Fatalf("expect %s event %v", MissingImagePullSecretReason, !cs.expectStarted)
+			}
+			if !cs.expectStarted && dc.requeueAfter != missingPullSecretRecheckInterval {
+				t.Fatalf("expect missing pull secrets checked again after %v, got %v", missingPullSecretRecheckInterval, dc.requeueAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// ReadinessRegressedReason is added in a deployment event when the updated ready replicas
+	// fall below the ones of a completed batch of the current rollout.
+	ReadinessRegressedReason = "ReadinessRegressed"
+
+	// ReadinessRecoveredReason is added in a deployment event when the regressed readiness
+	// recovers, and the rollout advances again.
+	ReadinessRecoveredReason = "ReadinessRecovered"
+
+	// ReadinessRegressionRolledBackReason is added in a deployment event when the deployment is
+	// rolled back to the previous revision because of the readiness regression.
+	ReadinessRegressionRolledBackReason = "ReadinessRegressionRolledBack"
+)
+
+// syncReadinessRegression records the last completed batch of the current rollout, and whether
+// the updated ready replicas have regressed below it since then. The regression after the rollout
+// completes is not taken into account, since there is nothing left to halt.
+func (dc *DeploymentController) syncReadinessRegression(d *apps.Deployment, prev, cur *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if dc.strategy.ReadinessRegression == "" || cur.UpdateRevision == "" {
+		return
+	}
+	sameRevision := prev != nil && prev.UpdateRevision == cur.UpdateRevision
+	completed := int32(0)
+	if sameRevision {
+		completed = prev.CompletedBatchReplicas
+	}
+	if cur.ExpectedUpdatedReplicas > 0 && cur.UpdatedReadyReplicas >= cur.ExpectedUpdatedReplicas {
+		completed = integer.Int32Max(completed, cur.ExpectedUpdatedReplicas)
+	}
+	replicas := *(d.Spec.Replicas)
+	cur.CompletedBatchReplicas = integer.Int32Min(completed, replicas)
+	cur.ReadinessRegressed = cur.CompletedBatchReplicas < replicas && cur.UpdatedReadyReplicas < cur.CompletedBatchReplicas
+
+	regressed := sameRevision && prev.ReadinessRegressed
+	if cur.ReadinessRegressed && !regressed {
+		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, ReadinessRegressedReason,
+			"Updated ready replicas of revision %s regressed to %d, below the completed batch with %d replicas",
+			cur.UpdateRevision, cur.UpdatedReadyReplicas, cur.CompletedBatchReplicas)
+	} else if !cur.ReadinessRegressed && regressed {
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, ReadinessRecoveredReason,
+			"Updated ready replicas of revision %s recovered to %d", cur.UpdateRevision, cur.UpdatedReadyReplicas)
+	}
+}
+
+// limitByReadinessRegression holds the new replica set at the completed batch in the previous extra
+// status while its readiness is regressed, no matter how limit is advanced. Like limitByBatchGates,
+// the hold is released once the generation is changed.
+func (dc *DeploymentController) limitByReadinessRegression(d *apps.Deployment, limit int32) int32 {
+	if dc.strategy.ReadinessRegression == "" {
+		return limit
+	}
+	prev := getExtraStatus(d)
+	if prev == nil || prev.ObservedGeneration != d.Generation || !prev.ReadinessRegressed {
+		return limit
+	}
+	return integer.Int32Min(limit, prev.CompletedBatchReplicas)
+}
+
+// rollbackRegressedRollout rolls the deployment back to the latest old revision, if the readiness
+// of the new replica set is regressed and the strategy asks for a rollback. The deployment is only
+// held by limitByReadinessRegression if there is no old revision to roll back to.
+func (dc *DeploymentController) rollbackRegressedRollout(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) (bool, error) {
+	if dc.strategy.ReadinessRegression != rolloutsv1alpha1.RollbackReadinessRegressionPolicy || newRS == nil {
+		return false, nil
+	}
+	prev := getExtraStatus(d)
+	if prev == nil || !prev.ReadinessRegressed || prev.UpdateRevision != newRS.Labels[deploymentutil.TemplateHashLabelKey] {
+		return false, nil
+	}
+	stableRS := latestReplicaSet(oldRSs)
+	if stableRS == nil {
+		return false, nil
+	}
+	stableRevision := stableRS.Labels[deploymentutil.TemplateHashLabelKey]
+	if err := dc.rollbackToRevision(ctx, d, oldRSs, stableRevision); err != nil {
+		return false, err
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, ReadinessRegressionRolledBackReason,
+		"Rolled back to revision %s since updated ready replicas of revision %s regressed", stableRevision, prev.UpdateRevision)
+	return true, nil
+}
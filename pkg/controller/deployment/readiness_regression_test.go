@@ -0,0 +1,156 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// syncWithRegressedReadiness settles the replica sets, then regresses the ready replicas of the
+// replica set with image before running syncDeployment, just like its pods become not ready.
+func syncWithRegressedReadiness(t *testing.T, dc *DeploymentController, client *fake.Clientset, d *apps.Deployment, image string, ready int32) *apps.Deployment {
+	settleReplicaSets(t, dc, client, d.Namespace)
+	rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		if rs.Spec.Template.Spec.Containers[0].Image != image {
+			continue
+		}
+		rs.Status.ReadyReplicas = ready
+		rs.Status.AvailableReplicas = ready
+		if rs, err = client.AppsV1().ReplicaSets(d.Namespace).UpdateStatus(context.TODO(), rs, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update replica set status: %v", err)
+		}
+		_ = dc.rsIndexer.Update(rs)
+	}
+
+	if d, err = client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	if err = dc.syncDeployment(context.TODO(), d); err != nil {
+		t.Fatalf("failed to sync deployment: %v", err)
+	}
+	if d, err = client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{}); err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	return d
+}
+
+func TestSyncReadinessRegression(t *testing.T) {
+	cases := []struct {
+		name              string
+		policy            rolloutsv1alpha1.ReadinessRegressionPolicyType
+		expectUpdated     int32
+		expectRegressed   bool
+		expectRolledBack  bool
+		expectRecoveredTo int32
+	}{
+		{
+			name:          "regression ignored by default",
+			expectUpdated: 6,
+		},
+		{
+			name:              "halted until recovered",
+			policy:            rolloutsv1alpha1.HaltReadinessRegressionPolicy,
+			expectUpdated:     3,
+			expectRegressed:   true,
+			expectRecoveredTo: 6,
+		},
+		{
+			name:             "rolled back",
+			policy:           rolloutsv1alpha1.RollbackReadinessRegressionPolicy,
+			expectRegressed:  true,
+			expectRolledBack: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(3), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:        rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:       d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:           intstr.FromString("30%"),
+				ReadinessRegression: cs.policy,
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS)
+
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if extraStatus := getExtraStatus(d); cs.policy != "" && (extraStatus == nil || extraStatus.CompletedBatchReplicas != 3) {
+				t.Fatalf("expect completed batch with 3 replicas, got %+v", extraStatus)
+			}
+
+			// The new pods regress after the batch completed, and then the partition is raised,
+			// the surge allows the new replica set to scale up even if they are not ready.
+			d = syncWithRegressedReadiness(t, dc, client, d, "demo:v2", 1)
+			dc.strategy.Partition = intstr.FromString("60%")
+			for i := 0; i < 10; i++ {
+				d = syncWithRegressedReadiness(t, dc, client, d, "demo:v2", 1)
+			}
+
+			events := collectEvents(recorder)
+			if regressed := hasEvent(events, ReadinessRegressedReason); regressed != cs.expectRegressed {
+				t.Fatalf("expect %s event %v, got %v", ReadinessRegressedReason, cs.expectRegressed, regressed)
+			}
+			if rolledBack := hasEvent(events, ReadinessRegressionRolledBackReason); rolledBack != cs.expectRolledBack {
+				t.Fatalf("expect %s event %v, got %v", ReadinessRegressionRolledBackReason, cs.expectRolledBack, rolledBack)
+			}
+			if cs.expectRolledBack {
+				if image := d.Spec.Template.Spec.Containers[0].Image; image != "demo:v1" {
+					t.Fatalf("expect deployment rolled back to demo:v1, got %s", image)
+				}
+				return
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace)["demo:v2"]; replicas != cs.expectUpdated {
+				t.Fatalf("expect %d updated replicas, got %d", cs.expectUpdated, replicas)
+			}
+			if cs.expectRecoveredTo == 0 {
+				return
+			}
+
+			// The rollout advances again once the readiness recovers.
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			expectReplicas := map[string]int32{"demo:v1": 10 - cs.expectRecoveredTo, "demo:v2": cs.expectRecoveredTo}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+				t.Fatalf("expect replicas %v after recovered, got %v", expectReplicas, replicas)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.ReadinessRegressed {
+				t.Fatalf("expect readiness not regressed after recovered, got %+v", extraStatus)
+			}
+			if !hasEvent(collectEvents(recorder), ReadinessRecoveredReason) {
+				t.Fatalf("expect %s event", ReadinessRecoveredReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"sync"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// readinessSample is the readiness of the new pods of a deployment sampled at some time.
+type readinessSample struct {
+	time time.Time
+	// rsUID and rsVersion are the new replica set sampled, the sample is stale once its
+	// status is changed, which bumps its resource version.
+	rsUID     types.UID
+	rsVersion string
+	soak      time.Duration
+
+	soaked int32
+	wait   time.Duration
+}
+
+// readinessSampler keeps the last readiness sample of each deployment, so that the pods are
+// not inspected more often than interval. A nil readinessSampler never reuses samples.
+type readinessSampler struct {
+	interval time.Duration
+
+	lock    sync.Mutex
+	samples map[types.NamespacedName]readinessSample
+}
+
+// newReadinessSampler returns a readinessSampler, or nil if interval is not positive, which
+// samples the pods on every sync.
+func newReadinessSampler(interval time.Duration) *readinessSampler {
+	if interval <= 0 {
+		return nil
+	}
+	return &readinessSampler{interval: interval, samples: map[types.NamespacedName]readinessSample{}}
+}
+
+// get returns the sample of deployment within interval before now, with the wait adjusted to
+// now. The sample expires early once the new replica set is changed, or a pod is to be soaked.
+func (s *readinessSampler) get(d *apps.Deployment, newRS *apps.ReplicaSet, soak time.Duration, now time.Time) (readinessSample, bool) {
+	if s == nil {
+		return readinessSample{}, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sample, ok := s.samples[types.NamespacedName{Namespace: d.Namespace, Name: d.Name}]
+	if !ok || sample.rsUID != newRS.UID || sample.rsVersion != newRS.ResourceVersion || sample.soak != soak {
+		return readinessSample{}, false
+	}
+	elapsed := now.Sub(sample.time)
+	if elapsed < 0 || elapsed >= s.interval || (sample.wait > 0 && elapsed >= sample.wait) {
+		return readinessSample{}, false
+	}
+	if sample.wait > 0 {
+		sample.wait -= elapsed
+	}
+	return sample, true
+}
+
+// put records the sample of deployment taken at now.
+func (s *readinessSampler) put(d *apps.Deployment, newRS *apps.ReplicaSet, soak time.Duration, now time.Time, soaked int32, wait time.Duration) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.samples[types.NamespacedName{Namespace: d.Namespace, Name: d.Name}] = readinessSample{
+		time:      now,
+		rsUID:     newRS.UID,
+		rsVersion: newRS.ResourceVersion,
+		soak:      soak,
+		soaked:    soaked,
+		wait:      wait,
+	}
+}
+
+// forget drops the sample of the deployment, e.g. once it is deleted.
+func (s *readinessSampler) forget(key types.NamespacedName) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.samples, key)
+}
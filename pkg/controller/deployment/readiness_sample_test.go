@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSampleSoakedPods(t *testing.T) {
+	now := time.Now()
+	d := newTestDeployment(3, intstr.FromInt(1), intstr.FromInt(0))
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	newRS.ResourceVersion = "1"
+	pods := newTestPodsReadySince(newRS, "soaked", 3, now.Add(-time.Hour))
+	notReady := pods[2].(*v1.Pod)
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+
+	strategy := rolloutsv1alpha1.DeploymentStrategy{BatchSoak: &rolloutsv1alpha1.DeploymentBatchSoak{Seconds: 600}}
+	dc, _, _ := newTestController(strategy, append([]runtime.Object{d, newRS}, pods...)...)
+	podIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		_ = podIndexer.Add(pod)
+	}
+	dc.podLister = corelisters.NewPodLister(podIndexer)
+	fakeClock := testingclock.NewFakeClock(now)
+	dc.clock = fakeClock
+	dc.readinessSamples = newReadinessSampler(time.Minute)
+
+	expectSoaked := func(step string, expect int32) {
+		t.Helper()
+		soaked, _, err := dc.sampleSoakedPods(d, newRS, 10*time.Minute)
+		if err != nil {
+			t.Fatalf("%s: failed to sample soaked pods: %v", step, err)
+		}
+		if soaked != expect {
+			t.Fatalf("%s: expect %d soaked pods, got %d", step, expect, soaked)
+		}
+	}
+	expectSoaked("first sample", 2)
+
+	// The pod becomes ready long ago, but it is not sampled again within the interval.
+	ready := notReady.DeepCopy()
+	ready.Status.Conditions[0].Status = v1.ConditionTrue
+	_ = podIndexer.Update(ready)
+	fakeClock.Step(30 * time.Second)
+	expectSoaked("within interval", 2)
+	fakeClock.Step(31 * time.Second)
+	expectSoaked("after interval", 3)
+
+	// The status of replica set is changed, so the pods are sampled again immediately.
+	notReady = ready.DeepCopy()
+	notReady.Status.Conditions[0].Status = v1.ConditionFalse
+	notReady.Status.Conditions[0].LastTransitionTime = metav1.NewTime(fakeClock.Now())
+	_ = podIndexer.Update(notReady)
+	fakeClock.Step(time.Second)
+	expectSoaked("replica set unchanged", 3)
+	newRS.ResourceVersion = "2"
+	expectSoaked("replica set changed", 2)
+}
+
+func TestReadinessSamplerExpiresOncePodSoaked(t *testing.T) {
+	now := time.Now()
+	d := newTestDeployment(3, intstr.FromInt(1), intstr.FromInt(0))
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	sampler := newReadinessSampler(time.Minute)
+	sampler.put(d, newRS, 10*time.Minute, now, 2, 40*time.Second)
+
+	if sample, ok := sampler.get(d, newRS, 10*time.Minute, now.Add(30*time.Second)); !ok || sample.wait != 10*time.Second {
+		t.Fatalf("expect the sample reused with wait 10s, got %+v, %v", sample, ok)
+	}
+	if _, ok := sampler.get(d, newRS, 10*time.Minute, now.Add(40*time.Second)); ok {
+		t.Fatalf("expect the sample expired once the next pod is soaked")
+	}
+	if _, ok := sampler.get(d, newRS, 5*time.Minute, now); ok {
+		t.Fatalf("expect the sample expired once the soak duration is changed")
+	}
+	if _, ok := (*readinessSampler)(nil).get(d, newRS, 10*time.Minute, now); ok {
+		t.Fatalf("expect nil sampler never reuses samples")
+	}
+}
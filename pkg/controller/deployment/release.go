@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// KeepPartitionLostControlPolicy keeps the new replica set at its current size, and scales
+	// the old replica sets to the rest of replicas once the new pods are available.
+	KeepPartitionLostControlPolicy = "KeepPartition"
+	// IgnoreLostControlPolicy leaves the replica sets as they are.
+	IgnoreLostControlPolicy = "Ignore"
+
+	// RolloutControlLostReason is the reason of the event emitted once a deployment released
+	// from rollout control in the middle of rollout is settled.
+	RolloutControlLostReason = "RolloutControlLost"
+)
+
+// isReleased returns true if the deployment was rolled by us, but is not under rollout control
+// any more, which is told by the extra status left by us.
+func isReleased(d *apps.Deployment) bool {
+	_, ok := d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation]
+	return ok && d.DeletionTimestamp == nil && !deploymentutil.IsUnderRolloutControl(d)
+}
+
+// syncReleasedDeployment settles a deployment released from rollout control in the middle of
+// rollout according to lostControlPolicy. The native deployment controller never scales a paused
+// deployment with Recreate strategy, so the replica sets would be left half-scaled forever, e.g.
+// with the surge pods of the new replica set. Once settled, the extra status and the strategy status
+// are removed so that the deployment is never processed again until it is under rollout control.
+func (dc *DeploymentController) syncReleasedDeployment(ctx context.Context, d *apps.Deployment) error {
+	// The rollout released is not active any more, whatever is left by lostControlPolicy.
+	dc.activeRollouts.release(d.Namespace, d.Name)
+	if lostControlPolicy != KeepPartitionLostControlPolicy {
+		return nil
+	}
+
+	// The native deployment controller takes over if it is resumed or rolling updated.
+	message := "Deployment was released from rollout control, left to the native deployment controller"
+	if d.Spec.Paused && d.Spec.Strategy.Type == apps.RecreateDeploymentStrategyType {
+		rsList, err := dc.getReplicaSetsForDeployment(ctx, d)
+		if err != nil {
+			return err
+		}
+		newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+		_, oldRSs := deploymentutil.FindOldReplicaSets(d, rsList)
+		if newRS != nil {
+			settled, err := dc.settleOldReplicaSets(ctx, d, newRS, oldRSs)
+			if err != nil || !settled {
+				return err
+			}
+			message = fmt.Sprintf("Deployment was released from rollout control in the middle of rollout, kept %d of %d replicas updated", *newRS.Spec.Replicas, *d.Spec.Replicas)
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				rolloutsv1alpha1.DeploymentExtraStatusAnnotation:    nil,
+				rolloutsv1alpha1.DeploymentStrategyStatusAnnotation: nil,
+			},
+		},
+	})
+	if _, err := dc.client.AppsV1().Deployments(d.Namespace).Patch(ctx, d.Name, types.MergePatchType, body, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("%s: %v", message, klog.KObj(d))
+	dc.eventRecorder.Event(d, v1.EventTypeNormal, RolloutControlLostReason, message)
+	return nil
+}
+
+// settleOldReplicaSets scales the old replica sets to the replicas of deployment not taken by
+// the new replica set. Old pods are never scaled down beyond the available new pods, so it
+// returns false until the new pods are available and the old replica sets are settled.
+func (dc *DeploymentController) settleOldReplicaSets(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) (bool, error) {
+	replicas := *d.Spec.Replicas
+	desired := integer.Int32Max(replicas-*newRS.Spec.Replicas, 0)
+	current := deploymentutil.GetReplicaCountForReplicaSets(oldRSs)
+	if current == desired || len(oldRSs) == 0 {
+		return true, nil
+	}
+
+	if current < desired {
+		// Scale the latest old replica set up, which is the most likely to be healthy.
+		sort.Sort(sort.Reverse(deploymentutil.ReplicaSetsByRevision(oldRSs)))
+		rs := oldRSs[0]
+		_, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, rs, *rs.Spec.Replicas+desired-current, d, RolloutControlLostReason)
+		return err == nil, err
+	}
+
+	target := integer.Int32Max(desired, replicas-newRS.Status.AvailableReplicas)
+	sort.Sort(deploymentutil.ReplicaSetsByCreationTimestamp(oldRSs))
+	for _, rs := range oldRSs {
+		if current <= target {
+			break
+		}
+		scaleDown := integer.Int32Min(*rs.Spec.Replicas, current-target)
+		if scaleDown == 0 {
+			continue
+		}
+		if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, rs, *rs.Spec.Replicas-scaleDown, d, RolloutControlLostReason); err != nil {
+			return false, err
+		}
+		current -= scaleDown
+	}
+	return current == desired, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestReconcileReleasedDeployment(t *testing.T) {
+	cases := []struct {
+		name          string
+		policy        string
+		resumed       bool
+		expectPending map[string]int32
+		expectSettled map[string]int32
+		expectRemoved bool
+	}{
+		{
+			name:          "keep partition",
+			policy:        KeepPartitionLostControlPolicy,
+			expectPending: map[string]int32{"demo:v1": 8, "demo:v2": 3},
+			expectSettled: map[string]int32{"demo:v1": 7, "demo:v2": 3},
+			expectRemoved: true,
+		},
+		{
+			name:          "left to the native controller once resumed",
+			policy:        KeepPartitionLostControlPolicy,
+			resumed:       true,
+			expectSettled: map[string]int32{"demo:v1": 8, "demo:v2": 3},
+			expectRemoved: true,
+		},
+		{
+			name:          "ignored",
+			policy:        IgnoreLostControlPolicy,
+			expectPending: map[string]int32{"demo:v1": 8, "demo:v2": 3},
+			expectSettled: map[string]int32{"demo:v1": 8, "demo:v2": 3},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(policy string) { lostControlPolicy = policy }(lostControlPolicy)
+			lostControlPolicy = cs.policy
+
+			// The deployment is surging to the second batch, and the control marker is removed
+			// before the surge pod is available.
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			d.Spec.Paused = !cs.resumed
+			d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] = `{"expectedUpdatedReplicas":3}`
+			d.Annotations[rolloutsv1alpha1.DeploymentStrategyStatusAnnotation] = `{"partitionReplicas":3}`
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 8)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			newRS.Status.AvailableReplicas = 2
+			dc, client, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS, newRS)
+			dc.activeRollouts = newActiveRolloutTracker()
+			dc.activeRollouts.admit(d.Namespace, d.Name, 1, sets.NewString())
+			reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(d).Build()
+			r := &ReconcileDeployment{Client: reader, controllerFactory: (*controllerFactory)(dc)}
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}}
+
+			if cs.expectPending != nil {
+				if _, err := r.Reconcile(context.TODO(), request); err != nil {
+					t.Fatalf("failed to reconcile: %v", err)
+				}
+				if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expectPending) {
+					t.Fatalf("expect replicas %v until the new pods are available, got %v", cs.expectPending, replicas)
+				}
+				settleReplicaSets(t, dc, client, d.Namespace)
+			}
+			if _, err := r.Reconcile(context.TODO(), request); err != nil {
+				t.Fatalf("failed to reconcile: %v", err)
+			}
+			if dc.activeRollouts.has(d.Namespace, d.Name) {
+				t.Fatalf("expect the released rollout not active any more")
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expectSettled) {
+				t.Fatalf("expect replicas %v settled, got %v", cs.expectSettled, replicas)
+			}
+
+			latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			_, kept := latest.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation]
+			if kept == cs.expectRemoved {
+				t.Fatalf("expect extra status removed %v, got %v", cs.expectRemoved, latest.Annotations)
+			}
+			if _, kept = latest.Annotations[rolloutsv1alpha1.DeploymentStrategyStatusAnnotation]; kept == cs.expectRemoved {
+				t.Fatalf("expect strategy status removed %v, got %v", cs.expectRemoved, latest.Annotations)
+			}
+			if hasEvent(collectEvents(recorder), RolloutControlLostReason) != cs.expectRemoved {
+				t.Fatalf("expect %s event %v", RolloutControlLostReason, cs.expectRemoved)
+			}
+		})
+	}
+}
+
+func TestDeploymentUpdatedOnControlLost(t *testing.T) {
+	oldObject := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldObject.Annotations = map[string]string{util.BatchReleaseControlAnnotation: "control-info"}
+	oldObject.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+	oldObject.Spec.Paused = true
+	newObject := oldObject.DeepCopy()
+	delete(newObject.Annotations, util.BatchReleaseControlAnnotation)
+
+	if !deploymentUpdated(event.UpdateEvent{ObjectOld: oldObject, ObjectNew: newObject}) {
+		t.Fatalf("expect the deployment released from rollout control enqueued")
+	}
+	if deploymentUpdated(event.UpdateEvent{ObjectOld: newObject, ObjectNew: newObject.DeepCopy()}) {
+		t.Fatalf("expect the deployment not under rollout control ignored")
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// InvalidReplicaSetNameReason is added in a deployment event once the name rendered from the
+// ReplicaSetNameTemplate of strategy is invalid, and the default name is used instead.
+const InvalidReplicaSetNameReason = "InvalidReplicaSetName"
+
+// newReplicaSetName returns the name of the new replica set with the pod-template-hash and the
+// revision, which is rendered from the ReplicaSetNameTemplate of strategy if it is set. It falls
+// back to the default name of "<deployment>-<hash>" with a warning event if the rendered name is
+// not a DNS-1123 label.
+func (dc *DeploymentController) newReplicaSetName(d *apps.Deployment, hash, revision string) string {
+	defaultName := d.Name + "-" + hash
+	nameTemplate := dc.strategy.ReplicaSetNameTemplate
+	if nameTemplate == "" {
+		return defaultName
+	}
+	name := strings.NewReplacer(
+		rolloutsv1alpha1.ReplicaSetNameDeployment, d.Name,
+		rolloutsv1alpha1.ReplicaSetNameHash, hash,
+		rolloutsv1alpha1.ReplicaSetNameRevision, revision,
+	).Replace(nameTemplate)
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, InvalidReplicaSetNameReason,
+			"Replica set name %q rendered from %q is invalid (%s), use %q instead", name, nameTemplate, strings.Join(errs, "; "), defaultName)
+		return defaultName
+	}
+	return name
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentWithReplicaSetNameTemplate(t *testing.T) {
+	cases := []struct {
+		name          string
+		nameTemplate  string
+		expectName    string
+		expectInvalid bool
+	}{
+		{
+			name:       "default name",
+			expectName: "deployment-{hash}",
+		},
+		{
+			name:         "custom suffix",
+			nameTemplate: "{deployment}-canary-{hash}",
+			expectName:   "deployment-canary-{hash}",
+		},
+		{
+			name:         "with revision",
+			nameTemplate: "{deployment}-r{revision}-{hash}",
+			expectName:   "deployment-r2-{hash}",
+		},
+		{
+			name:          "invalid characters",
+			nameTemplate:  "{deployment}.canary.{hash}",
+			expectName:    "deployment-{hash}",
+			expectInvalid: true,
+		},
+		{
+			name:          "too long",
+			nameTemplate:  "{deployment}-" + strings.Repeat("x", 60) + "-{hash}",
+			expectName:    "deployment-{hash}",
+			expectInvalid: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 2)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:           rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:          d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:              intstr.FromString("100%"),
+				ReplicaSetNameTemplate: cs.nameTemplate,
+			}
+			if errs := rolloutsv1alpha1.ValidateDeploymentStrategy(&strategy, nil); len(errs) > 0 {
+				t.Fatalf("expect valid strategy, got %v", errs)
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS)
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+
+			rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list replica sets: %v", err)
+			}
+			var newRS *apps.ReplicaSet
+			for i := range rsList.Items {
+				if rsList.Items[i].Name != oldRS.Name {
+					newRS = &rsList.Items[i]
+				}
+			}
+			if len(rsList.Items) != 2 || newRS == nil {
+				t.Fatalf("expect a new replica set beside the old one, got %d replica sets", len(rsList.Items))
+			}
+			expectName := strings.Replace(cs.expectName, "{hash}", newRS.Labels[apps.DefaultDeploymentUniqueLabelKey], 1)
+			if newRS.Name != expectName {
+				t.Fatalf("expect new replica set %s, got %s", expectName, newRS.Name)
+			}
+			if *newRS.Spec.Replicas != 2 {
+				t.Fatalf("expect new replica set rolled out, got %d replicas", *newRS.Spec.Replicas)
+			}
+			if invalid := hasEvent(collectEvents(recorder), InvalidReplicaSetNameReason); invalid != cs.expectInvalid {
+				t.Fatalf("expect %s event %v, got %v", InvalidReplicaSetNameReason, cs.expectInvalid, invalid)
+			}
+		})
+	}
+}
+
+func TestValidateReplicaSetNameTemplate(t *testing.T) {
+	cases := []struct {
+		name         string
+		nameTemplate string
+		expectValid  bool
+	}{
+		{
+			name:        "empty",
+			expectValid: true,
+		},
+		{
+			name:         "with hash",
+			nameTemplate: "{deployment}-v-{hash}",
+			expectValid:  true,
+		},
+		{
+			name:         "without hash",
+			nameTemplate: "{deployment}-canary",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			strategy := rolloutsv1alpha1.DeploymentStrategy{ReplicaSetNameTemplate: cs.nameTemplate}
+			if errs := rolloutsv1alpha1.ValidateDeploymentStrategy(&strategy, nil); (len(errs) == 0) != cs.expectValid {
+				t.Fatalf("expect valid %v, got %v", cs.expectValid, errs)
+			}
+		})
+	}
+}
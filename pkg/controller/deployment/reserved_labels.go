@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	labelsutil "github.com/openkruise/rollouts/pkg/util/labels"
+)
+
+// LabelConflictReason is added in a deployment event when a label to be added to its new replica
+// set is reserved and already set to another value, which is left as it is.
+const LabelConflictReason = "LabelConflict"
+
+// parseReservedLabelKeys parses the comma separated label keys, nil means none is reserved. The
+// template hash label key cannot be reserved, which tells the replica sets and their pods apart.
+func parseReservedLabelKeys(keys string) (sets.String, error) {
+	if keys == "" {
+		return nil, nil
+	}
+	reserved := sets.NewString()
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("deployment-reserved-label-keys %q is invalid: %s", key, strings.Join(errs, "; "))
+		}
+		if key == deploymentutil.TemplateHashLabelKey {
+			return nil, fmt.Errorf("deployment-reserved-label-keys %q cannot be reserved, which selects the pods of each replica set", key)
+		}
+		reserved.Insert(key)
+	}
+	return reserved, nil
+}
+
+// addLabel returns a copy of labels with the label added, and whether it is added. A reserved
+// label already set to another value, e.g. by an earlier rollout controller being migrated
+// from, is not overwritten, with a LabelConflict event emitted instead.
+func (dc *DeploymentController) addLabel(d *apps.Deployment, labels map[string]string, key, value string) (map[string]string, bool) {
+	if existing, ok := labels[key]; ok && existing != value && dc.reservedLabels.Has(key) {
+		dc.log().Info("Label is reserved, skip adding it", "label", key, "value", existing)
+		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, LabelConflictReason,
+			"Label %s=%s is reserved, skip setting it to %s", key, existing, value)
+		cloned := make(map[string]string, len(labels))
+		for k, v := range labels {
+			cloned[k] = v
+		}
+		return cloned, false
+	}
+	return labelsutil.CloneAndAddLabel(labels, key, value), true
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func TestParseReservedLabelKeys(t *testing.T) {
+	cases := []struct {
+		name      string
+		keys      string
+		expect    []string
+		expectErr bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "multiple keys",
+			keys:   "example.com/owner, example.com/revision",
+			expect: []string{"example.com/owner", "example.com/revision"},
+		},
+		{
+			name:      "invalid key",
+			keys:      "example.com/revision,bad key",
+			expectErr: true,
+		},
+		{
+			name:      "template hash label key",
+			keys:      "example.com/revision," + deploymentutil.TemplateHashLabelKey,
+			expectErr: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			reserved, err := parseReservedLabelKeys(cs.keys)
+			if (err != nil) != cs.expectErr {
+				t.Fatalf("expect error %v, got %v", cs.expectErr, err)
+			}
+			if got := reserved.List(); !sets.NewString(got...).Equal(sets.NewString(cs.expect...)) {
+				t.Fatalf("expect reserved keys %v, got %v", cs.expect, got)
+			}
+		})
+	}
+}
+
+func TestReconcileKeepsReservedLabels(t *testing.T) {
+	cases := []struct {
+		name           string
+		keys           string
+		expectPreserve bool
+	}{
+		{
+			name: "not reserved",
+		},
+		{
+			name:           "reserved",
+			keys:           rolloutsv1alpha1.RolloutGenerationLabel,
+			expectPreserve: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(5))
+			d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+			d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"rollingStyle":"Partition","rollingUpdate":{"maxSurge":1,"maxUnavailable":5},"partition":"50%"}`
+			d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+			d.Spec.Paused = true
+			// The labels left by the rollout controller being migrated from.
+			d.Spec.Template.Labels[deploymentutil.TemplateHashLabelKey] = "legacy-hash"
+			d.Spec.Template.Labels[rolloutsv1alpha1.RolloutGenerationLabel] = "legacy-generation"
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			dc, client, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS)
+			defer forgetDeploymentMetrics(d.Namespace, d.Name)
+			// Reserved via the factory like newReconciler does, not on the controller of deployment.
+			reserved, err := parseReservedLabelKeys(cs.keys)
+			if err != nil {
+				t.Fatalf("failed to parse reserved label keys: %v", err)
+			}
+			dc.reservedLabels = reserved
+			reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(d.DeepCopy()).Build()
+			r := &ReconcileDeployment{Client: reader, controllerFactory: (*controllerFactory)(dc)}
+			request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}}
+			if _, err = r.Reconcile(context.TODO(), request); err != nil {
+				t.Fatalf("failed to reconcile: %v", err)
+			}
+
+			newRS := getTestReplicaSet(t, client, d.Namespace, "demo:v2")
+			if generation := newRS.Labels[rolloutsv1alpha1.RolloutGenerationLabel]; (generation == "legacy-generation") != cs.expectPreserve {
+				t.Fatalf("expect reserved label preserved %v, got generation %q", cs.expectPreserve, generation)
+			}
+			// The template hash label is never reserved, otherwise the new replica set would select old pods.
+			hash := newRS.Spec.Template.Labels[deploymentutil.TemplateHashLabelKey]
+			if hash == "legacy-hash" || newRS.Spec.Selector.MatchLabels[deploymentutil.TemplateHashLabelKey] != hash {
+				t.Fatalf("expect template hash %q labeled and selected, got selector %v", hash, newRS.Spec.Selector)
+			}
+			if conflict := hasEvent(collectEvents(recorder), LabelConflictReason); conflict != cs.expectPreserve {
+				t.Fatalf("expect %s event %v, got %v", LabelConflictReason, cs.expectPreserve, conflict)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// RolledBackReason is added in a deployment event when it is rolled back by the rollback
+	// policy of strategy, because too many pods of its new replica set are failing.
+	RolledBackReason = "RolledBack"
+)
+
+// failingWaitingReasons are the reasons of waiting containers which will not recover by themselves.
+var failingWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"CreateContainerConfigError": true,
+}
+
+// checkFailedCanary rolls the deployment back to the latest old revision once the failing pods of
+// newRS reach the failure threshold of the rollback policy. Unlike the rollbacks which only update the
+// pod template, the stable replica set is scaled back up to full size and newRS is scaled down at once,
+// i.e. the deployment goes back to partition 0 of the failed revision. It returns true if the deployment
+// is rolled back.
+func (dc *DeploymentController) checkFailedCanary(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) (bool, error) {
+	policy := dc.strategy.RollbackPolicy
+	if policy == nil || newRS == nil {
+		return false, nil
+	}
+	count, example, err := dc.countFailingPods(newRS)
+	if err != nil {
+		return false, err
+	}
+	if count < policy.FailureThreshold {
+		return false, nil
+	}
+	stableRS := latestReplicaSet(oldRSs)
+	if stableRS == nil {
+		return false, nil
+	}
+
+	failedRevision := newRS.Labels[deploymentutil.TemplateHashLabelKey]
+	stableRevision := stableRS.Labels[deploymentutil.TemplateHashLabelKey]
+	dc.log().Info("Found pods of new replica set failing, roll back", "replicaSet", klog.KObj(newRS), "count", count, "stableRevision", stableRevision)
+	if err := dc.rollbackToStable(ctx, d, newRS, stableRS, oldRSs); err != nil {
+		return false, err
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, RolledBackReason,
+		"Rolled back to revision %s since %d pods of revision %s are failing, e.g. %s", stableRevision, count, failedRevision, example)
+	return true, nil
+}
+
+// checkRollbackAnnotation rolls the deployment back to the latest old revision as checkFailedCanary
+// does, once DeploymentRollbackAnnotation matches the revision of newRS, e.g. set via the admin
+// endpoint during an incident. It returns true if the deployment is rolled back.
+func (dc *DeploymentController) checkRollbackAnnotation(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) (bool, error) {
+	if newRS == nil {
+		return false, nil
+	}
+	failedRevision := newRS.Labels[deploymentutil.TemplateHashLabelKey]
+	if revision, ok := d.Annotations[rolloutsv1alpha1.DeploymentRollbackAnnotation]; !ok || revision != failedRevision {
+		return false, nil
+	}
+	stableRS := latestReplicaSet(oldRSs)
+	if stableRS == nil {
+		dc.log().Info("No old revision to roll back to, ignore the rollback annotation", "revision", failedRevision)
+		return false, nil
+	}
+
+	stableRevision := stableRS.Labels[deploymentutil.TemplateHashLabelKey]
+	dc.log().Info("Found rollback annotation, roll back", "replicaSet", klog.KObj(newRS), "stableRevision", stableRevision)
+	if err := dc.rollbackToStable(ctx, d, newRS, stableRS, oldRSs); err != nil {
+		return false, err
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, RolledBackReason,
+		"Rolled back to revision %s from revision %s by annotation %s", stableRevision, failedRevision, rolloutsv1alpha1.DeploymentRollbackAnnotation)
+	return true, nil
+}
+
+// rollbackToStable scales stableRS back up to full size and newRS down to 0, and then rolls the
+// pod template back to stableRS. The replica sets are swapped before updating the template,
+// otherwise the stable replica set turned into the new one would be held at partition. If the
+// template fails to be rolled back, newRS is scaled up by partition again in the next sync.
+func (dc *DeploymentController) rollbackToStable(ctx context.Context, d *apps.Deployment, newRS, stableRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) error {
+	if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, stableRS, *(d.Spec.Replicas), d, auditReasonRolledBack); err != nil {
+		return err
+	}
+	if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, newRS, 0, d, auditReasonRolledBack); err != nil {
+		return err
+	}
+	return dc.rollbackToRevision(ctx, d, oldRSs, stableRS.Labels[deploymentutil.TemplateHashLabelKey])
+}
+
+// countFailingPods returns the number of pods of newRS which are failing, and an example of them for
+// the event. The pods just created are not counted until they fail, e.g. crash after started.
+func (dc *DeploymentController) countFailingPods(newRS *apps.ReplicaSet) (int32, string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(newRS.Spec.Selector)
+	if err != nil {
+		return 0, "", err
+	}
+	pods, err := dc.cachedPods(newRS.Namespace, selector)
+	if err != nil {
+		return 0, "", err
+	}
+
+	count, example := int32(0), ""
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(pod, newRS) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		reason := podFailureReason(pod)
+		if reason == "" {
+			continue
+		}
+		if count == 0 {
+			example = "pod " + pod.Name + ": " + reason
+		}
+		count++
+	}
+	return count, example, nil
+}
+
+// podFailureReason returns why pod is failing, or empty if it is not.
+func podFailureReason(pod *v1.Pod) string {
+	if pod.Status.Phase == v1.PodFailed {
+		return string(v1.PodFailed)
+	}
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil && failingWaitingReasons[status.State.Waiting.Reason] {
+			return status.State.Waiting.Reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncDeploymentRollbackPolicy(t *testing.T) {
+	cases := []struct {
+		name             string
+		policy           *rolloutsv1alpha1.DeploymentRollbackPolicy
+		failing          int
+		expectRolledBack bool
+	}{
+		{
+			name:    "no rollback policy",
+			failing: 2,
+		},
+		{
+			name:    "failing pods below threshold",
+			policy:  &rolloutsv1alpha1.DeploymentRollbackPolicy{FailureThreshold: 3},
+			failing: 2,
+		},
+		{
+			name:             "failing pods reach threshold",
+			policy:           &rolloutsv1alpha1.DeploymentRollbackPolicy{FailureThreshold: 2},
+			failing:          2,
+			expectRolledBack: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			objects := []runtime.Object{d, oldRS, newRS}
+			for i := 0; i < 3; i++ {
+				pod := newTestPod(newRS, fmt.Sprintf("canary-%d", i))
+				if i < cs.failing {
+					pod.Status.Conditions = nil
+					pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+						Name:         "main",
+						RestartCount: 5,
+						State:        v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+					}}
+				}
+				objects = append(objects, pod)
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:   rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:  d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:      intstr.FromString("30%"),
+				RollbackPolicy: cs.policy,
+			}
+			dc, client, recorder := newTestController(strategy, objects...)
+
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if rolledBack := hasEvent(collectEvents(recorder), RolledBackReason); rolledBack != cs.expectRolledBack {
+				t.Fatalf("expect %s event %v, got %v", RolledBackReason, cs.expectRolledBack, rolledBack)
+			}
+			expectImage, expectReplicas := "demo:v2", map[string]int32{"demo:v1": 7, "demo:v2": 3}
+			if cs.expectRolledBack {
+				expectImage, expectReplicas = "demo:v1", map[string]int32{"demo:v1": 10, "demo:v2": 0}
+			}
+			if image := d.Spec.Template.Spec.Containers[0].Image; image != expectImage {
+				t.Fatalf("expect deployment with image %s, got %s", expectImage, image)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+				t.Fatalf("expect replicas %v, got %v", expectReplicas, replicas)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentRollbackAnnotation(t *testing.T) {
+	cases := []struct {
+		name             string
+		annotation       func(newRevision string) string
+		expectRolledBack bool
+	}{
+		{
+			name:       "no rollback annotation",
+			annotation: func(string) string { return "" },
+		},
+		{
+			name:       "rollback annotation of another revision",
+			annotation: func(string) string { return "superseded" },
+		},
+		{
+			name:             "rollback annotation of current revision",
+			annotation:       func(newRevision string) string { return newRevision },
+			expectRolledBack: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			if annotation := cs.annotation(newRS.Labels[deploymentutil.TemplateHashLabelKey]); annotation != "" {
+				d.Annotations[rolloutsv1alpha1.DeploymentRollbackAnnotation] = annotation
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("30%"),
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS, newRS)
+
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if rolledBack := hasEvent(collectEvents(recorder), RolledBackReason); rolledBack != cs.expectRolledBack {
+				t.Fatalf("expect %s event %v, got %v", RolledBackReason, cs.expectRolledBack, rolledBack)
+			}
+			expectImage, expectReplicas := "demo:v2", map[string]int32{"demo:v1": 7, "demo:v2": 3}
+			if cs.expectRolledBack {
+				expectImage, expectReplicas = "demo:v1", map[string]int32{"demo:v1": 10, "demo:v2": 0}
+				if _, ok := d.Annotations[rolloutsv1alpha1.DeploymentRollbackAnnotation]; ok {
+					t.Fatalf("expect rollback annotation removed once rolled back, got %v", d.Annotations)
+				}
+			}
+			if image := d.Spec.Template.Spec.Containers[0].Image; image != expectImage {
+				t.Fatalf("expect deployment with image %s, got %s", expectImage, image)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expectReplicas) {
+				t.Fatalf("expect replicas %v, got %v", expectReplicas, replicas)
+			}
+		})
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 
 	apps "k8s.io/api/apps/v1"
+	intstrutil "k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/integer"
 
@@ -36,6 +37,68 @@ func (dc *DeploymentController) rolloutRolling(ctx context.Context, d *apps.Depl
 	}
 	allRSs := append(oldRSs, newRS)
 
+	if err = dc.syncTestBatchGates(ctx, newRS); err != nil {
+		return err
+	}
+	if err = dc.syncCanaryService(ctx, d, newRS, oldRSs); err != nil {
+		return err
+	}
+
+	// Finish the previous rollout first, if it is superseded while scaling down its old replica sets.
+	finalized, err := dc.finalizeSupersededRollout(ctx, d, oldRSs)
+	if err != nil {
+		return err
+	}
+	if finalized {
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+	}
+
+	// The deployment is updated if it is rolled back, so leave the status to the next sync.
+	if rolledBack, err := dc.rollbackRegressedRollout(ctx, d, newRS, oldRSs); err != nil || rolledBack {
+		return err
+	}
+	if rolledBack, err := dc.checkUnschedulableBatch(ctx, d, newRS, oldRSs); err != nil || rolledBack {
+		return err
+	}
+	if rolledBack, err := dc.checkFailedCanary(ctx, d, newRS, oldRSs); err != nil || rolledBack {
+		return err
+	}
+	if rolledBack, err := dc.checkRollbackAnnotation(ctx, d, newRS, oldRSs); err != nil || rolledBack {
+		return err
+	}
+
+	// Hold the rollout if the new pods land on nodes they should not, only if configured.
+	untolerated, err := dc.checkUntoleratedTaints(d, newRS)
+	if err != nil {
+		return err
+	}
+	if untolerated && untoleratedTaintsBlock {
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+	}
+
+	// Hold the rollout while the new pods are stuck pending, rather than scaling any further.
+	stalled, err := dc.checkStalledScaling(d, newRS)
+	if err != nil {
+		return err
+	}
+	if stalled {
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+	}
+
+	// Freeze the rollout once the current batch misses its progress deadline, rather than retrying forever.
+	if dc.checkBatchProgressDeadline(d, newRS) {
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+	}
+
+	// Undo the manual edits of replicas first, which rolling would not correct.
+	repaired, err := dc.repairDriftedReplicaSets(ctx, d, newRS, oldRSs)
+	if err != nil {
+		return err
+	}
+	if repaired {
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+	}
+
 	// Scale up, if we can.
 	scaledUp, err := dc.reconcileNewReplicaSet(ctx, allRSs, newRS, d)
 	if err != nil {
@@ -56,7 +119,21 @@ func (dc *DeploymentController) rolloutRolling(ctx context.Context, d *apps.Depl
 		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
 	}
 
+	// The old replica sets may be held by partition, even if the new replica set is already
+	// completed, so we complete the rolling directly.
+	if dc.managesOldReplicaSets() && dc.isNewRSCompleted(d, newRS, oldRSs) && deploymentutil.GetReplicaCountForReplicaSets(oldRSs) > 0 {
+		if dc.holdFinalDrain(d, newRS, oldRSs) {
+			return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+		}
+		return dc.completeRolling(ctx, allRSs, oldRSs, newRS, d)
+	}
+
 	if deploymentutil.DeploymentComplete(d, &d.Status) {
+		// The deployment is updated if it is rolled back, so leave the status to the next sync.
+		rolledBack, err := dc.syncPostRolloutJob(ctx, d, newRS, oldRSs)
+		if err != nil || rolledBack {
+			return err
+		}
 		if err := dc.cleanupDeployment(ctx, oldRSs, d); err != nil {
 			return err
 		}
@@ -66,6 +143,72 @@ func (dc *DeploymentController) rolloutRolling(ctx context.Context, d *apps.Depl
 	return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
 }
 
+// isNewRSCompleted returns true if the new replica set is already at full size and available,
+// and the old replica sets have at most maxSurge replicas left, which may happen if a previous
+// attempt was interrupted near completion, e.g. by a restart of the controller. There is no
+// need to roll the remaining batches in this case.
+func (dc *DeploymentController) isNewRSCompleted(deployment *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) bool {
+	if !completeFullNewRS || newRS == nil {
+		return false
+	}
+	replicas := *(deployment.Spec.Replicas)
+	if *(newRS.Spec.Replicas) != replicas || newRS.Status.ObservedGeneration < newRS.Generation || newRS.Status.AvailableReplicas < replicas {
+		return false
+	}
+	return deploymentutil.GetReplicaCountForReplicaSets(oldRSs) <= deploymentutil.MaxSurge(*deployment)
+}
+
+// finalizeSupersededRollout scales down the old replica sets left by the previous rollout, if the
+// replica set it rolled to is at full size and available, i.e. the previous rollout was completed
+// but superseded by the current one before its old replica sets were scaled down to zero. They
+// are never rolled over together with the completed replica set, otherwise they may be left in
+// the middle of scaling down, e.g. while the current rollout holds at partition or test batch.
+func (dc *DeploymentController) finalizeSupersededRollout(ctx context.Context, deployment *apps.Deployment, oldRSs []*apps.ReplicaSet) (bool, error) {
+	if !finalizeSuperseded || !dc.managesOldReplicaSets() || len(oldRSs) < 2 {
+		return false, nil
+	}
+	sorted := make([]*apps.ReplicaSet, len(oldRSs))
+	copy(sorted, oldRSs)
+	sort.Sort(sort.Reverse(deploymentutil.ReplicaSetsByRevision(sorted)))
+	previousRS, staleRSs := sorted[0], deploymentutil.FilterActiveReplicaSets(sorted[1:])
+	if len(staleRSs) == 0 {
+		return false, nil
+	}
+	replicas := *(deployment.Spec.Replicas)
+	if *(previousRS.Spec.Replicas) != replicas || previousRS.Status.ObservedGeneration < previousRS.Generation || previousRS.Status.AvailableReplicas < replicas {
+		return false, nil
+	}
+	dc.log().V(4).Info("Previous rollout is superseded, scaling down old replica sets", "previous", previousRS.Name, "count", len(staleRSs))
+	for _, rs := range staleRSs {
+		if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, rs, 0, deployment, auditReasonRolloutCompleted); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// completeRolling scales down all the old replica sets once the new replica set is completed,
+// or only a step of them if they are drained in steps.
+func (dc *DeploymentController) completeRolling(ctx context.Context, allRSs, oldRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, deployment *apps.Deployment) error {
+	if limit, stepped := dc.scaleDownStepLimit(deployment, allRSs); stepped && deploymentutil.GetReplicaCountForReplicaSets(oldRSs) > limit {
+		if err := dc.scaleDownOldReplicaSetsByStep(ctx, oldRSs, deployment, limit); err != nil {
+			return err
+		}
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, deployment)
+	}
+	for _, rs := range deploymentutil.FilterActiveReplicaSets(oldRSs) {
+		if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, rs, 0, deployment, auditReasonRolloutCompleted); err != nil {
+			return err
+		}
+	}
+	if deploymentutil.DeploymentComplete(deployment, &deployment.Status) {
+		if err := dc.cleanupDeployment(ctx, oldRSs, deployment); err != nil {
+			return err
+		}
+	}
+	return dc.syncRolloutStatus(ctx, allRSs, newRS, deployment)
+}
+
 func (dc *DeploymentController) reconcileNewReplicaSet(ctx context.Context, allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, deployment *apps.Deployment) (bool, error) {
 	if *(newRS.Spec.Replicas) == *(deployment.Spec.Replicas) {
 		// Scaling not required.
@@ -73,26 +216,104 @@ func (dc *DeploymentController) reconcileNewReplicaSet(ctx context.Context, allR
 	}
 	if *(newRS.Spec.Replicas) > *(deployment.Spec.Replicas) {
 		// Scale down.
-		scaled, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, newRS, *(deployment.Spec.Replicas), deployment)
+		scaled, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, newRS, *(deployment.Spec.Replicas), deployment, auditReasonNewRSOversized)
 		return scaled, err
 	}
-	newReplicasCount, err := deploymentutil.NewRSNewReplicas(deployment, allRSs, newRS)
+	newReplicasCount, err := dc.newRSNewReplicas(deployment, allRSs, newRS)
 	if err != nil {
 		return false, err
 	}
-	scaled, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, newRS, newReplicasCount, deployment)
+	scaled, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, newRS, newReplicasCount, deployment, auditReasonRollingScaleUp)
 	return scaled, err
 }
 
+// newRSNewReplicas calculates the number of replicas the new replica set should have,
+// which is limited by both maxSurge and partition.
+func (dc *DeploymentController) newRSNewReplicas(deployment *apps.Deployment, allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet) (int32, error) {
+	if len(dc.strategy.VariantWeights) > 0 {
+		// The new replica set is scaled to its share of the variant weights, see rolloutVariants.
+		return *(newRS.Spec.Replicas), nil
+	}
+	if dc.isTestBatch(newRS) || !dc.managesOldReplicaSets() {
+		// The new pods of test batch are surged beyond replicas, which do not replace any old pods.
+		// So are the new pods if the old pods are left to others, which can never be replaced by us.
+		return integer.Int32Max(dc.newRSReplicasLimit(deployment), *(newRS.Spec.Replicas)), nil
+	}
+	newReplicasCount, err := deploymentutil.NewRSNewReplicas(deployment, allRSs, newRS)
+	if err != nil {
+		return 0, err
+	}
+	newReplicasCount, err = dc.limitScaleUpByTerminatingPods(deployment, allRSs, newRS, newReplicasCount)
+	if err != nil {
+		return 0, err
+	}
+	// Do not scale up beyond partition, but never scale down the new replica set here.
+	replicasLimit := integer.Int32Max(dc.newRSReplicasLimit(deployment), *(newRS.Spec.Replicas))
+	newReplicasCount = integer.Int32Min(newReplicasCount, replicasLimit)
+	dc.recordSurgeCapped(deployment, allRSs, newRS, newReplicasCount, replicasLimit)
+	return newReplicasCount, nil
+}
+
+// managesOldReplicaSets returns false if the old replica sets are left to another process or manual
+// action, then only the new replica set is scaled and the old ones are never touched by us.
+func (dc *DeploymentController) managesOldReplicaSets() bool {
+	return dc.strategy.ManageOldReplicaSets == nil || *dc.strategy.ManageOldReplicaSets
+}
+
+// newRSReplicasLimit returns the max replicas of the new replica set calculated via partition,
+// a promoted deployment is regarded as having partition 100%. It never advances beyond the
+// batch not soaked or checked yet, see limitByBatchGates, nor the completed batch whose readiness
+// is regressed.
+func (dc *DeploymentController) newRSReplicasLimit(deployment *apps.Deployment) int32 {
+	partition := dc.strategy.Partition
+	if dc.isPromoted(deployment) {
+		partition = intstrutil.FromString("100%")
+	}
+	return dc.limitByReadinessRegression(deployment, dc.limitByBatchGates(deployment, dc.partitionReplicasLimit(partition, deployment)))
+}
+
+// maxOldScaleDown returns how many replicas of old replica sets can be scaled down at most,
+// the old replica sets must keep the replicas that are not allowed to be updated by partition.
+// The new replica set may have overshot the partition if the deployment is scaled down in
+// the middle of rolling, then the old replica sets only keep the rest of spec.replicas, so
+// that the total replicas can still converge to spec.replicas. A new replica set at full
+// size is left to isNewRSCompleted instead. The last old pod is kept while the final drain
+// is not confirmed, and only a step is scaled down if they are drained in steps.
+func (dc *DeploymentController) maxOldScaleDown(deployment *apps.Deployment, allRSs, oldRSs []*apps.ReplicaSet) int32 {
+	replicas := *(deployment.Spec.Replicas)
+	oldReplicas := deploymentutil.GetReplicaCountForReplicaSets(oldRSs)
+	newReplicas := deploymentutil.GetReplicaCountForReplicaSets(allRSs) - oldReplicas
+	newReplicasLimit := dc.newRSReplicasLimit(deployment)
+	if newReplicas < replicas {
+		newReplicasLimit = integer.Int32Max(newReplicasLimit, newReplicas)
+	}
+	maxScaleDown := oldReplicas - (replicas - newReplicasLimit)
+	if dc.isFinalDrainHeld(deployment, deploymentutil.FindNewReplicaSet(deployment, allRSs)) {
+		maxScaleDown = integer.Int32Min(maxScaleDown, oldReplicas-1)
+	}
+	if limit, stepped := dc.scaleDownStepLimit(deployment, allRSs); stepped {
+		maxScaleDown = integer.Int32Min(maxScaleDown, limit)
+	}
+	return integer.Int32Max(maxScaleDown, 0)
+}
+
 func (dc *DeploymentController) reconcileOldReplicaSets(ctx context.Context, allRSs []*apps.ReplicaSet, oldRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, deployment *apps.Deployment) (bool, error) {
 	oldPodsCount := deploymentutil.GetReplicaCountForReplicaSets(oldRSs)
 	if oldPodsCount == 0 {
 		// Can't scale down further
 		return false, nil
 	}
+	if dc.isTestBatch(newRS) {
+		// The new pods of test batch are out of Service endpoints, so keep all the old pods serving.
+		return false, nil
+	}
+	if !dc.managesOldReplicaSets() {
+		// The old replica sets are left to another process or manual action.
+		return false, nil
+	}
 
 	allPodsCount := deploymentutil.GetReplicaCountForReplicaSets(allRSs)
-	klog.V(4).Infof("New replica set %s/%s has %d available pods.", newRS.Namespace, newRS.Name, newRS.Status.AvailableReplicas)
+	dc.log().V(4).Info("New replica set has available pods", "replicaSet", klog.KObj(newRS), "available", newRS.Status.AvailableReplicas)
 	maxUnavailable := deploymentutil.MaxUnavailable(*deployment)
 
 	// Check if we can scale down. We can scale down in the following 2 cases:
@@ -128,6 +349,8 @@ func (dc *DeploymentController) reconcileOldReplicaSets(ctx context.Context, all
 	minAvailable := *(deployment.Spec.Replicas) - maxUnavailable
 	newRSUnavailablePodCount := *(newRS.Spec.Replicas) - newRS.Status.AvailableReplicas
 	maxScaledDown := allPodsCount - minAvailable - newRSUnavailablePodCount
+	// Old replica sets should keep the replicas that are not allowed to be updated by partition.
+	maxScaledDown = integer.Int32Min(maxScaledDown, dc.maxOldScaleDown(deployment, allRSs, oldRSs))
 	if maxScaledDown <= 0 {
 		return false, nil
 	}
@@ -138,7 +361,8 @@ func (dc *DeploymentController) reconcileOldReplicaSets(ctx context.Context, all
 	if err != nil {
 		return false, nil
 	}
-	klog.V(4).Infof("Cleaned up unhealthy replicas from old RSes by %d", cleanupCount)
+	dc.log().V(4).Info("Cleaned up unhealthy replicas from old replica sets", "count", cleanupCount)
+	dc.countScaleDownStep(deployment, cleanupCount)
 
 	// Scale down old replica sets, need check maxUnavailable to ensure we can scale down
 	allRSs = append(oldRSs, newRS)
@@ -146,7 +370,8 @@ func (dc *DeploymentController) reconcileOldReplicaSets(ctx context.Context, all
 	if err != nil {
 		return false, nil
 	}
-	klog.V(4).Infof("Scaled down old RSes of deployment %s by %d", deployment.Name, scaledDownCount)
+	dc.log().V(4).Info("Scaled down old replica sets", "count", scaledDownCount)
+	dc.countScaleDownStep(deployment, scaledDownCount)
 
 	totalScaledDown := cleanupCount + scaledDownCount
 	return totalScaledDown > 0, nil
@@ -167,9 +392,10 @@ func (dc *DeploymentController) cleanupUnhealthyReplicas(ctx context.Context, ol
 			// cannot scale down this replica set.
 			continue
 		}
-		klog.V(4).Infof("Found %d available pods in old RS %s/%s", targetRS.Status.AvailableReplicas, targetRS.Namespace, targetRS.Name)
-		if *(targetRS.Spec.Replicas) == targetRS.Status.AvailableReplicas {
-			// no unhealthy replicas found, no scaling required.
+		dc.log().V(4).Info("Found available pods in old replica set", "replicaSet", klog.KObj(targetRS), "available", targetRS.Status.AvailableReplicas)
+		if *(targetRS.Spec.Replicas) <= targetRS.Status.AvailableReplicas {
+			// no unhealthy replicas found, no scaling required. The status of a replica set just
+			// scaled down may still count the pods being deleted as available.
 			continue
 		}
 
@@ -178,7 +404,7 @@ func (dc *DeploymentController) cleanupUnhealthyReplicas(ctx context.Context, ol
 		if newReplicasCount > *(targetRS.Spec.Replicas) {
 			return nil, 0, fmt.Errorf("when cleaning up unhealthy replicas, got invalid request to scale down %s/%s %d -> %d", targetRS.Namespace, targetRS.Name, *(targetRS.Spec.Replicas), newReplicasCount)
 		}
-		_, updatedOldRS, err := dc.scaleReplicaSetAndRecordEvent(ctx, targetRS, newReplicasCount, deployment)
+		_, updatedOldRS, err := dc.scaleReplicaSetAndRecordEvent(ctx, targetRS, newReplicasCount, deployment, auditReasonCleanupUnhealthy)
 		if err != nil {
 			return nil, totalScaledDown, err
 		}
@@ -188,6 +414,19 @@ func (dc *DeploymentController) cleanupUnhealthyReplicas(ctx context.Context, ol
 	return oldRSs, totalScaledDown, nil
 }
 
+// availableReplicasWithinSpec returns the available replicas of replica sets, each of which counts
+// at most its spec.replicas. The status of a replica set just scaled down may still count the pods
+// being deleted as available, which would scale down more old pods than maxUnavailable allows.
+func availableReplicasWithinSpec(replicaSets []*apps.ReplicaSet) int32 {
+	available := int32(0)
+	for _, rs := range replicaSets {
+		if rs != nil && rs.Spec.Replicas != nil {
+			available += integer.Int32Min(*(rs.Spec.Replicas), rs.Status.AvailableReplicas)
+		}
+	}
+	return available
+}
+
 // scaleDownOldReplicaSetsForRollingUpdate scales down old replica sets when deployment strategy is "RollingUpdate".
 // Need check maxUnavailable to ensure availability
 func (dc *DeploymentController) scaleDownOldReplicaSetsForRollingUpdate(ctx context.Context, allRSs []*apps.ReplicaSet, oldRSs []*apps.ReplicaSet, deployment *apps.Deployment) (int32, error) {
@@ -196,17 +435,17 @@ func (dc *DeploymentController) scaleDownOldReplicaSetsForRollingUpdate(ctx cont
 	// Check if we can scale down.
 	minAvailable := *(deployment.Spec.Replicas) - maxUnavailable
 	// Find the number of available pods.
-	availablePodCount := deploymentutil.GetAvailableReplicaCountForReplicaSets(allRSs)
+	availablePodCount := availableReplicasWithinSpec(allRSs)
 	if availablePodCount <= minAvailable {
 		// Cannot scale down.
 		return 0, nil
 	}
-	klog.V(4).Infof("Found %d available pods in deployment %s, scaling down old RSes", availablePodCount, deployment.Name)
+	dc.log().V(4).Info("Found available pods, scaling down old replica sets", "available", availablePodCount)
 
 	sort.Sort(deploymentutil.ReplicaSetsByCreationTimestamp(oldRSs))
 
 	totalScaledDown := int32(0)
-	totalScaleDownCount := availablePodCount - minAvailable
+	totalScaleDownCount := integer.Int32Min(availablePodCount-minAvailable, dc.maxOldScaleDown(deployment, allRSs, oldRSs))
 	for _, targetRS := range oldRSs {
 		if totalScaledDown >= totalScaleDownCount {
 			// No further scaling required.
@@ -222,7 +461,7 @@ func (dc *DeploymentController) scaleDownOldReplicaSetsForRollingUpdate(ctx cont
 		if newReplicasCount > *(targetRS.Spec.Replicas) {
 			return 0, fmt.Errorf("when scaling down old RS, got invalid request to scale down %s/%s %d -> %d", targetRS.Namespace, targetRS.Name, *(targetRS.Spec.Replicas), newReplicasCount)
 		}
-		_, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, targetRS, newReplicasCount, deployment)
+		_, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, targetRS, newReplicasCount, deployment, auditReasonRollingScaleDown)
 		if err != nil {
 			return totalScaledDown, err
 		}
@@ -0,0 +1,338 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	clienttesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestReconcileNewReplicaSetWithTerminatingPods(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		name             string
+		grace            time.Duration
+		proceed          bool
+		deletedAgo       time.Duration
+		expectReplicas   int32
+		expectDrainStuck bool
+	}{
+		{
+			name:           "terminating pods are ignored when grace is disabled",
+			deletedAgo:     time.Minute,
+			expectReplicas: 2,
+		},
+		{
+			name:           "terminating pod within grace occupies capacity",
+			grace:          5 * time.Minute,
+			deletedAgo:     time.Minute,
+			expectReplicas: 1,
+		},
+		{
+			name:             "stuck terminating pod keeps occupying capacity",
+			grace:            5 * time.Minute,
+			deletedAgo:       10 * time.Minute,
+			expectReplicas:   1,
+			expectDrainStuck: true,
+		},
+		{
+			name:             "stuck terminating pod is treated as removed",
+			grace:            5 * time.Minute,
+			proceed:          true,
+			deletedAgo:       10 * time.Minute,
+			expectReplicas:   2,
+			expectDrainStuck: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(grace time.Duration, proceed bool) { drainStuckGrace, drainStuckProceed = grace, proceed }(drainStuckGrace, drainStuckProceed)
+			drainStuckGrace, drainStuckProceed = cs.grace, cs.proceed
+
+			d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 3)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 1)
+			pod := newTestPod(oldRS, "old-terminating")
+			deletionTime := metav1.NewTime(now.Add(-cs.deletedAgo))
+			pod.DeletionTimestamp = &deletionTime
+
+			dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{Partition: intstr.FromString("100%")}, []runtime.Object{d, oldRS, newRS, pod}...)
+			dc.clock = testingclock.NewFakeClock(now)
+			_, err := dc.reconcileNewReplicaSet(context.TODO(), []*apps.ReplicaSet{oldRS, newRS}, newRS, d)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := dc.client.AppsV1().ReplicaSets(newRS.Namespace).Get(context.TODO(), newRS.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got.Spec.Replicas != cs.expectReplicas {
+				t.Errorf("expect new replica set scaled to %d, got %d", cs.expectReplicas, *got.Spec.Replicas)
+			}
+			if stuck := hasEvent(collectEvents(recorder), DrainStuckReason); stuck != cs.expectDrainStuck {
+				t.Errorf("expect DrainStuck event %v, got %v", cs.expectDrainStuck, stuck)
+			}
+		})
+	}
+}
+
+func TestDrainStuckEventOnce(t *testing.T) {
+	defer func(grace time.Duration) { drainStuckGrace = grace }(drainStuckGrace)
+	drainStuckGrace = 5 * time.Minute
+
+	now := time.Now()
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 3)
+	pod := newTestPod(oldRS, "old-terminating")
+	deletionTime := metav1.NewTime(now)
+	pod.DeletionTimestamp = &deletionTime
+	dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS, pod)
+	dc.stuckDrains = newUIDTracker()
+	clock := testingclock.NewFakeClock(now.Add(10 * time.Minute))
+	dc.clock = clock
+
+	// Only the first sync of each stuck episode emits the event.
+	for i, step := range []struct {
+		now         time.Time
+		expectEvent bool
+	}{
+		{now: now.Add(10 * time.Minute), expectEvent: true},
+		{now: now.Add(11 * time.Minute), expectEvent: false},
+		{now: now.Add(time.Minute), expectEvent: false},
+		{now: now.Add(12 * time.Minute), expectEvent: true},
+	} {
+		clock.SetTime(step.now)
+		if _, err := dc.countTerminatingPods(d, []*apps.ReplicaSet{oldRS}); err != nil {
+			t.Fatalf("round %d: failed to count terminating pods: %v", i, err)
+		}
+		if stuck := hasEvent(collectEvents(recorder), DrainStuckReason); stuck != step.expectEvent {
+			t.Fatalf("round %d: expect DrainStuck event %v, got %v", i, step.expectEvent, stuck)
+		}
+	}
+}
+
+func TestSyncDeploymentAlreadySatisfiedBatch(t *testing.T) {
+	now := time.Now()
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	objects := []runtime.Object{d, oldRS, newRS}
+	objects = append(objects, newTestPodsReadySince(newRS, "soaked", 3, now.Add(-time.Hour))...)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+		BatchSoak:     &rolloutsv1alpha1.DeploymentBatchSoak{Seconds: 600},
+	}
+	dc, client, _ := newTestController(strategy, objects...)
+	fakeClock := testingclock.NewFakeClock(now)
+	dc.clock = fakeClock
+	for i := 0; i < 3; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	batch := getExtraStatus(d)
+	if batch == nil || !batch.BatchSoaked || batch.ExpectedUpdatedReplicas != 3 {
+		t.Fatalf("expect the batch of 3 replicas soaked, got %+v", batch)
+	}
+
+	// The new partition results in the same batch, which is already satisfied.
+	d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = `{"partition":"25%"}`
+	if _, err := client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), d, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update deployment: %v", err)
+	}
+	dc.strategy.Partition = intstr.FromString("25%")
+	fakeClock.Step(time.Minute)
+	client.ClearActions()
+	if err := dc.syncDeployment(context.TODO(), d); err != nil {
+		t.Fatalf("failed to sync deployment: %v", err)
+	}
+	for _, action := range client.Actions() {
+		// Only the strategy status is updated with the new partition.
+		if patch, ok := action.(clienttesting.PatchAction); ok && !strings.Contains(string(patch.GetPatch()), rolloutsv1alpha1.DeploymentExtraStatusAnnotation) {
+			continue
+		}
+		if verb := action.GetVerb(); verb != "get" && verb != "list" && verb != "watch" {
+			t.Errorf("expect no writes for the satisfied batch, got %s %s", verb, action.GetResource().Resource)
+		}
+	}
+	d, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	if extraStatus := getExtraStatus(d); !extraStatus.BatchStartTime.Equal(batch.BatchStartTime) || !extraStatus.BatchSoaked {
+		t.Fatalf("expect the batch kept soaked since %v, got %+v", batch.BatchStartTime, extraStatus)
+	}
+
+	// The next batch is rolled as usual.
+	dc.strategy.Partition = intstr.FromString("40%")
+	for i := 0; i < 3; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v2"] != 4 {
+		t.Fatalf("expect the next batch rolled, got %v", replicas)
+	}
+}
+
+func TestSyncPartitionWithoutSurgeKeepsAvailability(t *testing.T) {
+	t.Run("stepping through partitions", func(t *testing.T) {
+		d := newTestDeployment(10, intstr.FromInt(0), intstr.FromInt(1))
+		oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+		strategy := rolloutsv1alpha1.DeploymentStrategy{
+			RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+			RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		}
+		dc, client, _ := newTestController(strategy, d, oldRS)
+
+		for _, partition := range []int{2, 5, 10} {
+			dc.strategy.Partition = intstr.FromInt(partition)
+			for i := 0; i < 30; i++ {
+				syncAndCheckAvailability(t, dc, client, d)
+				// Every other reconcile sees the replica sets with stale status, which still
+				// count the pods scaled down just now as available.
+				if i%2 == 1 {
+					settleReplicaSets(t, dc, client, d.Namespace)
+				}
+			}
+			expect := map[string]int32{"demo:v1": int32(10 - partition), "demo:v2": int32(partition)}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+				t.Fatalf("partition %d: expect replicas %v, got %v", partition, expect, replicas)
+			}
+		}
+	})
+
+	t.Run("stale status of old replica set", func(t *testing.T) {
+		d := newTestDeployment(10, intstr.FromInt(0), intstr.FromInt(1))
+		// The old replica set was just scaled down from 10, but its status is not updated yet.
+		oldRS := newTestReplicaSet(d, "demo:v1", 1, 9)
+		oldRS.Status.AvailableReplicas = 10
+		newRS := newTestReplicaSet(d, "demo:v2", 2, 1)
+		strategy := rolloutsv1alpha1.DeploymentStrategy{
+			RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+			RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+			Partition:     intstr.FromInt(5),
+		}
+		dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+
+		syncAndCheckAvailability(t, dc, client, d)
+		if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 8 {
+			t.Fatalf("expect old replica set scaled down by 1, got %v", replicas)
+		}
+	})
+}
+
+func TestSyncPartitionWithPercentFenceposts(t *testing.T) {
+	cases := []struct {
+		replicas          int32
+		maxSurge          string
+		maxUnavailable    string
+		expectSurge       int32
+		expectUnavailable int32
+	}{
+		{replicas: 4, maxSurge: "0%", maxUnavailable: "25%", expectSurge: 0, expectUnavailable: 1},
+		{replicas: 4, maxSurge: "25%", maxUnavailable: "0%", expectSurge: 1, expectUnavailable: 0},
+		{replicas: 4, maxSurge: "10%", maxUnavailable: "10%", expectSurge: 1, expectUnavailable: 0},
+		{replicas: 7, maxSurge: "25%", maxUnavailable: "25%", expectSurge: 2, expectUnavailable: 1},
+		{replicas: 7, maxSurge: "0%", maxUnavailable: "10%", expectSurge: 0, expectUnavailable: 1},
+		{replicas: 10, maxSurge: "25%", maxUnavailable: "25%", expectSurge: 3, expectUnavailable: 2},
+		{replicas: 10, maxSurge: "0%", maxUnavailable: "30%", expectSurge: 0, expectUnavailable: 3},
+	}
+
+	for _, cs := range cases {
+		t.Run(fmt.Sprintf("%d replicas with surge %s and unavailable %s", cs.replicas, cs.maxSurge, cs.maxUnavailable), func(t *testing.T) {
+			d := newTestDeployment(cs.replicas, intstr.FromString(cs.maxSurge), intstr.FromString(cs.maxUnavailable))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, cs.replicas)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+			withStrategy := dc.withStrategy(d)
+			if surge, unavailable := deploymentutil.MaxSurge(*withStrategy), deploymentutil.MaxUnavailable(*withStrategy); surge != cs.expectSurge || unavailable != cs.expectUnavailable {
+				t.Fatalf("expect surge %d and unavailable %d, got %d and %d", cs.expectSurge, cs.expectUnavailable, surge, unavailable)
+			}
+
+			for _, partition := range []string{"50%", "100%"} {
+				dc.strategy.Partition = intstr.FromString(partition)
+				for i := 0; i < 30; i++ {
+					syncAndCheckAvailability(t, dc, client, withStrategy)
+					if i%2 == 1 {
+						settleReplicaSets(t, dc, client, d.Namespace)
+					}
+				}
+			}
+			expect := map[string]int32{"demo:v1": 0, "demo:v2": cs.replicas}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+				t.Fatalf("expect replicas %v, got %v", expect, replicas)
+			}
+		})
+	}
+}
+
+// syncAndCheckAvailability runs syncDeployment, and then checks that the pods scaled down are no
+// more than the ones allowed by maxUnavailable and maxSurge, if they are gone at once, while
+// the new pods are not available until the replica sets are settled.
+func syncAndCheckAvailability(t *testing.T, dc *DeploymentController, client *fake.Clientset, d *apps.Deployment) {
+	latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get deployment: %v", err)
+	}
+	if err = dc.syncDeployment(context.TODO(), latest); err != nil {
+		t.Fatalf("failed to sync deployment: %v", err)
+	}
+	rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	available, total := int32(0), int32(0)
+	for _, rs := range rsList.Items {
+		available += integer.Int32Min(*rs.Spec.Replicas, rs.Status.AvailableReplicas)
+		total += *rs.Spec.Replicas
+	}
+	if minAvailable := *d.Spec.Replicas - deploymentutil.MaxUnavailable(*d); available < minAvailable {
+		t.Fatalf("expect at least %d available replicas, got %d", minAvailable, available)
+	}
+	if maxReplicas := *d.Spec.Replicas + deploymentutil.MaxSurge(*d); total > maxReplicas {
+		t.Fatalf("expect at most %d replicas, got %d", maxReplicas, total)
+	}
+
+	// Refresh the lister with the replica sets scaled, whose status are still stale.
+	indexer := newTestReplicaSetIndexer()
+	for i := range rsList.Items {
+		_ = indexer.Add(&rsList.Items[i])
+	}
+	dc.rsLister = appslisters.NewReplicaSetLister(indexer)
+	dc.rsIndexer = indexer
+}
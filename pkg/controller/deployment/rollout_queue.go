@@ -0,0 +1,191 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// RolloutQueuedReason is added in a deployment event when its rollout is queued because of the
+// max active rollouts of its namespace.
+const RolloutQueuedReason = "RolloutQueued"
+
+// queuedRolloutRecheckInterval is how often a queued rollout checks whether it can start, since
+// it is not notified once the active rollouts complete.
+const queuedRolloutRecheckInterval = 30 * time.Second
+
+// activeRolloutTracker tracks the rollouts admitted in each namespace, so that the rollouts
+// admitted concurrently by different workers are counted before their extra status is written.
+// A nil activeRolloutTracker only counts the active rollouts in the informer's store.
+type activeRolloutTracker struct {
+	lock   sync.Mutex
+	active map[string]sets.String
+}
+
+func newActiveRolloutTracker() *activeRolloutTracker {
+	return &activeRolloutTracker{active: map[string]sets.String{}}
+}
+
+// admit admits the rollout of deployment name if fewer than limit rollouts other than it are
+// active, which are the ones admitted before and the ones found active in the informer's store.
+func (t *activeRolloutTracker) admit(namespace, name string, limit int, active sets.String) bool {
+	if t == nil {
+		return active.Delete(name).Len() < limit
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.active[namespace].Has(name) {
+		return true
+	}
+	if active.Union(t.active[namespace]).Delete(name).Len() >= limit {
+		return false
+	}
+	if t.active[namespace] == nil {
+		t.active[namespace] = sets.NewString()
+	}
+	t.active[namespace].Insert(name)
+	return true
+}
+
+// has returns true if the rollout of deployment name has been admitted.
+func (t *activeRolloutTracker) has(namespace, name string) bool {
+	if t == nil {
+		return false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.active[namespace].Has(name)
+}
+
+// release forgets the rollout of deployment name, once it completes or is deleted.
+func (t *activeRolloutTracker) release(namespace, name string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.active[namespace].Has(name) {
+		t.active[namespace].Delete(name)
+		if t.active[namespace].Len() == 0 {
+			delete(t.active, namespace)
+		}
+	}
+}
+
+// maxActiveRollouts returns the max active rollouts of namespace from its annotation, or
+// --deployment-namespace-max-active-rollouts if it is not set, 0 means no limit.
+func (dc *DeploymentController) maxActiveRollouts(namespace string) int {
+	if dc.nsLister != nil {
+		ns, err := dc.nsLister.Get(namespace)
+		if err != nil && !errors.IsNotFound(err) {
+			dc.log().Error(err, "Failed to get namespace")
+		}
+		if err == nil {
+			if limit, ok := parseMaxActiveRollouts(ns); ok {
+				return limit
+			}
+		}
+	}
+	return namespaceMaxActiveRollouts
+}
+
+// parseMaxActiveRollouts returns the max active rollouts in the annotation of namespace.
+func parseMaxActiveRollouts(ns *v1.Namespace) (int, bool) {
+	value, ok := ns.Annotations[rolloutsv1alpha1.NamespaceMaxActiveRolloutsAnnotation]
+	if !ok {
+		return 0, false
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		klog.Warningf("Invalid annotation %s=%q of namespace %s", rolloutsv1alpha1.NamespaceMaxActiveRolloutsAnnotation, value, ns.Name)
+		return 0, false
+	}
+	return limit, true
+}
+
+// isActiveRollout returns true if the deployment is rolling according to its extra status, i.e.
+// it has started rolling to a revision, and it has not completed or been queued.
+func isActiveRollout(d *apps.Deployment) bool {
+	if !deploymentutil.IsUnderRolloutControl(d) || d.DeletionTimestamp != nil {
+		return false
+	}
+	extraStatus := getExtraStatus(d)
+	return extraStatus != nil && extraStatus.UpdateRevision != "" && !extraStatus.Queued &&
+		extraStatus.UpdatedReadyReplicas < replicasOf(d)
+}
+
+// queueRollout returns true if the rollout of deployment should wait, because the max active
+// rollouts of its namespace are reached. A rollout already started keeps rolling, and the
+// rollout is released once it completes or is not active any more.
+func (dc *DeploymentController) queueRollout(d *apps.Deployment, rsList []*apps.ReplicaSet) bool {
+	// There is nothing to roll if there are no old pods, e.g. the deployment is just created.
+	newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+	_, oldRSs := deploymentutil.FindOldReplicaSets(d, rsList)
+	if deploymentutil.GetReplicaCountForReplicaSets(oldRSs) == 0 {
+		dc.activeRollouts.release(d.Namespace, d.Name)
+		return false
+	}
+	if !isActiveRollout(d) {
+		dc.activeRollouts.release(d.Namespace, d.Name)
+	}
+	limit := dc.maxActiveRollouts(d.Namespace)
+	if limit <= 0 {
+		return false
+	}
+
+	prev := getExtraStatus(d)
+	queued := prev != nil && prev.Queued
+	if dc.activeRollouts.has(d.Namespace, d.Name) || (!queued && newRS != nil && *newRS.Spec.Replicas > 0) {
+		return false
+	}
+
+	active := sets.NewString()
+	deployments, err := dc.dLister.Deployments(d.Namespace).List(labels.Everything())
+	if err != nil {
+		dc.log().Error(err, "Failed to list deployments")
+		// The rollout is queued rather than exceeding the limit, and it is checked again later.
+		dc.enqueueAfter(d, queuedRolloutRecheckInterval)
+		return true
+	}
+	for _, other := range deployments {
+		if isActiveRollout(other) {
+			active.Insert(other.Name)
+		}
+	}
+	if dc.activeRollouts.admit(d.Namespace, d.Name, limit, active) {
+		return false
+	}
+
+	if !queued {
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, RolloutQueuedReason, "Rollout is queued since %d rollouts are active in namespace %s", limit, d.Namespace)
+	}
+	dc.log().V(3).Info("Rollout is queued, max active rollouts reached", "limit", limit)
+	dc.enqueueAfter(d, queuedRolloutRecheckInterval)
+	return true
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentQueuedByNamespaceLimit(t *testing.T) {
+	cases := []struct {
+		name         string
+		annotation   string
+		defaultLimit int
+		expectQueued bool
+	}{
+		{
+			name:         "limit of namespace reached",
+			annotation:   "1",
+			expectQueued: true,
+		},
+		{
+			name:         "limit of namespace not reached",
+			annotation:   "2",
+			defaultLimit: 1,
+		},
+		{
+			name:         "default limit reached",
+			defaultLimit: 1,
+			expectQueued: true,
+		},
+		{
+			name:         "limit of namespace overrides default",
+			annotation:   "0",
+			defaultLimit: 1,
+		},
+		{
+			name: "no limit",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(limit int) { namespaceMaxActiveRollouts = limit }(namespaceMaxActiveRollouts)
+			namespaceMaxActiveRollouts = cs.defaultLimit
+
+			ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{}}}
+			if cs.annotation != "" {
+				ns.Annotations[rolloutsv1alpha1.NamespaceMaxActiveRolloutsAnnotation] = cs.annotation
+			}
+			active := newTestControlledDeployment("active", true, &rolloutsv1alpha1.DeploymentExtraStatus{
+				UpdateRevision:          "demo-v2",
+				ExpectedUpdatedReplicas: 3,
+				UpdatedReadyReplicas:    3,
+			})
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 0)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("30%"),
+			}
+			dc, client, recorder := newTestController(strategy, ns, active, d, oldRS, newRS)
+			dc.activeRollouts = newActiveRolloutTracker()
+
+			for i := 0; i < 3; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			replicas := getReplicaSetReplicas(t, client, d.Namespace)
+			if queued := replicas["demo:v2"] == 0; queued != cs.expectQueued {
+				t.Fatalf("expect queued %v, got replicas %v", cs.expectQueued, replicas)
+			}
+			extraStatus := getExtraStatus(d)
+			if extraStatus == nil || extraStatus.Queued != cs.expectQueued {
+				t.Fatalf("expect queued %v in extra status, got %+v", cs.expectQueued, extraStatus)
+			}
+			if hasEvent(collectEvents(recorder), RolloutQueuedReason) != cs.expectQueued {
+				t.Fatalf("expect %s event %v", RolloutQueuedReason, cs.expectQueued)
+			}
+			if !cs.expectQueued {
+				return
+			}
+			if dc.requeueAfter != queuedRolloutRecheckInterval {
+				t.Fatalf("expect queued rollout checked again after %v, got %v", queuedRolloutRecheckInterval, dc.requeueAfter)
+			}
+
+			// The active rollout completes, so the queued one starts.
+			completed := newTestControlledDeployment("active", true, &rolloutsv1alpha1.DeploymentExtraStatus{
+				UpdateRevision:          "demo-v2",
+				ExpectedUpdatedReplicas: 10,
+				UpdatedReadyReplicas:    10,
+			})
+			dIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+			_ = dIndexer.Add(completed)
+			_ = dIndexer.Add(d)
+			dc.dLister = appslisters.NewDeploymentLister(dIndexer)
+			for i := 0; i < 5; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v2"] != 3 {
+				t.Fatalf("expect the queued rollout started, got replicas %v", replicas)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus.Queued {
+				t.Fatalf("expect not queued in extra status, got %+v", extraStatus)
+			}
+		})
+	}
+}
+
+func TestQueueRolloutReleasesInactiveRollout(t *testing.T) {
+	cases := []struct {
+		name          string
+		extraStatus   *rolloutsv1alpha1.DeploymentExtraStatus
+		expectTracked bool
+	}{
+		{
+			name: "rollout in progress",
+			extraStatus: &rolloutsv1alpha1.DeploymentExtraStatus{
+				UpdateRevision:       "demo-v2",
+				UpdatedReadyReplicas: 3,
+			},
+			expectTracked: true,
+		},
+		{
+			name: "rollout completed",
+			extraStatus: &rolloutsv1alpha1.DeploymentExtraStatus{
+				UpdateRevision:       "demo-v2",
+				UpdatedReadyReplicas: 10,
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestControlledDeployment("demo", true, cs.extraStatus)
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+			dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, oldRS, newRS)
+			dc.activeRollouts = newActiveRolloutTracker()
+			dc.activeRollouts.admit(d.Namespace, d.Name, 1, sets.NewString())
+
+			if dc.queueRollout(d, []*apps.ReplicaSet{oldRS, newRS}) {
+				t.Fatalf("expect the started rollout not queued")
+			}
+			if tracked := dc.activeRollouts.has(d.Namespace, d.Name); tracked != cs.expectTracked {
+				t.Fatalf("expect rollout tracked %v, got %v", cs.expectTracked, tracked)
+			}
+		})
+	}
+}
+
+func TestActiveRolloutTracker(t *testing.T) {
+	tracker := newActiveRolloutTracker()
+	if !tracker.admit("default", "a", 1, sets.NewString()) {
+		t.Fatalf("expect the first rollout admitted")
+	}
+	// The rollout admitted by another worker is not found active in the store yet.
+	if tracker.admit("default", "b", 1, sets.NewString()) {
+		t.Fatalf("expect the second rollout queued")
+	}
+	if !tracker.admit("other", "b", 1, sets.NewString()) {
+		t.Fatalf("expect the rollout in another namespace admitted")
+	}
+	if !tracker.admit("default", "a", 1, sets.NewString("a")) {
+		t.Fatalf("expect the admitted rollout kept admitted")
+	}
+	tracker.release("default", "a")
+	if !tracker.admit("default", "b", 1, sets.NewString()) {
+		t.Fatalf("expect the second rollout admitted once the first is released")
+	}
+	if (*activeRolloutTracker)(nil).admit("default", "c", 1, sets.NewString("b")) {
+		t.Fatalf("expect nil tracker counting the active rollouts in the store")
+	}
+}
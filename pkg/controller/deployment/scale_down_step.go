@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// isScaleDownStepped returns true if the old replica sets of d are drained in steps, i.e. the new
+// replica set may be scaled to full size and scaleDownStepSeconds of strategy is set.
+func (dc *DeploymentController) isScaleDownStepped(d *apps.Deployment) bool {
+	return dc.strategy.ScaleDownStepSeconds > 0 && dc.newRSReplicasLimit(d) >= *(d.Spec.Replicas)
+}
+
+// scaleDownStepLimit returns how many more replicas of the old replica sets can be scaled down in
+// this sync, and true if they are drained in steps, see isScaleDownStepped. A step scales down
+// maxUnavailable replicas, at least one, and no replica is scaled down until scaleDownStepSeconds
+// has elapsed since the last step, then the deployment is requeued for the next one.
+func (dc *DeploymentController) scaleDownStepLimit(d *apps.Deployment, allRSs []*apps.ReplicaSet) (int32, bool) {
+	if !dc.isScaleDownStepped(d) {
+		return 0, false
+	}
+	if last := dc.lastScaleDownStep(d, allRSs); last != nil {
+		left := time.Duration(dc.strategy.ScaleDownStepSeconds)*time.Second - dc.clock.Now().Sub(last.Time)
+		if left > 0 {
+			dc.enqueueAfter(d, left)
+			return 0, true
+		}
+	}
+	step := integer.Int32Max(deploymentutil.MaxUnavailable(*d), 1)
+	return integer.Int32Max(step-dc.scaleDownStepCount, 0), true
+}
+
+// countScaleDownStep adds the replicas of the old replica sets scaled down in this sync to the
+// step, if they are drained in steps.
+func (dc *DeploymentController) countScaleDownStep(d *apps.Deployment, count int32) {
+	if dc.isScaleDownStepped(d) {
+		dc.scaleDownStepCount += count
+	}
+}
+
+// lastScaleDownStep returns the time of the last step recorded in the extra status, if it is
+// taken for the current new replica set. The revision recorded right after the new replica set
+// is created may be empty, then the step is taken for it as well.
+func (dc *DeploymentController) lastScaleDownStep(d *apps.Deployment, allRSs []*apps.ReplicaSet) *metav1.Time {
+	prev := getExtraStatus(d)
+	newRS := deploymentutil.FindNewReplicaSet(d, allRSs)
+	if prev == nil || newRS == nil {
+		return nil
+	}
+	if prev.UpdateRevision != "" && prev.UpdateRevision != newRS.Labels[deploymentutil.TemplateHashLabelKey] {
+		return nil
+	}
+	return prev.ScaleDownStepTime
+}
+
+// scaleDownOldReplicaSetsByStep scales down at most limit replicas of the old replica sets, from
+// the oldest one, which is the step taken instead of scaling them all down to zero at once.
+func (dc *DeploymentController) scaleDownOldReplicaSetsByStep(ctx context.Context, oldRSs []*apps.ReplicaSet, d *apps.Deployment, limit int32) error {
+	oldRSs = deploymentutil.FilterActiveReplicaSets(oldRSs)
+	sort.Sort(deploymentutil.ReplicaSetsByCreationTimestamp(oldRSs))
+	for _, rs := range oldRSs {
+		if limit <= 0 {
+			break
+		}
+		scaleDownCount := integer.Int32Min(*(rs.Spec.Replicas), limit)
+		if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, rs, *(rs.Spec.Replicas)-scaleDownCount, d, auditReasonScaleDownStep); err != nil {
+			return err
+		}
+		limit -= scaleDownCount
+		dc.scaleDownStepCount += scaleDownCount
+	}
+	return nil
+}
+
+// syncScaleDownStep records the time of the step taken in this sync, or keeps the last one while
+// the deployment is rolling to the same revision.
+func (dc *DeploymentController) syncScaleDownStep(prev, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if dc.strategy.ScaleDownStepSeconds <= 0 {
+		return
+	}
+	if dc.scaleDownStepCount > 0 {
+		now := metav1.NewTime(dc.clock.Now())
+		extraStatus.ScaleDownStepTime = &now
+		return
+	}
+	if prev != nil && (prev.UpdateRevision == "" || prev.UpdateRevision == extraStatus.UpdateRevision) {
+		extraStatus.ScaleDownStepTime = prev.ScaleDownStepTime
+	}
+}
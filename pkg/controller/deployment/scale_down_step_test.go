@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentScalesDownOldReplicaSetInSteps(t *testing.T) {
+	cases := []struct {
+		name        string
+		stepSeconds int32
+		expect      []int32
+	}{
+		{
+			name:   "scaled down at once by default",
+			expect: []int32{10, 8, 0},
+		},
+		{
+			name:        "scaled down in steps",
+			stepSeconds: 60,
+			expect:      []int32{10, 8, 6, 4, 2, 0},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromString("100%"), intstr.FromInt(2))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:         rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:        d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:            intstr.FromString("100%"),
+				ScaleDownStepSeconds: cs.stepSeconds,
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+			clock := dc.clock.(*testingclock.FakeClock)
+
+			got := []int32{getReplicaSetReplicas(t, client, d.Namespace)["demo:v1"]}
+			for i := 0; i < 10 && got[len(got)-1] > 0; i++ {
+				dc.scaleDownStepCount = 0
+				syncAndSettle(t, dc, client, d.Namespace, d.Name)
+				replicas := getReplicaSetReplicas(t, client, d.Namespace)["demo:v1"]
+				got = append(got, replicas)
+				if cs.stepSeconds == 0 {
+					continue
+				}
+
+				// The next step is not taken before scaleDownStepSeconds elapses.
+				dc.scaleDownStepCount, dc.requeueAfter = 0, 0
+				syncAndSettle(t, dc, client, d.Namespace, d.Name)
+				if held := getReplicaSetReplicas(t, client, d.Namespace)["demo:v1"]; held != replicas {
+					t.Fatalf("expect old replica set held at %d before the next step, got %d", replicas, held)
+				}
+				if step := time.Duration(cs.stepSeconds) * time.Second; replicas > 0 && (dc.requeueAfter <= 0 || dc.requeueAfter > step) {
+					t.Fatalf("expect requeue after %ds for the next step, got %v", cs.stepSeconds, dc.requeueAfter)
+				}
+				clock.Step(time.Duration(cs.stepSeconds) * time.Second)
+			}
+			if !reflect.DeepEqual(got, cs.expect) {
+				t.Fatalf("expect old replica set scaled down by %v, got %v", cs.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+
+	apps "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// updateReplicaSetReplicas updates rsCopy, whose replicas have been set to the new scale. Only the
+// replicas are written via the scale subresource if it is enabled and nothing else is changed, so
+// that the fields managed by others are never overwritten. It falls back to updating the whole
+// replica set if the scale subresource fails, e.g. it is not allowed by RBAC.
+func (dc *DeploymentController) updateReplicaSetReplicas(ctx context.Context, rs, rsCopy *apps.ReplicaSet, onlyReplicas bool) (*apps.ReplicaSet, error) {
+	if scaleSubresource && onlyReplicas {
+		scale := &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: rs.Name, Namespace: rs.Namespace, ResourceVersion: rs.ResourceVersion},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: *(rsCopy.Spec.Replicas)},
+		}
+		updated, err := dc.client.AppsV1().ReplicaSets(rs.Namespace).UpdateScale(ctx, rs.Name, scale, metav1.UpdateOptions{})
+		if err == nil {
+			rsCopy.ResourceVersion = updated.ResourceVersion
+			return rsCopy, nil
+		}
+		dc.log().Error(err, "Failed to scale replica set via scale subresource, fall back to updating it", "replicaSet", klog.KObj(rs))
+	}
+	return dc.client.AppsV1().ReplicaSets(rsCopy.Namespace).Update(ctx, rsCopy, metav1.UpdateOptions{})
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestScaleReplicaSetViaSubresource(t *testing.T) {
+	cases := []struct {
+		name               string
+		enabled            bool
+		scaleErr           error
+		deployReplicas     int32
+		expectSubresources []string
+	}{
+		{
+			name:               "disabled",
+			deployReplicas:     3,
+			expectSubresources: []string{""},
+		},
+		{
+			name:               "scaled via subresource",
+			enabled:            true,
+			deployReplicas:     3,
+			expectSubresources: []string{"scale"},
+		},
+		{
+			name:               "fall back on error",
+			enabled:            true,
+			scaleErr:           fmt.Errorf("forbidden"),
+			deployReplicas:     3,
+			expectSubresources: []string{"scale", ""},
+		},
+		{
+			name:               "annotations changed as well",
+			enabled:            true,
+			deployReplicas:     4,
+			expectSubresources: []string{""},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(enabled bool) { scaleSubresource = enabled }(scaleSubresource)
+			scaleSubresource = cs.enabled
+
+			d := newTestDeployment(3, intstr.FromInt(1), intstr.FromInt(0))
+			rs := newTestReplicaSet(d, "demo:v1", 1, 3)
+			rs.ResourceVersion = "1"
+			d.Spec.Replicas = pointer.Int32(cs.deployReplicas)
+			dc, client, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, rs)
+			client.PrependReactor("update", "replicasets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "scale" {
+					return false, nil, nil
+				}
+				if cs.scaleErr != nil {
+					return true, nil, cs.scaleErr
+				}
+				scale := action.(clienttesting.UpdateAction).GetObject().(*autoscalingv1.Scale).DeepCopy()
+				scale.ResourceVersion = "2"
+				return true, scale, nil
+			})
+			client.ClearActions()
+
+			scaled, updated, err := dc.scaleReplicaSet(context.TODO(), rs, 1, d, "down", auditReasonRolloutCompleted)
+			if err != nil || !scaled {
+				t.Fatalf("expect scaled without error, got %v, %v", scaled, err)
+			}
+			if *updated.Spec.Replicas != 1 {
+				t.Fatalf("expect returned replica set with 1 replica, got %d", *updated.Spec.Replicas)
+			}
+
+			var subresources []string
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "update" && action.GetResource().Resource == "replicasets" {
+					subresources = append(subresources, action.GetSubresource())
+				}
+			}
+			if !reflect.DeepEqual(subresources, cs.expectSubresources) {
+				t.Fatalf("expect updates of subresources %q, got %q", cs.expectSubresources, subresources)
+			}
+			if cs.enabled && cs.scaleErr == nil && cs.deployReplicas == 3 {
+				if updated.ResourceVersion != "2" {
+					t.Fatalf("expect resource version of scale, got %s", updated.ResourceVersion)
+				}
+				return
+			}
+			latest, err := client.AppsV1().ReplicaSets(rs.Namespace).Get(context.TODO(), rs.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get replica set: %v", err)
+			}
+			if *latest.Spec.Replicas != 1 {
+				t.Fatalf("expect 1 replica, got %d", *latest.Spec.Replicas)
+			}
+		})
+	}
+}
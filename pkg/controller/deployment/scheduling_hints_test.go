@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentMergesSchedulingHints(t *testing.T) {
+	required := &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: "kubernetes.io/os", Operator: v1.NodeSelectorOpIn, Values: []string{"linux"}}},
+	}}}
+	existingTerm := v1.PreferredSchedulingTerm{Weight: 10, Preference: v1.NodeSelectorTerm{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: "disk", Operator: v1.NodeSelectorOpIn, Values: []string{"ssd"}}},
+	}}
+	hintTerm := v1.PreferredSchedulingTerm{Weight: 100, Preference: v1.NodeSelectorTerm{
+		MatchExpressions: []v1.NodeSelectorRequirement{{Key: "node-pool", Operator: v1.NodeSelectorOpIn, Values: []string{"stable"}}},
+	}}
+	zoneConstraint := v1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: v1.DoNotSchedule}
+	hostConstraint := v1.TopologySpreadConstraint{MaxSkew: 2, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: v1.ScheduleAnyway}
+
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	d.Spec.Template.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution:  required,
+		PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{existingTerm},
+	}}
+	d.Spec.Template.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{zoneConstraint}
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+		SchedulingHints: &rolloutsv1alpha1.DeploymentSchedulingHints{
+			PreferredNodeAffinity: []v1.PreferredSchedulingTerm{hintTerm},
+			// The zone is constrained by the template already, so it is left out.
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{
+				{MaxSkew: 3, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: v1.DoNotSchedule},
+				hostConstraint,
+			},
+		},
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	// The new replica set is found again on the later syncs instead of taken as another revision.
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list replica sets: %v", err)
+	}
+	if len(rsList.Items) != 2 {
+		t.Fatalf("expect 2 replica sets, got %d", len(rsList.Items))
+	}
+	var newRS *apps.ReplicaSet
+	for i := range rsList.Items {
+		if rsList.Items[i].Name != oldRS.Name {
+			newRS = &rsList.Items[i]
+		}
+	}
+	if *newRS.Spec.Replicas != 3 {
+		t.Fatalf("expect 3 replicas of new replica set, got %d", *newRS.Spec.Replicas)
+	}
+
+	expectAffinity := &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution:  required,
+		PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{existingTerm, hintTerm},
+	}}
+	if spec := newRS.Spec.Template.Spec; !reflect.DeepEqual(spec.Affinity, expectAffinity) {
+		t.Fatalf("expect affinity %+v, got %+v", expectAffinity, spec.Affinity)
+	}
+	expectConstraints := []v1.TopologySpreadConstraint{zoneConstraint, hostConstraint}
+	if spec := newRS.Spec.Template.Spec; !reflect.DeepEqual(spec.TopologySpreadConstraints, expectConstraints) {
+		t.Fatalf("expect topology spread constraints %+v, got %+v", expectConstraints, spec.TopologySpreadConstraints)
+	}
+	if affinity := d.Spec.Template.Spec.Affinity; len(affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 || len(d.Spec.Template.Spec.TopologySpreadConstraints) != 1 {
+		t.Fatalf("expect the template of deployment untouched, got %+v", d.Spec.Template.Spec)
+	}
+}
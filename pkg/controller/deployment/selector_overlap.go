@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// SelectorOverlapReason is added in a deployment event when its selector overlaps with
+	// another deployment in the same namespace.
+	SelectorOverlapReason = "SelectorOverlap"
+	// AmbiguousOwnershipReason is added in a deployment event when it is not synced because
+	// some replica sets may be claimed by both it and an overlapping deployment.
+	AmbiguousOwnershipReason = "AmbiguousOwnership"
+)
+
+// ambiguousOwnershipRecheckInterval is how often to check the ambiguous ownership again, since
+// the deployment is not notified once the other deployment is changed.
+const ambiguousOwnershipRecheckInterval = 30 * time.Second
+
+// checkSelectorOverlap emits a warning event for each deployment in the same namespace whose
+// selector overlaps with the one of d, and returns true if the ownership of some replica sets
+// selected by both of them is ambiguous, in which case d should not be mutated at all.
+//
+// The replica sets are always scoped by their controller references, so an overlap alone only
+// confuses the consumers of labels, e.g. Services. But a replica set selected by both of them
+// without a controller may be adopted by the other controller at any time, and a replica set
+// controlled by one of them but also owned by the other is claimed by both.
+func (dc *DeploymentController) checkSelectorOverlap(d *apps.Deployment) (bool, error) {
+	if !checkSelectorOverlaps || dc.dLister == nil {
+		return false, nil
+	}
+	deployments, err := dc.dLister.Deployments(d.Namespace).List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+	var selected []*apps.ReplicaSet
+	ambiguous := false
+	for _, other := range deployments {
+		if other.UID == d.UID || other.DeletionTimestamp != nil || !selectorsOverlap(d, other) {
+			continue
+		}
+		dc.log().Info("Selector overlaps with another deployment", "other", klog.KObj(other))
+		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, SelectorOverlapReason,
+			"Selector overlaps with deployment %s, which is an anti-pattern", other.Name)
+
+		if selected == nil {
+			selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+			if err != nil {
+				return false, err
+			}
+			if selected, err = dc.rsLister.ReplicaSets(d.Namespace).List(selector); err != nil {
+				return false, err
+			}
+		}
+		for _, rs := range selected {
+			if !selectorMatches(other.Spec.Selector, rs.Labels) || !isOwnershipAmbiguous(rs, d, other) {
+				continue
+			}
+			ambiguous = true
+			dc.eventRecorder.Eventf(d, v1.EventTypeWarning, AmbiguousOwnershipReason,
+				"Stop syncing since replica set %s may be claimed by both this deployment and deployment %s", rs.Name, other.Name)
+		}
+	}
+	if ambiguous {
+		dc.enqueueAfter(d, ambiguousOwnershipRecheckInterval)
+	}
+	return ambiguous, nil
+}
+
+// isOwnershipAmbiguous returns true if rs selected by both deployments has no controller, or
+// is controlled by one of them but also owned by the other.
+func isOwnershipAmbiguous(rs *apps.ReplicaSet, a, b *apps.Deployment) bool {
+	controllerRef := metav1.GetControllerOf(rs)
+	if controllerRef == nil {
+		return true
+	}
+	owners := 0
+	for _, ref := range rs.OwnerReferences {
+		if ref.UID == a.UID || ref.UID == b.UID {
+			owners++
+		}
+	}
+	return owners > 1
+}
+
+// selectorsOverlap returns true if the selector of either deployment matches the pod template
+// labels of the other, i.e. some pods can be selected by both of them.
+func selectorsOverlap(a, b *apps.Deployment) bool {
+	return selectorMatches(a.Spec.Selector, b.Spec.Template.Labels) || selectorMatches(b.Spec.Selector, a.Spec.Template.Labels)
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) bool {
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		return false
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentWithOverlappingSelector(t *testing.T) {
+	cases := []struct {
+		name            string
+		otherLabels     map[string]string
+		foreignOwner    string
+		disabled        bool
+		expectOverlap   bool
+		expectAmbiguous bool
+	}{
+		{
+			name:          "replica sets controlled by each",
+			otherLabels:   map[string]string{"app": "demo"},
+			foreignOwner:  "other",
+			expectOverlap: true,
+		},
+		{
+			name:            "orphan replica set selected by both",
+			otherLabels:     map[string]string{"app": "demo"},
+			expectOverlap:   true,
+			expectAmbiguous: true,
+		},
+		{
+			name:            "replica set controlled by other and owned by both",
+			otherLabels:     map[string]string{"app": "demo"},
+			foreignOwner:    "both",
+			expectOverlap:   true,
+			expectAmbiguous: true,
+		},
+		{
+			name:        "no overlap",
+			otherLabels: map[string]string{"app": "another"},
+		},
+		{
+			name:        "check disabled",
+			otherLabels: map[string]string{"app": "demo"},
+			disabled:    true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(check bool) { checkSelectorOverlaps = check }(checkSelectorOverlaps)
+			checkSelectorOverlaps = !cs.disabled
+
+			d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 4)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 0)
+
+			other := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+			other.Name, other.UID = "other", types.UID("other-uid")
+			other.Spec.Selector.MatchLabels = cs.otherLabels
+			other.Spec.Template.Labels = cs.otherLabels
+			foreignRS := newTestReplicaSet(other, "demo:v0", 1, 2)
+			switch cs.foreignOwner {
+			case "":
+				foreignRS.OwnerReferences = nil
+			case "both":
+				foreignRS.OwnerReferences = append(foreignRS.OwnerReferences, metav1.OwnerReference{
+					APIVersion: "apps/v1", Kind: "Deployment", Name: d.Name, UID: d.UID,
+				})
+			}
+
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS, newRS, other, foreignRS)
+			for i := 0; i < 3; i++ {
+				syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+
+			replicas := getReplicaSetReplicas(t, client, d.Namespace)
+			if started := replicas["demo:v2"] > 0; started == cs.expectAmbiguous {
+				t.Fatalf("expect rollout started %v, got replicas %v", !cs.expectAmbiguous, replicas)
+			}
+			if replicas["demo:v0"] != 2 {
+				t.Fatalf("expect foreign replica set untouched, got replicas %v", replicas)
+			}
+			events := collectEvents(recorder)
+			if hasEvent(events, SelectorOverlapReason) != cs.expectOverlap {
+				t.Fatalf("expect %s event %v, got %v", SelectorOverlapReason, cs.expectOverlap, events)
+			}
+			if hasEvent(events, AmbiguousOwnershipReason) != cs.expectAmbiguous {
+				t.Fatalf("expect %s event %v, got %v", AmbiguousOwnershipReason, cs.expectAmbiguous, events)
+			}
+			if cs.expectAmbiguous && dc.requeueAfter != ambiguousOwnershipRecheckInterval {
+				t.Fatalf("expect ambiguous ownership checked again after %v, got %v", ambiguousOwnershipRecheckInterval, dc.requeueAfter)
+			}
+		})
+	}
+}
+
+func TestSelectorsOverlap(t *testing.T) {
+	d := newTestDeployment(1, intstr.FromInt(1), intstr.FromInt(0))
+	narrower := d.DeepCopy()
+	narrower.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo", "tier": "web"}}
+	narrower.Spec.Template.Labels = map[string]string{"app": "demo", "tier": "web"}
+	disjoint := d.DeepCopy()
+	disjoint.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "another"}}
+	disjoint.Spec.Template.Labels = map[string]string{"app": "another"}
+	empty := d.DeepCopy()
+	empty.Spec.Selector = &metav1.LabelSelector{}
+
+	cases := []struct {
+		name   string
+		other  *apps.Deployment
+		expect bool
+	}{
+		{name: "same selector", other: d.DeepCopy(), expect: true},
+		{name: "narrower selector", other: narrower, expect: true},
+		{name: "disjoint selector", other: disjoint},
+		{name: "empty selector", other: empty, expect: true},
+	}
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if got := selectorsOverlap(d, cs.other); got != cs.expect {
+				t.Fatalf("expect overlap %v, got %v", cs.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+const (
+	// BatchSLAExceededReason is added in a deployment event when the current batch has
+	// taken longer than --deployment-batch-sla.
+	BatchSLAExceededReason = "BatchSLAExceeded"
+	// RolloutSLAExceededReason is added in a deployment event when the rollout has taken
+	// longer than --deployment-rollout-sla.
+	RolloutSLAExceededReason = "RolloutSLAExceeded"
+)
+
+// getExtraStatus returns the extra status recorded in the annotation of deployment,
+// nil if it has not been recorded or cannot be parsed.
+func getExtraStatus(deployment *apps.Deployment) *rolloutsv1alpha1.DeploymentExtraStatus {
+	extraStatusAnno := deployment.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation]
+	if extraStatusAnno == "" {
+		return nil
+	}
+	extraStatus := &rolloutsv1alpha1.DeploymentExtraStatus{}
+	if err := json.Unmarshal([]byte(extraStatusAnno), extraStatus); err != nil {
+		klog.Warningf("Failed to unmarshal extra status for Deployment %v, err: %v", klog.KObj(deployment), err)
+		return nil
+	}
+	return extraStatus
+}
+
+// syncProgressTimes carries the progress times over from the previous extra status, and
+// resets them once the deployment starts rolling to a new revision or a new batch.
+func (dc *DeploymentController) syncProgressTimes(prev, cur *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if cur.UpdateRevision == "" {
+		return
+	}
+	now := metav1.NewTime(dc.clock.Now())
+	if prev == nil || prev.UpdateRevision != cur.UpdateRevision || prev.RolloutStartTime == nil {
+		cur.RolloutStartTime = &now
+		cur.BatchStartTime = &now
+		return
+	}
+	cur.RolloutStartTime = prev.RolloutStartTime
+	cur.RolloutSLABreached = prev.RolloutSLABreached
+	if prev.ExpectedUpdatedReplicas != cur.ExpectedUpdatedReplicas || prev.BatchStartTime == nil {
+		cur.BatchStartTime = &now
+		return
+	}
+	cur.BatchStartTime = prev.BatchStartTime
+	cur.BatchSLABreached = prev.BatchSLABreached
+}
+
+// checkProgressSLA emits a warning event and increases the breach counter once the current
+// batch or the rollout takes longer than expected. The rollout will not be failed. Only a
+// batch which is still in progress is checked, because the deployment may be held at a
+// finished batch as long as users want.
+func (dc *DeploymentController) checkProgressSLA(deployment *apps.Deployment, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) {
+	if !isBatchInProgress(extraStatus) {
+		return
+	}
+
+	now := dc.clock.Now()
+	if batchSLA > 0 && !extraStatus.BatchSLABreached && extraStatus.BatchStartTime != nil {
+		if elapsed := now.Sub(extraStatus.BatchStartTime.Time); elapsed >= batchSLA {
+			extraStatus.BatchSLABreached = true
+			recordSLABreach(deployment, "batch")
+			dc.eventRecorder.Eventf(deployment, v1.EventTypeWarning, BatchSLAExceededReason,
+				"Batch with %d expected updated replicas has been in progress for %v, exceeding SLA %v",
+				extraStatus.ExpectedUpdatedReplicas, elapsed.Round(time.Second), batchSLA)
+		} else {
+			dc.enqueueAfter(deployment, batchSLA-elapsed)
+		}
+	}
+	if rolloutSLA > 0 && !extraStatus.RolloutSLABreached && extraStatus.RolloutStartTime != nil {
+		if elapsed := now.Sub(extraStatus.RolloutStartTime.Time); elapsed >= rolloutSLA {
+			extraStatus.RolloutSLABreached = true
+			recordSLABreach(deployment, "rollout")
+			dc.eventRecorder.Eventf(deployment, v1.EventTypeWarning, RolloutSLAExceededReason,
+				"Rollout to revision %s has been in progress for %v, exceeding SLA %v",
+				extraStatus.UpdateRevision, elapsed.Round(time.Second), rolloutSLA)
+		} else {
+			dc.enqueueAfter(deployment, rolloutSLA-elapsed)
+		}
+	}
+}
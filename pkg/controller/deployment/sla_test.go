@@ -0,0 +1,190 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncProgressTimes(t *testing.T) {
+	now := time.Now()
+	dc := &DeploymentController{clock: testingclock.NewFakeClock(now)}
+	before := metav1.NewTime(now.Add(-time.Hour))
+
+	prev := &rolloutsv1alpha1.DeploymentExtraStatus{
+		ExpectedUpdatedReplicas: 3,
+		UpdateRevision:          "v2",
+		RolloutStartTime:        &before,
+		BatchStartTime:          &before,
+		BatchSLABreached:        true,
+		RolloutSLABreached:      true,
+	}
+
+	cur := &rolloutsv1alpha1.DeploymentExtraStatus{ExpectedUpdatedReplicas: 3, UpdateRevision: "v2"}
+	dc.syncProgressTimes(prev, cur)
+	if !cur.RolloutStartTime.Equal(&before) || !cur.BatchStartTime.Equal(&before) || !cur.BatchSLABreached || !cur.RolloutSLABreached {
+		t.Errorf("expect progress times carried over, got %+v", cur)
+	}
+
+	cur = &rolloutsv1alpha1.DeploymentExtraStatus{ExpectedUpdatedReplicas: 5, UpdateRevision: "v2"}
+	dc.syncProgressTimes(prev, cur)
+	if !cur.RolloutStartTime.Equal(&before) || !cur.BatchStartTime.Time.Equal(now) || cur.BatchSLABreached || !cur.RolloutSLABreached {
+		t.Errorf("expect batch times reset for new batch, got %+v", cur)
+	}
+
+	cur = &rolloutsv1alpha1.DeploymentExtraStatus{ExpectedUpdatedReplicas: 5, UpdateRevision: "v3"}
+	dc.syncProgressTimes(prev, cur)
+	if !cur.RolloutStartTime.Time.Equal(now) || !cur.BatchStartTime.Time.Equal(now) || cur.BatchSLABreached || cur.RolloutSLABreached {
+		t.Errorf("expect all times reset for new revision, got %+v", cur)
+	}
+}
+
+func TestCheckProgressSLA(t *testing.T) {
+	now := time.Now()
+	defer func(batch, rollout time.Duration) { batchSLA, rolloutSLA = batch, rollout }(batchSLA, rolloutSLA)
+	batchSLA, rolloutSLA = 10*time.Minute, 30*time.Minute
+
+	cases := []struct {
+		name          string
+		batchElapsed  time.Duration
+		elapsed       time.Duration
+		readyReplicas int32
+		breached      bool
+		expectEvents  []string
+		expectRequeue bool
+	}{
+		{
+			name:          "batch in progress within SLA",
+			batchElapsed:  5 * time.Minute,
+			elapsed:       5 * time.Minute,
+			readyReplicas: 1,
+			expectRequeue: true,
+		},
+		{
+			name:          "batch in progress exceeds batch SLA",
+			batchElapsed:  15 * time.Minute,
+			elapsed:       15 * time.Minute,
+			readyReplicas: 1,
+			expectEvents:  []string{BatchSLAExceededReason},
+			expectRequeue: true,
+		},
+		{
+			name:          "batch in progress exceeds both SLA",
+			batchElapsed:  15 * time.Minute,
+			elapsed:       time.Hour,
+			readyReplicas: 1,
+			expectEvents:  []string{BatchSLAExceededReason, RolloutSLAExceededReason},
+		},
+		{
+			name:          "breached batch is reported only once",
+			batchElapsed:  15 * time.Minute,
+			elapsed:       15 * time.Minute,
+			readyReplicas: 1,
+			breached:      true,
+			expectRequeue: true,
+		},
+		{
+			name:          "finished batch is never reported",
+			batchElapsed:  time.Hour,
+			elapsed:       time.Hour,
+			readyReplicas: 3,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{})
+			dc.clock = testingclock.NewFakeClock(now)
+			batchStart := metav1.NewTime(now.Add(-cs.batchElapsed))
+			rolloutStart := metav1.NewTime(now.Add(-cs.elapsed))
+			extraStatus := &rolloutsv1alpha1.DeploymentExtraStatus{
+				UpdatedReadyReplicas:    cs.readyReplicas,
+				ExpectedUpdatedReplicas: 3,
+				UpdateRevision:          "v2",
+				RolloutStartTime:        &rolloutStart,
+				BatchStartTime:          &batchStart,
+				BatchSLABreached:        cs.breached,
+			}
+
+			breaches := testutil.ToFloat64(slaBreachTotal.WithLabelValues("batch")) + testutil.ToFloat64(slaBreachTotal.WithLabelValues("rollout"))
+			dc.checkProgressSLA(d, extraStatus)
+			events := collectEvents(recorder)
+			if len(events) != len(cs.expectEvents) {
+				t.Fatalf("expect events %v, got %v", cs.expectEvents, events)
+			}
+			for _, reason := range cs.expectEvents {
+				if !hasEvent(events, reason) {
+					t.Errorf("expect event %s, got %v", reason, events)
+				}
+			}
+			increased := testutil.ToFloat64(slaBreachTotal.WithLabelValues("batch")) + testutil.ToFloat64(slaBreachTotal.WithLabelValues("rollout")) - breaches
+			if int(increased) != len(cs.expectEvents) {
+				t.Errorf("expect breach counter increased by %d, got %v", len(cs.expectEvents), increased)
+			}
+			if requeue := dc.requeueAfter > 0; requeue != cs.expectRequeue {
+				t.Errorf("expect requeue %v, got %v", cs.expectRequeue, requeue)
+			}
+		})
+	}
+}
+
+func TestProgressSLATimer(t *testing.T) {
+	defer func(batch, rollout time.Duration) { batchSLA, rolloutSLA = batch, rollout }(batchSLA, rolloutSLA)
+	batchSLA, rolloutSLA = 10*time.Minute, 0
+
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{})
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	dc.clock = fakeClock
+
+	var prev *rolloutsv1alpha1.DeploymentExtraStatus
+	steps := []struct {
+		elapse        time.Duration
+		expectRequeue time.Duration
+		expectBreach  bool
+	}{
+		{elapse: 0, expectRequeue: 10 * time.Minute},
+		{elapse: 4 * time.Minute, expectRequeue: 6 * time.Minute},
+		{elapse: 5 * time.Minute, expectRequeue: time.Minute},
+		{elapse: time.Minute, expectBreach: true},
+		{elapse: time.Hour},
+	}
+	for i, step := range steps {
+		fakeClock.Step(step.elapse)
+		dc.requeueAfter = 0
+		cur := &rolloutsv1alpha1.DeploymentExtraStatus{UpdatedReadyReplicas: 1, ExpectedUpdatedReplicas: 3, UpdateRevision: "v2"}
+		dc.syncProgressTimes(prev, cur)
+		dc.checkProgressSLA(d, cur)
+		prev = cur
+
+		if dc.requeueAfter != step.expectRequeue {
+			t.Errorf("step %d: expect requeue after %v, got %v", i, step.expectRequeue, dc.requeueAfter)
+		}
+		if breached := hasEvent(collectEvents(recorder), BatchSLAExceededReason); breached != step.expectBreach {
+			t.Errorf("step %d: expect breach reported %v, got %v", i, step.expectBreach, breached)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+// syncBatchSoak counts the new pods which have been continuously ready for the soak duration,
+// and decides whether they are enough for the current batch. The deployment is requeued once
+// the next pod is going to be soaked if they are not enough yet.
+func (dc *DeploymentController) syncBatchSoak(d *apps.Deployment, newRS *apps.ReplicaSet, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) {
+	soak := dc.strategy.BatchSoak
+	if soak == nil || newRS == nil {
+		return
+	}
+	soaked, wait, err := dc.sampleSoakedPods(d, newRS, time.Duration(soak.Seconds)*time.Second)
+	if err != nil {
+		dc.log().Error(err, "Failed to count soaked pods", "replicaSet", klog.KObj(newRS))
+		return
+	}
+
+	percent := int32(100)
+	if soak.Percent != nil {
+		percent = *soak.Percent
+	}
+	required := (extraStatus.ExpectedUpdatedReplicas*percent + 99) / 100
+	extraStatus.SoakedReplicas = soaked
+	extraStatus.BatchSoaked = soaked >= required
+	if !extraStatus.BatchSoaked && wait > 0 {
+		dc.enqueueAfter(d, wait)
+	}
+}
+
+// sampleSoakedPods counts the soaked pods of newRS, or reuses the last sample of deployment if
+// it is taken within the sample interval and the replica set is not changed since then.
+func (dc *DeploymentController) sampleSoakedPods(d *apps.Deployment, newRS *apps.ReplicaSet, soak time.Duration) (int32, time.Duration, error) {
+	now := dc.clock.Now()
+	if sample, ok := dc.readinessSamples.get(d, newRS, soak, now); ok {
+		return sample.soaked, sample.wait, nil
+	}
+	soaked, wait, err := dc.countSoakedPods(newRS, soak)
+	if err != nil {
+		return 0, 0, err
+	}
+	dc.readinessSamples.put(d, newRS, soak, now, soaked, wait)
+	return soaked, wait, nil
+}
+
+// countSoakedPods returns the number of pods of newRS which have been ready since at least soak
+// ago, and how long to wait until the next ready pod is soaked. A pod which flaps is soaked
+// again from the last time it became ready, which is the last transition time of its condition.
+func (dc *DeploymentController) countSoakedPods(newRS *apps.ReplicaSet, soak time.Duration) (int32, time.Duration, error) {
+	selector, err := metav1.LabelSelectorAsSelector(newRS.Spec.Selector)
+	if err != nil {
+		return 0, 0, err
+	}
+	pods, err := dc.cachedPods(newRS.Namespace, selector)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := dc.clock.Now()
+	soaked, wait := int32(0), time.Duration(0)
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(pod, newRS) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		cond := util.GetPodReadyCondition(pod.Status)
+		if cond == nil || cond.Status != v1.ConditionTrue {
+			continue
+		}
+		if ready := now.Sub(cond.LastTransitionTime.Time); ready < soak {
+			if left := soak - ready; wait == 0 || left < wait {
+				wait = left
+			}
+			continue
+		}
+		soaked++
+	}
+	return soaked, wait, nil
+}
+
+// limitByBatchGates holds the new replica set at the batch in the previous extra status until it
+// is soaked, has passed the custom checks and its pause has elapsed, no matter how limit is advanced. The hold is released
+// once the generation is changed, i.e. its template or replicas is changed, the batch is not
+// comparable any more.
+func (dc *DeploymentController) limitByBatchGates(d *apps.Deployment, limit int32) int32 {
+	if dc.strategy.BatchSoak == nil && dc.strategy.BatchChecks == nil && dc.strategy.PauseSeconds <= 0 {
+		return limit
+	}
+	prev := getExtraStatus(d)
+	if prev == nil || prev.ObservedGeneration != d.Generation || prev.ExpectedUpdatedReplicas <= 0 {
+		return limit
+	}
+	soaked := dc.strategy.BatchSoak == nil || prev.BatchSoaked
+	checked := dc.strategy.BatchChecks == nil || prev.BatchChecksPassed
+	paused := dc.strategy.PauseSeconds <= 0 || prev.BatchPauseElapsed
+	if soaked && checked && paused {
+		return limit
+	}
+	return integer.Int32Min(limit, prev.ExpectedUpdatedReplicas)
+}
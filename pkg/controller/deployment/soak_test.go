@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// newTestPodsReadySince returns n ready pods of rs, which became ready at the given time.
+func newTestPodsReadySince(rs *apps.ReplicaSet, prefix string, n int, since time.Time) []runtime.Object {
+	var pods []runtime.Object
+	for i := 0; i < n; i++ {
+		pod := newTestPod(rs, fmt.Sprintf("%s-%d", prefix, i))
+		pod.Status.Conditions[0].LastTransitionTime = metav1.NewTime(since)
+		pods = append(pods, pod)
+	}
+	return pods
+}
+
+func TestSyncBatchSoak(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name          string
+		percent       *int32
+		soaked        int
+		flapped       int
+		notReady      int
+		expectSoaked  int32
+		expectHealthy bool
+		expectRequeue bool
+	}{
+		{
+			name:          "all soaked",
+			soaked:        20,
+			expectSoaked:  20,
+			expectHealthy: true,
+		},
+		{
+			name:          "one flapped recently",
+			soaked:        19,
+			flapped:       1,
+			expectSoaked:  19,
+			expectRequeue: true,
+		},
+		{
+			name:          "one flapped recently within percentile",
+			percent:       pointer.Int32(95),
+			soaked:        19,
+			flapped:       1,
+			expectSoaked:  19,
+			expectHealthy: true,
+		},
+		{
+			name:          "two flapped recently beyond percentile",
+			percent:       pointer.Int32(95),
+			soaked:        18,
+			flapped:       2,
+			expectSoaked:  18,
+			expectRequeue: true,
+		},
+		{
+			name:         "not ready pods never soak",
+			percent:      pointer.Int32(95),
+			soaked:       18,
+			notReady:     2,
+			expectSoaked: 18,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(20, intstr.FromInt(1), intstr.FromInt(0))
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 20)
+			objects := []runtime.Object{d, newRS}
+			objects = append(objects, newTestPodsReadySince(newRS, "soaked", cs.soaked, now.Add(-time.Hour))...)
+			objects = append(objects, newTestPodsReadySince(newRS, "flapped", cs.flapped, now.Add(-time.Minute))...)
+			for _, object := range newTestPodsReadySince(newRS, "not-ready", cs.notReady, now.Add(-time.Hour)) {
+				object.(*v1.Pod).Status.Conditions[0].Status = v1.ConditionFalse
+				objects = append(objects, object)
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{BatchSoak: &rolloutsv1alpha1.DeploymentBatchSoak{Seconds: 600, Percent: cs.percent}}
+			dc, _, _ := newTestController(strategy, objects...)
+			dc.clock = testingclock.NewFakeClock(now)
+
+			extraStatus := &rolloutsv1alpha1.DeploymentExtraStatus{ExpectedUpdatedReplicas: 20}
+			dc.syncBatchSoak(d, newRS, extraStatus)
+			if extraStatus.SoakedReplicas != cs.expectSoaked || extraStatus.BatchSoaked != cs.expectHealthy {
+				t.Fatalf("expect %d soaked replicas and batch soaked %v, got %+v", cs.expectSoaked, cs.expectHealthy, extraStatus)
+			}
+			if requeue := dc.requeueAfter > 0; requeue != cs.expectRequeue {
+				t.Fatalf("expect requeue %v, got %v", cs.expectRequeue, requeue)
+			}
+			if cs.expectRequeue && dc.requeueAfter != 9*time.Minute {
+				t.Fatalf("expect requeue once the flapped pods are soaked, got %v", dc.requeueAfter)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentHeldUntilBatchSoaked(t *testing.T) {
+	now := time.Now()
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 7)
+	newRS := newTestReplicaSet(d, "demo:v2", 2, 3)
+	objects := []runtime.Object{d, oldRS, newRS}
+	objects = append(objects, newTestPodsReadySince(newRS, "soaked", 2, now.Add(-time.Hour))...)
+	objects = append(objects, newTestPodsReadySince(newRS, "flapped", 1, now.Add(-time.Minute))...)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("30%"),
+		BatchSoak:     &rolloutsv1alpha1.DeploymentBatchSoak{Seconds: 600},
+	}
+	dc, client, _ := newTestController(strategy, objects...)
+	fakeClock := testingclock.NewFakeClock(now)
+	dc.clock = fakeClock
+
+	d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.SoakedReplicas != 2 || extraStatus.BatchSoaked {
+		t.Fatalf("expect batch not soaked with 2 soaked replicas, got %+v", extraStatus)
+	}
+
+	// The next batch is held even if partition is increased.
+	dc.strategy.Partition = intstr.FromString("60%")
+	for i := 0; i < 5; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	expect := map[string]int32{"demo:v1": 7, "demo:v2": 3}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+		t.Fatalf("expect replicas %v held until soaked, got %v", expect, replicas)
+	}
+	if extraStatus := getExtraStatus(d); extraStatus.ExpectedUpdatedReplicas != 3 {
+		t.Fatalf("expect the held batch exposed, got %+v", extraStatus)
+	}
+
+	// The flapped pod is soaked, so the deployment advances to the next batch.
+	fakeClock.Step(10 * time.Minute)
+	for i := 0; i < 5; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v2"] <= 3 {
+		t.Fatalf("expect the next batch to be rolled once soaked, got %v", replicas)
+	}
+	if extraStatus := getExtraStatus(d); extraStatus.ExpectedUpdatedReplicas != 6 {
+		t.Fatalf("expect the next batch exposed, got %+v", extraStatus)
+	}
+}
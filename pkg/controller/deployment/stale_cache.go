@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// isCacheStale checks whether the informer caches seem stale for the deployment, in which
+// case the deployment will be requeued after staleCacheRequeueDelay instead of being synced.
+// The caches are regarded as stale if:
+// 1. the informers have not been synced yet, or
+// 2. no replica set is found in cache, but the deployment is known to have replicas, and
+// the apiserver confirms that some replica sets of it still exist, which usually happens for a
+// short period after the apiserver restarted.
+func (dc *DeploymentController) isCacheStale(ctx context.Context, d *apps.Deployment, rsList []*apps.ReplicaSet) (bool, error) {
+	if staleCacheRequeueDelay <= 0 {
+		return false, nil
+	}
+
+	for _, synced := range []func() bool{dc.dListerSynced, dc.rsListerSynced, dc.podListerSynced} {
+		if synced != nil && !synced() {
+			dc.log().Info("Informer caches are not synced yet, defer syncing")
+			dc.enqueueAfter(d, staleCacheRequeueDelay)
+			return true, nil
+		}
+	}
+
+	if len(rsList) > 0 || d.Status.Replicas == 0 {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	liveList, err := dc.client.AppsV1().ReplicaSets(d.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return false, err
+	}
+	owned := false
+	for i := range liveList.Items {
+		if metav1.IsControlledBy(&liveList.Items[i], d) {
+			owned = true
+			break
+		}
+	}
+	if !owned {
+		return false, nil
+	}
+
+	dc.log().Info("No replica set found in cache but some exist in apiserver, defer syncing")
+	dc.enqueueAfter(d, staleCacheRequeueDelay)
+	return true, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// ScalingStalledReason is added in a deployment event when the rollout is held because some pods
+// of its new replica set have been pending for longer than pendingGraceSeconds.
+const ScalingStalledReason = "ScalingStalled"
+
+// checkStalledScaling returns true if any pod of newRS has been pending for longer than
+// pendingGraceSeconds of strategy, then the rollout is held with a warning event and requeued
+// after the grace period to check again. Otherwise the deployment is requeued once the next
+// pending pod would exceed the grace period.
+func (dc *DeploymentController) checkStalledScaling(d *apps.Deployment, newRS *apps.ReplicaSet) (bool, error) {
+	if dc.strategy.PendingGraceSeconds <= 0 || newRS == nil {
+		return false, nil
+	}
+	grace := time.Duration(dc.strategy.PendingGraceSeconds) * time.Second
+	count, wait, example, err := dc.countStalledPods(newRS, grace)
+	if err != nil {
+		return false, err
+	}
+	if count == 0 {
+		if wait > 0 {
+			dc.enqueueAfter(d, wait)
+		}
+		return false, nil
+	}
+	dc.log().Info("Hold the rollout since new pods have been pending too long", "replicaSet", klog.KObj(newRS), "count", count, "grace", grace)
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, ScalingStalledReason,
+		"Rollout is held since %d pods of new replica set %s have been pending for longer than %v, e.g. pod %s", count, newRS.Name, grace, example)
+	dc.enqueueAfter(d, grace)
+	return true, nil
+}
+
+// countStalledPods returns the number of pods of newRS which have been pending since at least
+// grace ago, how long to wait until the next pending pod exceeds the grace period, and the name
+// of one of them for the event.
+func (dc *DeploymentController) countStalledPods(newRS *apps.ReplicaSet, grace time.Duration) (int32, time.Duration, string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(newRS.Spec.Selector)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	pods, err := dc.cachedPods(newRS.Namespace, selector)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	now := dc.clock.Now()
+	count, wait, example := int32(0), time.Duration(0), ""
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(pod, newRS) || pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodPending {
+			continue
+		}
+		if pending := now.Sub(pod.CreationTimestamp.Time); pending < grace {
+			if left := grace - pending; wait == 0 || left < wait {
+				wait = left
+			}
+			continue
+		}
+		if count == 0 {
+			example = pod.Name
+		}
+		count++
+	}
+	return count, wait, example, nil
+}
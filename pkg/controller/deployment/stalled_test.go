@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentHeldByStalledScaling(t *testing.T) {
+	cases := []struct {
+		name          string
+		graceSeconds  int32
+		running       bool
+		expect        map[string]int32
+		expectEvent   bool
+		expectRequeue time.Duration
+	}{
+		{
+			name:   "not held by default",
+			expect: map[string]int32{"demo:v1": 8, "demo:v2": 2},
+		},
+		{
+			name:          "pending within grace",
+			graceSeconds:  900,
+			expect:        map[string]int32{"demo:v1": 8, "demo:v2": 2},
+			expectRequeue: 5 * time.Minute,
+		},
+		{
+			name:          "pending beyond grace",
+			graceSeconds:  300,
+			expect:        map[string]int32{"demo:v1": 10, "demo:v2": 2},
+			expectEvent:   true,
+			expectRequeue: 5 * time.Minute,
+		},
+		{
+			name:         "running but not ready",
+			graceSeconds: 300,
+			running:      true,
+			expect:       map[string]int32{"demo:v1": 8, "demo:v2": 2},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			now := time.Now()
+			d := newTestDeployment(10, intstr.FromInt(2), intstr.FromInt(2))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 2)
+			newRS.Status.ReadyReplicas, newRS.Status.AvailableReplicas = 0, 0
+			objects := []runtime.Object{d, oldRS, newRS}
+			for i := 0; i < 2; i++ {
+				pod := newTestPod(newRS, fmt.Sprintf("pending-%d", i))
+				pod.CreationTimestamp = metav1.NewTime(now.Add(-10 * time.Minute))
+				pod.Status = v1.PodStatus{Phase: v1.PodPending}
+				if cs.running {
+					pod.Status.Phase = v1.PodRunning
+				}
+				objects = append(objects, pod)
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:        rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:       d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:           intstr.FromString("50%"),
+				PendingGraceSeconds: cs.graceSeconds,
+			}
+			dc, client, recorder := newTestController(strategy, objects...)
+			dc.clock.(*testingclock.FakeClock).SetTime(now)
+
+			if err := dc.syncDeployment(context.TODO(), d); err != nil {
+				t.Fatalf("failed to sync deployment: %v", err)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expect) {
+				t.Fatalf("expect replicas %v, got %v", cs.expect, replicas)
+			}
+			events := collectEvents(recorder)
+			if stalled := hasEvent(events, ScalingStalledReason); stalled != cs.expectEvent {
+				t.Fatalf("expect %s event %v, got %v", ScalingStalledReason, cs.expectEvent, events)
+			}
+			if cs.expectRequeue > 0 && (dc.requeueAfter <= 0 || dc.requeueAfter > cs.expectRequeue) {
+				t.Fatalf("expect requeue within %v, got %v", cs.expectRequeue, dc.requeueAfter)
+			}
+		})
+	}
+}
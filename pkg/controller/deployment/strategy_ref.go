@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// StrategyRefIgnoredReason is added in a deployment event when both the inline strategy annotation
+// and the strategy ref annotation are set, then the ref is ignored.
+const StrategyRefIgnoredReason = "StrategyRefIgnored"
+
+// strategyRefCache caches the ConfigMaps referred to by the strategy ref annotation of deployments.
+// A cached ConfigMap is read again from the watch cache of apiserver, which is at least as new as
+// the cached resourceVersion and never hits etcd, and it is only replaced once its resourceVersion
+// changes. A nil strategyRefCache reads the ConfigMaps from etcd on every sync.
+type strategyRefCache struct {
+	lock       sync.Mutex
+	configMaps map[types.NamespacedName]*v1.ConfigMap
+}
+
+func newStrategyRefCache() *strategyRefCache {
+	return &strategyRefCache{configMaps: map[types.NamespacedName]*v1.ConfigMap{}}
+}
+
+// get returns the ConfigMap of key, which is read via getter with the options for the cached
+// resourceVersion.
+func (c *strategyRefCache) get(key types.NamespacedName, getter func(options metav1.GetOptions) (*v1.ConfigMap, error)) (*v1.ConfigMap, error) {
+	if c == nil {
+		return getter(metav1.GetOptions{})
+	}
+	c.lock.Lock()
+	cached := c.configMaps[key]
+	c.lock.Unlock()
+
+	options := metav1.GetOptions{}
+	if cached != nil {
+		options.ResourceVersion = cached.ResourceVersion
+	}
+	cm, err := getter(options)
+	if err != nil {
+		// The ConfigMap may have been deleted, which is read again next time.
+		c.evict(key)
+		return nil, err
+	}
+	if cached != nil && cached.ResourceVersion == cm.ResourceVersion {
+		return cached, nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.configMaps[key] = cm
+	return cm, nil
+}
+
+// evict removes the cached ConfigMap of key, e.g. once it is deleted.
+func (c *strategyRefCache) evict(key types.NamespacedName) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.configMaps, key)
+}
+
+// parseStrategyRef parses the strategy ref annotation in the format of "<configmap>/<key>".
+func parseStrategyRef(ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("strategy ref %q is not in the format of <configmap>/<key>", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// deploymentsOfStrategyRef returns a MapFunc which maps a ConfigMap to the deployments under our
+// control whose strategy ref annotation refers to it, so that they are synced once it changes.
+func deploymentsOfStrategyRef(reader client.Reader) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		deploymentList := &apps.DeploymentList{}
+		if err := reader.List(context.TODO(), deploymentList, client.InNamespace(obj.GetNamespace())); err != nil {
+			klog.Errorf("Failed to list deployments in namespace %s: %v", obj.GetNamespace(), err)
+			return nil
+		}
+		var requests []reconcile.Request
+		for i := range deploymentList.Items {
+			d := &deploymentList.Items[i]
+			if !deploymentutil.IsUnderRolloutControl(d) {
+				continue
+			}
+			name, _, err := parseStrategyRef(d.Annotations[rolloutsv1alpha1.DeploymentStrategyRefAnnotation])
+			if err == nil && name == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name}})
+			}
+		}
+		return requests
+	}
+}
+
+// strategyAnnotation returns the strategy JSON of deployment, which is the inline strategy annotation,
+// or read from the ConfigMap referred to by the strategy ref annotation if the former is not set. A
+// warning event is emitted once both of them are set, since the ref is ignored then.
+func (f *controllerFactory) strategyAnnotation(deployment *apps.Deployment) (string, error) {
+	strategyAnno := deployment.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation]
+	ref := deployment.Annotations[rolloutsv1alpha1.DeploymentStrategyRefAnnotation]
+	ignored := ref != "" && strategyAnno != ""
+	if f.ignoredRefs.observe(client.ObjectKeyFromObject(deployment), deployment.UID, ignored) {
+		klog.Warningf("Deployment %v has both annotation %s and %s, the latter is ignored", klog.KObj(deployment),
+			rolloutsv1alpha1.DeploymentStrategyAnnotation, rolloutsv1alpha1.DeploymentStrategyRefAnnotation)
+		f.eventRecorder.Eventf(deployment, v1.EventTypeWarning, StrategyRefIgnoredReason,
+			"Strategy ref %q is ignored since annotation %s is set", ref, rolloutsv1alpha1.DeploymentStrategyAnnotation)
+	}
+	if ref == "" || ignored {
+		return strategyAnno, nil
+	}
+
+	name, key, err := parseStrategyRef(ref)
+	if err != nil {
+		return "", err
+	}
+	cm, err := f.strategyRefs.get(types.NamespacedName{Namespace: deployment.Namespace, Name: name}, func(options metav1.GetOptions) (*v1.ConfigMap, error) {
+		return f.client.CoreV1().ConfigMaps(deployment.Namespace).Get(context.TODO(), name, options)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get ConfigMap of strategy ref %q: %v", ref, err)
+	}
+	strategy, ok := cm.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q of strategy ref %q is not found in ConfigMap", key, ref)
+	}
+	return strategy, nil
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+func newTestStrategyConfigMap(resourceVersion, strategy string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "strategies", Namespace: "default", ResourceVersion: resourceVersion},
+		Data:       map[string]string{"demo": strategy},
+	}
+}
+
+func TestNewControllerWithStrategyRef(t *testing.T) {
+	cases := []struct {
+		name            string
+		inline          string
+		ref             string
+		expectPartition intstr.IntOrString
+		expectError     bool
+		expectIgnored   bool
+	}{
+		{
+			name:            "inline strategy",
+			inline:          `{"rollingStyle":"Partition","partition":"20%"}`,
+			expectPartition: intstr.FromString("20%"),
+		},
+		{
+			name:            "strategy ref",
+			ref:             "strategies/demo",
+			expectPartition: intstr.FromString("50%"),
+		},
+		{
+			name:            "inline strategy wins over ref",
+			inline:          `{"rollingStyle":"Partition","partition":"20%"}`,
+			ref:             "strategies/demo",
+			expectPartition: intstr.FromString("20%"),
+			expectIgnored:   true,
+		},
+		{
+			name:        "malformed ref",
+			ref:         "strategies",
+			expectError: true,
+		},
+		{
+			name:        "missing ConfigMap",
+			ref:         "missing/demo",
+			expectError: true,
+		},
+		{
+			name:        "missing key",
+			ref:         "strategies/missing",
+			expectError: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestStrategyRefDeployment(cs.inline, cs.ref)
+			cm := newTestStrategyConfigMap("1", `{"rollingStyle":"Partition","partition":"50%"}`)
+			dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, cm)
+			dc.strategyRefs = newStrategyRefCache()
+
+			controller, err := (*controllerFactory)(dc).NewController(d)
+			events := collectEvents(recorder)
+			if cs.expectError {
+				if err == nil || controller != nil {
+					t.Fatalf("expect error for strategy ref, got controller %v", controller)
+				}
+				if !hasEvent(events, InvalidStrategyAnnotationReason) {
+					t.Fatalf("expect %s event, got %v", InvalidStrategyAnnotationReason, events)
+				}
+				return
+			}
+			if err != nil || controller == nil {
+				t.Fatalf("expect controller, got error %v", err)
+			}
+			if controller.strategy.Partition != cs.expectPartition {
+				t.Fatalf("expect partition %v, got %v", cs.expectPartition.String(), controller.strategy.Partition.String())
+			}
+			if ignored := hasEvent(events, StrategyRefIgnoredReason); ignored != cs.expectIgnored {
+				t.Fatalf("expect %s event %v, got %v", StrategyRefIgnoredReason, cs.expectIgnored, events)
+			}
+		})
+	}
+}
+
+func TestStrategyRefCacheInvalidatedByResourceVersion(t *testing.T) {
+	d := newTestStrategyRefDeployment("", "strategies/demo")
+	cm := newTestStrategyConfigMap("1", `{"rollingStyle":"Partition","partition":"50%"}`)
+	dc, client, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, cm)
+	dc.strategyRefs = newStrategyRefCache()
+	factory := (*controllerFactory)(dc)
+
+	expectPartition := func(expect string) {
+		t.Helper()
+		controller, err := factory.NewController(d)
+		if err != nil || controller == nil {
+			t.Fatalf("expect controller, got error %v", err)
+		}
+		if got := controller.strategy.Partition.String(); got != expect {
+			t.Fatalf("expect partition %s, got %s", expect, got)
+		}
+	}
+	updateConfigMap := func(cm *v1.ConfigMap) {
+		t.Helper()
+		if _, err := client.CoreV1().ConfigMaps(cm.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update ConfigMap: %v", err)
+		}
+	}
+
+	expectPartition("50%")
+	// The cached ConfigMap is kept while its resourceVersion is unchanged.
+	updateConfigMap(newTestStrategyConfigMap("1", `{"rollingStyle":"Partition","partition":"80%"}`))
+	expectPartition("50%")
+	updateConfigMap(newTestStrategyConfigMap("2", `{"rollingStyle":"Partition","partition":"80%"}`))
+	expectPartition("80%")
+}
+
+func TestStrategyRefCacheEvictedOnError(t *testing.T) {
+	d := newTestStrategyRefDeployment("", "strategies/demo")
+	cm := newTestStrategyConfigMap("1", `{"rollingStyle":"Partition","partition":"50%"}`)
+	dc, client, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d, cm)
+	dc.strategyRefs = newStrategyRefCache()
+	factory := (*controllerFactory)(dc)
+
+	if controller, err := factory.NewController(d); err != nil || controller == nil {
+		t.Fatalf("expect controller, got error %v", err)
+	}
+	if len(dc.strategyRefs.configMaps) != 1 {
+		t.Fatalf("expect the ConfigMap cached, got %v", dc.strategyRefs.configMaps)
+	}
+	if err := client.CoreV1().ConfigMaps(cm.Namespace).Delete(context.TODO(), cm.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete ConfigMap: %v", err)
+	}
+	if controller, err := factory.NewController(d); err == nil || controller != nil {
+		t.Fatalf("expect error once the ConfigMap is deleted, got controller %v", controller)
+	}
+	if len(dc.strategyRefs.configMaps) != 0 {
+		t.Fatalf("expect the deleted ConfigMap evicted, got %v", dc.strategyRefs.configMaps)
+	}
+}
+
+func TestStrategyRefIgnoredEventOnce(t *testing.T) {
+	d := newTestStrategyRefDeployment(`{"rollingStyle":"Partition","partition":"20%"}`, "strategies/demo")
+	dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, d)
+	dc.ignoredRefs = newUIDTracker()
+	factory := (*controllerFactory)(dc)
+
+	expectIgnoredEvent := func(step string, expect bool) {
+		t.Helper()
+		if controller, err := factory.NewController(d); err != nil || controller == nil {
+			t.Fatalf("%s: expect controller, got error %v", step, err)
+		}
+		if ignored := hasEvent(collectEvents(recorder), StrategyRefIgnoredReason); ignored != expect {
+			t.Fatalf("%s: expect %s event %v, got %v", step, StrategyRefIgnoredReason, expect, ignored)
+		}
+	}
+	expectIgnoredEvent("first ignored", true)
+	expectIgnoredEvent("still ignored", false)
+
+	// The event is emitted again once the ref is ignored again.
+	delete(d.Annotations, rolloutsv1alpha1.DeploymentStrategyRefAnnotation)
+	expectIgnoredEvent("ref removed", false)
+	d.Annotations[rolloutsv1alpha1.DeploymentStrategyRefAnnotation] = "strategies/demo"
+	expectIgnoredEvent("ignored again", true)
+}
+
+func TestDeploymentsOfStrategyRef(t *testing.T) {
+	referring := newTestStrategyRefDeployment("", "strategies/demo")
+	otherRef := newTestStrategyRefDeployment("", "others/demo")
+	otherRef.Name = "other-ref"
+	uncontrolled := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	uncontrolled.Name = "uncontrolled"
+	uncontrolled.Annotations[rolloutsv1alpha1.DeploymentStrategyRefAnnotation] = "strategies/demo"
+	otherNamespace := referring.DeepCopy()
+	otherNamespace.Namespace = "other"
+
+	reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(referring, otherRef, uncontrolled, otherNamespace).Build()
+	requests := deploymentsOfStrategyRef(reader)(newTestStrategyConfigMap("1", ""))
+	if len(requests) != 1 || requests[0].Namespace != referring.Namespace || requests[0].Name != referring.Name {
+		t.Fatalf("expect only the controlled deployment referring to the ConfigMap enqueued, got %v", requests)
+	}
+}
+
+func newTestStrategyRefDeployment(inline, ref string) *apps.Deployment {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+	if inline != "" {
+		d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = inline
+	}
+	if ref != "" {
+		d.Annotations[rolloutsv1alpha1.DeploymentStrategyRefAnnotation] = ref
+	}
+	d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+	d.Spec.Paused = true
+	return d
+}
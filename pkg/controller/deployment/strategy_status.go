@@ -0,0 +1,54 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+
+	apps "k8s.io/api/apps/v1"
+	intstrutil "k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// strategyStatus returns the effective strategy of d in the JSON of DeploymentStrategyStatus, which
+// is written to the strategy status annotation together with the extra status. The fields are in the
+// order of the struct, so the same strategy always results in the same annotation.
+func (dc *DeploymentController) strategyStatus(d *apps.Deployment) string {
+	promoted := dc.isPromoted(d)
+	partition := dc.strategy.Partition
+	if promoted {
+		partition = intstrutil.FromString("100%")
+	}
+	status := rolloutsv1alpha1.DeploymentStrategyStatus{
+		RollingStyle:      dc.strategy.RollingStyle,
+		Partition:         dc.strategy.Partition.String(),
+		PartitionReplicas: dc.partitionReplicasLimit(partition, d),
+		Promoted:          promoted,
+		Paused:            dc.isPaused(d),
+	}
+	if rollingUpdate := dc.strategy.RollingUpdate; rollingUpdate != nil {
+		if rollingUpdate.MaxSurge != nil {
+			status.MaxSurge = rollingUpdate.MaxSurge.String()
+		}
+		if rollingUpdate.MaxUnavailable != nil {
+			status.MaxUnavailable = rollingUpdate.MaxUnavailable.String()
+		}
+	}
+	data, _ := json.Marshal(&status)
+	return string(data)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentWritesStrategyStatus(t *testing.T) {
+	cases := []struct {
+		name     string
+		promoted bool
+		expect   rolloutsv1alpha1.DeploymentStrategyStatus
+	}{
+		{
+			name: "rolling to partition",
+			expect: rolloutsv1alpha1.DeploymentStrategyStatus{
+				RollingStyle:      rolloutsv1alpha1.PartitionRollingStyleType,
+				Partition:         "30%",
+				PartitionReplicas: 3,
+				MaxSurge:          "1",
+				MaxUnavailable:    "0",
+			},
+		},
+		{
+			name:     "promoted",
+			promoted: true,
+			expect: rolloutsv1alpha1.DeploymentStrategyStatus{
+				RollingStyle:      rolloutsv1alpha1.PartitionRollingStyleType,
+				Partition:         "30%",
+				PartitionReplicas: 10,
+				Promoted:          true,
+				MaxSurge:          "1",
+				MaxUnavailable:    "0",
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			if cs.promoted {
+				d.Annotations[rolloutsv1alpha1.DeploymentPromoteAnnotation] = "true"
+			}
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("30%"),
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+			d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+
+			status := rolloutsv1alpha1.DeploymentStrategyStatus{}
+			if err := json.Unmarshal([]byte(d.Annotations[rolloutsv1alpha1.DeploymentStrategyStatusAnnotation]), &status); err != nil {
+				t.Fatalf("failed to unmarshal strategy status %q: %v", d.Annotations[rolloutsv1alpha1.DeploymentStrategyStatusAnnotation], err)
+			}
+			if !reflect.DeepEqual(status, cs.expect) {
+				t.Fatalf("expect strategy status %+v, got %+v", cs.expect, status)
+			}
+		})
+	}
+}
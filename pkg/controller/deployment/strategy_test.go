@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+// newTestNativeDeployment returns a deployment under rollout control, whose native strategy is
+// Recreate and paused, just like what the batch release leaves it, with strategy annotated.
+func newTestNativeDeployment(strategy string) *apps.Deployment {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	d.Annotations[util.BatchReleaseControlAnnotation] = `{"name":"demo"}`
+	d.Annotations[rolloutsv1alpha1.DeploymentStrategyAnnotation] = strategy
+	d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+	d.Spec.Paused = true
+	return d
+}
+
+func TestNewControllerDefaultsStrategy(t *testing.T) {
+	d := newTestNativeDeployment(`{"rollingStyle":"Partition","partition":"30%"}`)
+	dc, err := (&controllerFactory{}).NewController(d)
+	if err != nil || dc == nil {
+		t.Fatalf("expect controller for deployment under rollout control, got %v", err)
+	}
+	maxSurge, maxUnavailable := intstr.FromString("25%"), intstr.FromString("25%")
+	expect := &apps.RollingUpdateDeployment{MaxSurge: &maxSurge, MaxUnavailable: &maxUnavailable}
+	if rollingUpdate := dc.strategy.RollingUpdate; rollingUpdate == nil ||
+		*rollingUpdate.MaxSurge != *expect.MaxSurge || *rollingUpdate.MaxUnavailable != *expect.MaxUnavailable {
+		t.Fatalf("expect rolling update defaulted to %+v, got %+v", expect, rollingUpdate)
+	}
+}
+
+func TestSyncDeploymentReplacesNativeStrategy(t *testing.T) {
+	d := newTestNativeDeployment(`{"rollingStyle":"Partition","partition":"30%"}`)
+	// Leave the native spec.paused aside, which is covered by TestSyncDeploymentIgnoresNativePaused.
+	d.Spec.Paused = false
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+	maxSurge, maxUnavailable := intstr.FromInt(1), intstr.FromInt(0)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: &apps.RollingUpdateDeployment{MaxSurge: &maxSurge, MaxUnavailable: &maxUnavailable},
+		Partition:     intstr.FromString("30%"),
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	// The native Recreate would scale the new replica set to partition at once, while maxSurge of
+	// our strategy only allows one more replica at a time.
+	for i := 0; i < 20; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		replicas := getReplicaSetReplicas(t, client, d.Namespace)
+		if total := replicas["demo:v1"] + replicas["demo:v2"]; total > 11 {
+			t.Fatalf("expect to surge by one replica at most, got %v", replicas)
+		}
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 7 || replicas["demo:v2"] != 3 {
+		t.Fatalf("expect to roll by our strategy to 7/3, got %v", replicas)
+	}
+}
+
+func TestSyncDeploymentIgnoresNativePaused(t *testing.T) {
+	cases := []struct {
+		name           string
+		paused         bool
+		expectReplicas map[string]int32
+		expectReason   string
+	}{
+		{
+			name:           "rolling while natively paused",
+			expectReplicas: map[string]int32{"demo:v1": 7, "demo:v2": 3},
+		},
+		{
+			name:           "paused by strategy",
+			paused:         true,
+			expectReplicas: map[string]int32{"demo:v1": 10},
+			expectReason:   deploymentutil.PausedDeployReason,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestNativeDeployment(`{"rollingStyle":"Partition","partition":"30%"}`)
+			d.Spec.ProgressDeadlineSeconds = pointer.Int32(600)
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			maxSurge, maxUnavailable := intstr.FromInt(1), intstr.FromInt(0)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: &apps.RollingUpdateDeployment{MaxSurge: &maxSurge, MaxUnavailable: &maxUnavailable},
+				Partition:     intstr.FromString("30%"),
+				Paused:        cs.paused,
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS)
+
+			for i := 0; i < 20; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expectReplicas) {
+				t.Fatalf("expect replicas %v, got %v", cs.expectReplicas, replicas)
+			}
+			reason := ""
+			if cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing); cond != nil &&
+				(cond.Reason == deploymentutil.PausedDeployReason || cond.Reason == deploymentutil.ResumedDeployReason) {
+				reason = cond.Reason
+			}
+			if reason != cs.expectReason {
+				t.Fatalf("expect paused reason %q, got %q", cs.expectReason, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// rolloutsReadyPath is the path of the endpoint reporting whether any rollout is stuck beyond
+// its SLA, which is served along with the metrics.
+const rolloutsReadyPath = "/rollouts/readyz"
+
+// stuckRolloutsHandler responds 503 if any deployment under rollout control has been stuck
+// beyond --deployment-batch-sla or --deployment-rollout-sla, or 200 otherwise. It only reads
+// the extra status of deployments from the informer's store.
+type stuckRolloutsHandler struct {
+	dLister appslisters.DeploymentLister
+	clock   clock.PassiveClock
+}
+
+func (h *stuckRolloutsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deployments, err := h.dLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list deployments for stuck rollouts: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := h.clock.Now()
+	var stuck []string
+	for _, d := range deployments {
+		if !deploymentutil.IsUnderRolloutControl(d) {
+			continue
+		}
+		if reason := stuckReason(getExtraStatus(d), now); reason != "" {
+			stuck = append(stuck, fmt.Sprintf("%s/%s: %s", d.Namespace, d.Name, reason))
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if len(stuck) == 0 {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	sort.Strings(stuck)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintln(w, strings.Join(stuck, "\n"))
+}
+
+// stuckReason returns why the rollout is stuck beyond its SLA, or empty if it is not. As
+// checkProgressSLA, a batch which is finished is never stuck, no matter how long it is held.
+func stuckReason(extraStatus *rolloutsv1alpha1.DeploymentExtraStatus, now time.Time) string {
+	if extraStatus == nil || !isBatchInProgress(extraStatus) {
+		return ""
+	}
+	if extraStatus.BatchSLABreached || batchSLA > 0 && extraStatus.BatchStartTime != nil && now.Sub(extraStatus.BatchStartTime.Time) >= batchSLA {
+		return fmt.Sprintf("batch with %d expected updated replicas exceeded SLA", extraStatus.ExpectedUpdatedReplicas)
+	}
+	if extraStatus.RolloutSLABreached || rolloutSLA > 0 && extraStatus.RolloutStartTime != nil && now.Sub(extraStatus.RolloutStartTime.Time) >= rolloutSLA {
+		return fmt.Sprintf("rollout to revision %s exceeded SLA", extraStatus.UpdateRevision)
+	}
+	return ""
+}
+
+// isBatchInProgress returns true if the current batch is still waiting for updated pods.
+func isBatchInProgress(extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) bool {
+	return extraStatus.UpdateRevision != "" && extraStatus.UpdatedReadyReplicas < extraStatus.ExpectedUpdatedReplicas
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+// newTestControlledDeployment returns a deployment under rollout control with the extra status.
+func newTestControlledDeployment(name string, controlled bool, extraStatus *rolloutsv1alpha1.DeploymentExtraStatus) *apps.Deployment {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	d.Name = name
+	if controlled {
+		d.Annotations[util.BatchReleaseControlAnnotation] = "control-info"
+	}
+	d.Spec.Strategy = apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType}
+	d.Spec.Paused = true
+	extraStatusByte, _ := json.Marshal(extraStatus)
+	d.Annotations[rolloutsv1alpha1.DeploymentExtraStatusAnnotation] = string(extraStatusByte)
+	return d
+}
+
+func TestStuckRolloutsHandler(t *testing.T) {
+	defer func(batch, rollout time.Duration) { batchSLA, rolloutSLA = batch, rollout }(batchSLA, rolloutSLA)
+	batchSLA, rolloutSLA = 10*time.Minute, time.Hour
+
+	now := time.Now()
+	since := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(-d))
+		return &t
+	}
+	progressing := func(batchAge, rolloutAge time.Duration, ready int32) *rolloutsv1alpha1.DeploymentExtraStatus {
+		return &rolloutsv1alpha1.DeploymentExtraStatus{
+			UpdateRevision:          "demo-v2",
+			ExpectedUpdatedReplicas: 3,
+			UpdatedReadyReplicas:    ready,
+			BatchStartTime:          since(batchAge),
+			RolloutStartTime:        since(rolloutAge),
+		}
+	}
+	cases := []struct {
+		name        string
+		deployments []runtime.Object
+		expectCode  int
+		expectBody  string
+	}{
+		{
+			name: "no rollout stuck",
+			deployments: []runtime.Object{
+				newTestControlledDeployment("progressing", true, progressing(time.Minute, time.Minute, 1)),
+				newTestControlledDeployment("held", true, progressing(time.Hour, 2*time.Hour, 3)),
+			},
+			expectCode: http.StatusOK,
+			expectBody: "ok",
+		},
+		{
+			name: "batch stuck",
+			deployments: []runtime.Object{
+				newTestControlledDeployment("progressing", true, progressing(time.Minute, time.Minute, 1)),
+				newTestControlledDeployment("stuck", true, progressing(11*time.Minute, 11*time.Minute, 1)),
+			},
+			expectCode: http.StatusServiceUnavailable,
+			expectBody: "default/stuck: batch with 3 expected updated replicas exceeded SLA",
+		},
+		{
+			name: "rollout stuck",
+			deployments: []runtime.Object{
+				newTestControlledDeployment("stuck", true, progressing(time.Minute, 2*time.Hour, 1)),
+			},
+			expectCode: http.StatusServiceUnavailable,
+			expectBody: "default/stuck: rollout to revision demo-v2 exceeded SLA",
+		},
+		{
+			name: "stuck deployment not under rollout control",
+			deployments: []runtime.Object{
+				newTestControlledDeployment("released", false, progressing(time.Hour, 2*time.Hour, 1)),
+			},
+			expectCode: http.StatusOK,
+			expectBody: "ok",
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, cs.deployments...)
+			h := &stuckRolloutsHandler{dLister: dc.dLister, clock: testingclock.NewFakeClock(now)}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, rolloutsReadyPath, nil))
+			if w.Code != cs.expectCode {
+				t.Fatalf("expect code %d, got %d: %s", cs.expectCode, w.Code, w.Body.String())
+			}
+			if body := strings.TrimSpace(w.Body.String()); body != cs.expectBody {
+				t.Fatalf("expect body %q, got %q", cs.expectBody, body)
+			}
+		})
+	}
+
+	t.Run("read only", func(t *testing.T) {
+		dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{})
+		h := &stuckRolloutsHandler{dLister: dc.dLister, clock: testingclock.NewFakeClock(now)}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, rolloutsReadyPath, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("expect code %d, got %d", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// MaxSurgeCappedReason is added in a deployment event when the new replica set would be scaled up
+// further by maxSurge, but is held by maxSurgeReplicas of strategy.
+const MaxSurgeCappedReason = "MaxSurgeCapped"
+
+// capSurge sets maxSurge of d to maxSurgeReplicas of strategy if it would surge more pods, so that
+// all the scaling of d is clamped by the cap.
+func (dc *DeploymentController) capSurge(d *apps.Deployment) {
+	if dc.strategy.MaxSurgeReplicas == nil || d.Spec.Strategy.RollingUpdate == nil {
+		return
+	}
+	if deploymentutil.MaxSurge(*d) <= *dc.strategy.MaxSurgeReplicas {
+		return
+	}
+	maxSurge := intstr.FromInt(int(*dc.strategy.MaxSurgeReplicas))
+	d.Spec.Strategy.RollingUpdate.MaxSurge = &maxSurge
+}
+
+// uncappedMaxSurge returns how many pods d would surge without maxSurgeReplicas of strategy.
+func (dc *DeploymentController) uncappedMaxSurge(d *apps.Deployment) int32 {
+	uncapped := *d
+	uncapped.Spec.Strategy = apps.DeploymentStrategy{
+		Type:          apps.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: dc.strategy.RollingUpdate.DeepCopy(),
+	}
+	dc.requireSurge(&uncapped)
+	return deploymentutil.MaxSurge(uncapped)
+}
+
+// recordSurgeCapped emits an event if the new replica set is scaled up to newReplicas, short of
+// replicasLimit, only because the surge is clamped by maxSurgeReplicas of strategy.
+func (dc *DeploymentController) recordSurgeCapped(d *apps.Deployment, allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, newReplicas, replicasLimit int32) {
+	if dc.strategy.MaxSurgeReplicas == nil || newReplicas >= replicasLimit {
+		return
+	}
+	maxSurge, uncapped := deploymentutil.MaxSurge(*d), dc.uncappedMaxSurge(d)
+	if uncapped <= maxSurge {
+		return
+	}
+	total := deploymentutil.GetReplicaCountForReplicaSets(allRSs) - *(newRS.Spec.Replicas) + newReplicas
+	if total < *(d.Spec.Replicas)+maxSurge {
+		return
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeNormal, MaxSurgeCappedReason,
+		"Surge of new replica set %s is capped at %d pods by maxSurgeReplicas instead of %d by maxSurge", newRS.Name, maxSurge, uncapped)
+}
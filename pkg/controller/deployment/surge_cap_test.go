@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncDeploymentCapsSurge(t *testing.T) {
+	cases := []struct {
+		name         string
+		cap          *int32
+		expectNew    int32
+		expectCapped bool
+	}{
+		{
+			name:      "surge by percentage",
+			expectNew: 50,
+		},
+		{
+			name:         "surge capped",
+			cap:          pointer.Int32(10),
+			expectNew:    10,
+			expectCapped: true,
+		},
+		{
+			name:      "cap above surge",
+			cap:       pointer.Int32(80),
+			expectNew: 50,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(100, intstr.FromString("50%"), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 100)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:     rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:    d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				MaxSurgeReplicas: cs.cap,
+				Partition:        intstr.FromString("60%"),
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS)
+
+			// Only the first scale up is checked, before any old pod is scaled down.
+			syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			replicas := getReplicaSetReplicas(t, client, d.Namespace)
+			if replicas["demo:v1"] != 100 || replicas["demo:v2"] != cs.expectNew {
+				t.Fatalf("expect replicas 100/%d, got %v", cs.expectNew, replicas)
+			}
+			if capped := hasEvent(collectEvents(recorder), MaxSurgeCappedReason); capped != cs.expectCapped {
+				t.Fatalf("expect %s event %v, got %v", MaxSurgeCappedReason, cs.expectCapped, capped)
+			}
+		})
+	}
+}
@@ -22,15 +22,15 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"time"
 
 	apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/klog/v2"
 
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
 	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
-	"github.com/openkruise/rollouts/pkg/util"
 	labelsutil "github.com/openkruise/rollouts/pkg/util/labels"
 )
 
@@ -52,7 +52,11 @@ func (dc *DeploymentController) sync(ctx context.Context, d *apps.Deployment, rs
 	if err != nil {
 		return err
 	}
-	if err := dc.scale(ctx, d, newRS, oldRSs); err != nil {
+	scale := dc.scale
+	if scaleByFrozenRatio && dc.isPaused(d) {
+		scale = dc.scaleWithFrozenRatio
+	}
+	if err := scale(ctx, d, newRS, oldRSs); err != nil {
 		// If we get an error while trying to scale, the deployment will be requeued
 		// so we can abort this resync
 		return err
@@ -65,7 +69,7 @@ func (dc *DeploymentController) sync(ctx context.Context, d *apps.Deployment, rs
 // checkPausedConditions checks if the given deployment is paused or not and adds an appropriate condition.
 // These conditions are needed so that we won't accidentally report lack of progress for resumed deployments
 // that were paused for longer than progressDeadlineSeconds.
-func (dc *DeploymentController) checkPausedConditions(ctx context.Context, d *apps.Deployment) error {
+func (dc *DeploymentController) checkPausedConditions(ctx context.Context, d *apps.Deployment, paused bool) error {
 	if !deploymentutil.HasProgressDeadline(d) {
 		return nil
 	}
@@ -77,12 +81,12 @@ func (dc *DeploymentController) checkPausedConditions(ctx context.Context, d *ap
 	pausedCondExists := cond != nil && cond.Reason == deploymentutil.PausedDeployReason
 
 	needsUpdate := false
-	if d.Spec.Paused && !pausedCondExists {
-		condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionUnknown, deploymentutil.PausedDeployReason, "Deployment is paused")
+	if paused && !pausedCondExists {
+		condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionUnknown, deploymentutil.PausedDeployReason, "Deployment is paused", dc.clock.Now())
 		deploymentutil.SetDeploymentCondition(&d.Status, *condition)
 		needsUpdate = true
-	} else if !d.Spec.Paused && pausedCondExists {
-		condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionUnknown, deploymentutil.ResumedDeployReason, "Deployment is resumed")
+	} else if !paused && pausedCondExists {
+		condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionUnknown, deploymentutil.ResumedDeployReason, "Deployment is resumed", dc.clock.Now())
 		deploymentutil.SetDeploymentCondition(&d.Status, *condition)
 		needsUpdate = true
 	}
@@ -144,12 +148,21 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 	if existingNewRS != nil {
 		rsCopy := existingNewRS.DeepCopy()
 
+		// The template is rolled back to an old replica set, if its revision is not the latest one.
+		existingRevision, err := deploymentutil.Revision(existingNewRS)
+		rolledBack := err == nil && existingRevision < maxOldRevision
+
 		// Set existing new replica set's annotation
 		annotationsUpdated := deploymentutil.SetNewReplicaSetAnnotations(d, rsCopy, newRevision, true, maxRevHistoryLengthInChars)
 		minReadySecondsNeedsUpdate := rsCopy.Spec.MinReadySeconds != d.Spec.MinReadySeconds
-		if annotationsUpdated || minReadySecondsNeedsUpdate {
+		// Nothing is written to the replica sets in dry-run mode.
+		if (annotationsUpdated || minReadySecondsNeedsUpdate) && !dc.dryRun {
 			rsCopy.Spec.MinReadySeconds = d.Spec.MinReadySeconds
-			return dc.client.AppsV1().ReplicaSets(rsCopy.ObjectMeta.Namespace).Update(ctx, rsCopy, metav1.UpdateOptions{})
+			updatedRS, err := dc.client.AppsV1().ReplicaSets(rsCopy.ObjectMeta.Namespace).Update(ctx, rsCopy, metav1.UpdateOptions{})
+			if err != nil || !rolledBack {
+				return updatedRS, err
+			}
+			return updatedRS, dc.setRolledBackCondition(ctx, d, updatedRS, existingRevision)
 		}
 
 		// Should use the revision in existingNewRS's annotation, since it set by before
@@ -160,7 +173,7 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 		cond := deploymentutil.GetDeploymentCondition(d.Status, apps.DeploymentProgressing)
 		if deploymentutil.HasProgressDeadline(d) && cond == nil {
 			msg := fmt.Sprintf("Found new replica set %q", rsCopy.Name)
-			condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, deploymentutil.FoundNewRSReason, msg)
+			condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, deploymentutil.FoundNewRSReason, msg, dc.clock.Now())
 			deploymentutil.SetDeploymentCondition(&d.Status, *condition)
 			needsUpdate = true
 		}
@@ -180,19 +193,29 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 
 	// new ReplicaSet does not exist, create one.
 	newRSTemplate := *d.Spec.Template.DeepCopy()
-	podTemplateSpecHash := util.ComputeHash(&newRSTemplate, d.Status.CollisionCount)
-	newRSTemplate.Labels = labelsutil.CloneAndAddLabel(d.Spec.Template.Labels, apps.DefaultDeploymentUniqueLabelKey, podTemplateSpecHash)
+	podTemplateSpecHash := deploymentutil.ComputeTemplateHash(&newRSTemplate, d.Status.CollisionCount)
+	newRSTemplate.Labels = labelsutil.CloneAndAddLabel(d.Spec.Template.Labels, deploymentutil.TemplateHashLabelKey, podTemplateSpecHash)
+	// Keep the new pods of test batch out of Service endpoints, see syncTestBatchGates.
+	if dc.strategy.TestBatch {
+		newRSTemplate.Spec.ReadinessGates = append(newRSTemplate.Spec.ReadinessGates, v1.PodReadinessGate{ConditionType: rolloutsv1alpha1.TestBatchReadinessGate})
+	}
+	// Merge the scheduling hints after hashing, so that they do not change the hash of template.
+	schedulingHints := deploymentutil.MergeSchedulingHints(&newRSTemplate, dc.strategy.SchedulingHints)
 	// Add podTemplateHash label to selector.
-	newRSSelector := labelsutil.CloneSelectorAndAddLabel(d.Spec.Selector, apps.DefaultDeploymentUniqueLabelKey, podTemplateSpecHash)
+	newRSSelector := labelsutil.CloneSelectorAndAddLabel(d.Spec.Selector, deploymentutil.TemplateHashLabelKey, podTemplateSpecHash)
+
+	// Stamp the rollout generation on the new replica set only, not on its pods, otherwise the
+	// template would never equal to the template of deployment.
+	newRSLabels, _ := dc.addLabel(d, newRSTemplate.Labels, rolloutsv1alpha1.RolloutGenerationLabel, rolloutGeneration(podTemplateSpecHash, dc.clock.Now()))
 
 	// Create new ReplicaSet
 	newRS := apps.ReplicaSet{
 		ObjectMeta: metav1.ObjectMeta{
 			// Make the name deterministic, to ensure idempotence
-			Name:            d.Name + "-" + podTemplateSpecHash,
+			Name:            dc.newReplicaSetName(d, podTemplateSpecHash, newRevision),
 			Namespace:       d.Namespace,
 			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, controllerKind)},
-			Labels:          newRSTemplate.Labels,
+			Labels:          newRSLabels,
 		},
 		Spec: apps.ReplicaSetSpec{
 			Replicas:        new(int32),
@@ -202,7 +225,7 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 		},
 	}
 	allRSs := append(oldRSs, &newRS)
-	newReplicasCount, err := deploymentutil.NewRSNewReplicas(d, allRSs, &newRS)
+	newReplicasCount, err := dc.newRSNewReplicas(d, allRSs, &newRS)
 	if err != nil {
 		return nil, err
 	}
@@ -210,9 +233,14 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 	*(newRS.Spec.Replicas) = newReplicasCount
 	// Set new replica set's annotation
 	deploymentutil.SetNewReplicaSetAnnotations(d, &newRS, newRevision, false, maxRevHistoryLengthInChars)
+	deploymentutil.SetSchedulingHintsAnnotation(&newRS, schedulingHints)
 	// Create the new ReplicaSet. If it already exists, then we need to check for possible
 	// hash collisions. If there is any other error, we need to report it in the status of
 	// the Deployment.
+	if dc.dryRun {
+		dc.recordDryRunScale(newRS.Name, 0, newReplicasCount, true)
+		return &newRS, nil
+	}
 	alreadyExists := false
 	createdRS, err := dc.client.AppsV1().ReplicaSets(d.Namespace).Create(ctx, &newRS, metav1.CreateOptions{})
 	switch {
@@ -231,7 +259,7 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 		// Otherwise, this is a hash collision and we need to increment the collisionCount field in
 		// the status of the Deployment and requeue to try the creation in the next sync.
 		controllerRef := metav1.GetControllerOf(rs)
-		if controllerRef != nil && controllerRef.UID == d.UID && deploymentutil.EqualIgnoreHash(&d.Spec.Template, &rs.Spec.Template) {
+		if controllerRef != nil && controllerRef.UID == d.UID && deploymentutil.EqualIgnoreHash(deploymentutil.ReplicaSetTemplateOf(&d.Spec.Template, rs), &rs.Spec.Template) {
 			createdRS = rs
 			err = nil
 			break
@@ -248,7 +276,7 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 		// error.
 		_, dErr := dc.client.AppsV1().Deployments(d.Namespace).UpdateStatus(ctx, d, metav1.UpdateOptions{})
 		if dErr == nil {
-			klog.V(2).Infof("Found a hash collision for deployment %q - bumping collisionCount (%d->%d) to resolve it", d.Name, preCollisionCount, *d.Status.CollisionCount)
+			dc.log().V(2).Info("Found a hash collision, bumping collisionCount to resolve it", "from", preCollisionCount, "to", *d.Status.CollisionCount)
 		}
 		return nil, err
 	case errors.HasStatusCause(err, v1.NamespaceTerminatingCause):
@@ -257,7 +285,7 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 	case err != nil:
 		msg := fmt.Sprintf("Failed to create new replica set %q: %v", newRS.Name, err)
 		if deploymentutil.HasProgressDeadline(d) {
-			cond := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionFalse, deploymentutil.FailedRSCreateReason, msg)
+			cond := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionFalse, deploymentutil.FailedRSCreateReason, msg, dc.clock.Now())
 			deploymentutil.SetDeploymentCondition(&d.Status, *cond)
 			// We don't really care about this error at this point, since we have a bigger issue to report.
 			// TODO: Identify which errors are permanent and switch DeploymentIsFailed to take into account
@@ -267,14 +295,20 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 		dc.eventRecorder.Eventf(d, v1.EventTypeWarning, deploymentutil.FailedRSCreateReason, msg)
 		return nil, err
 	}
+	if !alreadyExists {
+		if createdRS, err = dc.ensureControllerRef(ctx, d, createdRS); err != nil {
+			return nil, err
+		}
+	}
 	if !alreadyExists && newReplicasCount > 0 {
 		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, "ScalingReplicaSet", "Scaled up replica set %s to %d", createdRS.Name, newReplicasCount)
+		dc.auditor.record(d, createdRS.Name, 0, newReplicasCount, auditReasonNewRSCreated)
 	}
 
 	needsUpdate := deploymentutil.SetDeploymentRevision(d, newRevision)
 	if !alreadyExists && deploymentutil.HasProgressDeadline(d) {
 		msg := fmt.Sprintf("Created new replica set %q", createdRS.Name)
-		condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, deploymentutil.NewReplicaSetReason, msg)
+		condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, deploymentutil.NewReplicaSetReason, msg, dc.clock.Now())
 		deploymentutil.SetDeploymentCondition(&d.Status, *condition)
 		needsUpdate = true
 	}
@@ -284,19 +318,48 @@ func (dc *DeploymentController) getNewReplicaSet(ctx context.Context, d *apps.De
 	return createdRS, err
 }
 
+// rolloutGeneration returns the value of RolloutGenerationLabel for the rollout to the template
+// with the given hash started at start.
+func rolloutGeneration(podTemplateSpecHash string, start time.Time) string {
+	return fmt.Sprintf("%s-%d", podTemplateSpecHash, start.Unix())
+}
+
+// setRolledBackCondition records the rollback of deployment to the old replica set rs, whose revision
+// was the given one before it was bumped to the latest revision.
+func (dc *DeploymentController) setRolledBackCondition(ctx context.Context, d *apps.Deployment, rs *apps.ReplicaSet, revision int64) error {
+	dc.eventRecorder.Eventf(d, v1.EventTypeNormal, deploymentutil.RolledBackReason, "Rolled back deployment %q to revision %d", d.Name, revision)
+	if !deploymentutil.HasProgressDeadline(d) {
+		return nil
+	}
+	msg := fmt.Sprintf("Rolled back to replica set %q", rs.Name)
+	condition := deploymentutil.NewDeploymentCondition(apps.DeploymentProgressing, v1.ConditionTrue, deploymentutil.RolledBackReason, msg, dc.clock.Now())
+	deploymentutil.SetDeploymentCondition(&d.Status, *condition)
+	_, err := dc.client.AppsV1().Deployments(d.Namespace).UpdateStatus(ctx, d, metav1.UpdateOptions{})
+	return err
+}
+
 // scale scales proportionally in order to mitigate risk. Otherwise, scaling up can increase the size
 // of the new replica set and scaling down can decrease the sizes of the old ones, both of which would
 // have the effect of hastening the rollout progress, which could produce a higher proportion of unavailable
 // replicas in the event of a problem with the rolled out template. Should run only on scaling events or
 // when a deployment is paused and not during the normal rollout process.
 func (dc *DeploymentController) scale(ctx context.Context, deployment *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) error {
+	// Only the oversized new replica set is scaled down if the old replica sets are left to others.
+	if !dc.managesOldReplicaSets() {
+		if newRS == nil || *(newRS.Spec.Replicas) <= *(deployment.Spec.Replicas) {
+			return nil
+		}
+		_, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, newRS, *(deployment.Spec.Replicas), deployment, auditReasonNewRSOversized)
+		return err
+	}
+
 	// If there is only one active replica set then we should scale that up to the full count of the
 	// deployment. If there is no active replica set, then we should scale up the newest replica set.
 	if activeOrLatest := deploymentutil.FindActiveOrLatest(newRS, oldRSs); activeOrLatest != nil {
 		if *(activeOrLatest.Spec.Replicas) == *(deployment.Spec.Replicas) {
 			return nil
 		}
-		_, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, activeOrLatest, *(deployment.Spec.Replicas), deployment)
+		_, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, activeOrLatest, *(deployment.Spec.Replicas), deployment, auditReasonScaling)
 		return err
 	}
 
@@ -304,7 +367,7 @@ func (dc *DeploymentController) scale(ctx context.Context, deployment *apps.Depl
 	// This case handles replica set adoption during a saturated new replica set.
 	if deploymentutil.IsSaturated(deployment, newRS) {
 		for _, old := range deploymentutil.FilterActiveReplicaSets(oldRSs) {
-			if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, old, 0, deployment); err != nil {
+			if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, old, 0, deployment, auditReasonScaling); err != nil {
 				return err
 			}
 		}
@@ -378,7 +441,7 @@ func (dc *DeploymentController) scale(ctx context.Context, deployment *apps.Depl
 			}
 
 			// TODO: Use transactions when we have them.
-			if _, _, err := dc.scaleReplicaSet(ctx, rs, nameToSize[rs.Name], deployment, scalingOperation); err != nil {
+			if _, _, err := dc.scaleReplicaSet(ctx, rs, nameToSize[rs.Name], deployment, scalingOperation, auditReasonProportionalScaling); err != nil {
 				// Return as soon as we fail, the deployment is requeued
 				return err
 			}
@@ -387,7 +450,7 @@ func (dc *DeploymentController) scale(ctx context.Context, deployment *apps.Depl
 	return nil
 }
 
-func (dc *DeploymentController) scaleReplicaSetAndRecordEvent(ctx context.Context, rs *apps.ReplicaSet, newScale int32, deployment *apps.Deployment) (bool, *apps.ReplicaSet, error) {
+func (dc *DeploymentController) scaleReplicaSetAndRecordEvent(ctx context.Context, rs *apps.ReplicaSet, newScale int32, deployment *apps.Deployment, reason string) (bool, *apps.ReplicaSet, error) {
 	// No need to scale
 	if *(rs.Spec.Replicas) == newScale {
 		return false, rs, nil
@@ -398,11 +461,11 @@ func (dc *DeploymentController) scaleReplicaSetAndRecordEvent(ctx context.Contex
 	} else {
 		scalingOperation = "down"
 	}
-	scaled, newRS, err := dc.scaleReplicaSet(ctx, rs, newScale, deployment, scalingOperation)
+	scaled, newRS, err := dc.scaleReplicaSet(ctx, rs, newScale, deployment, scalingOperation, reason)
 	return scaled, newRS, err
 }
 
-func (dc *DeploymentController) scaleReplicaSet(ctx context.Context, rs *apps.ReplicaSet, newScale int32, deployment *apps.Deployment, scalingOperation string) (bool, *apps.ReplicaSet, error) {
+func (dc *DeploymentController) scaleReplicaSet(ctx context.Context, rs *apps.ReplicaSet, newScale int32, deployment *apps.Deployment, scalingOperation, reason string) (bool, *apps.ReplicaSet, error) {
 
 	sizeNeedsUpdate := *(rs.Spec.Replicas) != newScale
 
@@ -415,10 +478,17 @@ func (dc *DeploymentController) scaleReplicaSet(ctx context.Context, rs *apps.Re
 		rsCopy := rs.DeepCopy()
 		*(rsCopy.Spec.Replicas) = newScale
 		deploymentutil.SetReplicasAnnotations(rsCopy, *(deployment.Spec.Replicas), *(deployment.Spec.Replicas)+deploymentutil.MaxSurge(*deployment))
-		rs, err = dc.client.AppsV1().ReplicaSets(rsCopy.Namespace).Update(ctx, rsCopy, metav1.UpdateOptions{})
+		if dc.dryRun {
+			if sizeNeedsUpdate {
+				dc.recordDryRunScale(rs.Name, oldScale, newScale, false)
+			}
+			return sizeNeedsUpdate, rsCopy, nil
+		}
+		rs, err = dc.updateReplicaSetReplicas(ctx, rs, rsCopy, !annotationsNeedUpdate)
 		if err == nil && sizeNeedsUpdate {
 			scaled = true
 			dc.eventRecorder.Eventf(deployment, v1.EventTypeNormal, "ScalingReplicaSet", "Scaled %s replica set %s to %d from %d", scalingOperation, rs.Name, newScale, oldScale)
+			dc.auditor.record(deployment, rs.Name, oldScale, newScale, reason)
 		}
 	}
 	return scaled, rs, err
@@ -428,7 +498,7 @@ func (dc *DeploymentController) scaleReplicaSet(ctx context.Context, rs *apps.Re
 // where N=d.Spec.RevisionHistoryLimit. Old replica sets are older versions of the podtemplate of a deployment kept
 // around by default 1) for historical reasons and 2) for the ability to rollback a deployment.
 func (dc *DeploymentController) cleanupDeployment(ctx context.Context, oldRSs []*apps.ReplicaSet, deployment *apps.Deployment) error {
-	if !deploymentutil.HasRevisionHistoryLimit(deployment) {
+	if !deploymentutil.HasRevisionHistoryLimit(deployment) || !dc.managesOldReplicaSets() || dc.dryRun {
 		return nil
 	}
 
@@ -444,7 +514,7 @@ func (dc *DeploymentController) cleanupDeployment(ctx context.Context, oldRSs []
 	}
 
 	sort.Sort(deploymentutil.ReplicaSetsByRevision(cleanableRSes))
-	klog.V(4).Infof("Looking to cleanup old replica sets for deployment %q", deployment.Name)
+	dc.log().V(4).Info("Looking to cleanup old replica sets")
 
 	for i := int32(0); i < diff; i++ {
 		rs := cleanableRSes[i]
@@ -452,7 +522,7 @@ func (dc *DeploymentController) cleanupDeployment(ctx context.Context, oldRSs []
 		if rs.Status.Replicas != 0 || *(rs.Spec.Replicas) != 0 || rs.Generation > rs.Status.ObservedGeneration || rs.DeletionTimestamp != nil {
 			continue
 		}
-		klog.V(4).Infof("Trying to cleanup replica set %q for deployment %q", rs.Name, deployment.Name)
+		dc.log().V(4).Info("Trying to cleanup replica set", "replicaSet", rs.Name)
 		if err := dc.client.AppsV1().ReplicaSets(rs.Namespace).Delete(ctx, rs.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
 			// Return error instead of aggregating and continuing DELETEs on the theory
 			// that we may be overloading the api server.
@@ -465,7 +535,7 @@ func (dc *DeploymentController) cleanupDeployment(ctx context.Context, oldRSs []
 
 // syncDeploymentStatus checks if the status is up-to-date and sync it if necessary
 func (dc *DeploymentController) syncDeploymentStatus(ctx context.Context, allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, d *apps.Deployment) error {
-	newStatus := calculateStatus(allRSs, newRS, d)
+	newStatus := dc.calculateStatus(allRSs, newRS, d)
 
 	if reflect.DeepEqual(d.Status, newStatus) {
 		return nil
@@ -478,7 +548,7 @@ func (dc *DeploymentController) syncDeploymentStatus(ctx context.Context, allRSs
 }
 
 // calculateStatus calculates the latest status for the provided deployment by looking into the provided replica sets.
-func calculateStatus(allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, deployment *apps.Deployment) apps.DeploymentStatus {
+func (dc *DeploymentController) calculateStatus(allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, deployment *apps.Deployment) apps.DeploymentStatus {
 	availableReplicas := deploymentutil.GetAvailableReplicaCountForReplicaSets(allRSs)
 	totalReplicas := deploymentutil.GetReplicaCountForReplicaSets(allRSs)
 	unavailableReplicas := totalReplicas - availableReplicas
@@ -504,29 +574,52 @@ func calculateStatus(allRSs []*apps.ReplicaSet, newRS *apps.ReplicaSet, deployme
 	for i := range conditions {
 		status.Conditions = append(status.Conditions, conditions[i])
 	}
+	// The strategy must have been fixed if we get here.
+	deploymentutil.RemoveDeploymentCondition(&status, deploymentutil.InvalidRolloutStrategy)
+	dc.setWaitingForApprovalCondition(&status)
 
 	if availableReplicas >= *(deployment.Spec.Replicas)-deploymentutil.MaxUnavailable(*deployment) {
-		minAvailability := deploymentutil.NewDeploymentCondition(apps.DeploymentAvailable, v1.ConditionTrue, deploymentutil.MinimumReplicasAvailable, "Deployment has minimum availability.")
+		minAvailability := deploymentutil.NewDeploymentCondition(apps.DeploymentAvailable, v1.ConditionTrue, deploymentutil.MinimumReplicasAvailable, "Deployment has minimum availability.", dc.clock.Now())
 		deploymentutil.SetDeploymentCondition(&status, *minAvailability)
 	} else {
-		noMinAvailability := deploymentutil.NewDeploymentCondition(apps.DeploymentAvailable, v1.ConditionFalse, deploymentutil.MinimumReplicasUnavailable, "Deployment does not have minimum availability.")
+		noMinAvailability := deploymentutil.NewDeploymentCondition(apps.DeploymentAvailable, v1.ConditionFalse, deploymentutil.MinimumReplicasUnavailable, "Deployment does not have minimum availability.", dc.clock.Now())
 		deploymentutil.SetDeploymentCondition(&status, *noMinAvailability)
 	}
 
 	return status
 }
 
+// syncReplicasAnnotations updates the desired-replicas and max-replicas annotations of the active
+// replica sets without scaling them, so that the replicas change will not be regarded as a scaling
+// event any more. The updated replica sets replace those in rsList, so that they will not be
+// updated again against stale resource versions in the same sync.
+func (dc *DeploymentController) syncReplicasAnnotations(ctx context.Context, d *apps.Deployment, rsList []*apps.ReplicaSet) error {
+	for i, rs := range rsList {
+		if rs == nil || *(rs.Spec.Replicas) == 0 {
+			continue
+		}
+		_, updatedRS, err := dc.scaleReplicaSet(ctx, rs, *(rs.Spec.Replicas), d, "", "")
+		if err != nil {
+			return err
+		}
+		rsList[i] = updatedRS
+	}
+	return nil
+}
+
 // isScalingEvent checks whether the provided deployment has been updated with a scaling event
 // by looking at the desired-replicas annotation in the active replica sets of the deployment.
 //
 // rsList should come from getReplicaSetsForDeployment(d).
 func (dc *DeploymentController) isScalingEvent(ctx context.Context, d *apps.Deployment, rsList []*apps.ReplicaSet) (bool, error) {
-	newRS, oldRSs, err := dc.getAllReplicaSetsAndSyncRevision(ctx, d, rsList, false)
-	if err != nil {
-		return false, err
-	}
-	allRSs := append(oldRSs, newRS)
-	for _, rs := range deploymentutil.FilterActiveReplicaSets(allRSs) {
+	// The annotations of old replica sets are never updated if they are left to others, and the
+	// new replica set is scaled against the new replicas in rolling directly.
+	if !dc.managesOldReplicaSets() {
+		return false, nil
+	}
+	// The revision is not synced here, it is synced once the deployment is rolled or scaled,
+	// otherwise the replica sets would be updated twice against the same stale rsList.
+	for _, rs := range deploymentutil.FilterActiveReplicaSets(rsList) {
 		desired, ok := deploymentutil.GetDesiredReplicasAnnotation(rs)
 		if !ok {
 			continue
@@ -0,0 +1,155 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// syncLimiter is a semaphore limiting the concurrent syncs of deployments, whose size can be
+// changed at runtime, unlike the MaxConcurrentReconciles of controller fixed at startup. The
+// size is at most the max workers, since no more syncs can run concurrently anyway.
+type syncLimiter struct {
+	mu     sync.Mutex
+	max    int
+	limit  int
+	active int
+	// changed is closed and renewed whenever a sync is released or the limit is changed, so
+	// that the waiting syncs check again.
+	changed chan struct{}
+}
+
+// newSyncLimiter returns a syncLimiter allowing limit of max workers to sync concurrently.
+func newSyncLimiter(max, limit int) (*syncLimiter, error) {
+	l := &syncLimiter{max: max, changed: make(chan struct{})}
+	if err := l.validate(limit); err != nil {
+		return nil, err
+	}
+	l.limit = limit
+	return l, nil
+}
+
+// validate returns an error unless limit is between 1 and the max workers.
+func (l *syncLimiter) validate(limit int) error {
+	if limit < 1 || limit > l.max {
+		return fmt.Errorf("max concurrent syncs %d must be between 1 and deployment-workers %d", limit, l.max)
+	}
+	return nil
+}
+
+// acquire blocks until a sync is allowed, or returns the error of ctx if it is done meanwhile.
+// A nil syncLimiter never blocks.
+func (l *syncLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		if l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release releases a sync allowed by acquire.
+func (l *syncLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.notify()
+}
+
+// resize changes the max concurrent syncs, the syncs in progress beyond the new limit are
+// not interrupted, but no more syncs are allowed until they finish.
+func (l *syncLimiter) resize(limit int) error {
+	if err := l.validate(limit); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit == l.limit {
+		return nil
+	}
+	klog.Infof("Changed max concurrent syncs of advanced deployments from %d to %d", l.limit, limit)
+	l.limit = limit
+	l.notify()
+	return nil
+}
+
+// notify wakes up the waiting syncs, l.mu must be held.
+func (l *syncLimiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// readSyncLimit reads the max concurrent syncs from the file at path.
+func readSyncLimit(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid max concurrent syncs in %s: %v", path, err)
+	}
+	return limit, nil
+}
+
+// reload resizes l with the file at path, the current limit is kept if the file cannot be read
+// or holds an invalid value.
+func (l *syncLimiter) reload(path string) error {
+	limit, err := readSyncLimit(path)
+	if err != nil {
+		return err
+	}
+	return l.resize(limit)
+}
+
+// watchReload reloads l with the file at path whenever a signal is received, until ctx is done.
+func (l *syncLimiter) watchReload(ctx context.Context, path string, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			klog.Infof("Received %v, reloading max concurrent syncs of advanced deployments from %s", sig, path)
+			if err := l.reload(path); err != nil {
+				klog.Errorf("Failed to reload max concurrent syncs of advanced deployments: %v", err)
+			}
+		}
+	}
+}
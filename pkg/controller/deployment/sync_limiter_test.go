@@ -0,0 +1,166 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSyncLimiterResize(t *testing.T) {
+	cases := []struct {
+		name        string
+		limit       int
+		expectLimit int
+		expectErr   bool
+	}{
+		{name: "unchanged", limit: 2, expectLimit: 2},
+		{name: "lowered", limit: 1, expectLimit: 1},
+		{name: "raised to max", limit: 3, expectLimit: 3},
+		{name: "zero", limit: 0, expectLimit: 2, expectErr: true},
+		{name: "negative", limit: -1, expectLimit: 2, expectErr: true},
+		{name: "beyond max", limit: 4, expectLimit: 2, expectErr: true},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			l, err := newSyncLimiter(3, 2)
+			if err != nil {
+				t.Fatalf("failed to create sync limiter: %v", err)
+			}
+			if err = l.resize(cs.limit); (err != nil) != cs.expectErr {
+				t.Fatalf("expect error %v, got %v", cs.expectErr, err)
+			}
+			if l.limit != cs.expectLimit {
+				t.Fatalf("expect limit %d, got %d", cs.expectLimit, l.limit)
+			}
+		})
+	}
+}
+
+func TestSyncLimiterAcquire(t *testing.T) {
+	l, err := newSyncLimiter(3, 1)
+	if err != nil {
+		t.Fatalf("failed to create sync limiter: %v", err)
+	}
+	if err = l.acquire(context.TODO()); err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+
+	// The second sync waits until the limit is raised.
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.acquire(context.TODO()) }()
+	select {
+	case err = <-acquired:
+		t.Fatalf("expect the second sync blocked, got acquired with error %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err = l.resize(2); err != nil {
+		t.Fatalf("failed to resize: %v", err)
+	}
+	if err = <-acquired; err != nil {
+		t.Fatalf("failed to acquire after resized: %v", err)
+	}
+
+	// The syncs beyond the lowered limit are not interrupted, but block the next one until released.
+	if err = l.resize(1); err != nil {
+		t.Fatalf("failed to resize: %v", err)
+	}
+	go func() { acquired <- l.acquire(context.TODO()) }()
+	l.release()
+	select {
+	case err = <-acquired:
+		t.Fatalf("expect the third sync blocked, got acquired with error %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release()
+	if err = <-acquired; err != nil {
+		t.Fatalf("failed to acquire after released: %v", err)
+	}
+
+	// A sync waiting is given up once its context is done.
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	if err = l.acquire(ctx); err != context.Canceled {
+		t.Fatalf("expect %v, got %v", context.Canceled, err)
+	}
+
+	var nilLimiter *syncLimiter
+	if err = nilLimiter.acquire(ctx); err != nil {
+		t.Fatalf("expect nil limiter never blocks, got %v", err)
+	}
+	nilLimiter.release()
+}
+
+func TestSyncLimiterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workers")
+	l, err := newSyncLimiter(5, 3)
+	if err != nil {
+		t.Fatalf("failed to create sync limiter: %v", err)
+	}
+	if err = l.reload(path); err == nil || l.limit != 3 {
+		t.Fatalf("expect error and limit 3 kept without file, got error %v and limit %d", err, l.limit)
+	}
+	for _, step := range []struct {
+		content     string
+		expectLimit int
+		expectErr   bool
+	}{
+		{content: "2\n", expectLimit: 2},
+		{content: "not a number", expectLimit: 2, expectErr: true},
+		{content: "10", expectLimit: 2, expectErr: true},
+		{content: " 4 ", expectLimit: 4},
+	} {
+		if err = os.WriteFile(path, []byte(step.content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err = l.reload(path); (err != nil) != step.expectErr {
+			t.Fatalf("expect error %v reloading %q, got %v", step.expectErr, step.content, err)
+		}
+		if l.limit != step.expectLimit {
+			t.Fatalf("expect limit %d after reloading %q, got %d", step.expectLimit, step.content, l.limit)
+		}
+	}
+}
+
+func TestSyncLimiterWatchReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workers")
+	if err := os.WriteFile(path, []byte("2"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	l, err := newSyncLimiter(5, 3)
+	if err != nil {
+		t.Fatalf("failed to create sync limiter: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		l.watchReload(ctx, path, signals)
+		close(done)
+	}()
+	signals <- syscall.SIGHUP
+	cancel()
+	<-done
+	if l.limit != 2 {
+		t.Fatalf("expect limit 2 reloaded on signal, got %d", l.limit)
+	}
+}
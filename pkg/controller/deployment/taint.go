@@ -0,0 +1,96 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// UntoleratedTaintReason is added in a deployment event when some pods of its new replica set
+// are scheduled onto nodes with taints not tolerated by the template, e.g. the tolerations
+// are missing in the template after a sidecar is injected.
+const UntoleratedTaintReason = "UntoleratedTaint"
+
+// checkUntoleratedTaints returns true and emits a warning event if any pod of newRS is running
+// on a node with NoSchedule or NoExecute taints which are not tolerated by the template of newRS.
+func (dc *DeploymentController) checkUntoleratedTaints(d *apps.Deployment, newRS *apps.ReplicaSet) (bool, error) {
+	if dc.nodeLister == nil || newRS == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(newRS.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	pods, err := dc.cachedPods(newRS.Namespace, selector)
+	if err != nil {
+		return false, err
+	}
+
+	count := 0
+	var example string
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(pod, newRS) || pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		node, err := dc.nodeLister.Get(pod.Spec.NodeName)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		taint := findUntoleratedTaint(node.Spec.Taints, newRS.Spec.Template.Spec.Tolerations)
+		if taint == nil {
+			continue
+		}
+		if count == 0 {
+			example = "pod " + pod.Name + " on node " + node.Name + " with taint " + taint.ToString()
+		}
+		count++
+	}
+	if count == 0 {
+		return false, nil
+	}
+	dc.log().Info("Found pods of new replica set on nodes with untolerated taints", "replicaSet", klog.KObj(newRS), "count", count, "block", untoleratedTaintsBlock)
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, UntoleratedTaintReason,
+		"%d pods of new replica set %s are scheduled onto nodes with taints not tolerated by the template, e.g. %s", count, newRS.Name, example)
+	return true, nil
+}
+
+// findUntoleratedTaint returns the first NoSchedule or NoExecute taint not tolerated by tolerations.
+func findUntoleratedTaint(taints []v1.Taint, tolerations []v1.Toleration) *v1.Taint {
+	for i := range taints {
+		taint := &taints[i]
+		if taint.Effect != v1.TaintEffectNoSchedule && taint.Effect != v1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return taint
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func newTestNode(name string, taints ...v1.Taint) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: v1.NodeSpec{Taints: taints}}
+}
+
+func TestCheckUntoleratedTaints(t *testing.T) {
+	infraTaint := v1.Taint{Key: "dedicated", Value: "infra", Effect: v1.TaintEffectNoSchedule}
+	cases := []struct {
+		name        string
+		taints      []v1.Taint
+		tolerations []v1.Toleration
+		nodeName    string
+		oldPod      bool
+		expect      bool
+	}{
+		{
+			name:     "untainted node",
+			nodeName: "node",
+		},
+		{
+			name:     "untolerated NoSchedule taint",
+			taints:   []v1.Taint{infraTaint},
+			nodeName: "node",
+			expect:   true,
+		},
+		{
+			name:     "untolerated NoExecute taint",
+			taints:   []v1.Taint{{Key: "dedicated", Value: "infra", Effect: v1.TaintEffectNoExecute}},
+			nodeName: "node",
+			expect:   true,
+		},
+		{
+			name:     "PreferNoSchedule taint is ignored",
+			taints:   []v1.Taint{{Key: "dedicated", Value: "infra", Effect: v1.TaintEffectPreferNoSchedule}},
+			nodeName: "node",
+		},
+		{
+			name:        "tolerated taint",
+			taints:      []v1.Taint{infraTaint},
+			tolerations: []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "infra", Effect: v1.TaintEffectNoSchedule}},
+			nodeName:    "node",
+		},
+		{
+			name:        "taint tolerated by other value",
+			taints:      []v1.Taint{infraTaint},
+			tolerations: []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "gpu", Effect: v1.TaintEffectNoSchedule}},
+			nodeName:    "node",
+			expect:      true,
+		},
+		{
+			name:   "unscheduled pod",
+			taints: []v1.Taint{infraTaint},
+		},
+		{
+			name:     "unknown node",
+			taints:   []v1.Taint{infraTaint},
+			nodeName: "other",
+		},
+		{
+			name:     "old pod is ignored",
+			taints:   []v1.Taint{infraTaint},
+			nodeName: "node",
+			oldPod:   true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 3)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 1)
+			newRS.Spec.Template.Spec.Tolerations = cs.tolerations
+			pod := newTestPod(newRS, "pod")
+			if cs.oldPod {
+				pod = newTestPod(oldRS, "pod")
+			}
+			pod.Spec.NodeName = cs.nodeName
+			dc, _, recorder := newTestController(rolloutsv1alpha1.DeploymentStrategy{}, []runtime.Object{d, oldRS, newRS, pod, newTestNode("node", cs.taints...)}...)
+
+			found, err := dc.checkUntoleratedTaints(d, newRS)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if found != cs.expect {
+				t.Fatalf("expect untolerated taints found %v, got %v", cs.expect, found)
+			}
+			if warned := hasEvent(collectEvents(recorder), UntoleratedTaintReason); warned != cs.expect {
+				t.Fatalf("expect %s event %v, got %v", UntoleratedTaintReason, cs.expect, warned)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentWithUntoleratedTaints(t *testing.T) {
+	cases := []struct {
+		name   string
+		block  bool
+		expect map[string]int32
+	}{
+		{
+			name:   "warn without blocking",
+			expect: map[string]int32{"demo:v1": 5, "demo:v2": 5},
+		},
+		{
+			name:   "block the rollout",
+			block:  true,
+			expect: map[string]int32{"demo:v1": 9, "demo:v2": 1},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(block bool) { untoleratedTaintsBlock = block }(untoleratedTaintsBlock)
+			untoleratedTaintsBlock = cs.block
+
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 9)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 1)
+			pod := newTestPod(newRS, "pod")
+			pod.Spec.NodeName = "node"
+			node := newTestNode("node", v1.Taint{Key: "dedicated", Value: "infra", Effect: v1.TaintEffectNoSchedule})
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:     intstr.FromString("50%"),
+			}
+			dc, client, recorder := newTestController(strategy, []runtime.Object{d, oldRS, newRS, pod, node}...)
+
+			for i := 0; i < 20; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expect) {
+				t.Fatalf("expect replicas %v, got %v", cs.expect, replicas)
+			}
+			if !hasEvent(collectEvents(recorder), UntoleratedTaintReason) {
+				t.Errorf("expect %s event", UntoleratedTaintReason)
+			}
+		})
+	}
+}
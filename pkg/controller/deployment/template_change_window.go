@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// TemplateChangeCoalescedReason is added in a deployment event once its pending template change
+// is superseded by another one within the window, so only the latest one will be rolled out.
+const TemplateChangeCoalescedReason = "TemplateChangeCoalesced"
+
+// holdTemplateChange returns true if the changed template of deployment should wait for the
+// window before its new replica set is created, so that the following changes within the window
+// are coalesced into a single rollout to the latest template. The window restarts on each change
+// and the deployment is requeued once it expires. The first replica set is never held, since
+// there is nothing to roll from.
+func (dc *DeploymentController) holdTemplateChange(d *apps.Deployment, rsList []*apps.ReplicaSet) bool {
+	dc.pendingRevision, dc.pendingSince = "", nil
+	window := time.Duration(dc.strategy.TemplateChangeWindowSeconds) * time.Second
+	if window <= 0 || deploymentutil.FindNewReplicaSet(d, rsList) != nil || len(deploymentutil.FilterActiveReplicaSets(rsList)) == 0 {
+		return false
+	}
+
+	now := dc.clock.Now()
+	revision := deploymentutil.ComputeTemplateHash(&d.Spec.Template, d.Status.CollisionCount)
+	since := metav1.NewTime(now)
+	if prev := getExtraStatus(d); prev != nil && prev.PendingRevision == revision && prev.PendingSince != nil {
+		since = *prev.PendingSince
+	} else if prev != nil && prev.PendingRevision != "" {
+		dc.eventRecorder.Eventf(d, v1.EventTypeNormal, TemplateChangeCoalescedReason,
+			"Template change %s is superseded by %s within %v", prev.PendingRevision, revision, window)
+	}
+	// Keep the pending revision until its new replica set is created, even if the window expires.
+	dc.pendingRevision, dc.pendingSince = revision, &since
+
+	left := window - now.Sub(since.Time)
+	if left <= 0 {
+		return false
+	}
+	dc.log().V(3).Info("Hold template change to coalesce the following changes", "revision", revision, "left", left)
+	dc.enqueueAfter(d, left)
+	return true
+}
+
+// syncPendingRevision sets the template change held in this sync, see holdTemplateChange.
+func (dc *DeploymentController) syncPendingRevision(cur *rolloutsv1alpha1.DeploymentExtraStatus) {
+	cur.PendingRevision = dc.pendingRevision
+	cur.PendingSince = dc.pendingSince
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncCoalescedTemplateChanges(t *testing.T) {
+	cases := []struct {
+		name            string
+		windowSeconds   int32
+		expectImages    []string
+		expectCoalesced bool
+	}{
+		{
+			name:         "rollout started on each change",
+			expectImages: []string{"demo:v1", "demo:v2", "demo:v3", "demo:v4"},
+		},
+		{
+			name:            "changes coalesced within window",
+			windowSeconds:   60,
+			expectImages:    []string{"demo:v1", "demo:v4"},
+			expectCoalesced: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:                rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:               d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:                   intstr.FromString("100%"),
+				TemplateChangeWindowSeconds: cs.windowSeconds,
+			}
+			dc, client, recorder := newTestController(strategy, d, oldRS)
+			fakeClock := dc.clock.(*testingclock.FakeClock)
+
+			// The template is changed every 30 seconds, each of which is synced a few times.
+			images := map[string]bool{}
+			for _, image := range []string{"demo:v2", "demo:v3", "demo:v4"} {
+				latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get deployment: %v", err)
+				}
+				latest.Spec.Template.Spec.Containers[0].Image = image
+				if _, err = client.AppsV1().Deployments(d.Namespace).Update(context.TODO(), latest, metav1.UpdateOptions{}); err != nil {
+					t.Fatalf("failed to update deployment: %v", err)
+				}
+				for i := 0; i < 3; i++ {
+					d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+				}
+				for image := range getReplicaSetReplicas(t, client, d.Namespace) {
+					images[image] = true
+				}
+				if extraStatus := getExtraStatus(d); cs.expectCoalesced && (extraStatus == nil || extraStatus.PendingRevision == "") {
+					t.Fatalf("expect template change pending, got %+v", extraStatus)
+				}
+				fakeClock.Step(30 * time.Second)
+			}
+
+			// The latest template is rolled out once the window expires.
+			fakeClock.Step(time.Duration(cs.windowSeconds) * time.Second)
+			for i := 0; i < 20; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			replicas := getReplicaSetReplicas(t, client, d.Namespace)
+			var created []string
+			for _, image := range []string{"demo:v1", "demo:v2", "demo:v3", "demo:v4"} {
+				if images[image] || replicas[image] > 0 {
+					created = append(created, image)
+				}
+			}
+			if !reflect.DeepEqual(created, cs.expectImages) {
+				t.Fatalf("expect replica sets of %v, got %v", cs.expectImages, created)
+			}
+			if replicas["demo:v4"] != 10 {
+				t.Fatalf("expect rolled out to demo:v4, got %v", replicas)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.PendingRevision != "" || extraStatus.PendingSince != nil {
+				t.Fatalf("expect no template change pending, got %+v", extraStatus)
+			}
+			if coalesced := hasEvent(collectEvents(recorder), TemplateChangeCoalescedReason); coalesced != cs.expectCoalesced {
+				t.Fatalf("expect %s event %v, got %v", TemplateChangeCoalescedReason, cs.expectCoalesced, coalesced)
+			}
+		})
+	}
+}
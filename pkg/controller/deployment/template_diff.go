@@ -0,0 +1,176 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// TemplateDiffReason is added in a deployment event when it starts rolling to a new revision,
+// the message summarizes the diff between the stable and new pod templates.
+const TemplateDiffReason = "TemplateDiff"
+
+// maxTemplateDiffLength bounds the length of template diff summary, so that the event will
+// not be too large to read, or to be accepted by apiserver.
+const maxTemplateDiffLength = 512
+
+// recordTemplateDiff emits an event with the diff between the pod templates of the stable
+// replica set and the new replica set, and returns the summary of the diff.
+func (dc *DeploymentController) recordTemplateDiff(d *apps.Deployment, newRS *apps.ReplicaSet, rsList []*apps.ReplicaSet) string {
+	stableRS := findStableReplicaSet(newRS, rsList)
+	if stableRS == nil {
+		return ""
+	}
+	diffs := diffPodTemplates(&stableRS.Spec.Template, &newRS.Spec.Template)
+	if len(diffs) == 0 {
+		return ""
+	}
+	summary := summarizeTemplateDiff(diffs)
+	dc.eventRecorder.Eventf(d, v1.EventTypeNormal, TemplateDiffReason, "Rolling from replica set %s to %s: %s",
+		stableRS.Name, newRS.Name, summary)
+	return summary
+}
+
+// findStableReplicaSet returns the old replica set with the highest revision which still has
+// replicas, or nil if all of them have been scaled down, i.e. there is no rollout at all.
+func findStableReplicaSet(newRS *apps.ReplicaSet, rsList []*apps.ReplicaSet) *apps.ReplicaSet {
+	oldRSs := deploymentutil.FilterReplicaSets(rsList, func(rs *apps.ReplicaSet) bool {
+		return rs != nil && rs.UID != newRS.UID && *(rs.Spec.Replicas) > 0
+	})
+	if len(oldRSs) == 0 {
+		return nil
+	}
+	sort.Sort(deploymentutil.ReplicaSetsByRevision(oldRSs))
+	return oldRSs[len(oldRSs)-1]
+}
+
+// diffPodTemplates returns the human readable changes from the stable to the updated template.
+// Only names of env are reported, because their values may be sensitive.
+func diffPodTemplates(stable, updated *v1.PodTemplateSpec) []string {
+	diffs := diffContainers("container", stable.Spec.Containers, updated.Spec.Containers)
+	diffs = append(diffs, diffContainers("init container", stable.Spec.InitContainers, updated.Spec.InitContainers)...)
+
+	stableCopy, updatedCopy := stable.DeepCopy(), updated.DeepCopy()
+	for _, template := range []*v1.PodTemplateSpec{stableCopy, updatedCopy} {
+		delete(template.Labels, deploymentutil.TemplateHashLabelKey)
+		deploymentutil.RemoveTestBatchGate(&template.Spec)
+		template.Spec.Containers, template.Spec.InitContainers = nil, nil
+	}
+	if !apiequality.Semantic.DeepEqual(stableCopy, updatedCopy) {
+		diffs = append(diffs, "other pod template fields changed")
+	}
+	return diffs
+}
+
+func diffContainers(kind string, stable, updated []v1.Container) []string {
+	var diffs []string
+	stableByName := make(map[string]*v1.Container, len(stable))
+	for i := range stable {
+		stableByName[stable[i].Name] = &stable[i]
+	}
+	for i := range updated {
+		u := &updated[i]
+		s, ok := stableByName[u.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s %s added", kind, u.Name))
+			continue
+		}
+		delete(stableByName, u.Name)
+		if s.Image != u.Image {
+			diffs = append(diffs, fmt.Sprintf("%s %s image %s -> %s", kind, u.Name, s.Image, u.Image))
+		}
+		diffs = append(diffs, diffEnv(kind, u.Name, s.Env, u.Env)...)
+
+		sCopy, uCopy := s.DeepCopy(), u.DeepCopy()
+		sCopy.Image, sCopy.Env, uCopy.Image, uCopy.Env = "", nil, "", nil
+		if !apiequality.Semantic.DeepEqual(sCopy, uCopy) {
+			diffs = append(diffs, fmt.Sprintf("%s %s other fields changed", kind, u.Name))
+		}
+	}
+	for i := range stable {
+		if _, ok := stableByName[stable[i].Name]; ok {
+			diffs = append(diffs, fmt.Sprintf("%s %s removed", kind, stable[i].Name))
+		}
+	}
+	return diffs
+}
+
+func diffEnv(kind, container string, stable, updated []v1.EnvVar) []string {
+	stableByName := make(map[string]*v1.EnvVar, len(stable))
+	for i := range stable {
+		stableByName[stable[i].Name] = &stable[i]
+	}
+	var added, changed, removed []string
+	for i := range updated {
+		s, ok := stableByName[updated[i].Name]
+		switch {
+		case !ok:
+			added = append(added, updated[i].Name)
+		case !apiequality.Semantic.DeepEqual(s, &updated[i]):
+			changed = append(changed, updated[i].Name)
+		}
+		delete(stableByName, updated[i].Name)
+	}
+	for i := range stable {
+		if _, ok := stableByName[stable[i].Name]; ok {
+			removed = append(removed, stable[i].Name)
+		}
+	}
+
+	var diffs []string
+	for _, d := range []struct {
+		action string
+		names  []string
+	}{{"added", added}, {"changed", changed}, {"removed", removed}} {
+		if len(d.names) > 0 {
+			diffs = append(diffs, fmt.Sprintf("%s %s env %s %s", kind, container, strings.Join(d.names, ","), d.action))
+		}
+	}
+	return diffs
+}
+
+// summarizeTemplateDiff joins the diffs into a summary no longer than maxTemplateDiffLength,
+// the diffs that do not fit are counted at the end.
+func summarizeTemplateDiff(diffs []string) string {
+	summary := ""
+	for i, diff := range diffs {
+		next := diff
+		if i > 0 {
+			next = summary + "; " + diff
+		}
+		more := ""
+		if i < len(diffs)-1 {
+			more = fmt.Sprintf("; and %d more changes", len(diffs)-i-1)
+		}
+		if len(next)+len(more) > maxTemplateDiffLength {
+			if i == 0 {
+				return diff[:maxTemplateDiffLength-len(more)-3] + "..." + more
+			}
+			return fmt.Sprintf("%s; and %d more changes", summary, len(diffs)-i)
+		}
+		summary = next
+	}
+	return summary
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestDiffPodTemplates(t *testing.T) {
+	stable := &v1.PodTemplateSpec{}
+	stable.Labels = map[string]string{"app": "demo", apps.DefaultDeploymentUniqueLabelKey: "v1"}
+	stable.Spec.Containers = []v1.Container{{
+		Name:  "main",
+		Image: "demo:v1",
+		Env:   []v1.EnvVar{{Name: "FOO", Value: "foo"}, {Name: "BAR", Value: "bar"}},
+	}}
+
+	cases := []struct {
+		name   string
+		update func(template *v1.PodTemplateSpec)
+		expect []string
+	}{
+		{
+			name:   "only hash label changed",
+			update: func(template *v1.PodTemplateSpec) {},
+		},
+		{
+			name: "image only",
+			update: func(template *v1.PodTemplateSpec) {
+				template.Spec.Containers[0].Image = "demo:v2"
+			},
+			expect: []string{"container main image demo:v1 -> demo:v2"},
+		},
+		{
+			name: "env only",
+			update: func(template *v1.PodTemplateSpec) {
+				template.Spec.Containers[0].Env = []v1.EnvVar{{Name: "FOO", Value: "secret"}, {Name: "BAZ", Value: "baz"}}
+			},
+			expect: []string{"container main env BAZ added", "container main env FOO changed", "container main env BAR removed"},
+		},
+		{
+			name: "container added and other fields changed",
+			update: func(template *v1.PodTemplateSpec) {
+				template.Spec.Containers[0].Args = []string{"--debug"}
+				template.Spec.Containers = append(template.Spec.Containers, v1.Container{Name: "sidecar", Image: "sidecar:v1"})
+				template.Spec.InitContainers = []v1.Container{{Name: "init", Image: "init:v1"}}
+				template.Annotations = map[string]string{"foo": "bar"}
+			},
+			expect: []string{
+				"container main other fields changed",
+				"container sidecar added",
+				"init container init added",
+				"other pod template fields changed",
+			},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			updated := stable.DeepCopy()
+			updated.Labels[apps.DefaultDeploymentUniqueLabelKey] = "v2"
+			cs.update(updated)
+			if diffs := diffPodTemplates(stable, updated); !reflect.DeepEqual(diffs, cs.expect) {
+				t.Errorf("expect diffs %v, got %v", cs.expect, diffs)
+			}
+			if strings.Contains(strings.Join(diffPodTemplates(stable, updated), ";"), "secret") {
+				t.Errorf("expect env values not exposed")
+			}
+		})
+	}
+}
+
+func TestSummarizeTemplateDiff(t *testing.T) {
+	if summary := summarizeTemplateDiff([]string{"a", "b"}); summary != "a; b" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+
+	var diffs []string
+	for i := 0; i < 100; i++ {
+		diffs = append(diffs, fmt.Sprintf("container c%d image demo:v1 -> demo:v2", i))
+	}
+	summary := summarizeTemplateDiff(diffs)
+	if len(summary) > maxTemplateDiffLength || !strings.HasSuffix(summary, "more changes") {
+		t.Errorf("expect bounded summary, got %d: %q", len(summary), summary)
+	}
+
+	long := summarizeTemplateDiff([]string{strings.Repeat("x", 1000), "y"})
+	if len(long) > maxTemplateDiffLength || !strings.HasSuffix(long, "...; and 1 more changes") {
+		t.Errorf("expect truncated summary, got %d: %q", len(long), long)
+	}
+}
+
+func TestSyncDeploymentRecordsTemplateDiff(t *testing.T) {
+	d := newTestDeployment(2, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 2)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("100%"),
+	}
+	dc, client, recorder := newTestController(strategy, d, oldRS)
+
+	var diffEvents []string
+	for i := 0; i < 10; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		for _, event := range collectEvents(recorder) {
+			if strings.Contains(event, TemplateDiffReason) {
+				diffEvents = append(diffEvents, event)
+			}
+		}
+	}
+	if len(diffEvents) != 1 || !strings.Contains(diffEvents[0], "container main image demo:v1 -> demo:v2") {
+		t.Fatalf("expect template diff recorded once at rollout start, got %v", diffEvents)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+const (
+	// TestBatchReason is the reason of the readiness gate condition of pods in a test batch.
+	TestBatchReason = "TestBatch"
+	// TestBatchPromotedReason is the reason of the readiness gate condition of pods once
+	// the test batch is promoted.
+	TestBatchPromotedReason = "TestBatchPromoted"
+)
+
+// isTestBatch returns true if the new replica set is rolling a test batch, whose pods
+// are created with the readiness gate of test batch.
+func (dc *DeploymentController) isTestBatch(newRS *apps.ReplicaSet) bool {
+	return dc.strategy.TestBatch && newRS != nil && deploymentutil.HasTestBatchGate(&newRS.Spec.Template.Spec)
+}
+
+// syncTestBatchGates sets the readiness gate condition of the new pods, which is false to keep
+// them out of Service endpoints while in a test batch, and true once the batch is promoted.
+// The pods created later by the new replica set are handled in the following syncs, since the
+// replica set controller updates the status of replica set once new pods are created.
+func (dc *DeploymentController) syncTestBatchGates(ctx context.Context, newRS *apps.ReplicaSet) error {
+	if newRS == nil || !deploymentutil.HasTestBatchGate(&newRS.Spec.Template.Spec) {
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(newRS.Spec.Selector)
+	if err != nil {
+		return err
+	}
+	pods, err := dc.cachedPods(newRS.Namespace, selector)
+	if err != nil {
+		return err
+	}
+
+	status, reason := v1.ConditionTrue, TestBatchPromotedReason
+	if dc.strategy.TestBatch {
+		status, reason = v1.ConditionFalse, TestBatchReason
+	}
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(pod, newRS) || !deploymentutil.HasTestBatchGate(&pod.Spec) {
+			continue
+		}
+		if cond := getTestBatchCondition(pod); cond != nil && cond.Status == status {
+			continue
+		}
+		pod = pod.DeepCopy()
+		setTestBatchCondition(pod, v1.PodCondition{
+			Type:               rolloutsv1alpha1.TestBatchReadinessGate,
+			Status:             status,
+			Reason:             reason,
+			LastTransitionTime: metav1.NewTime(dc.clock.Now()),
+		})
+		if _, err = dc.client.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		dc.log().V(3).Info("Set test batch condition", "pod", klog.KObj(pod), "status", status)
+	}
+	return nil
+}
+
+func getTestBatchCondition(pod *v1.Pod) *v1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == rolloutsv1alpha1.TestBatchReadinessGate {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func setTestBatchCondition(pod *v1.Pod, condition v1.PodCondition) {
+	if cond := getTestBatchCondition(pod); cond != nil {
+		*cond = condition
+		return
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, condition)
+}
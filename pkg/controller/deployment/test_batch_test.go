@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+func TestSyncDeploymentWithTestBatch(t *testing.T) {
+	d := newTestDeployment(4, intstr.FromInt(1), intstr.FromInt(0))
+	oldRS := newTestReplicaSet(d, "demo:v1", 1, 4)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:  rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate: d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:     intstr.FromString("50%"),
+		TestBatch:     true,
+	}
+	dc, client, _ := newTestController(strategy, d, oldRS)
+
+	// syncWithPods creates the pods of new replica set like the replica set controller,
+	// and then syncs the deployment again.
+	syncWithPods := func() map[string]v1.ConditionStatus {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		rsList, err := dc.rsLister.ReplicaSets(d.Namespace).List(labels.Everything())
+		if err != nil {
+			t.Fatalf("failed to list replica sets: %v", err)
+		}
+		newRS := deploymentutil.FindNewReplicaSet(d, rsList)
+		if newRS == nil {
+			t.Fatalf("expect new replica set created")
+		}
+		podIndexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc})
+		for i := 0; i < int(*newRS.Spec.Replicas); i++ {
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            fmt.Sprintf("%s-%d", newRS.Name, i),
+					Namespace:       newRS.Namespace,
+					Labels:          newRS.Spec.Template.Labels,
+					OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(newRS, apps.SchemeGroupVersion.WithKind("ReplicaSet"))},
+				},
+				Spec: newRS.Spec.Template.Spec,
+			}
+			if latest, err := client.CoreV1().Pods(pod.Namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{}); err == nil {
+				pod = latest
+			} else if pod, err = client.CoreV1().Pods(pod.Namespace).Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("failed to create pod: %v", err)
+			}
+			_ = podIndexer.Add(pod)
+		}
+		dc.podLister = corelisters.NewPodLister(podIndexer)
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+
+		pods, err := client.CoreV1().Pods(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list pods: %v", err)
+		}
+		conditions := map[string]v1.ConditionStatus{}
+		for i := range pods.Items {
+			if cond := getTestBatchCondition(&pods.Items[i]); cond != nil {
+				conditions[pods.Items[i].Name] = cond.Status
+			}
+		}
+		return conditions
+	}
+
+	var conditions map[string]v1.ConditionStatus
+	for i := 0; i < 10; i++ {
+		conditions = syncWithPods()
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 4 || replicas["demo:v2"] != 2 {
+		t.Fatalf("expect test batch surged without scaling down old pods, got %v", replicas)
+	}
+	if rsList, _ := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{}); len(rsList.Items) != 2 {
+		t.Fatalf("expect the new replica set with readiness gate matched as new, got %d replica sets", len(rsList.Items))
+	}
+	if len(conditions) != 2 {
+		t.Fatalf("expect 2 pods in test batch, got %v", conditions)
+	}
+	for name, status := range conditions {
+		if status != v1.ConditionFalse {
+			t.Fatalf("expect pod %s kept out of endpoints, got %s", name, status)
+		}
+	}
+
+	// Promote the test batch to a real one.
+	dc.strategy.TestBatch = false
+	for i := 0; i < 10; i++ {
+		conditions = syncWithPods()
+	}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); replicas["demo:v1"] != 2 || replicas["demo:v2"] != 2 {
+		t.Fatalf("expect old pods replaced after promotion, got %v", replicas)
+	}
+	for name, status := range conditions {
+		if status != v1.ConditionTrue {
+			t.Fatalf("expect pod %s ready after promotion, got %s", name, status)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/integer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// tinyDeploymentPolicy returns the policy of strategy for deployment, or nil if the deployment
+// is not tiny or there is no such policy.
+func (dc *DeploymentController) tinyDeploymentPolicy(d *apps.Deployment) *rolloutsv1alpha1.TinyDeploymentPolicy {
+	if replicasOf(d) > rolloutsv1alpha1.TinyDeploymentMaxReplicas {
+		return nil
+	}
+	return dc.strategy.TinyDeployment
+}
+
+// replicasOf returns the replicas of deployment, which defaults to 1.
+func replicasOf(d *apps.Deployment) int32 {
+	if d.Spec.Replicas == nil {
+		return 1
+	}
+	return *d.Spec.Replicas
+}
+
+// partitionReplicasLimit returns the max replicas of the new replica set allowed by partition,
+// which updates none of the pods of a tiny deployment until 100% if it is all or nothing, and
+// otherwise updates at least the min canary replicas for a non-zero percentage.
+func (dc *DeploymentController) partitionReplicasLimit(partition intstr.IntOrString, d *apps.Deployment) int32 {
+	if partition.Type != intstr.String || partition.String() == "0%" {
+		return deploymentutil.NewRSReplicasLimit(partition, d)
+	}
+	if policy := dc.tinyDeploymentPolicy(d); policy != nil && policy.AllOrNothing && partition.String() != "100%" {
+		return 0
+	}
+
+	limit := deploymentutil.NewRSReplicasLimit(partition, d)
+	replicas := replicasOf(d)
+	minCanary := integer.Int32Min(dc.minCanaryReplicas(), replicas)
+	if partition.String() != "100%" && replicas > 1 {
+		// Never complete the rollout before 100%, as NewRSReplicasLimit does.
+		minCanary = integer.Int32Min(minCanary, replicas-1)
+	}
+	return integer.Int32Max(limit, minCanary)
+}
+
+// minCanaryReplicas returns the min canary replicas of strategy, which defaults to 1.
+func (dc *DeploymentController) minCanaryReplicas() int32 {
+	if dc.strategy.MinCanaryReplicas == nil {
+		return 1
+	}
+	return *dc.strategy.MinCanaryReplicas
+}
+
+// requireSurge makes the rolling update of a tiny deployment surge a new pod before scaling
+// down an old pod, if it is required by strategy.
+func (dc *DeploymentController) requireSurge(d *apps.Deployment) {
+	policy := dc.tinyDeploymentPolicy(d)
+	if policy == nil || !policy.RequireSurge {
+		return
+	}
+	if d.Spec.Strategy.RollingUpdate == nil {
+		d.Spec.Strategy.RollingUpdate = &apps.RollingUpdateDeployment{}
+	}
+	rollingUpdate := d.Spec.Strategy.RollingUpdate
+	maxUnavailable := intstr.FromInt(0)
+	rollingUpdate.MaxUnavailable = &maxUnavailable
+	if rollingUpdate.MaxSurge != nil {
+		if surge, err := intstr.GetScaledValueFromIntOrPercent(rollingUpdate.MaxSurge, int(replicasOf(d)), true); err == nil && surge > 0 {
+			return
+		}
+	}
+	maxSurge := intstr.FromInt(1)
+	rollingUpdate.MaxSurge = &maxSurge
+}
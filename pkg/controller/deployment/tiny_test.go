@@ -0,0 +1,254 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/integer"
+	"k8s.io/utils/pointer"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncTinyDeployment(t *testing.T) {
+	allOrNothing := &rolloutsv1alpha1.TinyDeploymentPolicy{AllOrNothing: true}
+	requireSurge := &rolloutsv1alpha1.TinyDeploymentPolicy{RequireSurge: true}
+	cases := []struct {
+		name               string
+		replicas           int32
+		partition          intstr.IntOrString
+		policy             *rolloutsv1alpha1.TinyDeploymentPolicy
+		expectReplicas     map[string]int32
+		expectMinAvailable int32
+	}{
+		{
+			name:           "1 replica with percentage rounded up",
+			replicas:       1,
+			partition:      intstr.FromString("30%"),
+			expectReplicas: map[string]int32{"demo:v1": 0, "demo:v2": 1},
+		},
+		{
+			name:               "1 replica all or nothing with percentage",
+			replicas:           1,
+			partition:          intstr.FromString("30%"),
+			policy:             allOrNothing,
+			expectReplicas:     map[string]int32{"demo:v1": 1, "demo:v2": 0},
+			expectMinAvailable: 1,
+		},
+		{
+			name:           "1 replica all or nothing with integer",
+			replicas:       1,
+			partition:      intstr.FromInt(1),
+			policy:         allOrNothing,
+			expectReplicas: map[string]int32{"demo:v1": 0, "demo:v2": 1},
+		},
+		{
+			name:               "1 replica require surge",
+			replicas:           1,
+			partition:          intstr.FromString("100%"),
+			policy:             requireSurge,
+			expectReplicas:     map[string]int32{"demo:v1": 0, "demo:v2": 1},
+			expectMinAvailable: 1,
+		},
+		{
+			name:               "2 replicas with percentage rounded up",
+			replicas:           2,
+			partition:          intstr.FromString("30%"),
+			expectReplicas:     map[string]int32{"demo:v1": 1, "demo:v2": 1},
+			expectMinAvailable: 1,
+		},
+		{
+			name:               "2 replicas all or nothing with percentage",
+			replicas:           2,
+			partition:          intstr.FromString("50%"),
+			policy:             allOrNothing,
+			expectReplicas:     map[string]int32{"demo:v1": 2, "demo:v2": 0},
+			expectMinAvailable: 2,
+		},
+		{
+			name:           "2 replicas all or nothing with 100%",
+			replicas:       2,
+			partition:      intstr.FromString("100%"),
+			policy:         allOrNothing,
+			expectReplicas: map[string]int32{"demo:v1": 0, "demo:v2": 2},
+		},
+		{
+			name:               "2 replicas require surge",
+			replicas:           2,
+			partition:          intstr.FromString("100%"),
+			policy:             requireSurge,
+			expectReplicas:     map[string]int32{"demo:v1": 0, "demo:v2": 2},
+			expectMinAvailable: 2,
+		},
+		{
+			name:           "3 replicas not tiny",
+			replicas:       3,
+			partition:      intstr.FromString("30%"),
+			policy:         allOrNothing,
+			expectReplicas: map[string]int32{"demo:v1": 2, "demo:v2": 1},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			// The rolling update alone would scale the old pods down before the new pods.
+			d := newTestDeployment(cs.replicas, intstr.FromInt(0), intstr.FromInt(1))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, cs.replicas)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 0)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:   rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:  d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:      cs.partition,
+				TinyDeployment: cs.policy,
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+
+			minAvailable := cs.replicas
+			for i := 0; i < 10; i++ {
+				latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get deployment: %v", err)
+				}
+				if err = dc.syncDeployment(context.TODO(), latest); err != nil {
+					t.Fatalf("failed to sync deployment: %v", err)
+				}
+				// The pods scaled down are gone at once, while the new pods are not available yet.
+				rsList, err := client.AppsV1().ReplicaSets(d.Namespace).List(context.TODO(), metav1.ListOptions{})
+				if err != nil {
+					t.Fatalf("failed to list replica sets: %v", err)
+				}
+				available := int32(0)
+				for _, rs := range rsList.Items {
+					available += integer.Int32Min(*rs.Spec.Replicas, rs.Status.AvailableReplicas)
+				}
+				minAvailable = integer.Int32Min(minAvailable, available)
+				settleReplicaSets(t, dc, client, d.Namespace)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expectReplicas) {
+				t.Fatalf("expect replicas %v, got %v", cs.expectReplicas, replicas)
+			}
+			if cs.expectMinAvailable > 0 && minAvailable < cs.expectMinAvailable {
+				t.Fatalf("expect at least %d available replicas during rollout, got %d", cs.expectMinAvailable, minAvailable)
+			}
+		})
+	}
+}
+
+func TestPlanTinyDeployment(t *testing.T) {
+	d := newTestDeployment(1, intstr.FromInt(0), intstr.FromInt(1))
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingUpdate:  d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		Partition:      intstr.FromString("100%"),
+		TinyDeployment: &rolloutsv1alpha1.TinyDeploymentPolicy{RequireSurge: true},
+	}
+	plan, err := PlanRollout(d, strategy)
+	if err != nil {
+		t.Fatalf("failed to plan rollout: %v", err)
+	}
+	if plan.MaxSurge != 1 || plan.MaxUnavailable != 0 {
+		t.Fatalf("expect surge required, got maxSurge %d maxUnavailable %d", plan.MaxSurge, plan.MaxUnavailable)
+	}
+}
+
+func TestSyncMinCanaryReplicas(t *testing.T) {
+	cases := []struct {
+		name           string
+		replicas       int32
+		partition      intstr.IntOrString
+		minCanary      *int32
+		expectReplicas map[string]int32
+	}{
+		{
+			name:           "default min canary",
+			replicas:       5,
+			partition:      intstr.FromString("10%"),
+			expectReplicas: map[string]int32{"demo:v1": 4, "demo:v2": 1},
+		},
+		{
+			name:           "min canary beyond percentage",
+			replicas:       5,
+			partition:      intstr.FromString("10%"),
+			minCanary:      pointer.Int32(2),
+			expectReplicas: map[string]int32{"demo:v1": 3, "demo:v2": 2},
+		},
+		{
+			name:           "percentage beyond min canary",
+			replicas:       5,
+			partition:      intstr.FromString("60%"),
+			minCanary:      pointer.Int32(2),
+			expectReplicas: map[string]int32{"demo:v1": 2, "demo:v2": 3},
+		},
+		{
+			name:           "min canary never completes the rollout",
+			replicas:       3,
+			partition:      intstr.FromString("10%"),
+			minCanary:      pointer.Int32(5),
+			expectReplicas: map[string]int32{"demo:v1": 1, "demo:v2": 2},
+		},
+		{
+			name:           "min canary of 1 replica",
+			replicas:       1,
+			partition:      intstr.FromString("10%"),
+			minCanary:      pointer.Int32(2),
+			expectReplicas: map[string]int32{"demo:v1": 0, "demo:v2": 1},
+		},
+		{
+			name:           "zero percentage",
+			replicas:       5,
+			partition:      intstr.FromString("0%"),
+			minCanary:      pointer.Int32(2),
+			expectReplicas: map[string]int32{"demo:v1": 5, "demo:v2": 0},
+		},
+		{
+			name:           "integer partition",
+			replicas:       5,
+			partition:      intstr.FromInt(1),
+			minCanary:      pointer.Int32(2),
+			expectReplicas: map[string]int32{"demo:v1": 4, "demo:v2": 1},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d := newTestDeployment(cs.replicas, intstr.FromInt(0), intstr.FromInt(1))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, cs.replicas)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 0)
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:      rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:     d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:         cs.partition,
+				MinCanaryReplicas: cs.minCanary,
+			}
+			dc, client, _ := newTestController(strategy, d, oldRS, newRS)
+
+			for i := 0; i < 10; i++ {
+				d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+			}
+			if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, cs.expectReplicas) {
+				t.Fatalf("expect replicas %v, got %v", cs.expectReplicas, replicas)
+			}
+			if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.ExpectedUpdatedReplicas != cs.expectReplicas["demo:v2"] {
+				t.Fatalf("expect %d expected updated replicas, got %+v", cs.expectReplicas["demo:v2"], extraStatus)
+			}
+		})
+	}
+}
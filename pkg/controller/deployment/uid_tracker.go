@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// uidTracker remembers the objects found in some state, e.g. skipped or paused, so that an event
+// is only emitted once they enter the state instead of on every reconcile. The objects are keyed
+// by name so that they can be forgotten once deleted, and an object recreated with the same name
+// is regarded as a new one by its UID. A nil uidTracker reports the state as entered every time.
+type uidTracker struct {
+	lock sync.Mutex
+	uids map[types.NamespacedName]types.UID
+}
+
+func newUIDTracker() *uidTracker {
+	return &uidTracker{uids: map[types.NamespacedName]types.UID{}}
+}
+
+// observe records whether the object is in the state, and returns true if it enters the state
+// for the first time since it was last observed out of it.
+func (t *uidTracker) observe(key types.NamespacedName, uid types.UID, in bool) bool {
+	if t == nil {
+		return in
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !in {
+		delete(t.uids, key)
+		return false
+	}
+	if existing, ok := t.uids[key]; ok && existing == uid {
+		return false
+	}
+	t.uids[key] = uid
+	return true
+}
+
+// forget removes the object, e.g. once it is deleted.
+func (t *uidTracker) forget(key types.NamespacedName) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.uids, key)
+}
+
+// len returns the number of objects remembered.
+func (t *uidTracker) len() int {
+	if t == nil {
+		return 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.uids)
+}
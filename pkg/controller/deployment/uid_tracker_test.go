@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestUIDTracker(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "demo"}
+	tracker := newUIDTracker()
+	steps := []struct {
+		name   string
+		uid    types.UID
+		in     bool
+		expect bool
+	}{
+		{name: "enter", uid: "a", in: true, expect: true},
+		{name: "stay", uid: "a", in: true, expect: false},
+		{name: "leave", uid: "a", in: false, expect: false},
+		{name: "enter again", uid: "a", in: true, expect: true},
+		{name: "recreated with the same name", uid: "b", in: true, expect: true},
+		{name: "stay after recreated", uid: "b", in: true, expect: false},
+	}
+	for _, step := range steps {
+		if entered := tracker.observe(key, step.uid, step.in); entered != step.expect {
+			t.Fatalf("%s: expect entered %v, got %v", step.name, step.expect, entered)
+		}
+	}
+	tracker.forget(key)
+	if tracker.len() != 0 {
+		t.Fatalf("expect nothing remembered once forgotten, got %d", tracker.len())
+	}
+
+	var nilTracker *uidTracker
+	if !nilTracker.observe(key, "a", true) || !nilTracker.observe(key, "a", true) || nilTracker.observe(key, "a", false) {
+		t.Fatalf("expect nil tracker to report the state as entered every time")
+	}
+	nilTracker.forget(key)
+}
+
+func TestReconcileForgetsDeletedDeployment(t *testing.T) {
+	dc, _, _ := newTestController(rolloutsv1alpha1.DeploymentStrategy{})
+	dc.canaryStyles, dc.pausedByAnnos, dc.ignoredRefs = newUIDTracker(), newUIDTracker(), newUIDTracker()
+	key := types.NamespacedName{Namespace: "default", Name: "deployment"}
+	dc.canaryStyles.observe(key, "uid", true)
+	dc.pausedByAnnos.observe(key, "uid", true)
+	dc.ignoredRefs.observe(key, "uid", true)
+
+	reader := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build()
+	r := &ReconcileDeployment{Client: reader, controllerFactory: (*controllerFactory)(dc)}
+	if _, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("failed to reconcile: %v", err)
+	}
+	if dc.canaryStyles.len() != 0 || dc.pausedByAnnos.len() != 0 || dc.ignoredRefs.len() != 0 {
+		t.Fatalf("expect deleted deployment forgotten, got %d canary styles, %d paused and %d ignored refs",
+			dc.canaryStyles.len(), dc.pausedByAnnos.len(), dc.ignoredRefs.len())
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"time"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+	"github.com/openkruise/rollouts/pkg/util"
+)
+
+const (
+	// BatchUnschedulableReason is added in a deployment event when some pods of its new replica set
+	// have been unschedulable for longer than the window, e.g. because of insufficient capacity.
+	BatchUnschedulableReason = "BatchUnschedulable"
+	// BatchUnschedulableRolledBackReason is added in a deployment event when it is rolled back
+	// because the pods of its new replica set are unschedulable.
+	BatchUnschedulableRolledBackReason = "BatchUnschedulableRolledBack"
+)
+
+// checkUnschedulableBatch emits a warning event if any pod of newRS has been unschedulable for longer
+// than the window of strategy, and rolls the deployment back to the latest old revision if the strategy
+// asks for it. The deployment is requeued once the next pending pod would exceed the window. It returns
+// true if the deployment is rolled back.
+func (dc *DeploymentController) checkUnschedulableBatch(ctx context.Context, d *apps.Deployment, newRS *apps.ReplicaSet, oldRSs []*apps.ReplicaSet) (bool, error) {
+	policy := dc.strategy.BatchUnschedulable
+	if policy == nil || newRS == nil {
+		return false, nil
+	}
+	window := time.Duration(policy.Seconds) * time.Second
+	count, wait, example, err := dc.countUnschedulablePods(newRS, window)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		dc.enqueueAfter(d, wait)
+	}
+	if count == 0 {
+		return false, nil
+	}
+	dc.log().Info("Found pods of new replica set unschedulable too long", "replicaSet", klog.KObj(newRS), "count", count, "window", window, "rollback", policy.Rollback)
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, BatchUnschedulableReason,
+		"%d pods of new replica set %s have been unschedulable for longer than %v, e.g. %s", count, newRS.Name, window, example)
+	if !policy.Rollback {
+		return false, nil
+	}
+
+	stableRS := latestReplicaSet(oldRSs)
+	if stableRS == nil {
+		return false, nil
+	}
+	stableRevision := stableRS.Labels[deploymentutil.TemplateHashLabelKey]
+	if err := dc.rollbackToRevision(ctx, d, oldRSs, stableRevision); err != nil {
+		return false, err
+	}
+	dc.eventRecorder.Eventf(d, v1.EventTypeWarning, BatchUnschedulableRolledBackReason,
+		"Rolled back to revision %s since pods of revision %s are unschedulable", stableRevision, newRS.Labels[deploymentutil.TemplateHashLabelKey])
+	return true, nil
+}
+
+// countUnschedulablePods returns the number of pending pods of newRS which have been unschedulable
+// since at least window ago, how long to wait until the next unschedulable pod exceeds the window,
+// and an example of them for the event.
+func (dc *DeploymentController) countUnschedulablePods(newRS *apps.ReplicaSet, window time.Duration) (int32, time.Duration, string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(newRS.Spec.Selector)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	pods, err := dc.cachedPods(newRS.Namespace, selector)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	now := dc.clock.Now()
+	count, wait, example := int32(0), time.Duration(0), ""
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(pod, newRS) || pod.DeletionTimestamp != nil || pod.Spec.NodeName != "" || pod.Status.Phase != v1.PodPending {
+			continue
+		}
+		_, cond := util.GetPodCondition(&pod.Status, v1.PodScheduled)
+		if cond == nil || cond.Status != v1.ConditionFalse || cond.Reason != v1.PodReasonUnschedulable {
+			continue
+		}
+		if pending := now.Sub(cond.LastTransitionTime.Time); pending < window {
+			if left := window - pending; wait == 0 || left < wait {
+				wait = left
+			}
+			continue
+		}
+		if count == 0 {
+			example = "pod " + pod.Name + ": " + cond.Message
+		}
+		count++
+	}
+	return count, wait, example, nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	testingclock "k8s.io/utils/clock/testing"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestSyncUnschedulableBatch(t *testing.T) {
+	cases := []struct {
+		name             string
+		policy           *rolloutsv1alpha1.DeploymentBatchUnschedulable
+		scheduled        bool
+		expectEvent      bool
+		expectRolledBack bool
+		expectRequeue    time.Duration
+	}{
+		{
+			name: "not detected by default",
+		},
+		{
+			name:          "unschedulable within window",
+			policy:        &rolloutsv1alpha1.DeploymentBatchUnschedulable{Seconds: 900},
+			expectRequeue: 5 * time.Minute,
+		},
+		{
+			name:        "unschedulable beyond window",
+			policy:      &rolloutsv1alpha1.DeploymentBatchUnschedulable{Seconds: 300},
+			expectEvent: true,
+		},
+		{
+			name:      "scheduled but pending",
+			policy:    &rolloutsv1alpha1.DeploymentBatchUnschedulable{Seconds: 300},
+			scheduled: true,
+		},
+		{
+			name:             "rolled back",
+			policy:           &rolloutsv1alpha1.DeploymentBatchUnschedulable{Seconds: 300, Rollback: true},
+			expectEvent:      true,
+			expectRolledBack: true,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			now := time.Now()
+			d := newTestDeployment(10, intstr.FromInt(2), intstr.FromInt(0))
+			oldRS := newTestReplicaSet(d, "demo:v1", 1, 10)
+			newRS := newTestReplicaSet(d, "demo:v2", 2, 2)
+			newRS.Status.ReadyReplicas, newRS.Status.AvailableReplicas = 0, 0
+			objects := []runtime.Object{d, oldRS, newRS}
+			for i := 0; i < 2; i++ {
+				pod := newTestPod(newRS, fmt.Sprintf("pending-%d", i))
+				pod.Status = v1.PodStatus{
+					Phase: v1.PodPending,
+					Conditions: []v1.PodCondition{{
+						Type:               v1.PodScheduled,
+						Status:             v1.ConditionFalse,
+						Reason:             v1.PodReasonUnschedulable,
+						Message:            "0/3 nodes are available: 3 Insufficient cpu.",
+						LastTransitionTime: metav1.NewTime(now.Add(-10 * time.Minute)),
+					}},
+				}
+				if cs.scheduled {
+					pod.Spec.NodeName = "node"
+					pod.Status.Conditions[0].Status = v1.ConditionTrue
+					pod.Status.Conditions[0].Reason = ""
+				}
+				objects = append(objects, pod)
+			}
+			strategy := rolloutsv1alpha1.DeploymentStrategy{
+				RollingStyle:       rolloutsv1alpha1.PartitionRollingStyleType,
+				RollingUpdate:      d.Spec.Strategy.RollingUpdate.DeepCopy(),
+				Partition:          intstr.FromString("50%"),
+				BatchUnschedulable: cs.policy,
+			}
+			dc, client, recorder := newTestController(strategy, objects...)
+			dc.clock.(*testingclock.FakeClock).SetTime(now)
+
+			if err := dc.syncDeployment(context.TODO(), d); err != nil {
+				t.Fatalf("failed to sync deployment: %v", err)
+			}
+			events := collectEvents(recorder)
+			if unschedulable := hasEvent(events, BatchUnschedulableReason); unschedulable != cs.expectEvent {
+				t.Fatalf("expect %s event %v, got %v", BatchUnschedulableReason, cs.expectEvent, events)
+			}
+			if rolledBack := hasEvent(events, BatchUnschedulableRolledBackReason); rolledBack != cs.expectRolledBack {
+				t.Fatalf("expect %s event %v, got %v", BatchUnschedulableRolledBackReason, cs.expectRolledBack, events)
+			}
+			latest, err := client.AppsV1().Deployments(d.Namespace).Get(context.TODO(), d.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get deployment: %v", err)
+			}
+			expectImage := "demo:v2"
+			if cs.expectRolledBack {
+				expectImage = "demo:v1"
+			}
+			if image := latest.Spec.Template.Spec.Containers[0].Image; image != expectImage {
+				t.Fatalf("expect deployment with image %s, got %s", expectImage, image)
+			}
+			if cs.expectRequeue > 0 && (dc.requeueAfter <= 0 || dc.requeueAfter > cs.expectRequeue) {
+				t.Fatalf("expect requeue within %v, got %v", cs.expectRequeue, dc.requeueAfter)
+			}
+		})
+	}
+}
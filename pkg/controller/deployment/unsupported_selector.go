@@ -0,0 +1,50 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"fmt"
+
+	apps "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// UnsupportedSelectorReason is added in a deployment event when it is refused to be processed because
+// of its selector, see checkSelector.
+const UnsupportedSelectorReason = "UnsupportedSelector"
+
+// checkSelector returns an error if the selector of deployment cannot tell its own pods apart, i.e.
+// it is missing, invalid, empty or does not match the pod template. The replica sets created with
+// such a selector may adopt the pods of others. The selector of apps/v1 Deployment is immutable,
+// so it is never changed under the replica sets once it passes the check.
+func checkSelector(deployment *apps.Deployment) error {
+	if deployment.Spec.Selector == nil {
+		return fmt.Errorf("selector is not set")
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector: %v", err)
+	}
+	if selector.Empty() {
+		return fmt.Errorf("empty selector matches all pods")
+	}
+	if !selector.Matches(labels.Set(deployment.Spec.Template.Labels)) {
+		return fmt.Errorf("selector %q does not match template labels", selector.String())
+	}
+	return nil
+}
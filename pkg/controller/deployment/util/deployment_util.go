@@ -35,6 +35,7 @@ import (
 	"k8s.io/klog/v2"
 	"k8s.io/utils/integer"
 
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
 	"github.com/openkruise/rollouts/pkg/util"
 )
 
@@ -78,6 +79,22 @@ const (
 	// ResumedDeployReason is added in a deployment when it is resumed. Useful for not failing accidentally
 	// deployments that paused amidst a rollout and are bounded by a deadline.
 	ResumedDeployReason = "DeploymentResumed"
+	// RolledBackReason is added in a deployment when it rolls back to the template of an existing old
+	// replica set, which is the same as the reason of the event emitted by the stock rollback.
+	RolledBackReason = "DeploymentRollback"
+	// PartitionHeldReason is added in a deployment when it is held at partition, i.e. the new replica set
+	// has been scaled up to partition and the rest pods are kept in old replica sets. Lack of progress
+	// shouldn't be estimated while a deployment is held at partition.
+	PartitionHeldReason = "DeploymentPartitionHeld"
+	//
+	// AdvancedRolloutProgressing:
+
+	// BatchProgressingReason is added in a deployment when its new replica set is rolling towards
+	// the partition of the current batch.
+	BatchProgressingReason = "BatchProgressing"
+	// PartitionCompleteReason is added in a deployment when its new replica set has reached the
+	// partition of the current batch, or the rollout is complete.
+	PartitionCompleteReason = "PartitionComplete"
 	//
 	// Available:
 
@@ -88,13 +105,25 @@ const (
 	MinimumReplicasUnavailable = "MinimumReplicasUnavailable"
 )
 
-// NewDeploymentCondition creates a new deployment condition.
-func NewDeploymentCondition(condType apps.DeploymentConditionType, status v1.ConditionStatus, reason, message string) *apps.DeploymentCondition {
+// AdvancedRolloutProgressing is the type of the deployment condition reporting how far the advanced
+// rollout has progressed, which is set beside the conditions of the stock Deployment.
+const AdvancedRolloutProgressing apps.DeploymentConditionType = "AdvancedRolloutProgressing"
+
+// InvalidRolloutStrategy is the type of the deployment condition set if the strategy annotation of
+// the deployment is malformed or invalid, so that it is not processed until the strategy is fixed.
+const InvalidRolloutStrategy apps.DeploymentConditionType = "InvalidRolloutStrategy"
+
+// WaitingForApproval is the type of the deployment condition set while the deployment is held at
+// a manual partition of strategy, until the partition is approved via annotation.
+const WaitingForApproval apps.DeploymentConditionType = "WaitingForApproval"
+
+// NewDeploymentCondition creates a new deployment condition updated at the given now.
+func NewDeploymentCondition(condType apps.DeploymentConditionType, status v1.ConditionStatus, reason, message string, now time.Time) *apps.DeploymentCondition {
 	return &apps.DeploymentCondition{
 		Type:               condType,
 		Status:             status,
-		LastUpdateTime:     metav1.Now(),
-		LastTransitionTime: metav1.Now(),
+		LastUpdateTime:     metav1.NewTime(now),
+		LastTransitionTime: metav1.NewTime(now),
 		Reason:             reason,
 		Message:            message,
 	}
@@ -126,6 +155,21 @@ func SetDeploymentCondition(status *apps.DeploymentStatus, condition apps.Deploy
 	status.Conditions = append(newConditions, condition)
 }
 
+// SetDeploymentConditionIfChanged is like SetDeploymentCondition, but the condition is updated if its
+// message is changed as well. The current condition is kept as it is, including lastUpdateTime, if
+// nothing is changed, so that the status is not updated on every sync.
+func SetDeploymentConditionIfChanged(status *apps.DeploymentStatus, condition apps.DeploymentCondition) {
+	currentCond := GetDeploymentCondition(*status, condition.Type)
+	if currentCond != nil && currentCond.Status == condition.Status && currentCond.Reason == condition.Reason && currentCond.Message == condition.Message {
+		return
+	}
+	if currentCond != nil && currentCond.Status == condition.Status {
+		condition.LastTransitionTime = currentCond.LastTransitionTime
+	}
+	newConditions := filterOutCondition(status.Conditions, condition.Type)
+	status.Conditions = append(newConditions, condition)
+}
+
 // RemoveDeploymentCondition removes the deployment condition with the provided type.
 func RemoveDeploymentCondition(status *apps.DeploymentStatus, condType apps.DeploymentConditionType) {
 	status.Conditions = filterOutCondition(status.Conditions, condType)
@@ -269,6 +313,28 @@ var annotationsToSkip = map[string]bool{
 	DesiredReplicasAnnotation:      true,
 	MaxReplicasAnnotation:          true,
 	apps.DeprecatedRollbackTo:      true,
+	// The nonce should not change the replica sets.
+	rolloutsv1alpha1.DeploymentResyncAnnotation: true,
+	// The batches are decided by the deployment, copying them would update the replica sets
+	// on each batch even if their sizes are already satisfied.
+	rolloutsv1alpha1.DeploymentStrategyAnnotation:    true,
+	rolloutsv1alpha1.DeploymentStrategyRefAnnotation: true,
+	rolloutsv1alpha1.DeploymentExtraStatusAnnotation: true,
+	rolloutsv1alpha1.DeploymentPromoteAnnotation:     true,
+	// The strategy status changes with the batches as well.
+	rolloutsv1alpha1.DeploymentStrategyStatusAnnotation: true,
+	// The hints merged are only recorded on the replica sets, never copied back to the deployment.
+	rolloutsv1alpha1.SchedulingHintsAnnotation: true,
+
+	// The confirmation only concerns the old replica sets.
+	rolloutsv1alpha1.DeploymentConfirmDrainAnnotation: true,
+	// The pause and dry run are only for the controller.
+	rolloutsv1alpha1.DeploymentPausedAnnotation: true,
+	rolloutsv1alpha1.DeploymentDryRunAnnotation: true,
+	// The rollback and the approval are only requests to the controller, which are removed
+	// once fulfilled.
+	rolloutsv1alpha1.DeploymentRollbackAnnotation:         true,
+	rolloutsv1alpha1.DeploymentApprovePartitionAnnotation: true,
 }
 
 // skipCopyAnnotation returns true if we should skip copying the annotation with the given annotation key
@@ -528,6 +594,11 @@ func ListPods(deployment *apps.Deployment, rsList []*apps.ReplicaSet, getPodList
 	return owned, nil
 }
 
+// TemplateHashLabelKey is the key of the label carrying the template hash, which is added to the
+// selector and template of each replica set to tell their pods apart. It defaults to the key of
+// the stock deployment controller, and can be changed for the teams managing their own one.
+var TemplateHashLabelKey = apps.DefaultDeploymentUniqueLabelKey
+
 // EqualIgnoreHash returns true if two given podTemplateSpec are equal, ignoring the diff in value of Labels[pod-template-hash]
 // We ignore pod-template-hash because:
 //  1. The hash result would be different upon podTemplateSpec API changes
@@ -537,16 +608,57 @@ func EqualIgnoreHash(template1, template2 *v1.PodTemplateSpec) bool {
 	t1Copy := template1.DeepCopy()
 	t2Copy := template2.DeepCopy()
 	// Remove hash labels from template.Labels before comparing
-	delete(t1Copy.Labels, apps.DefaultDeploymentUniqueLabelKey)
-	delete(t2Copy.Labels, apps.DefaultDeploymentUniqueLabelKey)
+	delete(t1Copy.Labels, TemplateHashLabelKey)
+	delete(t2Copy.Labels, TemplateHashLabelKey)
+	// Remove the readiness gate of test batch, which is added to the new replica set by us
+	RemoveTestBatchGate(&t1Copy.Spec)
+	RemoveTestBatchGate(&t2Copy.Spec)
+	// Ignore the fields which are only different because one of them is not defaulted yet
+	if NormalizeTemplateDefaults {
+		NormalizePodTemplate(t1Copy)
+		NormalizePodTemplate(t2Copy)
+	}
 	return apiequality.Semantic.DeepEqual(t1Copy, t2Copy)
 }
 
+// ComputeTemplateHash returns the pod-template-hash of template, which is computed over the
+// normalized template if NormalizeTemplateDefaults is true, so that the hash is the same no
+// matter whether the template is defaulted by apiserver or not.
+func ComputeTemplateHash(template *v1.PodTemplateSpec, collisionCount *int32) string {
+	if !NormalizeTemplateDefaults {
+		return util.ComputeHash(template, collisionCount)
+	}
+	normalized := template.DeepCopy()
+	NormalizePodTemplate(normalized)
+	return util.ComputeHash(normalized, collisionCount)
+}
+
+// HasTestBatchGate returns true if the pod spec has the readiness gate of test batch.
+func HasTestBatchGate(spec *v1.PodSpec) bool {
+	for _, gate := range spec.ReadinessGates {
+		if gate.ConditionType == rolloutsv1alpha1.TestBatchReadinessGate {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveTestBatchGate removes the readiness gate of test batch from the pod spec.
+func RemoveTestBatchGate(spec *v1.PodSpec) {
+	var gates []v1.PodReadinessGate
+	for _, gate := range spec.ReadinessGates {
+		if gate.ConditionType != rolloutsv1alpha1.TestBatchReadinessGate {
+			gates = append(gates, gate)
+		}
+	}
+	spec.ReadinessGates = gates
+}
+
 // FindNewReplicaSet returns the new RS this given deployment targets (the one with the same pod template).
 func FindNewReplicaSet(deployment *apps.Deployment, rsList []*apps.ReplicaSet) *apps.ReplicaSet {
 	sort.Sort(ReplicaSetsByCreationTimestamp(rsList))
 	for i := range rsList {
-		if EqualIgnoreHash(&rsList[i].Spec.Template, &deployment.Spec.Template) {
+		if EqualIgnoreHash(&rsList[i].Spec.Template, ReplicaSetTemplateOf(&deployment.Spec.Template, rsList[i])) {
 			// In rare cases, such as after cluster upgrades, Deployment may end up with
 			// having more than one new ReplicaSets that have the same template as its template,
 			// see https://github.com/kubernetes/kubernetes/issues/40415
@@ -652,13 +764,10 @@ func DeploymentProgressing(deployment *apps.Deployment, newStatus *apps.Deployme
 		newStatus.AvailableReplicas > deployment.Status.AvailableReplicas
 }
 
-// used for unit testing
-var nowFn = func() time.Time { return time.Now() }
-
 // DeploymentTimedOut considers a deployment to have timed out once its condition that reports progress
 // is older than progressDeadlineSeconds or a Progressing condition with a TimedOutReason reason already
-// exists.
-func DeploymentTimedOut(deployment *apps.Deployment, newStatus *apps.DeploymentStatus) bool {
+// exists. The given now is the current time of the clock used by the controller.
+func DeploymentTimedOut(deployment *apps.Deployment, newStatus *apps.DeploymentStatus, now time.Time) bool {
 	if !HasProgressDeadline(deployment) {
 		return false
 	}
@@ -692,7 +801,6 @@ func DeploymentTimedOut(deployment *apps.Deployment, newStatus *apps.DeploymentS
 	// progress or tried to create a replica set, or resumed a paused deployment and
 	// compare against progressDeadlineSeconds.
 	from := condition.LastUpdateTime
-	now := nowFn()
 	delta := time.Duration(*deployment.Spec.ProgressDeadlineSeconds) * time.Second
 	timedOut := from.Add(delta).Before(now)
 
@@ -449,6 +449,54 @@ func TestResolveFenceposts(t *testing.T) {
 			expectUnavailable: 1,
 			expectError:       false,
 		},
+		{
+			maxSurge:          newString("25%"),
+			maxUnavailable:    newString("25%"),
+			desired:           4,
+			expectSurge:       1,
+			expectUnavailable: 1,
+			expectError:       false,
+		},
+		{
+			maxSurge:          newString("30%"),
+			maxUnavailable:    newString("30%"),
+			desired:           4,
+			expectSurge:       2,
+			expectUnavailable: 1,
+			expectError:       false,
+		},
+		{
+			maxSurge:          newString("25%"),
+			maxUnavailable:    newString("25%"),
+			desired:           7,
+			expectSurge:       2,
+			expectUnavailable: 1,
+			expectError:       false,
+		},
+		{
+			maxSurge:          newString("50%"),
+			maxUnavailable:    newString("50%"),
+			desired:           7,
+			expectSurge:       4,
+			expectUnavailable: 3,
+			expectError:       false,
+		},
+		{
+			maxSurge:          newString("0%"),
+			maxUnavailable:    newString("10%"),
+			desired:           7,
+			expectSurge:       0,
+			expectUnavailable: 1,
+			expectError:       false,
+		},
+		{
+			maxSurge:          newString("25%"),
+			maxUnavailable:    newString("25%"),
+			desired:           10,
+			expectSurge:       3,
+			expectUnavailable: 2,
+			expectError:       false,
+		},
 	}
 
 	for num, test := range tests {
@@ -901,8 +949,8 @@ func TestDeploymentTimedOut(t *testing.T) {
 	tests := []struct {
 		name string
 
-		d     apps.Deployment
-		nowFn func() time.Time
+		d   apps.Deployment
+		now time.Time
 
 		expected bool
 	}{
@@ -910,28 +958,28 @@ func TestDeploymentTimedOut(t *testing.T) {
 			name: "nil progressDeadlineSeconds specified - no timeout",
 
 			d:        deployment(apps.DeploymentProgressing, v1.ConditionTrue, "", null, timeFn(1, 9)),
-			nowFn:    func() time.Time { return timeFn(1, 20) },
+			now:      timeFn(1, 20),
 			expected: false,
 		},
 		{
 			name: "infinite progressDeadlineSeconds specified - no timeout",
 
 			d:        deployment(apps.DeploymentProgressing, v1.ConditionTrue, "", &infinite, timeFn(1, 9)),
-			nowFn:    func() time.Time { return timeFn(1, 20) },
+			now:      timeFn(1, 20),
 			expected: false,
 		},
 		{
 			name: "progressDeadlineSeconds: 10s, now - started => 00:01:20 - 00:01:09 => 11s",
 
 			d:        deployment(apps.DeploymentProgressing, v1.ConditionTrue, "", &ten, timeFn(1, 9)),
-			nowFn:    func() time.Time { return timeFn(1, 20) },
+			now:      timeFn(1, 20),
 			expected: true,
 		},
 		{
 			name: "progressDeadlineSeconds: 10s, now - started => 00:01:20 - 00:01:11 => 9s",
 
 			d:        deployment(apps.DeploymentProgressing, v1.ConditionTrue, "", &ten, timeFn(1, 11)),
-			nowFn:    func() time.Time { return timeFn(1, 20) },
+			now:      timeFn(1, 20),
 			expected: false,
 		},
 		{
@@ -944,8 +992,7 @@ func TestDeploymentTimedOut(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			nowFn = test.nowFn
-			if got, exp := DeploymentTimedOut(&test.d, &test.d.Status), test.expected; got != exp {
+			if got, exp := DeploymentTimedOut(&test.d, &test.d.Status, test.now), test.expected; got != exp {
 				t.Errorf("expected timeout: %t, got: %t", exp, got)
 			}
 		})
@@ -1196,3 +1243,64 @@ func TestNewRSReplicasLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestNewRSReplicasLimitClamped(t *testing.T) {
+	cases := []struct {
+		name      string
+		partition intstr.IntOrString
+		replicas  int32
+		expect    int32
+	}{
+		{
+			name:      "absolute replicas",
+			partition: intstr.FromInt(3),
+			replicas:  10,
+			expect:    3,
+		},
+		{
+			name:      "absolute replicas clamped to replicas",
+			partition: intstr.FromInt(30),
+			replicas:  10,
+			expect:    10,
+		},
+		{
+			name:      "absolute replicas of scaled in deployment",
+			partition: intstr.FromInt(3),
+			replicas:  0,
+			expect:    0,
+		},
+		{
+			name:      "percentage",
+			partition: intstr.FromString("30%"),
+			replicas:  10,
+			expect:    3,
+		},
+		{
+			name:      "percentage rounded up",
+			partition: intstr.FromString("25%"),
+			replicas:  10,
+			expect:    3,
+		},
+		{
+			name:      "percentage kept below replicas",
+			partition: intstr.FromString("99%"),
+			replicas:  10,
+			expect:    9,
+		},
+		{
+			name:      "full percentage",
+			partition: intstr.FromString("100%"),
+			replicas:  10,
+			expect:    10,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			deployment := apps.Deployment{Spec: apps.DeploymentSpec{Replicas: pointer.Int32(cs.replicas)}}
+			if got := NewRSReplicasLimit(cs.partition, &deployment); got != cs.expect {
+				t.Fatalf("expect new replica set limit %d, got %d", cs.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+// MergeSchedulingHints appends hints to the preferred node affinity and topology spread
+// constraints of template, the existing ones of template are kept as they are. The constraints
+// whose topologyKey and whenUnsatisfiable are constrained by template already are left out, since
+// they cannot be duplicated. It returns the hints merged, or nil if nothing is merged.
+func MergeSchedulingHints(template *v1.PodTemplateSpec, hints *rolloutsv1alpha1.DeploymentSchedulingHints) *rolloutsv1alpha1.DeploymentSchedulingHints {
+	if hints == nil {
+		return nil
+	}
+	merged := &rolloutsv1alpha1.DeploymentSchedulingHints{}
+	spec := &template.Spec
+	if len(hints.PreferredNodeAffinity) > 0 {
+		if spec.Affinity == nil {
+			spec.Affinity = &v1.Affinity{}
+		}
+		if spec.Affinity.NodeAffinity == nil {
+			spec.Affinity.NodeAffinity = &v1.NodeAffinity{}
+		}
+		for _, term := range hints.PreferredNodeAffinity {
+			merged.PreferredNodeAffinity = append(merged.PreferredNodeAffinity, *term.DeepCopy())
+			spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+				spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, *term.DeepCopy())
+		}
+	}
+	for _, constraint := range hints.TopologySpreadConstraints {
+		if isTopologyConstrained(spec.TopologySpreadConstraints, &constraint) {
+			continue
+		}
+		merged.TopologySpreadConstraints = append(merged.TopologySpreadConstraints, *constraint.DeepCopy())
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, *constraint.DeepCopy())
+	}
+	if len(merged.PreferredNodeAffinity) == 0 && len(merged.TopologySpreadConstraints) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// isTopologyConstrained returns true if any of constraints has the same topologyKey and
+// whenUnsatisfiable as constraint.
+func isTopologyConstrained(constraints []v1.TopologySpreadConstraint, constraint *v1.TopologySpreadConstraint) bool {
+	for i := range constraints {
+		if constraints[i].TopologyKey == constraint.TopologyKey && constraints[i].WhenUnsatisfiable == constraint.WhenUnsatisfiable {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSchedulingHintsAnnotation records the hints merged into the template of rs, see
+// ReplicaSetTemplateOf for details.
+func SetSchedulingHintsAnnotation(rs *apps.ReplicaSet, hints *rolloutsv1alpha1.DeploymentSchedulingHints) {
+	if hints == nil {
+		return
+	}
+	data, err := json.Marshal(hints)
+	if err != nil {
+		klog.Errorf("Failed to marshal scheduling hints of replica set %v: %v", klog.KObj(rs), err)
+		return
+	}
+	if rs.Annotations == nil {
+		rs.Annotations = map[string]string{}
+	}
+	rs.Annotations[rolloutsv1alpha1.SchedulingHintsAnnotation] = string(data)
+}
+
+// ReplicaSetTemplateOf returns template with the scheduling hints recorded on rs merged, which
+// is what the template of rs is expected to be if rs is created from template. It returns
+// template itself if rs records no hints, so that the template of rs created without hints, or
+// by the native deployment controller, is compared with template as it is.
+func ReplicaSetTemplateOf(template *v1.PodTemplateSpec, rs *apps.ReplicaSet) *v1.PodTemplateSpec {
+	data := rs.Annotations[rolloutsv1alpha1.SchedulingHintsAnnotation]
+	if data == "" {
+		return template
+	}
+	hints := &rolloutsv1alpha1.DeploymentSchedulingHints{}
+	if err := json.Unmarshal([]byte(data), hints); err != nil {
+		klog.Warningf("Failed to unmarshal scheduling hints of replica set %v: %v", klog.KObj(rs), err)
+		return template
+	}
+	merged := template.DeepCopy()
+	MergeSchedulingHints(merged, hints)
+	return merged
+}
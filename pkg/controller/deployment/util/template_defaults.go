@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+// NormalizeTemplateDefaults decides whether pod templates are normalized by NormalizePodTemplate
+// before they are compared or hashed, so that a template which only differs in the fields
+// defaulted by apiserver will not be taken as a new revision.
+var NormalizeTemplateDefaults = true
+
+// NormalizePodTemplate sets the fields of template left empty to the defaults of apiserver,
+// which is the same as the defaulting of core/v1 pod templates.
+func NormalizePodTemplate(template *v1.PodTemplateSpec) {
+	spec := &template.Spec
+	if spec.DNSPolicy == "" {
+		spec.DNSPolicy = v1.DNSClusterFirst
+	}
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = v1.RestartPolicyAlways
+	}
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &v1.PodSecurityContext{}
+	}
+	if spec.TerminationGracePeriodSeconds == nil {
+		spec.TerminationGracePeriodSeconds = pointer.Int64(v1.DefaultTerminationGracePeriodSeconds)
+	}
+	if spec.SchedulerName == "" {
+		spec.SchedulerName = v1.DefaultSchedulerName
+	}
+	if spec.EnableServiceLinks == nil {
+		spec.EnableServiceLinks = pointer.Bool(v1.DefaultEnableServiceLinks)
+	}
+	for i := range spec.InitContainers {
+		normalizeContainer(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		normalizeContainer(&spec.Containers[i])
+	}
+	for i := range spec.Volumes {
+		normalizeVolume(&spec.Volumes[i])
+	}
+}
+
+func normalizeContainer(container *v1.Container) {
+	if container.ImagePullPolicy == "" {
+		container.ImagePullPolicy = defaultImagePullPolicy(container.Image)
+	}
+	if container.TerminationMessagePath == "" {
+		container.TerminationMessagePath = v1.TerminationMessagePathDefault
+	}
+	if container.TerminationMessagePolicy == "" {
+		container.TerminationMessagePolicy = v1.TerminationMessageReadFile
+	}
+	for i := range container.Ports {
+		if container.Ports[i].Protocol == "" {
+			container.Ports[i].Protocol = v1.ProtocolTCP
+		}
+	}
+	for i := range container.Env {
+		if from := container.Env[i].ValueFrom; from != nil && from.FieldRef != nil && from.FieldRef.APIVersion == "" {
+			from.FieldRef.APIVersion = "v1"
+		}
+	}
+	for _, probe := range []*v1.Probe{container.LivenessProbe, container.ReadinessProbe, container.StartupProbe} {
+		normalizeProbe(probe)
+	}
+}
+
+// defaultImagePullPolicy returns Always if the image is tagged latest or not tagged at all,
+// otherwise IfNotPresent.
+func defaultImagePullPolicy(image string) v1.PullPolicy {
+	if strings.Contains(image, "@") {
+		return v1.PullIfNotPresent
+	}
+	tag := ""
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		tag = image[i+1:]
+	}
+	if tag == "" || tag == "latest" {
+		return v1.PullAlways
+	}
+	return v1.PullIfNotPresent
+}
+
+func normalizeProbe(probe *v1.Probe) {
+	if probe == nil {
+		return
+	}
+	if probe.TimeoutSeconds == 0 {
+		probe.TimeoutSeconds = 1
+	}
+	if probe.PeriodSeconds == 0 {
+		probe.PeriodSeconds = 10
+	}
+	if probe.SuccessThreshold == 0 {
+		probe.SuccessThreshold = 1
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = 3
+	}
+	if action := probe.HTTPGet; action != nil {
+		if action.Path == "" {
+			action.Path = "/"
+		}
+		if action.Scheme == "" {
+			action.Scheme = v1.URISchemeHTTP
+		}
+	}
+}
+
+func normalizeVolume(volume *v1.Volume) {
+	source := &volume.VolumeSource
+	if (*source == v1.VolumeSource{}) {
+		source.EmptyDir = &v1.EmptyDirVolumeSource{}
+	}
+	if source.ConfigMap != nil && source.ConfigMap.DefaultMode == nil {
+		source.ConfigMap.DefaultMode = pointer.Int32(v1.ConfigMapVolumeSourceDefaultMode)
+	}
+	if source.Secret != nil && source.Secret.DefaultMode == nil {
+		source.Secret.DefaultMode = pointer.Int32(v1.SecretVolumeSourceDefaultMode)
+	}
+	if source.DownwardAPI != nil && source.DownwardAPI.DefaultMode == nil {
+		source.DownwardAPI.DefaultMode = pointer.Int32(v1.DownwardAPIVolumeSourceDefaultMode)
+	}
+	if source.Projected != nil && source.Projected.DefaultMode == nil {
+		source.Projected.DefaultMode = pointer.Int32(v1.ProjectedVolumeSourceDefaultMode)
+	}
+	if source.HostPath != nil && source.HostPath.Type == nil {
+		unset := v1.HostPathUnset
+		source.HostPath.Type = &unset
+	}
+}
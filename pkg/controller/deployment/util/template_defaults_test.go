@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+)
+
+func newSubmittedPodTemplate(image string) *v1.PodTemplateSpec {
+	return &v1.PodTemplateSpec{
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name:           "main",
+				Image:          image,
+				Ports:          []v1.ContainerPort{{ContainerPort: 80}},
+				ReadinessProbe: &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{Port: intstr.FromInt(80)}}},
+			}},
+			Volumes: []v1.Volume{{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{}}}},
+		},
+	}
+}
+
+// newDefaultedPodTemplate returns the template submitted by newSubmittedPodTemplate as stored by apiserver.
+func newDefaultedPodTemplate(image string, pullPolicy v1.PullPolicy) *v1.PodTemplateSpec {
+	template := newSubmittedPodTemplate(image)
+	spec := &template.Spec
+	spec.DNSPolicy = v1.DNSClusterFirst
+	spec.RestartPolicy = v1.RestartPolicyAlways
+	spec.SecurityContext = &v1.PodSecurityContext{}
+	spec.TerminationGracePeriodSeconds = pointer.Int64(30)
+	spec.SchedulerName = v1.DefaultSchedulerName
+	spec.EnableServiceLinks = pointer.Bool(true)
+	container := &spec.Containers[0]
+	container.ImagePullPolicy = pullPolicy
+	container.TerminationMessagePath = "/dev/termination-log"
+	container.TerminationMessagePolicy = v1.TerminationMessageReadFile
+	container.Ports[0].Protocol = v1.ProtocolTCP
+	container.ReadinessProbe.TimeoutSeconds = 1
+	container.ReadinessProbe.PeriodSeconds = 10
+	container.ReadinessProbe.SuccessThreshold = 1
+	container.ReadinessProbe.FailureThreshold = 3
+	container.ReadinessProbe.HTTPGet.Path = "/"
+	container.ReadinessProbe.HTTPGet.Scheme = v1.URISchemeHTTP
+	spec.Volumes[0].ConfigMap.DefaultMode = pointer.Int32(0644)
+	return template
+}
+
+func TestEqualIgnoreHashWithDefaults(t *testing.T) {
+	cases := []struct {
+		name      string
+		submitted *v1.PodTemplateSpec
+		stored    *v1.PodTemplateSpec
+		normalize bool
+		expected  bool
+	}{
+		{
+			name:      "only different in defaults",
+			submitted: newSubmittedPodTemplate("demo:v1"),
+			stored:    newDefaultedPodTemplate("demo:v1", v1.PullIfNotPresent),
+			normalize: true,
+			expected:  true,
+		},
+		{
+			name:      "only different in defaults of untagged image",
+			submitted: newSubmittedPodTemplate("registry:5000/demo"),
+			stored:    newDefaultedPodTemplate("registry:5000/demo", v1.PullAlways),
+			normalize: true,
+			expected:  true,
+		},
+		{
+			name:      "different in pull policy set explicitly",
+			submitted: newSubmittedPodTemplate("demo:latest"),
+			stored:    newDefaultedPodTemplate("demo:latest", v1.PullIfNotPresent),
+			normalize: true,
+			expected:  false,
+		},
+		{
+			name:      "different in image",
+			submitted: newSubmittedPodTemplate("demo:v2"),
+			stored:    newDefaultedPodTemplate("demo:v1", v1.PullIfNotPresent),
+			normalize: true,
+			expected:  false,
+		},
+		{
+			name:      "only different in defaults without normalizing",
+			submitted: newSubmittedPodTemplate("demo:v1"),
+			stored:    newDefaultedPodTemplate("demo:v1", v1.PullIfNotPresent),
+			expected:  false,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			defer func(normalize bool) { NormalizeTemplateDefaults = normalize }(NormalizeTemplateDefaults)
+			NormalizeTemplateDefaults = cs.normalize
+
+			if equal := EqualIgnoreHash(cs.submitted, cs.stored); equal != cs.expected {
+				t.Errorf("expect equal %v, got %v", cs.expected, equal)
+			}
+			if equal := ComputeTemplateHash(cs.submitted, nil) == ComputeTemplateHash(cs.stored, nil); equal != cs.expected {
+				t.Errorf("expect equal hash %v, got %v", cs.expected, equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"context"
+	"sort"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/utils/integer"
+
+	deploymentutil "github.com/openkruise/rollouts/pkg/controller/deployment/util"
+)
+
+// variantReplicas splits replicas among the replica sets of rsList in proportion to the weights of
+// their pod-template-hashes, keyed by the names of replica sets. The replica sets not weighted get 0.
+// The rounded down shares leave a few replicas, which are given one each to the replica sets with
+// the largest fractions, ties broken by the larger weights and then the names, so that the shares
+// always add up to replicas. It returns nil if no replica set has a positive weight.
+func variantReplicas(replicas int32, weights map[string]int32, rsList []*apps.ReplicaSet) map[string]int32 {
+	type variant struct {
+		name     string
+		weight   int64
+		fraction int64
+	}
+	if len(weights) == 0 {
+		return nil
+	}
+	sorted := deploymentutil.FilterReplicaSets(rsList, func(rs *apps.ReplicaSet) bool { return rs != nil })
+	sort.Sort(sort.Reverse(deploymentutil.ReplicaSetsByRevision(sorted)))
+	// Only the latest replica set of each hash is weighted, in case they are not unique.
+	weighted := map[string]bool{}
+	var variants []*variant
+	total := int64(0)
+	for _, rs := range sorted {
+		hash := rs.Labels[deploymentutil.TemplateHashLabelKey]
+		weight, ok := weights[hash]
+		if !ok || weight <= 0 || weighted[hash] {
+			continue
+		}
+		weighted[hash] = true
+		variants = append(variants, &variant{name: rs.Name, weight: int64(weight)})
+		total += int64(weight)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	shares := make(map[string]int32, len(sorted))
+	for _, rs := range sorted {
+		shares[rs.Name] = 0
+	}
+	left := replicas
+	for _, v := range variants {
+		share := int64(replicas) * v.weight
+		shares[v.name] = int32(share / total)
+		v.fraction = share % total
+		left -= shares[v.name]
+	}
+	sort.SliceStable(variants, func(i, j int) bool {
+		if variants[i].fraction != variants[j].fraction {
+			return variants[i].fraction > variants[j].fraction
+		}
+		if variants[i].weight != variants[j].weight {
+			return variants[i].weight > variants[j].weight
+		}
+		return variants[i].name < variants[j].name
+	})
+	for i := 0; left > 0; i++ {
+		shares[variants[i%len(variants)].name]++
+		left--
+	}
+	return shares
+}
+
+// rolloutVariants scales each replica set to its share of the variant weights, instead of rolling
+// the new replica set against partition. The replica sets below their shares are scaled up first
+// within maxSurge, then those above are scaled down within maxUnavailable, the same as rolling. All
+// replica sets are held as they are if none of them is weighted, rather than scaled down to 0.
+func (dc *DeploymentController) rolloutVariants(ctx context.Context, d *apps.Deployment, rsList []*apps.ReplicaSet) error {
+	newRS, oldRSs, err := dc.getAllReplicaSetsAndSyncRevision(ctx, d, rsList, true)
+	if err != nil {
+		return err
+	}
+	allRSs := append(oldRSs, newRS)
+	replicas := *(d.Spec.Replicas)
+	shares := variantReplicas(replicas, dc.strategy.VariantWeights, allRSs)
+	if shares == nil {
+		dc.log().Info("No replica set is weighted by variants, hold all replica sets as they are")
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+	}
+
+	sorted := deploymentutil.FilterReplicaSets(allRSs, func(rs *apps.ReplicaSet) bool { return rs != nil })
+	sort.Sort(deploymentutil.ReplicaSetsByRevision(sorted))
+
+	// Scale up, if we can.
+	surge := replicas + deploymentutil.MaxSurge(*d) - deploymentutil.GetReplicaCountForReplicaSets(allRSs)
+	scaledUp := false
+	for _, rs := range sorted {
+		current := *(rs.Spec.Replicas)
+		if surge <= 0 || shares[rs.Name] <= current {
+			continue
+		}
+		up := integer.Int32Min(shares[rs.Name]-current, surge)
+		scaled, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, rs, current+up, d, auditReasonVariantWeights)
+		if err != nil {
+			return err
+		}
+		surge -= up
+		scaledUp = scaledUp || scaled
+	}
+	if scaledUp {
+		return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+	}
+
+	// Scale down, if we can. The unavailable pods can always be scaled down, since they do not
+	// serve anyway.
+	minAvailable := replicas - deploymentutil.MaxUnavailable(*d)
+	budget := integer.Int32Max(deploymentutil.GetAvailableReplicaCountForReplicaSets(allRSs)-minAvailable, 0)
+	for _, rs := range sorted {
+		current := *(rs.Spec.Replicas)
+		if shares[rs.Name] >= current {
+			continue
+		}
+		unavailable := integer.Int32Max(current-rs.Status.AvailableReplicas, 0)
+		down := integer.Int32Min(current-shares[rs.Name], budget+unavailable)
+		if down <= 0 {
+			continue
+		}
+		if _, _, err := dc.scaleReplicaSetAndRecordEvent(ctx, rs, current-down, d, auditReasonVariantWeights); err != nil {
+			return err
+		}
+		budget -= integer.Int32Max(down-unavailable, 0)
+	}
+	return dc.syncRolloutStatus(ctx, allRSs, newRS, d)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deployment
+
+import (
+	"reflect"
+	"testing"
+
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
+)
+
+func TestVariantReplicas(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(0))
+	rsList := []*apps.ReplicaSet{
+		newTestReplicaSet(d, "demo:v0", 1, 0),
+		newTestReplicaSet(d, "demo:v1", 2, 10),
+		newTestReplicaSet(d, "demo:v3", 3, 0),
+		newTestReplicaSet(d, "demo:v2", 4, 0),
+	}
+	abc := map[string]int32{"demo-v1": 60, "demo-v3": 30, "demo-v2": 10}
+	cases := []struct {
+		name     string
+		replicas int32
+		weights  map[string]int32
+		expect   map[string]int32
+	}{
+		{
+			name:     "exact split",
+			replicas: 10,
+			weights:  abc,
+			expect:   map[string]int32{"deployment-demo-v0": 0, "deployment-demo-v1": 6, "deployment-demo-v3": 3, "deployment-demo-v2": 1},
+		},
+		{
+			name:     "remainder to the largest fraction",
+			replicas: 11,
+			weights:  abc,
+			expect:   map[string]int32{"deployment-demo-v0": 0, "deployment-demo-v1": 7, "deployment-demo-v3": 3, "deployment-demo-v2": 1},
+		},
+		{
+			name:     "remainders to several fractions",
+			replicas: 7,
+			weights:  abc,
+			expect:   map[string]int32{"deployment-demo-v0": 0, "deployment-demo-v1": 4, "deployment-demo-v3": 2, "deployment-demo-v2": 1},
+		},
+		{
+			name:     "smallest variant rounded to 0",
+			replicas: 3,
+			weights:  abc,
+			expect:   map[string]int32{"deployment-demo-v0": 0, "deployment-demo-v1": 2, "deployment-demo-v3": 1, "deployment-demo-v2": 0},
+		},
+		{
+			name:     "tie broken by larger weight",
+			replicas: 1,
+			weights:  map[string]int32{"demo-v1": 50, "demo-v3": 50, "demo-v2": 100},
+			expect:   map[string]int32{"deployment-demo-v0": 0, "deployment-demo-v1": 0, "deployment-demo-v3": 0, "deployment-demo-v2": 1},
+		},
+		{
+			name:     "tie broken by name",
+			replicas: 1,
+			weights:  map[string]int32{"demo-v1": 50, "demo-v3": 50},
+			expect:   map[string]int32{"deployment-demo-v0": 0, "deployment-demo-v1": 1, "deployment-demo-v3": 0, "deployment-demo-v2": 0},
+		},
+		{
+			name:     "missing variant ignored",
+			replicas: 9,
+			weights:  map[string]int32{"demo-v1": 60, "demo-v3": 30, "demo-v4": 10},
+			expect:   map[string]int32{"deployment-demo-v0": 0, "deployment-demo-v1": 6, "deployment-demo-v3": 3, "deployment-demo-v2": 0},
+		},
+		{
+			name:     "no variant weighted",
+			replicas: 10,
+			weights:  map[string]int32{"demo-v4": 100, "demo-v1": 0},
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if got := variantReplicas(cs.replicas, cs.weights, rsList); !reflect.DeepEqual(got, cs.expect) {
+				t.Fatalf("expect shares %v, got %v", cs.expect, got)
+			}
+		})
+	}
+}
+
+func TestSyncDeploymentWithVariantWeights(t *testing.T) {
+	d := newTestDeployment(10, intstr.FromInt(1), intstr.FromInt(1))
+	unweightedRS := newTestReplicaSet(d, "demo:v0", 1, 2)
+	stableRS := newTestReplicaSet(d, "demo:v1", 2, 8)
+	canaryRS := newTestReplicaSet(d, "demo:v3", 3, 0)
+	newRS := newTestReplicaSet(d, "demo:v2", 4, 0)
+	strategy := rolloutsv1alpha1.DeploymentStrategy{
+		RollingStyle:   rolloutsv1alpha1.PartitionRollingStyleType,
+		RollingUpdate:  d.Spec.Strategy.RollingUpdate.DeepCopy(),
+		VariantWeights: map[string]int32{"demo-v1": 60, "demo-v3": 30, "demo-v2": 10},
+	}
+	dc, client, _ := newTestController(strategy, d, unweightedRS, stableRS, canaryRS, newRS)
+
+	for i := 0; i < 20; i++ {
+		d = syncAndSettle(t, dc, client, d.Namespace, d.Name)
+		total := int32(0)
+		for _, replicas := range getReplicaSetReplicas(t, client, d.Namespace) {
+			total += replicas
+		}
+		if total > 11 || total < 9 {
+			t.Fatalf("expect replicas within maxSurge and maxUnavailable, got %v", getReplicaSetReplicas(t, client, d.Namespace))
+		}
+	}
+	expect := map[string]int32{"demo:v0": 0, "demo:v1": 6, "demo:v3": 3, "demo:v2": 1}
+	if replicas := getReplicaSetReplicas(t, client, d.Namespace); !reflect.DeepEqual(replicas, expect) {
+		t.Fatalf("expect replicas %v split by weights, got %v", expect, replicas)
+	}
+	if extraStatus := getExtraStatus(d); extraStatus == nil || extraStatus.ExpectedUpdatedReplicas != 1 {
+		t.Fatalf("expect the share of new replica set exposed, got %+v", extraStatus)
+	}
+}
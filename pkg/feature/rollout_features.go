@@ -28,11 +28,21 @@ const (
 	RolloutHistoryGate featuregate.Feature = "RolloutHistory"
 	// AdvancedDeploymentGate enable advanced deployment controller.
 	AdvancedDeploymentGate featuregate.Feature = "AdvancedDeployment"
+	// AdvancedDeploymentDryRunGate enable the dry-run annotation of advanced deployment, with which
+	// the scaling of replica sets is only reported instead of being done.
+	AdvancedDeploymentDryRunGate featuregate.Feature = "AdvancedDeploymentDryRun"
+	// AdvancedDeploymentPodlessModeGate disable caching pods in advanced deployment controller to
+	// save memory on large clusters. The availability of replica sets is then taken from their
+	// status only, which may count the pods ready for less than minReadySeconds of deployment,
+	// and the strategies inspecting pods, e.g. batchSoak and testBatch, are not supported.
+	AdvancedDeploymentPodlessModeGate featuregate.Feature = "AdvancedDeploymentPodlessMode"
 )
 
 var defaultFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
-	RolloutHistoryGate:     {Default: false, PreRelease: featuregate.Alpha},
-	AdvancedDeploymentGate: {Default: false, PreRelease: featuregate.Alpha},
+	RolloutHistoryGate:                {Default: false, PreRelease: featuregate.Alpha},
+	AdvancedDeploymentGate:            {Default: false, PreRelease: featuregate.Alpha},
+	AdvancedDeploymentDryRunGate:      {Default: false, PreRelease: featuregate.Alpha},
+	AdvancedDeploymentPodlessModeGate: {Default: false, PreRelease: featuregate.Alpha},
 }
 
 func init() {
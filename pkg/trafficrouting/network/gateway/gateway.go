@@ -15,10 +15,12 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"reflect"
 
 	rolloutv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
 	"github.com/openkruise/rollouts/pkg/trafficrouting/network"
+	"github.com/openkruise/rollouts/pkg/util"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/retry"
@@ -28,6 +30,10 @@ import (
 	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 )
 
+// OriginalWeightsAnnotation records the weights of stable service in each rule of the HTTPRoute
+// before the canary routes are set, so that they are restored once the rollout is finalised.
+const OriginalWeightsAnnotation = "rollouts.kruise.io/original-backend-weights"
+
 type Config struct {
 	RolloutName   string
 	RolloutNs     string
@@ -61,8 +67,10 @@ func (r *gatewayController) EnsureRoutes(ctx context.Context, weight *int32, mat
 	if err != nil {
 		return false, err
 	}
-	// desired route
-	desiredRule := r.buildDesiredHTTPRoute(httpRoute.Spec.Rules, weight, matches)
+	// desired route, which is built from the original rules, so that the canary rules of the
+	// previous step are cleaned up, e.g. the header matches are replaced by weights.
+	originalRule := r.buildRestoredHTTPRoute(httpRoute.Spec.Rules, getOriginalWeights(&httpRoute))
+	desiredRule := r.buildDesiredHTTPRoute(originalRule, weight, matches)
 	if reflect.DeepEqual(httpRoute.Spec.Rules, desiredRule) {
 		return true, nil
 	}
@@ -73,13 +81,14 @@ func (r *gatewayController) EnsureRoutes(ctx context.Context, weight *int32, mat
 			klog.Errorf("error getting updated httpRoute(%s/%s) from client", httpRoute.Namespace, httpRoute.Name)
 			return err
 		}
+		r.recordOriginalWeights(routeClone)
 		routeClone.Spec.Rules = desiredRule
 		return r.Client.Update(context.TODO(), routeClone)
 	}); err != nil {
 		klog.Errorf("update rollout(%s/%s) httpRoute(%s) failed: %s", r.conf.RolloutNs, r.conf.RolloutName, httpRoute.Name, err.Error())
 		return false, err
 	}
-	klog.Infof("rollout(%s/%s) set HTTPRoute(name:%s weight:%d) success", r.conf.RolloutNs, r.conf.RolloutName, *r.conf.TrafficConf.HTTPRouteName, *weight)
+	klog.Infof("rollout(%s/%s) set HTTPRoute(name:%s weight:%s matches:%s) success", r.conf.RolloutNs, r.conf.RolloutName, *r.conf.TrafficConf.HTTPRouteName, util.DumpJSON(weight), util.DumpJSON(matches))
 	return false, nil
 }
 
@@ -94,8 +103,9 @@ func (r *gatewayController) Finalise(ctx context.Context) error {
 		return err
 	}
 	// desired rule
-	desiredRule := r.buildDesiredHTTPRoute(httpRoute.Spec.Rules, utilpointer.Int32(-1), nil)
-	if reflect.DeepEqual(httpRoute.Spec.Rules, desiredRule) {
+	desiredRule := r.buildRestoredHTTPRoute(httpRoute.Spec.Rules, getOriginalWeights(httpRoute))
+	_, recorded := httpRoute.Annotations[OriginalWeightsAnnotation]
+	if reflect.DeepEqual(httpRoute.Spec.Rules, desiredRule) && !recorded {
 		return nil
 	}
 	routeClone := &gatewayv1alpha2.HTTPRoute{}
@@ -104,7 +114,8 @@ func (r *gatewayController) Finalise(ctx context.Context) error {
 			klog.Errorf("error getting updated httpRoute(%s/%s) from client", httpRoute.Namespace, httpRoute.Name)
 			return err
 		}
-		routeClone.Spec.Rules = desiredRule
+		routeClone.Spec.Rules = r.buildRestoredHTTPRoute(routeClone.Spec.Rules, getOriginalWeights(routeClone))
+		delete(routeClone.Annotations, OriginalWeightsAnnotation)
 		return r.Client.Update(context.TODO(), routeClone)
 	}); err != nil {
 		klog.Errorf("update rollout(%s/%s) httpRoute(%s) failed: %s", r.conf.RolloutNs, r.conf.RolloutName, httpRoute.Name, err.Error())
@@ -115,23 +126,10 @@ func (r *gatewayController) Finalise(ctx context.Context) error {
 }
 
 func (r *gatewayController) buildDesiredHTTPRoute(rules []gatewayv1alpha2.HTTPRouteRule, weight *int32, matches []rolloutv1alpha1.HttpRouteMatch) []gatewayv1alpha2.HTTPRouteRule {
-	var desired []gatewayv1alpha2.HTTPRouteRule
 	// Only when finalize method parameter weight=-1,
 	// then we need to remove the canary route policy and restore to the original configuration
 	if weight != nil && *weight == -1 {
-		for i := range rules {
-			rule := rules[i]
-			filterOutServiceBackendRef(&rule, r.conf.CanaryService)
-			_, stableRef := getServiceBackendRef(rule, r.conf.StableService)
-			if stableRef != nil {
-				stableRef.Weight = utilpointer.Int32(1)
-				setServiceBackendRef(&rule, *stableRef)
-			}
-			if len(rule.BackendRefs) != 0 {
-				desired = append(desired, rule)
-			}
-		}
-		return desired
+		return r.buildRestoredHTTPRoute(rules, nil)
 		// according to the Gateway API definition, weight and headers cannot be supported at the same time.
 		// A/B Testing, according to headers. current only support one match
 	} else if len(matches) > 0 {
@@ -141,6 +139,74 @@ func (r *gatewayController) buildDesiredHTTPRoute(rules []gatewayv1alpha2.HTTPRo
 	return r.buildCanaryWeightHttpRoutes(rules, weight)
 }
 
+// buildRestoredHTTPRoute removes the canary rules and the canary service from rules, and restores
+// the weights of stable service in the rules split with canary to the original ones, which are
+// indexed by the rules except the canary ones. The weights are reset to 1 if the original ones were
+// not recorded, e.g. the canary routes were set by an older version or the rule is added since.
+func (r *gatewayController) buildRestoredHTTPRoute(rules []gatewayv1alpha2.HTTPRouteRule, originalWeights []*int32) []gatewayv1alpha2.HTTPRouteRule {
+	var desired []gatewayv1alpha2.HTTPRouteRule
+	for i := range rules {
+		rule := rules[i]
+		if _, canaryRef := getServiceBackendRef(rule, r.conf.CanaryService); canaryRef == nil {
+			desired = append(desired, rule)
+			continue
+		}
+		filterOutServiceBackendRef(&rule, r.conf.CanaryService)
+		if len(rule.BackendRefs) == 0 {
+			continue
+		}
+		index := len(desired)
+		_, stableRef := getServiceBackendRef(rule, r.conf.StableService)
+		if stableRef != nil {
+			if index < len(originalWeights) {
+				stableRef.Weight = originalWeights[index]
+			} else {
+				stableRef.Weight = utilpointer.Int32(1)
+			}
+			setServiceBackendRef(&rule, *stableRef)
+		}
+		desired = append(desired, rule)
+	}
+	return desired
+}
+
+// recordOriginalWeights records the weights of stable service in each rule of route into its
+// annotations, unless they have been recorded or the canary routes have been set already.
+func (r *gatewayController) recordOriginalWeights(route *gatewayv1alpha2.HTTPRoute) {
+	if _, ok := route.Annotations[OriginalWeightsAnnotation]; ok {
+		return
+	}
+	weights := make([]*int32, len(route.Spec.Rules))
+	for i := range route.Spec.Rules {
+		if _, canaryRef := getServiceBackendRef(route.Spec.Rules[i], r.conf.CanaryService); canaryRef != nil {
+			return
+		}
+		if _, stableRef := getServiceBackendRef(route.Spec.Rules[i], r.conf.StableService); stableRef != nil {
+			weights[i] = stableRef.Weight
+		}
+	}
+	data, _ := json.Marshal(weights)
+	if route.Annotations == nil {
+		route.Annotations = map[string]string{}
+	}
+	route.Annotations[OriginalWeightsAnnotation] = string(data)
+}
+
+// getOriginalWeights returns the weights recorded by recordOriginalWeights, or nil if they are not
+// recorded or malformed.
+func getOriginalWeights(route *gatewayv1alpha2.HTTPRoute) []*int32 {
+	data, ok := route.Annotations[OriginalWeightsAnnotation]
+	if !ok {
+		return nil
+	}
+	weights := []*int32{}
+	if err := json.Unmarshal([]byte(data), &weights); err != nil {
+		klog.Warningf("httpRoute(%s/%s) has malformed annotation %s: %s", route.Namespace, route.Name, OriginalWeightsAnnotation, err.Error())
+		return nil
+	}
+	return weights
+}
+
 func (r *gatewayController) buildCanaryHeaderHttpRoutes(rules []gatewayv1alpha2.HTTPRouteRule, headers []gatewayv1alpha2.HTTPHeaderMatch) []gatewayv1alpha2.HTTPRouteRule {
 	var desired []gatewayv1alpha2.HTTPRouteRule
 	var canarys []gatewayv1alpha2.HTTPRouteRule
@@ -153,12 +219,18 @@ func (r *gatewayController) buildCanaryHeaderHttpRoutes(rules []gatewayv1alpha2.
 		if _, stableRef := getServiceBackendRef(rule, r.conf.StableService); stableRef == nil {
 			continue
 		}
-		// according to stable rule to create canary rule
+		// according to stable rule to create canary rule, whose matches are the ones of stable rule
+		// plus the canary headers, so that it takes precedence over the stable rule.
 		canaryRule := rule.DeepCopy()
 		_, canaryRef := getServiceBackendRef(*canaryRule, r.conf.StableService)
 		canaryRef.Name = gatewayv1alpha2.ObjectName(r.conf.CanaryService)
 		canaryRule.BackendRefs = []gatewayv1alpha2.HTTPBackendRef{*canaryRef}
-		// set canary headers in httpRoute
+		// set canary headers in httpRoute, a rule without matches matches all the requests,
+		// which is the same as the prefix match of "/".
+		if len(canaryRule.Matches) == 0 {
+			pathPrefix := gatewayv1alpha2.PathMatchPathPrefix
+			canaryRule.Matches = []gatewayv1alpha2.HTTPRouteMatch{{Path: &gatewayv1alpha2.HTTPPathMatch{Type: &pathPrefix, Value: utilpointer.String("/")}}}
+		}
 		for j := range canaryRule.Matches {
 			match := &canaryRule.Matches[j]
 			match.Headers = append(match.Headers, headers...)
@@ -204,15 +276,24 @@ func generateCanaryWeight(canaryPercent int32) (stableWeight int32, canaryWeight
 func getServiceBackendRef(rule gatewayv1alpha2.HTTPRouteRule, serviceName string) (int, *gatewayv1alpha2.HTTPBackendRef) {
 	for i := range rule.BackendRefs {
 		ref := rule.BackendRefs[i]
-		if ref.Kind != nil && *ref.Kind == "Service" && string(ref.Name) == serviceName {
+		if isServiceBackendRef(ref) && string(ref.Name) == serviceName {
 			return i, &ref
 		}
 	}
 	return 0, nil
 }
 
+// isServiceBackendRef returns true if ref refers to a Service, which is the default if its group
+// and kind are omitted.
+func isServiceBackendRef(ref gatewayv1alpha2.HTTPBackendRef) bool {
+	if ref.Group != nil && *ref.Group != "" {
+		return false
+	}
+	return ref.Kind == nil || *ref.Kind == "Service"
+}
+
 func setServiceBackendRef(rule *gatewayv1alpha2.HTTPRouteRule, ref gatewayv1alpha2.HTTPBackendRef) {
-	if ref.Kind == nil || *ref.Kind != "Service" {
+	if !isServiceBackendRef(ref) {
 		return
 	}
 	index, currentRef := getServiceBackendRef(*rule, string(ref.Name))
@@ -14,12 +14,16 @@ limitations under the License.
 package gateway
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
 	rolloutsv1alpha1 "github.com/openkruise/rollouts/api/v1alpha1"
 	"github.com/openkruise/rollouts/pkg/util"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilpointer "k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 )
 
@@ -460,3 +464,208 @@ func TestBuildDesiredHTTPRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestEnsureRoutesAndFinalise(t *testing.T) {
+	port80 := gatewayv1alpha2.PortNumber(80)
+	// The first rule splits traffic between the stable service and another one, and the second
+	// one refers to the stable service with the kind omitted and a port of its own.
+	getRoute := func() *gatewayv1alpha2.HTTPRoute {
+		route := routeDemo.DeepCopy()
+		route.Name = "store-route"
+		route.Namespace = "default"
+		route.Spec.Rules[1].BackendRefs[0].Weight = utilpointer.Int32(90)
+		route.Spec.Rules[1].BackendRefs = append(route.Spec.Rules[1].BackendRefs, gatewayv1alpha2.HTTPBackendRef{
+			BackendRef: gatewayv1alpha2.BackendRef{
+				BackendObjectReference: gatewayv1alpha2.BackendObjectReference{
+					Kind: &kindSvc,
+					Name: "store-svc-legacy",
+					Port: &portNum,
+				},
+				Weight: utilpointer.Int32(10),
+			},
+		})
+		route.Spec.Rules[3].BackendRefs[0].Kind = nil
+		route.Spec.Rules[3].BackendRefs[0].Port = &port80
+		return route
+	}
+
+	cases := []struct {
+		name    string
+		weights []*int32
+		matches []rolloutsv1alpha1.HttpRouteMatch
+	}{
+		{
+			name:    "completed after canary weights",
+			weights: []*int32{utilpointer.Int32(20), utilpointer.Int32(50), utilpointer.Int32(100)},
+		},
+		{
+			name:    "aborted in canary weight",
+			weights: []*int32{utilpointer.Int32(20)},
+		},
+		{
+			name:    "aborted in canary headers",
+			weights: []*int32{nil},
+			matches: []rolloutsv1alpha1.HttpRouteMatch{{Headers: []gatewayv1alpha2.HTTPHeaderMatch{{Name: "user_id", Value: "123456"}}}},
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = gatewayv1alpha2.AddToScheme(scheme)
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			original := getRoute()
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(original.DeepCopy()).Build()
+			conf := Config{
+				RolloutName:   "rollout-demo",
+				RolloutNs:     "default",
+				CanaryService: "store-svc-canary",
+				StableService: "store-svc",
+				TrafficConf:   &rolloutsv1alpha1.GatewayTrafficRouting{HTTPRouteName: utilpointer.String(original.Name)},
+			}
+			controller, _ := NewGatewayTrafficRouting(c, conf)
+			getCurrent := func() *gatewayv1alpha2.HTTPRoute {
+				route := &gatewayv1alpha2.HTTPRoute{}
+				if err := c.Get(context.TODO(), types.NamespacedName{Namespace: original.Namespace, Name: original.Name}, route); err != nil {
+					t.Fatalf("failed to get route: %s", err.Error())
+				}
+				return route
+			}
+
+			for _, weight := range cs.weights {
+				for i := 0; i < 2; i++ {
+					if _, err := controller.EnsureRoutes(context.TODO(), weight, cs.matches); err != nil {
+						t.Fatalf("failed to ensure routes: %s", err.Error())
+					}
+				}
+				current := getCurrent()
+				if recorded := current.Annotations[OriginalWeightsAnnotation]; recorded != "[null,90,null,null]" {
+					t.Fatalf("expect original weights recorded, got %s", recorded)
+				}
+				if weight == nil {
+					continue
+				}
+				for _, i := range []int{1, 3} {
+					_, stableRef := getServiceBackendRef(current.Spec.Rules[i], conf.StableService)
+					_, canaryRef := getServiceBackendRef(current.Spec.Rules[i], conf.CanaryService)
+					if stableRef == nil || canaryRef == nil || *stableRef.Weight != 100-*weight || *canaryRef.Weight != *weight {
+						t.Fatalf("expect weights %d of canary in rule %d, got %s", *weight, i, util.DumpJSON(current.Spec.Rules[i]))
+					}
+					if !reflect.DeepEqual(canaryRef.Port, stableRef.Port) || !reflect.DeepEqual(canaryRef.Kind, stableRef.Kind) {
+						t.Fatalf("expect canary refers to the port of stable in rule %d, got %s", i, util.DumpJSON(current.Spec.Rules[i]))
+					}
+				}
+				if _, legacyRef := getServiceBackendRef(current.Spec.Rules[1], "store-svc-legacy"); legacyRef == nil || *legacyRef.Weight != 10 {
+					t.Fatalf("expect weight of other service kept, got %s", util.DumpJSON(current.Spec.Rules[1]))
+				}
+			}
+
+			if err := controller.Finalise(context.TODO()); err != nil {
+				t.Fatalf("failed to finalise: %s", err.Error())
+			}
+			current := getCurrent()
+			if !reflect.DeepEqual(current.Spec.Rules, original.Spec.Rules) {
+				t.Fatalf("expect rules restored to %s, got %s", util.DumpJSON(original.Spec.Rules), util.DumpJSON(current.Spec.Rules))
+			}
+			if _, ok := current.Annotations[OriginalWeightsAnnotation]; ok {
+				t.Fatalf("expect annotation %s removed, got %v", OriginalWeightsAnnotation, current.Annotations)
+			}
+		})
+	}
+}
+
+func TestBuildRestoredHTTPRouteWithoutOriginalWeights(t *testing.T) {
+	controller := &gatewayController{conf: Config{CanaryService: "store-svc-canary", StableService: "store-svc"}}
+	rules := controller.buildCanaryWeightHttpRoutes(routeDemo.DeepCopy().Spec.Rules, utilpointer.Int32(20))
+	restored := controller.buildRestoredHTTPRoute(rules, nil)
+	for _, i := range []int{1, 3} {
+		if _, canaryRef := getServiceBackendRef(restored[i], "store-svc-canary"); canaryRef != nil {
+			t.Fatalf("expect canary removed from rule %d, got %s", i, util.DumpJSON(restored[i]))
+		}
+		if _, stableRef := getServiceBackendRef(restored[i], "store-svc"); stableRef == nil || !reflect.DeepEqual(stableRef.Weight, utilpointer.Int32(1)) {
+			t.Fatalf("expect weight of stable reset to 1 in rule %d, got %s", i, util.DumpJSON(restored[i]))
+		}
+	}
+}
+
+func TestEnsureRoutesSwitchingSteps(t *testing.T) {
+	route := routeDemo.DeepCopy()
+	route.Name = "store-route"
+	route.Namespace = "default"
+	// The last rule matches all requests.
+	route.Spec.Rules[3].Matches = nil
+	headers := []gatewayv1alpha2.HTTPHeaderMatch{{Name: "X-Canary", Value: "true"}}
+	matches := []rolloutsv1alpha1.HttpRouteMatch{{Headers: headers}}
+
+	scheme := runtime.NewScheme()
+	_ = gatewayv1alpha2.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(route.DeepCopy()).Build()
+	conf := Config{
+		RolloutName:   "rollout-demo",
+		RolloutNs:     "default",
+		CanaryService: "store-svc-canary",
+		StableService: "store-svc",
+		TrafficConf:   &rolloutsv1alpha1.GatewayTrafficRouting{HTTPRouteName: utilpointer.String(route.Name)},
+	}
+	controller, _ := NewGatewayTrafficRouting(c, conf)
+	ensureRoutes := func(weight *int32, matches []rolloutsv1alpha1.HttpRouteMatch) []gatewayv1alpha2.HTTPRouteRule {
+		for i := 0; i < 2; i++ {
+			if _, err := controller.EnsureRoutes(context.TODO(), weight, matches); err != nil {
+				t.Fatalf("failed to ensure routes: %s", err.Error())
+			}
+		}
+		current := &gatewayv1alpha2.HTTPRoute{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: route.Name}, current); err != nil {
+			t.Fatalf("failed to get route: %s", err.Error())
+		}
+		return current.Spec.Rules
+	}
+	expectHeaderRules := func(rules []gatewayv1alpha2.HTTPRouteRule) {
+		if !reflect.DeepEqual(rules[:len(route.Spec.Rules)], route.Spec.Rules) {
+			t.Fatalf("expect stable rules kept, got %s", util.DumpJSON(rules))
+		}
+		canaryRules := rules[len(route.Spec.Rules):]
+		if len(canaryRules) != 2 {
+			t.Fatalf("expect 2 canary rules, got %s", util.DumpJSON(canaryRules))
+		}
+		for _, rule := range canaryRules {
+			if len(rule.BackendRefs) != 1 || string(rule.BackendRefs[0].Name) != conf.CanaryService || len(rule.Matches) == 0 {
+				t.Fatalf("expect canary rule with matches, got %s", util.DumpJSON(rule))
+			}
+			for _, match := range rule.Matches {
+				if len(match.Headers) == 0 || !reflect.DeepEqual(match.Headers[len(match.Headers)-1], headers[0]) {
+					t.Fatalf("expect canary header in each match, got %s", util.DumpJSON(rule))
+				}
+			}
+		}
+		if path := canaryRules[1].Matches[0].Path; path == nil || *path.Value != "/" || *path.Type != gatewayv1alpha2.PathMatchPathPrefix {
+			t.Fatalf("expect canary rule of matching all requests with prefix /, got %s", util.DumpJSON(canaryRules[1]))
+		}
+	}
+
+	expectHeaderRules(ensureRoutes(nil, matches))
+
+	// The canary rules are cleaned up once the step advances to weights.
+	rules := ensureRoutes(utilpointer.Int32(30), nil)
+	if len(rules) != len(route.Spec.Rules) {
+		t.Fatalf("expect canary header rules cleaned up, got %s", util.DumpJSON(rules))
+	}
+	for _, i := range []int{1, 3} {
+		if _, canaryRef := getServiceBackendRef(rules[i], conf.CanaryService); canaryRef == nil || *canaryRef.Weight != 30 {
+			t.Fatalf("expect canary weight 30 in rule %d, got %s", i, util.DumpJSON(rules[i]))
+		}
+	}
+
+	// The weights are cleaned up if it goes back to headers, e.g. the rollout is restarted.
+	expectHeaderRules(ensureRoutes(nil, matches))
+
+	if err := controller.Finalise(context.TODO()); err != nil {
+		t.Fatalf("failed to finalise: %s", err.Error())
+	}
+	current := &gatewayv1alpha2.HTTPRoute{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: route.Name}, current); err != nil {
+		t.Fatalf("failed to get route: %s", err.Error())
+	}
+	if !reflect.DeepEqual(current.Spec.Rules, route.Spec.Rules) {
+		t.Fatalf("expect rules restored to %s, got %s", util.DumpJSON(route.Spec.Rules), util.DumpJSON(current.Spec.Rules))
+	}
+}
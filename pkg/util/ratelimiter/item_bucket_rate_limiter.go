@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
+)
+
+// ItemBucketRateLimiter is a token bucket rate limiter for each item. Unlike the overall
+// BucketRateLimiter, a hot item which is requeued frequently only exhausts its own bucket,
+// so that it cannot starve the other items.
+type ItemBucketRateLimiter struct {
+	mu      sync.Mutex
+	qps     float64
+	burst   int
+	clock   clock.PassiveClock
+	buckets map[interface{}]*itemBucket
+	// lastSweep is the last time idle buckets are removed.
+	lastSweep time.Time
+}
+
+type itemBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+var _ workqueue.RateLimiter = &ItemBucketRateLimiter{}
+
+// NewItemBucketRateLimiter returns an ItemBucketRateLimiter allowing qps requeues with the
+// given burst for each item.
+func NewItemBucketRateLimiter(qps float64, burst int, clock clock.PassiveClock) *ItemBucketRateLimiter {
+	return &ItemBucketRateLimiter{
+		qps:       qps,
+		burst:     burst,
+		clock:     clock,
+		buckets:   map[interface{}]*itemBucket{},
+		lastSweep: clock.Now(),
+	}
+}
+
+// When takes a token from the bucket of item, and returns how long to wait for it.
+func (r *ItemBucketRateLimiter) When(item interface{}) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	r.sweep(now)
+	bucket, ok := r.buckets[item]
+	if !ok {
+		bucket = &itemBucket{limiter: rate.NewLimiter(rate.Limit(r.qps), r.burst)}
+		r.buckets[item] = bucket
+	}
+	bucket.lastUsed = now
+	return bucket.limiter.ReserveN(now, 1).DelayFrom(now)
+}
+
+// NumRequeues always returns 0, the failures are counted by other rate limiters.
+func (r *ItemBucketRateLimiter) NumRequeues(item interface{}) int {
+	return 0
+}
+
+// Forget does nothing, because the bucket of item should not be refilled once it is
+// processed successfully, otherwise a hot item will never be limited.
+func (r *ItemBucketRateLimiter) Forget(item interface{}) {}
+
+// sweep removes the buckets that have been idle for long enough to be refilled, they are
+// the same as new buckets. It runs at most once per refill period.
+func (r *ItemBucketRateLimiter) sweep(now time.Time) {
+	refill := time.Duration(float64(r.burst) / r.qps * float64(time.Second))
+	if now.Sub(r.lastSweep) < refill {
+		return
+	}
+	for item, bucket := range r.buckets {
+		if now.Sub(bucket.lastUsed) >= refill {
+			delete(r.buckets, item)
+		}
+	}
+	r.lastSweep = now
+}
+
+// FairControllerRateLimiter is like DefaultControllerRateLimiter, but limits each item with
+// its own bucket instead of an overall bucket shared by all items.
+func FairControllerRateLimiter(itemLimiter *ItemBucketRateLimiter) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		itemLimiter,
+	)
+}
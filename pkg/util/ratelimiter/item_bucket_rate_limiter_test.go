@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kruise Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestItemBucketRateLimiterFairness(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	limiter := NewItemBucketRateLimiter(1, 2, fakeClock)
+
+	// A hot item is requeued 1000 times within 10 seconds, while each cold item is
+	// requeued only once per second.
+	hotWithinWindow := 0
+	for i := 0; i < 1000; i++ {
+		if i%100 == 0 {
+			for _, cold := range []string{"cold-1", "cold-2", "cold-3"} {
+				if delay := limiter.When(cold); delay != 0 {
+					t.Fatalf("expect cold item %s not delayed, got %v", cold, delay)
+				}
+			}
+		}
+		if delay := limiter.When("hot"); delay < 10*time.Second {
+			hotWithinWindow++
+		}
+		fakeClock.Step(10 * time.Millisecond)
+	}
+	// Only burst + qps * 10s requeues of the hot item can be processed within the window.
+	if hotWithinWindow > 12 {
+		t.Errorf("expect at most 12 requeues of hot item within 10s, got %d", hotWithinWindow)
+	}
+}
+
+func TestItemBucketRateLimiterSweep(t *testing.T) {
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	limiter := NewItemBucketRateLimiter(1, 2, fakeClock)
+
+	for i := 0; i < 3; i++ {
+		limiter.When("idle")
+	}
+	if delay := limiter.When("idle"); delay != 2*time.Second {
+		t.Fatalf("expect idle item delayed for 2s, got %v", delay)
+	}
+
+	fakeClock.Step(time.Minute)
+	limiter.When("other")
+	if _, ok := limiter.buckets["idle"]; ok {
+		t.Errorf("expect bucket of idle item removed")
+	}
+	if delay := limiter.When("idle"); delay != 0 {
+		t.Errorf("expect refilled item not delayed, got %v", delay)
+	}
+}
@@ -24,3 +24,12 @@ func DefaultControllerRateLimiter() workqueue.RateLimiter {
 		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), bucketSize)},
 	)
 }
+
+// ControllerRateLimiterWithBackoff is like DefaultControllerRateLimiter, but backs off the failures
+// of each item with backoff instead of the base and max delays of flags.
+func ControllerRateLimiterWithBackoff(backoff workqueue.RateLimiter) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		backoff,
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), bucketSize)},
+	)
+}
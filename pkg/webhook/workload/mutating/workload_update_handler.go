@@ -19,6 +19,7 @@ package mutating
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"math"
 	"net/http"
 	"reflect"
@@ -32,6 +33,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
@@ -203,6 +205,11 @@ func (h *WorkloadHandler) handleStatefulSetLikeWorkload(newObj, oldObj *unstruct
 }
 
 func (h *WorkloadHandler) handleDeployment(newObj, oldObj *apps.Deployment) (bool, error) {
+	// reject the strategy of advanced deployment with which the rolling can never make progress
+	if err := validateDeploymentStrategy(newObj, oldObj); err != nil {
+		return false, err
+	}
+
 	// in rollout progressing
 	if newObj.Annotations[util.InRolloutProgressingAnnotation] != "" {
 		if !newObj.Spec.Paused || !reflect.DeepEqual(newObj.Spec.Strategy, oldObj.Spec.Strategy) {
@@ -254,6 +261,23 @@ func (h *WorkloadHandler) handleDeployment(newObj, oldObj *apps.Deployment) (boo
 	return true, nil
 }
 
+// validateDeploymentStrategy validates the strategy annotation of advanced deployment once it is changed.
+func validateDeploymentStrategy(newObj, oldObj *apps.Deployment) error {
+	strategyAnno := newObj.Annotations[appsv1alpha1.DeploymentStrategyAnnotation]
+	if strategyAnno == "" || strategyAnno == oldObj.Annotations[appsv1alpha1.DeploymentStrategyAnnotation] {
+		return nil
+	}
+	strategy := appsv1alpha1.DeploymentStrategy{}
+	if err := json.Unmarshal([]byte(strategyAnno), &strategy); err != nil {
+		return fmt.Errorf("annotation %s is malformed: %v", appsv1alpha1.DeploymentStrategyAnnotation, err)
+	}
+	fldPath := field.NewPath("metadata", "annotations").Key(appsv1alpha1.DeploymentStrategyAnnotation)
+	if errList := appsv1alpha1.ValidateDeploymentStrategy(&strategy, fldPath); len(errList) > 0 {
+		return errList.ToAggregate()
+	}
+	return nil
+}
+
 func (h *WorkloadHandler) handleCloneSet(newObj, oldObj *kruiseappsv1alpha1.CloneSet) (bool, error) {
 	// indicate whether the workload can enter the rollout process
 	// when cloneSet don't contain any pods, no need to enter rollout progressing